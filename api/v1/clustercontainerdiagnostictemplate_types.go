@@ -0,0 +1,45 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterContainerDiagnosticTemplate is the Schema for the clustercontainerdiagnostictemplates
+// API. It holds reusable steps for ContainerDiagnostics in any namespace.
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Description",type=string,JSONPath=`.spec.description`
+type ClusterContainerDiagnosticTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ContainerDiagnosticTemplateSpec `json:"spec,omitempty"`
+}
+
+// ClusterContainerDiagnosticTemplateList contains a list of ClusterContainerDiagnosticTemplate
+// +kubebuilder:object:root=true
+type ClusterContainerDiagnosticTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterContainerDiagnosticTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterContainerDiagnosticTemplate{}, &ClusterContainerDiagnosticTemplateList{})
+}
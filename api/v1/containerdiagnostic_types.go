@@ -26,9 +26,240 @@ type ContainerDiagnosticStep struct {
 	// +kubebuilder:validation:Enum=install;execute;package;clean
 	Command string `json:"command"`
 
-	// The arguments for the command (if any).
+	// The arguments for the command (if any). An execute step may instead specify execCommand.
 	// +kubebuilder:validation:Optional
 	Arguments []string `json:"arguments"`
+
+	// Optional. For the package command, limits the files that are collected.
+	// +kubebuilder:validation:Optional
+	Filter *PackageFilter `json:"filter,omitempty"`
+
+	// Optional. For the execute command, the tool and its arguments (e.g. ["top", "-b", "-n", "1"])
+	// instead of arguments. Each element is passed to the tool as-is without shell parsing.
+	// +kubebuilder:validation:Optional
+	ExecCommand []string `json:"execCommand,omitempty"`
+
+	// Optional. For the execute command, more arguments appended to execCommand.
+	// +kubebuilder:validation:Optional
+	Args []string `json:"args,omitempty"`
+
+	// Optional. For the execute command, environment variables set for the tool.
+	// +kubebuilder:validation:Optional
+	Env []StepEnvVar `json:"env,omitempty"`
+
+	// Optional. For the execute command, the directory the tool runs in. Defaults to the
+	// directory of the ContainerDiagnostic.
+	// +kubebuilder:validation:Optional
+	WorkingDir string `json:"workingDir,omitempty"`
+
+	// Optional. For the execute command, run the tool in the background and continue without
+	// waiting for it to finish.
+	// +kubebuilder:validation:Optional
+	Background bool `json:"background,omitempty"`
+
+	// Optional. For the execute command, whether the standard output of the tool is written to
+	// the output file. Standard error is always written. Defaults to true.
+	// +kubebuilder:validation:Optional
+	CaptureStdout *bool `json:"captureStdout,omitempty"`
+
+	// Optional. For the execute command, whether a non-zero exit code of the tool is ignored.
+	// Otherwise, the failure is reported in the status and the output is still collected.
+	// Defaults to false with execCommand and true with arguments.
+	// +kubebuilder:validation:Optional
+	IgnoreErrors *bool `json:"ignoreErrors,omitempty"`
+
+	// Optional. For the execute command, run the tool more than once.
+	// +kubebuilder:validation:Optional
+	Repeat *StepRepeat `json:"repeat,omitempty"`
+
+	// Optional. For the execute command, the file name (in the directory of the
+	// ContainerDiagnostic) that the output is written to and packaged. Defaults to
+	// containerdiag_<time>_<step>.txt.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[^/]+$`
+	OutputFile string `json:"outputFile,omitempty"`
+}
+
+// StepEnvVar is an environment variable of an execute step
+type StepEnvVar struct {
+	// The name of the environment variable.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	Name string `json:"name"`
+
+	// Optional. The value of the environment variable.
+	// +kubebuilder:validation:Optional
+	Value string `json:"value,omitempty"`
+}
+
+// StepRepeat runs the tool of an execute step more than once
+type StepRepeat struct {
+	// The number of times the tool is run.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	Count int32 `json:"count"`
+
+	// Optional. The number of seconds to wait between runs. Defaults to 0.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	IntervalSeconds int32 `json:"intervalSeconds,omitempty"`
+}
+
+// PackageFilter limits the files collected by a package step. The arguments of the step
+// are searched for regular files and then the filters are applied in the order below.
+// Skipped files are listed in the bundle manifest.
+type PackageFilter struct {
+	// Optional. Only package files whose path or file name matches one of these
+	// patterns (e.g. "*.log"). Defaults to all files.
+	// +kubebuilder:validation:Optional
+	Include []string `json:"include,omitempty"`
+
+	// Optional. Skip files whose path or file name matches one of these patterns (e.g. "*.tmp").
+	// +kubebuilder:validation:Optional
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Optional. Skip files that were last modified more than this many minutes ago.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	ModifiedWithinMinutes int `json:"modifiedWithinMinutes,omitempty"`
+
+	// Optional. Skip files larger than this many MB.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxFileSizeMB int `json:"maxFileSizeMB,omitempty"`
+
+	// Optional. Only package this many of the most recently modified files.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	NewestFiles int `json:"newestFiles,omitempty"`
+}
+
+// S3Upload configures uploading the final download to S3-compatible object storage
+type S3Upload struct {
+	// The host and optional port of the S3-compatible endpoint (e.g. s3.us-east-1.amazonaws.com or minio.minio.svc:9000).
+	// +kubebuilder:validation:Required
+	Endpoint string `json:"endpoint"`
+
+	// The name of the bucket.
+	// +kubebuilder:validation:Required
+	Bucket string `json:"bucket"`
+
+	// Optional. A prefix for the object name (e.g. "diagnostics/").
+	// +kubebuilder:validation:Optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Optional. The region of the bucket. If not specified, it is looked up.
+	// +kubebuilder:validation:Optional
+	Region string `json:"region,omitempty"`
+
+	// A Secret in the namespace of the ContainerDiagnostic with the keys accessKeyID,
+	// secretAccessKey, and optionally sessionToken.
+	// +kubebuilder:validation:Required
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+
+	// Optional. Whether to use plain HTTP instead of HTTPS. Defaults to false.
+	// +kubebuilder:validation:Optional
+	Insecure bool `json:"insecure,omitempty"`
+
+	// Optional. Server-side encryption of the object: AES256 (SSE-S3) or aws:kms (SSE-KMS).
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=AES256;"aws:kms"
+	ServerSideEncryption string `json:"serverSideEncryption,omitempty"`
+
+	// Optional. The KMS key ID if ServerSideEncryption is aws:kms.
+	// +kubebuilder:validation:Optional
+	KMSKeyID string `json:"kmsKeyID,omitempty"`
+
+	// Optional. If greater than 0, Status.Download is a presigned link to the object that
+	// expires after this many seconds (maximum 7 days). Otherwise, it is the object URL.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=604800
+	PresignExpirySeconds int `json:"presignExpirySeconds,omitempty"`
+}
+
+// ContainerLogs configures collecting the logs of each target container through the
+// Kubernetes API (like kubectl logs)
+type ContainerLogs struct {
+	// Optional. Whether to skip collecting container logs. Defaults to false.
+	// +kubebuilder:validation:Optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Optional. The number of lines from the end of each log. Defaults to 0 which is all lines.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	TailLines int64 `json:"tailLines,omitempty"`
+
+	// Optional. Only lines written in this many seconds before collection. Defaults to 0 which is all lines.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	SinceSeconds int64 `json:"sinceSeconds,omitempty"`
+
+	// Optional. The maximum size of each log in MB; the beginning of a larger log is collected.
+	// Defaults to 0 which is the operator's default of 50 MB.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxSizeMB int64 `json:"maxSizeMB,omitempty"`
+}
+
+// TemplateReference refers to a ContainerDiagnosticTemplate in the namespace of the
+// ContainerDiagnostic or a ClusterContainerDiagnosticTemplate
+type TemplateReference struct {
+	// Optional. ContainerDiagnosticTemplate or ClusterContainerDiagnosticTemplate. Defaults to
+	// ContainerDiagnosticTemplate.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=ContainerDiagnosticTemplate;ClusterContainerDiagnosticTemplate
+	Kind string `json:"kind,omitempty"`
+
+	// The name of the template.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// ContainerWatch waits in each target container until its CPU or memory usage crosses a
+// threshold before running the execute steps. Usage is sampled from the container's cgroup
+// (v1 or v2).
+type ContainerWatch struct {
+	// Optional. The CPU usage (in millicores, e.g. 1500 for 1.5 CPUs) averaged over each
+	// interval at or above which the threshold is crossed.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	CPUThresholdMillicores *int64 `json:"cpuThresholdMillicores,omitempty"`
+
+	// Optional. The memory usage (in MB, excluding inactive page cache like the kubelet's
+	// working set) at or above which the threshold is crossed.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	MemoryThresholdMB *int64 `json:"memoryThresholdMB,omitempty"`
+
+	// Optional. The number of seconds between samples. Defaults to 5.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	IntervalSeconds int `json:"intervalSeconds,omitempty"`
+
+	// Optional. The number of consecutive samples that must cross a threshold. Defaults to 1.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	ConsecutiveSamples int `json:"consecutiveSamples,omitempty"`
+
+	// Optional. The maximum number of seconds to wait. If no threshold is crossed, the execute
+	// steps are skipped and the samples are still collected. Defaults to 86400 (1 day).
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// BundleEncryption configures encrypting the final download with OpenPGP public keys
+type BundleEncryption struct {
+	// Optional. A key of a Secret in the namespace of the ContainerDiagnostic with one or more
+	// ASCII-armored OpenPGP public keys of the recipients.
+	// +kubebuilder:validation:Optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+
+	// Optional. A key of a ConfigMap in the namespace of the ContainerDiagnostic with one or
+	// more ASCII-armored OpenPGP public keys of the recipients.
+	// +kubebuilder:validation:Optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
 }
 
 // ContainerDiagnosticSpec defines the desired state of ContainerDiagnostic
@@ -53,10 +284,32 @@ type ContainerDiagnosticSpec struct {
 	// +kubebuilder:validation:Optional
 	TargetLabelSelectors []metav1.LabelSelector `json:"targetLabelSelectors"`
 
-	// A list of steps to perform for the specified Command.
+	// A list of steps to perform for the specified Command. If not specified, the steps of
+	// TemplateRef are used.
 	// +kubebuilder:validation:Optional
 	Steps []ContainerDiagnosticStep `json:"steps"`
 
+	// Optional. The names of the containers of each target pod to run the steps on. Defaults
+	// to all containers.
+	// +kubebuilder:validation:Optional
+	Containers []string `json:"containers,omitempty"`
+
+	// Optional. The names of containers of each target pod to skip (e.g. sidecars). If
+	// neither Containers nor ExcludeContainers is specified, the defaults of the operator
+	// (e.g. istio-proxy) are set when the ContainerDiagnostic is created.
+	// +kubebuilder:validation:Optional
+	ExcludeContainers []string `json:"excludeContainers,omitempty"`
+
+	// Optional. A template with the steps and defaults of this ContainerDiagnostic. Options
+	// specified here override the defaults of the template.
+	// +kubebuilder:validation:Optional
+	TemplateRef *TemplateReference `json:"templateRef,omitempty"`
+
+	// Optional. Values of the parameters of TemplateRef that are substituted for ${name} in
+	// the arguments of its steps.
+	// +kubebuilder:validation:Optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+
 	// Optional. Target directory for diagnostic files. Must end in trailing slash.
 	// Defaults to /tmp/containerdiag/.
 	// +kubebuilder:validation:Optional
@@ -79,6 +332,91 @@ type ContainerDiagnosticSpec struct {
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default=false
 	Debug bool `json:"debug,omitempty"`
+
+	// Optional. File name patterns (e.g. "*.tar.gz") of archives within the collected
+	// files to expand in the final zip. The zip of each container and linperf_RESULTS.tar.gz
+	// are always expanded; other archives (e.g. application zips) are left as-is.
+	// +kubebuilder:validation:Optional
+	ExpandArchives []string `json:"expandArchives,omitempty"`
+
+	// Optional. Format of the final archive for download: zip, tar.gz, or tar.zst.
+	// Defaults to zip.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=zip;tar.gz;tar.zst
+	// +kubebuilder:default=zip
+	OutputFormat string `json:"outputFormat,omitempty"`
+
+	// Optional. Compression level of the final archive. For zip and tar.gz, 1 (fastest)
+	// to 9 (smallest); for tar.zst, a zstd level of 1 (fastest) to 22 (smallest).
+	// Defaults to 0 which uses the default level of the OutputFormat.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=22
+	CompressionLevel int `json:"compressionLevel,omitempty"`
+
+	// Optional. The maximum total size (in MB, before compression) of the files collected
+	// by package steps across all containers. When the limit is reached, the most recently
+	// modified files are kept and the rest are skipped. Defaults to 0 which is unlimited.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxBundleSizeMB int `json:"maxBundleSizeMB,omitempty"`
+
+	// Optional. Whether to disable redaction of secrets (e.g. passwords in environment variables,
+	// Liberty server.xml password attributes, and {xor} strings) from collected text files
+	// before the final archive is created. Defaults to false.
+	// +kubebuilder:validation:Optional
+	DisableRedaction bool `json:"disableRedaction,omitempty"`
+
+	// Optional. Additional regular expressions (RE2 syntax) of values to redact. If a regular
+	// expression has a capture group, only the first group is redacted (e.g. "(?i)pin=(\\d+)").
+	// +kubebuilder:validation:Optional
+	RedactPatterns []string `json:"redactPatterns,omitempty"`
+
+	// Optional. Whether to write every pod in the cluster to cluster/pods.txt rather than only
+	// the target pods. This lists all pods in the cluster which may be slow on large clusters.
+	// Defaults to false.
+	// +kubebuilder:validation:Optional
+	DumpAllPods bool `json:"dumpAllPods,omitempty"`
+
+	// Optional. Options for collecting the current and previous (if restarted) logs of each
+	// target container. By default, all of the logs are collected.
+	// +kubebuilder:validation:Optional
+	Logs *ContainerLogs `json:"logs,omitempty"`
+
+	// Optional. Wait in each target container until a CPU or memory threshold is crossed
+	// before running the execute steps. Target containers are processed one at a time so
+	// this is usually used with a single target.
+	// +kubebuilder:validation:Optional
+	Watch *ContainerWatch `json:"watch,omitempty"`
+
+	// Optional. Encrypt the final download with OpenPGP public keys so that only the recipients
+	// can open it. The unencrypted download is deleted.
+	// +kubebuilder:validation:Optional
+	Encryption *BundleEncryption `json:"encryption,omitempty"`
+
+	// Optional. Upload the final download to S3-compatible object storage.
+	// +kubebuilder:validation:Optional
+	S3 *S3Upload `json:"s3,omitempty"`
+
+	// Optional. If set, the ContainerDiagnostic and its download are deleted this many
+	// seconds after it finishes. If not set, they are kept until the ContainerDiagnostic is
+	// deleted or the manager's retention limits are reached.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+
+	// Optional. Increment to run a finished ContainerDiagnostic again (the annotation
+	// diagnostic.ibm.com/rerun may be used instead). The finished run is added to
+	// Status.Runs and the spec may be changed in the same update.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	RunGeneration int64 `json:"runGeneration,omitempty"`
+
+	// Optional. The number of previous runs (and their downloads) kept in Status.Runs
+	// after the ContainerDiagnostic is run again. Defaults to 5.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	RunsHistoryLimit *int32 `json:"runsHistoryLimit,omitempty"`
 }
 
 // ContainerDiagnosticStatus defines the observed state of ContainerDiagnostic
@@ -113,11 +451,128 @@ type ContainerDiagnosticStatus struct {
 
 	// +kubebuilder:validation:Optional
 	DownloadPod string `json:"downloadPod"`
+
+	// Whether the download file no longer exists (e.g. the operator was restarted
+	// and the output directory isn't on a persistent volume).
+	// +kubebuilder:validation:Optional
+	DownloadMissing bool `json:"downloadMissing"`
+
+	// The URL of the uploaded object if Spec.S3 is specified.
+	// +kubebuilder:validation:Optional
+	UploadURL string `json:"uploadURL"`
+
+	// The URL of the download on the manager's download server (if enabled). Requests
+	// must have a bearer token of a user that may get this ContainerDiagnostic.
+	// +kubebuilder:validation:Optional
+	DownloadURL string `json:"downloadURL"`
+
+	// The number of files that weren't collected because of package step filters
+	// or MaxBundleSizeMB. They are listed in the manifest.json of the download.
+	// +kubebuilder:validation:Optional
+	SkippedFiles int `json:"skippedFiles"`
+
+	// The number of values redacted from the download. They are listed (without the
+	// values) in the redaction.json of the download.
+	// +kubebuilder:validation:Optional
+	Redactions int `json:"redactions"`
+
+	// The time when processing started.
+	// +kubebuilder:validation:Optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// The time when processing finished (successfully or not).
+	// +kubebuilder:validation:Optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// The Spec.RunGeneration of the current run.
+	// +kubebuilder:validation:Optional
+	RunGeneration int64 `json:"runGeneration,omitempty"`
+
+	// The previous runs (newest first) up to Spec.RunsHistoryLimit.
+	// +kubebuilder:validation:Optional
+	Runs []ContainerDiagnosticRunStatus `json:"runs,omitempty"`
+
+	// The watches of the target containers while waiting for a Spec.Watch threshold.
+	// +kubebuilder:validation:Optional
+	Watches []ContainerWatchStatus `json:"watches,omitempty"`
+
+	// The template of Spec.TemplateRef as read when the current run started. The rest of the
+	// run uses it so that later changes to the template don't affect the run.
+	// +kubebuilder:validation:Optional
+	Template *ContainerDiagnosticTemplateSpec `json:"template,omitempty"`
+}
+
+// ContainerWatchStatus is the state of the watch of a target container. The watch runs in the
+// background in the container and the operator checks its result every interval.
+type ContainerWatchStatus struct {
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace"`
+
+	// +kubebuilder:validation:Optional
+	Pod string `json:"pod"`
+
+	// +kubebuilder:validation:Optional
+	Container string `json:"container"`
+
+	// The directory in the container with the watch script and its output.
+	// +kubebuilder:validation:Optional
+	Directory string `json:"directory,omitempty"`
+
+	// Watching, Crossed, TimedOut, or Failed.
+	// +kubebuilder:validation:Optional
+	State string `json:"state"`
+
+	// Why the watch failed (if it did).
+	// +kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// ContainerDiagnosticRunStatus is the status of a previous run of a ContainerDiagnostic
+type ContainerDiagnosticRunStatus struct {
+
+	// The Spec.RunGeneration of the run.
+	// +kubebuilder:validation:Optional
+	RunGeneration int64 `json:"runGeneration,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	StatusCode int `json:"statusCode"`
+
+	// +kubebuilder:validation:Optional
+	StatusMessage string `json:"statusMessage"`
+
+	// +kubebuilder:validation:Optional
+	Result string `json:"result"`
+
+	// +kubebuilder:validation:Optional
+	Download string `json:"download,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	DownloadPath string `json:"downloadPath,omitempty"`
+
+	// Whether the download file no longer exists.
+	// +kubebuilder:validation:Optional
+	DownloadMissing bool `json:"downloadMissing,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	UploadURL string `json:"uploadURL,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
 }
 
 // ContainerDiagnostic is the Schema for the containerdiagnostics API
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
 // +kubebuilder:printcolumn:name="Command",type=string,JSONPath=`.spec.command`
 // +kubebuilder:printcolumn:name="StatusMessage",type=string,JSONPath=`.status.statusMessage`
 // +kubebuilder:printcolumn:name="Result",type=string,JSONPath=`.status.result`
@@ -0,0 +1,295 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var containerdiagnosticlog = logf.Log.WithName("containerdiagnostic-resource")
+
+// The directories of the operator image searched for the tools of install steps (in order)
+var InstallToolDirectories = []string{"/usr/sbin/", "/usr/bin/"}
+
+// The directory of the operator image with the scripts of install steps (e.g. linperf.sh)
+var InstallScriptDirectory = "/usr/local/bin/"
+
+// The scripts of install steps that are modified before they're uploaded
+var InstallScripts = []string{"linperf.sh"}
+
+// The containers (e.g. service mesh sidecars) excluded from ContainerDiagnostics that don't
+// specify containers or excludeContainers
+var DefaultExcludeContainers = []string{"istio-proxy", "linkerd-proxy"}
+
+// The names of the environment variables of execute steps
+var EnvVarNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (r *ContainerDiagnostic) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// Only new ContainerDiagnostics are defaulted so that existing ones (which may have started) don't change.
+// +kubebuilder:webhook:path=/mutate-diagnostic-ibm-com-v1-containerdiagnostic,mutating=true,failurePolicy=fail,sideEffects=None,groups=diagnostic.ibm.com,resources=containerdiagnostics,verbs=create,versions=v1,name=mcontainerdiagnostic.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Defaulter = &ContainerDiagnostic{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *ContainerDiagnostic) Default() {
+	containerdiagnosticlog.Info("default", "name", r.Name)
+
+	if r.Spec.Command != "script" {
+		return
+	}
+
+	// Remove the uploaded tools and output unless the steps already clean. If there are no
+	// steps, the steps of the template (if any) are used as-is.
+	if len(r.Spec.Steps) > 0 {
+		hasClean := false
+		for _, step := range r.Spec.Steps {
+			if step.Command == "clean" {
+				hasClean = true
+				break
+			}
+		}
+		if !hasClean {
+			r.Spec.Steps = append(r.Spec.Steps, ContainerDiagnosticStep{Command: "clean"})
+		}
+	}
+
+	if len(r.Spec.Containers) == 0 && len(r.Spec.ExcludeContainers) == 0 && len(DefaultExcludeContainers) > 0 {
+		r.Spec.ExcludeContainers = append([]string{}, DefaultExcludeContainers...)
+	}
+}
+
+// +kubebuilder:webhook:path=/validate-diagnostic-ibm-com-v1-containerdiagnostic,mutating=false,failurePolicy=fail,sideEffects=None,groups=diagnostic.ibm.com,resources=containerdiagnostics,verbs=create;update,versions=v1,name=vcontainerdiagnostic.kb.io,admissionReviewVersions={v1,v1beta1}
+
+var _ webhook.Validator = &ContainerDiagnostic{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *ContainerDiagnostic) ValidateCreate() error {
+	containerdiagnosticlog.Info("validate create", "name", r.Name)
+
+	return r.ValidateContainerDiagnostic()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *ContainerDiagnostic) ValidateUpdate(old runtime.Object) error {
+	containerdiagnosticlog.Info("validate update", "name", r.Name)
+
+	// The operator updates the finalizers of existing ContainerDiagnostics (including ones
+	// created before this webhook) so only changes to the spec are validated
+	oldContainerDiagnostic, ok := old.(*ContainerDiagnostic)
+	if r.GetDeletionTimestamp() != nil || (ok && equality.Semantic.DeepEqual(oldContainerDiagnostic.Spec, r.Spec)) {
+		return nil
+	}
+
+	if ok && r.Spec.RunGeneration < oldContainerDiagnostic.Spec.RunGeneration {
+		return apierrors.NewInvalid(
+			schema.GroupKind{Group: GroupVersion.Group, Kind: "ContainerDiagnostic"},
+			r.Name, field.ErrorList{field.Invalid(field.NewPath("spec", "runGeneration"), r.Spec.RunGeneration, "can't be decreased")})
+	}
+
+	// Once processing starts (status code 0 is uninitialized), a changed spec would have
+	// undefined effects on the run. A finished run (status code 2 success, 3 error, or 4 mixed)
+	// may be changed when runGeneration is incremented to run it again.
+	if ok && oldContainerDiagnostic.Status.StatusCode != 0 {
+		rerun := r.Spec.RunGeneration > oldContainerDiagnostic.Spec.RunGeneration && oldContainerDiagnostic.Status.StatusCode >= 2
+		if !rerun {
+			return apierrors.NewForbidden(
+				schema.GroupResource{Group: GroupVersion.Group, Resource: "containerdiagnostics"},
+				r.Name, field.Forbidden(field.NewPath("spec"), "the spec can't be changed while the ContainerDiagnostic is running, or after it has finished unless runGeneration is incremented"))
+		}
+	}
+
+	return r.ValidateContainerDiagnostic()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *ContainerDiagnostic) ValidateDelete() error {
+	return nil
+}
+
+// ValidateContainerDiagnostic checks for mistakes in the spec that would otherwise only be found
+// when the ContainerDiagnostic runs
+func (r *ContainerDiagnostic) ValidateContainerDiagnostic() error {
+	allErrs := ValidateContainerDiagnosticSpec(&r.Spec, field.NewPath("spec"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "ContainerDiagnostic"},
+		r.Name, allErrs)
+}
+
+// ValidateContainerDiagnosticSpec checks a ContainerDiagnostic spec
+func ValidateContainerDiagnosticSpec(spec *ContainerDiagnosticSpec, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if len(spec.Directory) > 0 && !strings.HasSuffix(spec.Directory, "/") {
+		allErrs = append(allErrs, field.Invalid(path.Child("directory"), spec.Directory, "must end in a trailing slash"))
+	}
+
+	if len(spec.Parameters) > 0 && spec.TemplateRef == nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("parameters"), spec.Parameters, "can only be specified with templateRef"))
+	}
+
+	for index, pattern := range spec.RedactPatterns {
+		_, err := regexp.Compile(pattern)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("redactPatterns").Index(index), pattern, fmt.Sprintf("is not a valid regular expression: %v", err)))
+		}
+	}
+
+	if spec.Watch != nil {
+		allErrs = append(allErrs, ValidateWatch(spec.Watch, path.Child("watch"))...)
+	}
+
+	if spec.Command != "script" {
+		return allErrs
+	}
+
+	// The steps of a template (with the parameters substituted) are checked by the operator when
+	// the ContainerDiagnostic runs
+	if len(spec.Steps) == 0 && spec.TemplateRef == nil {
+		allErrs = append(allErrs, field.Required(path.Child("steps"), "the script command must have steps or a templateRef"))
+	}
+
+	if len(spec.TargetObjects) == 0 && len(spec.TargetLabelSelectors) == 0 {
+		allErrs = append(allErrs, field.Required(path.Child("targetObjects"), "the script command must have targetObjects and/or targetLabelSelectors"))
+	}
+
+	allErrs = append(allErrs, ValidateSteps(spec.Steps, path.Child("steps"))...)
+
+	return allErrs
+}
+
+// ValidateWatch checks that a watch has at least one threshold
+func ValidateWatch(watch *ContainerWatch, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if watch.CPUThresholdMillicores == nil && watch.MemoryThresholdMB == nil {
+		allErrs = append(allErrs, field.Required(path, "must have cpuThresholdMillicores and/or memoryThresholdMB"))
+	}
+
+	return allErrs
+}
+
+// ValidateSteps checks the arguments of each step
+func ValidateSteps(steps []ContainerDiagnosticStep, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	for index, step := range steps {
+		stepPath := path.Index(index)
+
+		switch step.Command {
+		case "install":
+			for argumentIndex, commandLine := range step.Arguments {
+				for _, tool := range strings.Split(commandLine, " ") {
+					if len(tool) == 0 {
+						continue
+					}
+					if !InstallToolExists(tool) {
+						allErrs = append(allErrs, field.Invalid(stepPath.Child("arguments").Index(argumentIndex), tool, fmt.Sprintf("is not a tool in the operator image (searched %s)", strings.Join(InstallToolDirectories, ", "))))
+					}
+				}
+			}
+		case "execute":
+			if len(step.ExecCommand) > 0 {
+				if len(step.Arguments) > 0 {
+					allErrs = append(allErrs, field.Invalid(stepPath.Child("arguments"), step.Arguments, "can't be specified with execCommand"))
+				}
+				if len(strings.TrimSpace(step.ExecCommand[0])) == 0 {
+					allErrs = append(allErrs, field.Required(stepPath.Child("execCommand").Index(0), "execCommand must start with the name of the tool"))
+				}
+			} else if len(step.Arguments) == 0 || len(strings.TrimSpace(step.Arguments[0])) == 0 {
+				allErrs = append(allErrs, field.Required(stepPath.Child("arguments"), "execute must have arguments or execCommand starting with the name of the tool"))
+			}
+			envNames := make(map[string]bool)
+			for envIndex, envVar := range step.Env {
+				if !EnvVarNamePattern.MatchString(envVar.Name) {
+					allErrs = append(allErrs, field.Invalid(stepPath.Child("env").Index(envIndex).Child("name"), envVar.Name, "must be a valid environment variable name"))
+				} else if envNames[envVar.Name] {
+					allErrs = append(allErrs, field.Duplicate(stepPath.Child("env").Index(envIndex).Child("name"), envVar.Name))
+				}
+				envNames[envVar.Name] = true
+			}
+			if len(step.OutputFile) > 0 && (strings.Contains(step.OutputFile, "/") || step.OutputFile == "." || step.OutputFile == "..") {
+				allErrs = append(allErrs, field.Invalid(stepPath.Child("outputFile"), step.OutputFile, "must be a file name without a directory"))
+			}
+		case "package":
+			if len(step.Arguments) == 0 {
+				allErrs = append(allErrs, field.Required(stepPath.Child("arguments"), "package must have the files or directories to package"))
+			}
+		}
+
+		if step.Filter != nil && step.Command != "package" {
+			allErrs = append(allErrs, field.Invalid(stepPath.Child("filter"), step.Command, "filter can only be specified for package"))
+		}
+
+		if step.Command != "execute" && HasExecuteOptions(step) {
+			allErrs = append(allErrs, field.Invalid(stepPath, step.Command, "execCommand, args, env, workingDir, background, captureStdout, ignoreErrors, repeat, and outputFile can only be specified for execute"))
+		}
+	}
+
+	return allErrs
+}
+
+// HasExecuteOptions returns whether a step specifies any of the options of execute steps
+func HasExecuteOptions(step ContainerDiagnosticStep) bool {
+	return len(step.ExecCommand) > 0 || len(step.Args) > 0 || len(step.Env) > 0 || len(step.WorkingDir) > 0 ||
+		step.Background || step.CaptureStdout != nil || step.IgnoreErrors != nil || step.Repeat != nil || len(step.OutputFile) > 0
+}
+
+// InstallToolExists returns whether a tool of an install step exists in the operator image
+func InstallToolExists(tool string) bool {
+	// A name with a path could install any file of the image
+	if strings.Contains(tool, "/") {
+		return false
+	}
+
+	for _, script := range InstallScripts {
+		if tool == script {
+			_, err := os.Stat(InstallScriptDirectory + tool)
+			return err == nil
+		}
+	}
+
+	for _, directory := range InstallToolDirectories {
+		fileInfo, err := os.Stat(directory + tool)
+		if err == nil && !fileInfo.IsDir() {
+			return true
+		}
+	}
+
+	return false
+}
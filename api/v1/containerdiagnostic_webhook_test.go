@@ -0,0 +1,357 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func int64Pointer(value int64) *int64 {
+	return &value
+}
+
+// errorFields returns the field paths of the errors
+func errorFields(allErrs field.ErrorList) []string {
+	var fields []string
+	for _, err := range allErrs {
+		fields = append(fields, err.Field)
+	}
+	return fields
+}
+
+func TestValidateWatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		watch   *ContainerWatch
+		wantErr bool
+	}{
+		{"no thresholds", &ContainerWatch{IntervalSeconds: 10}, true},
+		{"cpu", &ContainerWatch{CPUThresholdMillicores: int64Pointer(1500)}, false},
+		{"memory", &ContainerWatch{MemoryThresholdMB: int64Pointer(2048)}, false},
+		{"cpu and memory", &ContainerWatch{CPUThresholdMillicores: int64Pointer(1500), MemoryThresholdMB: int64Pointer(2048)}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allErrs := ValidateWatch(test.watch, field.NewPath("spec", "watch"))
+			if (len(allErrs) > 0) != test.wantErr {
+				t.Fatalf("got %v, want error %t", allErrs, test.wantErr)
+			}
+			if test.wantErr && allErrs[0].Field != "spec.watch" {
+				t.Errorf("got field %s", allErrs[0].Field)
+			}
+		})
+	}
+
+	// The webhook uses the same validation
+	spec := &ContainerDiagnosticSpec{Command: "version", Watch: &ContainerWatch{}}
+	if fields := errorFields(ValidateContainerDiagnosticSpec(spec, field.NewPath("spec"))); len(fields) != 1 || fields[0] != "spec.watch" {
+		t.Errorf("got %v", fields)
+	}
+}
+
+// useTestInstallTools makes the tools that can be installed the files in temporary directories:
+// sbin/tool1, bin/tool2, a bin/directory, and the linperf.sh script
+func useTestInstallTools(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{"sbin/tool1", "bin/tool2", "scripts/linperf.sh"} {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(root, "bin", "directory"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	installToolDirectories := InstallToolDirectories
+	installScriptDirectory := InstallScriptDirectory
+	t.Cleanup(func() {
+		InstallToolDirectories = installToolDirectories
+		InstallScriptDirectory = installScriptDirectory
+	})
+	InstallToolDirectories = []string{filepath.Join(root, "sbin") + "/", filepath.Join(root, "bin") + "/"}
+	InstallScriptDirectory = filepath.Join(root, "scripts") + "/"
+}
+
+func TestInstallToolExists(t *testing.T) {
+	useTestInstallTools(t)
+
+	tests := []struct {
+		tool string
+		want bool
+	}{
+		{"tool1", true},
+		{"tool2", true},
+		{"linperf.sh", true},
+		{"missing", false},
+		{"directory", false},
+		{"../sbin/tool1", false},
+		{"/bin/sh", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.tool, func(t *testing.T) {
+			if got := InstallToolExists(test.tool); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+
+	// A script is only looked for in the script directory
+	os.Remove(InstallScriptDirectory + "linperf.sh")
+	if err := ioutil.WriteFile(InstallToolDirectories[1]+"linperf.sh", nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if InstallToolExists("linperf.sh") {
+		t.Error("linperf.sh was found outside of the script directory")
+	}
+}
+
+func TestValidateSteps(t *testing.T) {
+	useTestInstallTools(t)
+
+	tests := []struct {
+		name  string
+		steps []ContainerDiagnosticStep
+		want  []string
+	}{
+		{"valid", []ContainerDiagnosticStep{
+			{Command: "install", Arguments: []string{"tool1  tool2", "linperf.sh"}},
+			{Command: "execute", Arguments: []string{"tool1 -l"}, Env: []StepEnvVar{{Name: "A_1", Value: "x"}}, OutputFile: "out.txt"},
+			{Command: "execute", ExecCommand: []string{"tool2", "-l"}, Args: []string{"x"}},
+			{Command: "package", Arguments: []string{"/output"}, Filter: &PackageFilter{}},
+			{Command: "clean"},
+		}, nil},
+		{"unknown tools", []ContainerDiagnosticStep{
+			{Command: "install", Arguments: []string{"tool1", "tool2 missing", "../bin/tool1"}},
+		}, []string{"spec.steps[0].arguments[1]", "spec.steps[0].arguments[2]"}},
+		{"execute without a tool", []ContainerDiagnosticStep{
+			{Command: "execute"},
+			{Command: "execute", Arguments: []string{" "}},
+			{Command: "execute", ExecCommand: []string{""}},
+		}, []string{"spec.steps[0].arguments", "spec.steps[1].arguments", "spec.steps[2].execCommand[0]"}},
+		{"arguments with execCommand", []ContainerDiagnosticStep{
+			{Command: "execute", ExecCommand: []string{"tool1"}, Arguments: []string{"tool1"}},
+		}, []string{"spec.steps[0].arguments"}},
+		{"environment variable names", []ContainerDiagnosticStep{
+			{Command: "execute", Arguments: []string{"tool1"}, Env: []StepEnvVar{{Name: "A"}, {Name: "A"}, {Name: "1A"}, {Name: "A;B"}, {Name: ""}}},
+		}, []string{"spec.steps[0].env[1].name", "spec.steps[0].env[2].name", "spec.steps[0].env[3].name", "spec.steps[0].env[4].name"}},
+		{"output files", []ContainerDiagnosticStep{
+			{Command: "execute", Arguments: []string{"tool1"}, OutputFile: "../out.txt"},
+			{Command: "execute", Arguments: []string{"tool1"}, OutputFile: "/tmp/out.txt"},
+			{Command: "execute", Arguments: []string{"tool1"}, OutputFile: ".."},
+			{Command: "execute", Arguments: []string{"tool1"}, OutputFile: "."},
+		}, []string{"spec.steps[0].outputFile", "spec.steps[1].outputFile", "spec.steps[2].outputFile", "spec.steps[3].outputFile"}},
+		{"package without arguments", []ContainerDiagnosticStep{{Command: "package"}}, []string{"spec.steps[0].arguments"}},
+		{"filter of another command", []ContainerDiagnosticStep{{Command: "clean", Filter: &PackageFilter{}}}, []string{"spec.steps[0].filter"}},
+		{"execute options of another command", []ContainerDiagnosticStep{
+			{Command: "package", Arguments: []string{"/output"}, Background: true},
+			{Command: "clean", OutputFile: "out.txt"},
+		}, []string{"spec.steps[0]", "spec.steps[1]"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields := errorFields(ValidateSteps(test.steps, field.NewPath("spec", "steps")))
+			if !reflect.DeepEqual(fields, test.want) {
+				t.Errorf("got %v, want %v", fields, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateContainerDiagnosticSpec(t *testing.T) {
+	useTestInstallTools(t)
+
+	targetObjects := []corev1.ObjectReference{{Kind: "Pod", Name: "app"}}
+	steps := []ContainerDiagnosticStep{{Command: "execute", Arguments: []string{"tool1"}}}
+
+	tests := []struct {
+		name string
+		spec ContainerDiagnosticSpec
+		want []string
+	}{
+		{"valid", ContainerDiagnosticSpec{Command: "script", Steps: steps, TargetObjects: targetObjects, Directory: "/tmp/"}, nil},
+		{"template", ContainerDiagnosticSpec{Command: "script", TemplateRef: &TemplateReference{Name: "t"}, Parameters: map[string]string{"a": "b"}, TargetObjects: targetObjects}, nil},
+		{"version", ContainerDiagnosticSpec{Command: "version"}, nil},
+		{"directory without a trailing slash", ContainerDiagnosticSpec{Command: "version", Directory: "/tmp"}, []string{"spec.directory"}},
+		{"parameters without a template", ContainerDiagnosticSpec{Command: "version", Parameters: map[string]string{"a": "b"}}, []string{"spec.parameters"}},
+		{"redact patterns", ContainerDiagnosticSpec{Command: "version", RedactPatterns: []string{"ok", "("}}, []string{"spec.redactPatterns[1]"}},
+		{"script without steps or targets", ContainerDiagnosticSpec{Command: "script"}, []string{"spec.steps", "spec.targetObjects"}},
+		{"invalid step", ContainerDiagnosticSpec{Command: "script", Steps: []ContainerDiagnosticStep{{Command: "package"}}, TargetObjects: targetObjects}, []string{"spec.steps[0].arguments"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields := errorFields(ValidateContainerDiagnosticSpec(&test.spec, field.NewPath("spec")))
+			if !reflect.DeepEqual(fields, test.want) {
+				t.Errorf("got %v, want %v", fields, test.want)
+			}
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	execute := ContainerDiagnosticStep{Command: "execute", Arguments: []string{"top -b -n 1"}}
+	clean := ContainerDiagnosticStep{Command: "clean", Arguments: []string{"/tmp/extra"}}
+
+	tests := []struct {
+		name                  string
+		spec                  ContainerDiagnosticSpec
+		wantSteps             []ContainerDiagnosticStep
+		wantExcludeContainers []string
+	}{
+		{
+			"clean appended",
+			ContainerDiagnosticSpec{Command: "script", Steps: []ContainerDiagnosticStep{execute}},
+			[]ContainerDiagnosticStep{execute, {Command: "clean"}},
+			DefaultExcludeContainers,
+		},
+		{
+			"existing clean",
+			ContainerDiagnosticSpec{Command: "script", Steps: []ContainerDiagnosticStep{clean, execute}},
+			[]ContainerDiagnosticStep{clean, execute},
+			DefaultExcludeContainers,
+		},
+		{
+			"template steps",
+			ContainerDiagnosticSpec{Command: "script", TemplateRef: &TemplateReference{Name: "t"}},
+			nil,
+			DefaultExcludeContainers,
+		},
+		{
+			"containers",
+			ContainerDiagnosticSpec{Command: "script", Steps: []ContainerDiagnosticStep{clean}, Containers: []string{"istio-proxy"}},
+			[]ContainerDiagnosticStep{clean},
+			nil,
+		},
+		{
+			"exclude containers",
+			ContainerDiagnosticSpec{Command: "script", Steps: []ContainerDiagnosticStep{clean}, ExcludeContainers: []string{"sidecar"}},
+			[]ContainerDiagnosticStep{clean},
+			[]string{"sidecar"},
+		},
+		{
+			"version",
+			ContainerDiagnosticSpec{Command: "version", Steps: []ContainerDiagnosticStep{execute}},
+			[]ContainerDiagnosticStep{execute},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			containerDiagnostic := &ContainerDiagnostic{Spec: *test.spec.DeepCopy()}
+			containerDiagnostic.Default()
+			if !reflect.DeepEqual(containerDiagnostic.Spec.Steps, test.wantSteps) {
+				t.Errorf("got steps %+v, want %+v", containerDiagnostic.Spec.Steps, test.wantSteps)
+			}
+			if !reflect.DeepEqual(containerDiagnostic.Spec.ExcludeContainers, test.wantExcludeContainers) {
+				t.Errorf("got excludeContainers %v, want %v", containerDiagnostic.Spec.ExcludeContainers, test.wantExcludeContainers)
+			}
+		})
+	}
+
+	// The defaults are copied so that changes to one ContainerDiagnostic don't change the others
+	containerDiagnostic := &ContainerDiagnostic{Spec: ContainerDiagnosticSpec{Command: "script"}}
+	containerDiagnostic.Default()
+	containerDiagnostic.Spec.ExcludeContainers[0] = "changed"
+	if DefaultExcludeContainers[0] == "changed" {
+		t.Error("DefaultExcludeContainers was changed")
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	newContainerDiagnostic := func(statusCode int, runGeneration int64, directory string) *ContainerDiagnostic {
+		return &ContainerDiagnostic{
+			ObjectMeta: metav1.ObjectMeta{Name: "diag"},
+			Spec: ContainerDiagnosticSpec{
+				Command:       "version",
+				Directory:     directory,
+				RunGeneration: runGeneration,
+			},
+			Status: ContainerDiagnosticStatus{StatusCode: statusCode},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		old     runtime.Object
+		new     *ContainerDiagnostic
+		wantErr func(error) bool
+	}{
+		{"same spec while processing", newContainerDiagnostic(1, 0, "/tmp/"), newContainerDiagnostic(1, 0, "/tmp/"), nil},
+		{"same invalid spec", newContainerDiagnostic(2, 0, "/tmp"), newContainerDiagnostic(2, 0, "/tmp"), nil},
+		{"changed before processing", newContainerDiagnostic(0, 0, "/tmp/"), newContainerDiagnostic(0, 0, "/var/tmp/"), nil},
+		{"invalid before processing", newContainerDiagnostic(0, 0, "/tmp/"), newContainerDiagnostic(0, 0, "/var/tmp"), apierrors.IsInvalid},
+		{"changed while processing", newContainerDiagnostic(1, 0, "/tmp/"), newContainerDiagnostic(1, 0, "/var/tmp/"), apierrors.IsForbidden},
+		{"rerun while processing", newContainerDiagnostic(1, 0, "/tmp/"), newContainerDiagnostic(1, 1, "/tmp/"), apierrors.IsForbidden},
+		{"changed after success", newContainerDiagnostic(2, 0, "/tmp/"), newContainerDiagnostic(2, 0, "/var/tmp/"), apierrors.IsForbidden},
+		{"changed after error", newContainerDiagnostic(3, 0, "/tmp/"), newContainerDiagnostic(3, 0, "/var/tmp/"), apierrors.IsForbidden},
+		{"rerun after success", newContainerDiagnostic(2, 0, "/tmp/"), newContainerDiagnostic(2, 1, "/tmp/"), nil},
+		{"changed with a rerun after error", newContainerDiagnostic(3, 1, "/tmp/"), newContainerDiagnostic(3, 2, "/var/tmp/"), nil},
+		{"changed with a rerun after mixed", newContainerDiagnostic(4, 1, "/tmp/"), newContainerDiagnostic(4, 3, "/var/tmp/"), nil},
+		{"invalid with a rerun", newContainerDiagnostic(2, 0, "/tmp/"), newContainerDiagnostic(2, 1, "/var/tmp"), apierrors.IsInvalid},
+		{"runGeneration decreased", newContainerDiagnostic(2, 2, "/tmp/"), newContainerDiagnostic(2, 1, "/tmp/"), apierrors.IsInvalid},
+		{"runGeneration decreased before processing", newContainerDiagnostic(0, 2, "/tmp/"), newContainerDiagnostic(0, 1, "/tmp/"), apierrors.IsInvalid},
+		{"not a ContainerDiagnostic", &ContainerDiagnosticTemplate{}, newContainerDiagnostic(1, 0, "/tmp/"), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.new.ValidateUpdate(test.old)
+			if test.wantErr == nil {
+				if err != nil {
+					t.Errorf("got %v", err)
+				}
+			} else if err == nil || !test.wantErr(err) {
+				t.Errorf("got %v", err)
+			}
+		})
+	}
+
+	// A ContainerDiagnostic being deleted may be changed (e.g. to remove the finalizer)
+	old := newContainerDiagnostic(1, 0, "/tmp/")
+	deleting := newContainerDiagnostic(1, 0, "/var/tmp/")
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	if err := deleting.ValidateUpdate(old); err != nil {
+		t.Errorf("got %v while deleting", err)
+	}
+
+	// The metadata and status of a running ContainerDiagnostic may be changed
+	changed := old.DeepCopy()
+	changed.Finalizers = []string{"diagnostic.ibm.com/finalizer"}
+	changed.Status.StatusCode = 2
+	if err := changed.ValidateUpdate(old); err != nil {
+		t.Errorf("got %v for a change outside of the spec", err)
+	}
+}
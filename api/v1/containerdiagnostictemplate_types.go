@@ -0,0 +1,116 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TemplateParameter is a parameter of a template that is substituted for ${name} in the
+// arguments of its steps
+type TemplateParameter struct {
+	// The name of the parameter.
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	Name string `json:"name"`
+
+	// Optional. A description of the parameter.
+	// +kubebuilder:validation:Optional
+	Description string `json:"description,omitempty"`
+
+	// Optional. The value if a ContainerDiagnostic doesn't specify one.
+	// +kubebuilder:validation:Optional
+	Default string `json:"default,omitempty"`
+
+	// Optional. Whether a ContainerDiagnostic must specify a value. Defaults to false.
+	// +kubebuilder:validation:Optional
+	Required bool `json:"required,omitempty"`
+}
+
+// ContainerDiagnosticTemplateDefaults are the defaults of a template for the options that a
+// ContainerDiagnostic doesn't specify
+type ContainerDiagnosticTemplateDefaults struct {
+	// Optional. See ContainerDiagnosticSpec.
+	// +kubebuilder:validation:Optional
+	ExpandArchives []string `json:"expandArchives,omitempty"`
+
+	// Optional. See ContainerDiagnosticSpec.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=22
+	CompressionLevel int `json:"compressionLevel,omitempty"`
+
+	// Optional. See ContainerDiagnosticSpec.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxBundleSizeMB int `json:"maxBundleSizeMB,omitempty"`
+
+	// Optional. See ContainerDiagnosticSpec.
+	// +kubebuilder:validation:Optional
+	RedactPatterns []string `json:"redactPatterns,omitempty"`
+
+	// Optional. See ContainerDiagnosticSpec.
+	// +kubebuilder:validation:Optional
+	Logs *ContainerLogs `json:"logs,omitempty"`
+
+	// Optional. See ContainerDiagnosticSpec.
+	// +kubebuilder:validation:Optional
+	Watch *ContainerWatch `json:"watch,omitempty"`
+}
+
+// ContainerDiagnosticTemplateSpec defines the steps and defaults of ContainerDiagnostics that
+// reference a ContainerDiagnosticTemplate or ClusterContainerDiagnosticTemplate
+type ContainerDiagnosticTemplateSpec struct {
+	// Optional. A description of the template.
+	// +kubebuilder:validation:Optional
+	Description string `json:"description,omitempty"`
+
+	// Optional. The parameters substituted for ${name} in the arguments of the steps.
+	// +kubebuilder:validation:Optional
+	Parameters []TemplateParameter `json:"parameters,omitempty"`
+
+	// The steps of each ContainerDiagnostic that references the template and doesn't have
+	// its own steps.
+	// +kubebuilder:validation:MinItems=1
+	Steps []ContainerDiagnosticStep `json:"steps"`
+
+	// Optional. Defaults for the options that a ContainerDiagnostic doesn't specify.
+	// +kubebuilder:validation:Optional
+	Defaults ContainerDiagnosticTemplateDefaults `json:"defaults,omitempty"`
+}
+
+// ContainerDiagnosticTemplate is the Schema for the containerdiagnostictemplates API. It holds
+// reusable steps for ContainerDiagnostics in its namespace.
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Description",type=string,JSONPath=`.spec.description`
+type ContainerDiagnosticTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ContainerDiagnosticTemplateSpec `json:"spec,omitempty"`
+}
+
+// ContainerDiagnosticTemplateList contains a list of ContainerDiagnosticTemplate
+// +kubebuilder:object:root=true
+type ContainerDiagnosticTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ContainerDiagnosticTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ContainerDiagnosticTemplate{}, &ContainerDiagnosticTemplateList{})
+}
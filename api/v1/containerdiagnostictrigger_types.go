@@ -0,0 +1,168 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TriggerEvent is a change in the status of a container that triggers a ContainerDiagnostic
+// +kubebuilder:validation:Enum=Restart;OOMKilled;NotReady
+type TriggerEvent string
+
+const (
+	// TriggerRestart is an increase in the restart count of a container for any reason
+	TriggerRestart TriggerEvent = "Restart"
+
+	// TriggerOOMKilled is a restart of a container that was killed because it ran out of memory
+	TriggerOOMKilled TriggerEvent = "OOMKilled"
+
+	// TriggerNotReady is a running container that became not ready (e.g. its readiness
+	// probe failed) without restarting
+	TriggerNotReady TriggerEvent = "NotReady"
+)
+
+// ContainerDiagnosticTriggerSpec defines the desired state of ContainerDiagnosticTrigger
+type ContainerDiagnosticTriggerSpec struct {
+	// Optional. A list of LabelSelectors of the pods to watch in the namespace of the trigger. A
+	// pod that matches any of the selectors is watched. If not set, no pods are watched and the
+	// trigger is only fired by alerts received by the manager's Alertmanager receiver (which
+	// otherwise must also match).
+	// See https://kubernetes.io/docs/reference/kubernetes-api/common-definitions/label-selector/
+	// +kubebuilder:validation:Optional
+	TargetLabelSelectors []metav1.LabelSelector `json:"targetLabelSelectors,omitempty"`
+
+	// Optional. The names of the containers to watch. Defaults to all containers.
+	// +kubebuilder:validation:Optional
+	Containers []string `json:"containers,omitempty"`
+
+	// Optional. The events that trigger a ContainerDiagnostic. Restart includes OOMKilled.
+	// Defaults to Restart.
+	// +kubebuilder:validation:Optional
+	Events []TriggerEvent `json:"events,omitempty"`
+
+	// Optional. After a workload (e.g. a Deployment or StatefulSet, or a pod without an owner)
+	// triggers a ContainerDiagnostic, further events of its pods are ignored for this many
+	// seconds. Defaults to 600.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	CooldownSeconds *int32 `json:"cooldownSeconds,omitempty"`
+
+	// Optional. The maximum number of ContainerDiagnostics created by this trigger in any
+	// hour. Further events are ignored. Defaults to 10.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	MaxRunsPerHour *int32 `json:"maxRunsPerHour,omitempty"`
+
+	// Optional. Whether to ignore events. Defaults to false.
+	// +kubebuilder:validation:Optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// The ContainerDiagnostic created for each event. Its targetObjects is replaced with the
+	// affected pod, its containers with the affected container, and its targetLabelSelectors
+	// is cleared.
+	Template ContainerDiagnosticRunTemplate `json:"template"`
+}
+
+// TriggeredWorkload records when a workload last triggered a ContainerDiagnostic for the cooldown
+type TriggeredWorkload struct {
+	// The workload in the form kind/name (e.g. Deployment/app1)
+	Workload string `json:"workload"`
+
+	// The time of the last ContainerDiagnostic created for the workload
+	LastTriggerTime metav1.Time `json:"lastTriggerTime"`
+}
+
+// ObservedContainer records the restart count of a container of a watched pod when the trigger
+// last checked it
+type ObservedContainer struct {
+	Pod string `json:"pod"`
+
+	Container string `json:"container"`
+
+	RestartCount int32 `json:"restartCount"`
+}
+
+// ContainerDiagnosticTriggerStatus defines the observed state of ContainerDiagnosticTrigger
+type ContainerDiagnosticTriggerStatus struct {
+	// The number of ContainerDiagnostics created by this trigger.
+	// +kubebuilder:validation:Optional
+	Runs int `json:"runs"`
+
+	// The number of events ignored because of the cooldown or maxRunsPerHour.
+	// +kubebuilder:validation:Optional
+	Suppressed int `json:"suppressed"`
+
+	// The name of the last ContainerDiagnostic created.
+	// +kubebuilder:validation:Optional
+	LastRun string `json:"lastRun,omitempty"`
+
+	// The reason for the last ContainerDiagnostic created.
+	// +kubebuilder:validation:Optional
+	LastReason string `json:"lastReason,omitempty"`
+
+	// The time of the last ContainerDiagnostic created.
+	// +kubebuilder:validation:Optional
+	LastTriggerTime *metav1.Time `json:"lastTriggerTime,omitempty"`
+
+	// The times of the ContainerDiagnostics created in the last hour for maxRunsPerHour.
+	// +kubebuilder:validation:Optional
+	RecentTriggerTimes []metav1.Time `json:"recentTriggerTimes,omitempty"`
+
+	// The workloads in their cooldown.
+	// +kubebuilder:validation:Optional
+	Workloads []TriggeredWorkload `json:"workloads,omitempty"`
+
+	// When the restart counts of the watched pods were last checked.
+	// +kubebuilder:validation:Optional
+	ObservedTime *metav1.Time `json:"observedTime,omitempty"`
+
+	// The restart counts of the containers of the watched pods when they were last checked.
+	// Restarts are detected by comparing the pods with these so that restarts while the
+	// operator isn't running aren't missed.
+	// +kubebuilder:validation:Optional
+	ObservedContainers []ObservedContainer `json:"observedContainers,omitempty"`
+}
+
+// ContainerDiagnosticTrigger is the Schema for the containerdiagnostictriggers API. It watches
+// pods and creates a ContainerDiagnostic targeting a pod when one of its containers restarts,
+// is OOMKilled, or becomes not ready, or when an alert for the pod is received.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Suspend",type=boolean,JSONPath=`.spec.suspend`
+// +kubebuilder:printcolumn:name="Runs",type=integer,JSONPath=`.status.runs`
+// +kubebuilder:printcolumn:name="LastRun",type=string,JSONPath=`.status.lastRun`
+// +kubebuilder:printcolumn:name="LastReason",type=string,JSONPath=`.status.lastReason`
+type ContainerDiagnosticTrigger struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ContainerDiagnosticTriggerSpec   `json:"spec,omitempty"`
+	Status ContainerDiagnosticTriggerStatus `json:"status,omitempty"`
+}
+
+// ContainerDiagnosticTriggerList contains a list of ContainerDiagnosticTrigger
+// +kubebuilder:object:root=true
+type ContainerDiagnosticTriggerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ContainerDiagnosticTrigger `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ContainerDiagnosticTrigger{}, &ContainerDiagnosticTriggerList{})
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConcurrencyPolicy describes what happens when a run is due while a previous run is active
+// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+type ConcurrencyPolicy string
+
+const (
+	// AllowConcurrent allows runs to overlap
+	AllowConcurrent ConcurrencyPolicy = "Allow"
+
+	// ForbidConcurrent skips a run if the previous run hasn't finished
+	ForbidConcurrent ConcurrencyPolicy = "Forbid"
+
+	// ReplaceConcurrent deletes the previous run if it hasn't finished and starts a new run
+	ReplaceConcurrent ConcurrencyPolicy = "Replace"
+)
+
+// ContainerDiagnosticRunTemplate describes the ContainerDiagnostic created for each run
+type ContainerDiagnosticRunTemplate struct {
+	// Optional. Labels added to each ContainerDiagnostic.
+	// +kubebuilder:validation:Optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Optional. Annotations added to each ContainerDiagnostic.
+	// +kubebuilder:validation:Optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// The spec of each ContainerDiagnostic.
+	Spec ContainerDiagnosticSpec `json:"spec"`
+}
+
+// ScheduledContainerDiagnosticSpec defines the desired state of ScheduledContainerDiagnostic
+type ScheduledContainerDiagnosticSpec struct {
+	// The schedule in cron format (e.g. "0 * * * *" for hourly). See
+	// https://en.wikipedia.org/wiki/Cron
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+
+	// Optional. If a run is missed by more than this many seconds (e.g. the operator wasn't
+	// running), it's skipped. If not set, missed runs are never skipped.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+
+	// Optional. What happens when a run is due while a previous run hasn't finished: Allow
+	// (runs may overlap), Forbid (the new run is skipped), or Replace (the previous run is
+	// deleted). Defaults to Allow.
+	// +kubebuilder:validation:Optional
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+
+	// Optional. Whether to suspend subsequent runs. Runs that already started aren't
+	// affected. Defaults to false.
+	// +kubebuilder:validation:Optional
+	Suspend *bool `json:"suspend,omitempty"`
+
+	// Optional. The number of successful ContainerDiagnostics (and their downloads) to keep.
+	// Defaults to 3.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	SuccessfulRunsHistoryLimit *int32 `json:"successfulRunsHistoryLimit,omitempty"`
+
+	// Optional. The number of failed (error or mixed) ContainerDiagnostics (and their downloads)
+	// to keep. Defaults to 1.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	FailedRunsHistoryLimit *int32 `json:"failedRunsHistoryLimit,omitempty"`
+
+	// The ContainerDiagnostic created for each run.
+	Template ContainerDiagnosticRunTemplate `json:"template"`
+}
+
+// ScheduledContainerDiagnosticStatus defines the observed state of ScheduledContainerDiagnostic
+type ScheduledContainerDiagnosticStatus struct {
+	// The ContainerDiagnostics that haven't finished.
+	// +kubebuilder:validation:Optional
+	Active []corev1.ObjectReference `json:"active,omitempty"`
+
+	// The time when the last run was scheduled.
+	// +kubebuilder:validation:Optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// The time when the last successful run finished.
+	// +kubebuilder:validation:Optional
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+
+	// The name of the last ContainerDiagnostic created.
+	// +kubebuilder:validation:Optional
+	LastRun string `json:"lastRun,omitempty"`
+}
+
+// ScheduledContainerDiagnostic is the Schema for the scheduledcontainerdiagnostics API. It creates
+// ContainerDiagnostics on a schedule like a CronJob creates Jobs.
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`
+// +kubebuilder:printcolumn:name="Suspend",type=boolean,JSONPath=`.spec.suspend`
+// +kubebuilder:printcolumn:name="LastSchedule",type=date,JSONPath=`.status.lastScheduleTime`
+// +kubebuilder:printcolumn:name="LastRun",type=string,JSONPath=`.status.lastRun`
+type ScheduledContainerDiagnostic struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ScheduledContainerDiagnosticSpec   `json:"spec,omitempty"`
+	Status ScheduledContainerDiagnosticStatus `json:"status,omitempty"`
+}
+
+// ScheduledContainerDiagnosticList contains a list of ScheduledContainerDiagnostic
+// +kubebuilder:object:root=true
+type ScheduledContainerDiagnosticList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ScheduledContainerDiagnostic `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ScheduledContainerDiagnostic{}, &ScheduledContainerDiagnosticList{})
+}
@@ -24,16 +24,99 @@ package v1
 import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BundleEncryption) DeepCopyInto(out *BundleEncryption) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(corev1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BundleEncryption.
+func (in *BundleEncryption) DeepCopy() *BundleEncryption {
+	if in == nil {
+		return nil
+	}
+	out := new(BundleEncryption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterContainerDiagnosticTemplate) DeepCopyInto(out *ClusterContainerDiagnosticTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterContainerDiagnosticTemplate.
+func (in *ClusterContainerDiagnosticTemplate) DeepCopy() *ClusterContainerDiagnosticTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterContainerDiagnosticTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterContainerDiagnosticTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterContainerDiagnosticTemplateList) DeepCopyInto(out *ClusterContainerDiagnosticTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterContainerDiagnosticTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterContainerDiagnosticTemplateList.
+func (in *ClusterContainerDiagnosticTemplateList) DeepCopy() *ClusterContainerDiagnosticTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterContainerDiagnosticTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterContainerDiagnosticTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ContainerDiagnostic) DeepCopyInto(out *ContainerDiagnostic) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnostic.
@@ -86,6 +169,59 @@ func (in *ContainerDiagnosticList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticRunStatus) DeepCopyInto(out *ContainerDiagnosticRunStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticRunStatus.
+func (in *ContainerDiagnosticRunStatus) DeepCopy() *ContainerDiagnosticRunStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticRunStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticRunTemplate) DeepCopyInto(out *ContainerDiagnosticRunTemplate) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticRunTemplate.
+func (in *ContainerDiagnosticRunTemplate) DeepCopy() *ContainerDiagnosticRunTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticRunTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ContainerDiagnosticSpec) DeepCopyInto(out *ContainerDiagnosticSpec) {
 	*out = *in
@@ -113,6 +249,68 @@ func (in *ContainerDiagnosticSpec) DeepCopyInto(out *ContainerDiagnosticSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Containers != nil {
+		in, out := &in.Containers, &out.Containers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludeContainers != nil {
+		in, out := &in.ExcludeContainers, &out.ExcludeContainers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.TemplateRef != nil {
+		in, out := &in.TemplateRef, &out.TemplateRef
+		*out = new(TemplateReference)
+		**out = **in
+	}
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ExpandArchives != nil {
+		in, out := &in.ExpandArchives, &out.ExpandArchives
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RedactPatterns != nil {
+		in, out := &in.RedactPatterns, &out.RedactPatterns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Logs != nil {
+		in, out := &in.Logs, &out.Logs
+		*out = new(ContainerLogs)
+		**out = **in
+	}
+	if in.Watch != nil {
+		in, out := &in.Watch, &out.Watch
+		*out = new(ContainerWatch)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(BundleEncryption)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3Upload)
+		**out = **in
+	}
+	if in.TTLSecondsAfterFinished != nil {
+		in, out := &in.TTLSecondsAfterFinished, &out.TTLSecondsAfterFinished
+		*out = new(int32)
+		**out = **in
+	}
+	if in.RunsHistoryLimit != nil {
+		in, out := &in.RunsHistoryLimit, &out.RunsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticSpec.
@@ -128,6 +326,33 @@ func (in *ContainerDiagnosticSpec) DeepCopy() *ContainerDiagnosticSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ContainerDiagnosticStatus) DeepCopyInto(out *ContainerDiagnosticStatus) {
 	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Runs != nil {
+		in, out := &in.Runs, &out.Runs
+		*out = make([]ContainerDiagnosticRunStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Watches != nil {
+		in, out := &in.Watches, &out.Watches
+		*out = make([]ContainerWatchStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Template != nil {
+		in, out := &in.Template, &out.Template
+		*out = new(ContainerDiagnosticTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticStatus.
@@ -148,6 +373,41 @@ func (in *ContainerDiagnosticStep) DeepCopyInto(out *ContainerDiagnosticStep) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Filter != nil {
+		in, out := &in.Filter, &out.Filter
+		*out = new(PackageFilter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ExecCommand != nil {
+		in, out := &in.ExecCommand, &out.ExecCommand
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Args != nil {
+		in, out := &in.Args, &out.Args
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]StepEnvVar, len(*in))
+		copy(*out, *in)
+	}
+	if in.CaptureStdout != nil {
+		in, out := &in.CaptureStdout, &out.CaptureStdout
+		*out = new(bool)
+		**out = **in
+	}
+	if in.IgnoreErrors != nil {
+		in, out := &in.IgnoreErrors, &out.IgnoreErrors
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Repeat != nil {
+		in, out := &in.Repeat, &out.Repeat
+		*out = new(StepRepeat)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticStep.
@@ -159,3 +419,586 @@ func (in *ContainerDiagnosticStep) DeepCopy() *ContainerDiagnosticStep {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticTemplate) DeepCopyInto(out *ContainerDiagnosticTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticTemplate.
+func (in *ContainerDiagnosticTemplate) DeepCopy() *ContainerDiagnosticTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ContainerDiagnosticTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticTemplateDefaults) DeepCopyInto(out *ContainerDiagnosticTemplateDefaults) {
+	*out = *in
+	if in.ExpandArchives != nil {
+		in, out := &in.ExpandArchives, &out.ExpandArchives
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.RedactPatterns != nil {
+		in, out := &in.RedactPatterns, &out.RedactPatterns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Logs != nil {
+		in, out := &in.Logs, &out.Logs
+		*out = new(ContainerLogs)
+		**out = **in
+	}
+	if in.Watch != nil {
+		in, out := &in.Watch, &out.Watch
+		*out = new(ContainerWatch)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticTemplateDefaults.
+func (in *ContainerDiagnosticTemplateDefaults) DeepCopy() *ContainerDiagnosticTemplateDefaults {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticTemplateDefaults)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticTemplateList) DeepCopyInto(out *ContainerDiagnosticTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ContainerDiagnosticTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticTemplateList.
+func (in *ContainerDiagnosticTemplateList) DeepCopy() *ContainerDiagnosticTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ContainerDiagnosticTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticTemplateSpec) DeepCopyInto(out *ContainerDiagnosticTemplateSpec) {
+	*out = *in
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make([]TemplateParameter, len(*in))
+		copy(*out, *in)
+	}
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]ContainerDiagnosticStep, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	in.Defaults.DeepCopyInto(&out.Defaults)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticTemplateSpec.
+func (in *ContainerDiagnosticTemplateSpec) DeepCopy() *ContainerDiagnosticTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticTrigger) DeepCopyInto(out *ContainerDiagnosticTrigger) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticTrigger.
+func (in *ContainerDiagnosticTrigger) DeepCopy() *ContainerDiagnosticTrigger {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticTrigger)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ContainerDiagnosticTrigger) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticTriggerList) DeepCopyInto(out *ContainerDiagnosticTriggerList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ContainerDiagnosticTrigger, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticTriggerList.
+func (in *ContainerDiagnosticTriggerList) DeepCopy() *ContainerDiagnosticTriggerList {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticTriggerList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ContainerDiagnosticTriggerList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticTriggerSpec) DeepCopyInto(out *ContainerDiagnosticTriggerSpec) {
+	*out = *in
+	if in.TargetLabelSelectors != nil {
+		in, out := &in.TargetLabelSelectors, &out.TargetLabelSelectors
+		*out = make([]metav1.LabelSelector, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Containers != nil {
+		in, out := &in.Containers, &out.Containers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Events != nil {
+		in, out := &in.Events, &out.Events
+		*out = make([]TriggerEvent, len(*in))
+		copy(*out, *in)
+	}
+	if in.CooldownSeconds != nil {
+		in, out := &in.CooldownSeconds, &out.CooldownSeconds
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxRunsPerHour != nil {
+		in, out := &in.MaxRunsPerHour, &out.MaxRunsPerHour
+		*out = new(int32)
+		**out = **in
+	}
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticTriggerSpec.
+func (in *ContainerDiagnosticTriggerSpec) DeepCopy() *ContainerDiagnosticTriggerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticTriggerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticTriggerStatus) DeepCopyInto(out *ContainerDiagnosticTriggerStatus) {
+	*out = *in
+	if in.LastTriggerTime != nil {
+		in, out := &in.LastTriggerTime, &out.LastTriggerTime
+		*out = (*in).DeepCopy()
+	}
+	if in.RecentTriggerTimes != nil {
+		in, out := &in.RecentTriggerTimes, &out.RecentTriggerTimes
+		*out = make([]metav1.Time, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Workloads != nil {
+		in, out := &in.Workloads, &out.Workloads
+		*out = make([]TriggeredWorkload, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ObservedTime != nil {
+		in, out := &in.ObservedTime, &out.ObservedTime
+		*out = (*in).DeepCopy()
+	}
+	if in.ObservedContainers != nil {
+		in, out := &in.ObservedContainers, &out.ObservedContainers
+		*out = make([]ObservedContainer, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticTriggerStatus.
+func (in *ContainerDiagnosticTriggerStatus) DeepCopy() *ContainerDiagnosticTriggerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticTriggerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerLogs) DeepCopyInto(out *ContainerLogs) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerLogs.
+func (in *ContainerLogs) DeepCopy() *ContainerLogs {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerLogs)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerWatch) DeepCopyInto(out *ContainerWatch) {
+	*out = *in
+	if in.CPUThresholdMillicores != nil {
+		in, out := &in.CPUThresholdMillicores, &out.CPUThresholdMillicores
+		*out = new(int64)
+		**out = **in
+	}
+	if in.MemoryThresholdMB != nil {
+		in, out := &in.MemoryThresholdMB, &out.MemoryThresholdMB
+		*out = new(int64)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerWatch.
+func (in *ContainerWatch) DeepCopy() *ContainerWatch {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerWatch)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerWatchStatus) DeepCopyInto(out *ContainerWatchStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerWatchStatus.
+func (in *ContainerWatchStatus) DeepCopy() *ContainerWatchStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerWatchStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObservedContainer) DeepCopyInto(out *ObservedContainer) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObservedContainer.
+func (in *ObservedContainer) DeepCopy() *ObservedContainer {
+	if in == nil {
+		return nil
+	}
+	out := new(ObservedContainer)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PackageFilter) DeepCopyInto(out *PackageFilter) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageFilter.
+func (in *PackageFilter) DeepCopy() *PackageFilter {
+	if in == nil {
+		return nil
+	}
+	out := new(PackageFilter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3Upload) DeepCopyInto(out *S3Upload) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3Upload.
+func (in *S3Upload) DeepCopy() *S3Upload {
+	if in == nil {
+		return nil
+	}
+	out := new(S3Upload)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScheduledContainerDiagnostic) DeepCopyInto(out *ScheduledContainerDiagnostic) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScheduledContainerDiagnostic.
+func (in *ScheduledContainerDiagnostic) DeepCopy() *ScheduledContainerDiagnostic {
+	if in == nil {
+		return nil
+	}
+	out := new(ScheduledContainerDiagnostic)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScheduledContainerDiagnostic) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScheduledContainerDiagnosticList) DeepCopyInto(out *ScheduledContainerDiagnosticList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ScheduledContainerDiagnostic, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScheduledContainerDiagnosticList.
+func (in *ScheduledContainerDiagnosticList) DeepCopy() *ScheduledContainerDiagnosticList {
+	if in == nil {
+		return nil
+	}
+	out := new(ScheduledContainerDiagnosticList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScheduledContainerDiagnosticList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScheduledContainerDiagnosticSpec) DeepCopyInto(out *ScheduledContainerDiagnosticSpec) {
+	*out = *in
+	if in.StartingDeadlineSeconds != nil {
+		in, out := &in.StartingDeadlineSeconds, &out.StartingDeadlineSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Suspend != nil {
+		in, out := &in.Suspend, &out.Suspend
+		*out = new(bool)
+		**out = **in
+	}
+	if in.SuccessfulRunsHistoryLimit != nil {
+		in, out := &in.SuccessfulRunsHistoryLimit, &out.SuccessfulRunsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.FailedRunsHistoryLimit != nil {
+		in, out := &in.FailedRunsHistoryLimit, &out.FailedRunsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScheduledContainerDiagnosticSpec.
+func (in *ScheduledContainerDiagnosticSpec) DeepCopy() *ScheduledContainerDiagnosticSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScheduledContainerDiagnosticSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScheduledContainerDiagnosticStatus) DeepCopyInto(out *ScheduledContainerDiagnosticStatus) {
+	*out = *in
+	if in.Active != nil {
+		in, out := &in.Active, &out.Active
+		*out = make([]corev1.ObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastScheduleTime != nil {
+		in, out := &in.LastScheduleTime, &out.LastScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessfulTime != nil {
+		in, out := &in.LastSuccessfulTime, &out.LastSuccessfulTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScheduledContainerDiagnosticStatus.
+func (in *ScheduledContainerDiagnosticStatus) DeepCopy() *ScheduledContainerDiagnosticStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScheduledContainerDiagnosticStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StepEnvVar) DeepCopyInto(out *StepEnvVar) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StepEnvVar.
+func (in *StepEnvVar) DeepCopy() *StepEnvVar {
+	if in == nil {
+		return nil
+	}
+	out := new(StepEnvVar)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *StepRepeat) DeepCopyInto(out *StepRepeat) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new StepRepeat.
+func (in *StepRepeat) DeepCopy() *StepRepeat {
+	if in == nil {
+		return nil
+	}
+	out := new(StepRepeat)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TemplateParameter) DeepCopyInto(out *TemplateParameter) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TemplateParameter.
+func (in *TemplateParameter) DeepCopy() *TemplateParameter {
+	if in == nil {
+		return nil
+	}
+	out := new(TemplateParameter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TemplateReference) DeepCopyInto(out *TemplateReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TemplateReference.
+func (in *TemplateReference) DeepCopy() *TemplateReference {
+	if in == nil {
+		return nil
+	}
+	out := new(TemplateReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TriggeredWorkload) DeepCopyInto(out *TriggeredWorkload) {
+	*out = *in
+	in.LastTriggerTime.DeepCopyInto(&out.LastTriggerTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TriggeredWorkload.
+func (in *TriggeredWorkload) DeepCopy() *TriggeredWorkload {
+	if in == nil {
+		return nil
+	}
+	out := new(TriggeredWorkload)
+	in.DeepCopyInto(out)
+	return out
+}
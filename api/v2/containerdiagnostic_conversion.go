@@ -0,0 +1,647 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// The annotation of a v2 ContainerDiagnostic with the fields of the v1 object that v2 can't
+// represent exactly (the deprecated spec arguments, steps such as an execute step with the
+// arguments split into multiple strings, and the template of the current run) so that they
+// aren't lost when the object is converted back to v1 (the storage version). The annotation
+// isn't stored.
+const V1ConversionDataAnnotation = "diagnostic.ibm.com/v1-conversion-data"
+
+// V1ConversionData is the value of V1ConversionDataAnnotation
+type V1ConversionData struct {
+	Arguments []string                            `json:"arguments,omitempty"`
+	Steps     []v1.ContainerDiagnosticStep        `json:"steps,omitempty"`
+	Template  *v1.ContainerDiagnosticTemplateSpec `json:"template,omitempty"`
+}
+
+// The v1 status code and status message of each phase
+var phaseStatusCodes = []struct {
+	phase         DiagnosticPhase
+	statusCode    int
+	statusMessage string
+}{
+	{DiagnosticPhasePending, 0, ""},
+	{DiagnosticPhaseRunning, 1, "processing"},
+	{DiagnosticPhaseSucceeded, 2, "success"},
+	{DiagnosticPhaseFailed, 3, "error"},
+	{DiagnosticPhaseMixed, 4, "mixed"},
+}
+
+var _ conversion.Convertible = &ContainerDiagnostic{}
+
+// ConvertTo converts this ContainerDiagnostic to the hub version (v1)
+func (src *ContainerDiagnostic) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1.ContainerDiagnostic)
+	in := src.DeepCopy()
+
+	dst.ObjectMeta = in.ObjectMeta
+
+	data := &V1ConversionData{}
+	if value, ok := dst.Annotations[V1ConversionDataAnnotation]; ok {
+		// The annotation may have been edited so it's ignored if it can't be parsed
+		if err := json.Unmarshal([]byte(value), data); err != nil {
+			data = &V1ConversionData{}
+		}
+		delete(dst.Annotations, V1ConversionDataAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	// The original v1 steps are only used if the v2 steps haven't been changed
+	var steps []v1.ContainerDiagnosticStep
+	if data.Steps != nil && equality.Semantic.DeepEqual(ConvertStepsFromV1(data.Steps), in.Spec.Steps) {
+		steps = data.Steps
+	} else {
+		var err error
+		steps, err = ConvertStepsToV1(in.Spec.Steps)
+		if err != nil {
+			return err
+		}
+	}
+
+	spec := &in.Spec
+	dst.Spec = v1.ContainerDiagnosticSpec{
+		Command:                 ConvertTypeToCommand(spec.Type),
+		Arguments:               data.Arguments,
+		TargetObjects:           spec.Targets.Objects,
+		TargetLabelSelectors:    spec.Targets.LabelSelectors,
+		Steps:                   steps,
+		Containers:              spec.Targets.Containers,
+		ExcludeContainers:       spec.Targets.ExcludeContainers,
+		Parameters:              spec.Parameters,
+		Directory:               ConvertDirectoryToV1(spec.Directory),
+		MinDiskSpaceFreeMB:      spec.MinDiskSpaceFreeMB,
+		UseUUID:                 spec.UniqueDirectory == nil || *spec.UniqueDirectory,
+		Debug:                   spec.Debug,
+		ExpandArchives:          spec.Bundle.ExpandArchives,
+		OutputFormat:            spec.Bundle.Format,
+		CompressionLevel:        spec.Bundle.CompressionLevel,
+		MaxBundleSizeMB:         spec.Bundle.MaxSizeMB,
+		DisableRedaction:        spec.Redaction.Disabled,
+		RedactPatterns:          spec.Redaction.Patterns,
+		DumpAllPods:             spec.DumpAllPods,
+		TTLSecondsAfterFinished: ConvertDurationToSeconds32(spec.TTLAfterFinished),
+		RunGeneration:           spec.RunGeneration,
+		RunsHistoryLimit:        spec.RunsHistoryLimit,
+	}
+
+	if spec.TemplateRef != nil {
+		dst.Spec.TemplateRef = &v1.TemplateReference{Kind: spec.TemplateRef.Kind, Name: spec.TemplateRef.Name}
+	}
+
+	if spec.Logs != nil {
+		dst.Spec.Logs = &v1.ContainerLogs{
+			Disabled:     spec.Logs.Disabled,
+			TailLines:    spec.Logs.TailLines,
+			SinceSeconds: ConvertDurationToSeconds(spec.Logs.Since),
+			MaxSizeMB:    spec.Logs.MaxSizeMB,
+		}
+	}
+
+	if spec.Watch != nil {
+		dst.Spec.Watch = &v1.ContainerWatch{
+			CPUThresholdMillicores: spec.Watch.CPUThresholdMillicores,
+			MemoryThresholdMB:      spec.Watch.MemoryThresholdMB,
+			IntervalSeconds:        int(ConvertDurationToSeconds(spec.Watch.Interval)),
+			ConsecutiveSamples:     spec.Watch.ConsecutiveSamples,
+			TimeoutSeconds:         ConvertDurationToSeconds(spec.Watch.Timeout),
+		}
+	}
+
+	if spec.Encryption != nil {
+		dst.Spec.Encryption = &v1.BundleEncryption{
+			SecretKeyRef:    spec.Encryption.SecretKeyRef,
+			ConfigMapKeyRef: spec.Encryption.ConfigMapKeyRef,
+		}
+	}
+
+	if spec.S3 != nil {
+		dst.Spec.S3 = &v1.S3Upload{
+			Endpoint:             spec.S3.Endpoint,
+			Bucket:               spec.S3.Bucket,
+			Prefix:               spec.S3.Prefix,
+			Region:               spec.S3.Region,
+			SecretRef:            spec.S3.SecretRef,
+			Insecure:             spec.S3.Insecure,
+			ServerSideEncryption: spec.S3.ServerSideEncryption,
+			KMSKeyID:             spec.S3.KMSKeyID,
+			PresignExpirySeconds: int(ConvertDurationToSeconds(spec.S3.PresignExpiry)),
+		}
+	}
+
+	status := &in.Status
+	statusCode, statusMessage := ConvertPhaseToStatusCode(status.Phase)
+	dst.Status = v1.ContainerDiagnosticStatus{
+		StatusCode:        statusCode,
+		StatusMessage:     statusMessage,
+		Result:            status.Message,
+		Log:               status.Log,
+		Download:          status.Download.Location,
+		DownloadPath:      status.Download.Path,
+		DownloadFileName:  status.Download.FileName,
+		DownloadContainer: status.Download.Container,
+		DownloadNamespace: status.Download.Namespace,
+		DownloadPod:       status.Download.Pod,
+		DownloadMissing:   status.Download.Missing,
+		UploadURL:         status.Download.UploadURL,
+		DownloadURL:       status.Download.URL,
+		SkippedFiles:      status.SkippedFiles,
+		Redactions:        status.Redactions,
+		StartTime:         status.StartTime,
+		CompletionTime:    status.CompletionTime,
+		RunGeneration:     status.RunGeneration,
+		Template:          data.Template,
+	}
+
+	for _, run := range status.Runs {
+		runStatusCode, runStatusMessage := ConvertPhaseToStatusCode(run.Phase)
+		dst.Status.Runs = append(dst.Status.Runs, v1.ContainerDiagnosticRunStatus{
+			RunGeneration:   run.RunGeneration,
+			StatusCode:      runStatusCode,
+			StatusMessage:   runStatusMessage,
+			Result:          run.Message,
+			Download:        run.Location,
+			DownloadPath:    run.DownloadPath,
+			DownloadMissing: run.DownloadMissing,
+			UploadURL:       run.UploadURL,
+			StartTime:       run.StartTime,
+			CompletionTime:  run.CompletionTime,
+		})
+	}
+
+	for _, watch := range status.Watches {
+		dst.Status.Watches = append(dst.Status.Watches, v1.ContainerWatchStatus(watch))
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the hub version (v1) to this version
+func (dst *ContainerDiagnostic) ConvertFrom(srcRaw conversion.Hub) error {
+	in := srcRaw.(*v1.ContainerDiagnostic).DeepCopy()
+
+	dst.ObjectMeta = in.ObjectMeta
+	delete(dst.Annotations, V1ConversionDataAnnotation)
+
+	spec := &in.Spec
+	steps := ConvertStepsFromV1(spec.Steps)
+
+	data := &V1ConversionData{Arguments: spec.Arguments, Template: in.Status.Template}
+	if convertedSteps, err := ConvertStepsToV1(steps); err != nil || !equality.Semantic.DeepEqual(convertedSteps, spec.Steps) {
+		data.Steps = spec.Steps
+	}
+	if data.Arguments != nil || data.Steps != nil || data.Template != nil {
+		value, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if dst.Annotations == nil {
+			dst.Annotations = map[string]string{}
+		}
+		dst.Annotations[V1ConversionDataAnnotation] = string(value)
+	}
+
+	uniqueDirectory := spec.UseUUID
+	dst.Spec = ContainerDiagnosticSpec{
+		Type: ConvertCommandToType(spec.Command),
+		Targets: DiagnosticTargets{
+			Objects:           spec.TargetObjects,
+			LabelSelectors:    spec.TargetLabelSelectors,
+			Containers:        spec.Containers,
+			ExcludeContainers: spec.ExcludeContainers,
+		},
+		Steps:              steps,
+		Parameters:         spec.Parameters,
+		Directory:          ConvertDirectoryFromV1(spec.Directory),
+		UniqueDirectory:    &uniqueDirectory,
+		MinDiskSpaceFreeMB: spec.MinDiskSpaceFreeMB,
+		Debug:              spec.Debug,
+		Bundle: BundleOptions{
+			Format:           spec.OutputFormat,
+			CompressionLevel: spec.CompressionLevel,
+			MaxSizeMB:        spec.MaxBundleSizeMB,
+			ExpandArchives:   spec.ExpandArchives,
+		},
+		Redaction: RedactionOptions{
+			Disabled: spec.DisableRedaction,
+			Patterns: spec.RedactPatterns,
+		},
+		DumpAllPods:      spec.DumpAllPods,
+		TTLAfterFinished: ConvertSeconds32ToDuration(spec.TTLSecondsAfterFinished),
+		RunGeneration:    spec.RunGeneration,
+		RunsHistoryLimit: spec.RunsHistoryLimit,
+	}
+
+	if spec.TemplateRef != nil {
+		dst.Spec.TemplateRef = &TemplateReference{Kind: spec.TemplateRef.Kind, Name: spec.TemplateRef.Name}
+	}
+
+	if spec.Logs != nil {
+		dst.Spec.Logs = &ContainerLogs{
+			Disabled:  spec.Logs.Disabled,
+			TailLines: spec.Logs.TailLines,
+			Since:     ConvertSecondsToDuration(spec.Logs.SinceSeconds),
+			MaxSizeMB: spec.Logs.MaxSizeMB,
+		}
+	}
+
+	if spec.Watch != nil {
+		dst.Spec.Watch = &ContainerWatch{
+			CPUThresholdMillicores: spec.Watch.CPUThresholdMillicores,
+			MemoryThresholdMB:      spec.Watch.MemoryThresholdMB,
+			Interval:               ConvertSecondsToDuration(int64(spec.Watch.IntervalSeconds)),
+			ConsecutiveSamples:     spec.Watch.ConsecutiveSamples,
+			Timeout:                ConvertSecondsToDuration(spec.Watch.TimeoutSeconds),
+		}
+	}
+
+	if spec.Encryption != nil {
+		dst.Spec.Encryption = &BundleEncryption{
+			SecretKeyRef:    spec.Encryption.SecretKeyRef,
+			ConfigMapKeyRef: spec.Encryption.ConfigMapKeyRef,
+		}
+	}
+
+	if spec.S3 != nil {
+		dst.Spec.S3 = &S3Upload{
+			Endpoint:             spec.S3.Endpoint,
+			Bucket:               spec.S3.Bucket,
+			Prefix:               spec.S3.Prefix,
+			Region:               spec.S3.Region,
+			SecretRef:            spec.S3.SecretRef,
+			Insecure:             spec.S3.Insecure,
+			ServerSideEncryption: spec.S3.ServerSideEncryption,
+			KMSKeyID:             spec.S3.KMSKeyID,
+			PresignExpiry:        ConvertSecondsToDuration(int64(spec.S3.PresignExpirySeconds)),
+		}
+	}
+
+	status := &in.Status
+	dst.Status = ContainerDiagnosticStatus{
+		Phase:      ConvertStatusCodeToPhase(status.StatusCode),
+		Message:    status.Result,
+		Conditions: NewConditions(in),
+		Log:        status.Log,
+		Download: DownloadStatus{
+			Location:  status.Download,
+			Path:      status.DownloadPath,
+			FileName:  status.DownloadFileName,
+			Namespace: status.DownloadNamespace,
+			Pod:       status.DownloadPod,
+			Container: status.DownloadContainer,
+			Missing:   status.DownloadMissing,
+			URL:       status.DownloadURL,
+			UploadURL: status.UploadURL,
+		},
+		SkippedFiles:   status.SkippedFiles,
+		Redactions:     status.Redactions,
+		StartTime:      status.StartTime,
+		CompletionTime: status.CompletionTime,
+		RunGeneration:  status.RunGeneration,
+	}
+
+	for _, run := range status.Runs {
+		dst.Status.Runs = append(dst.Status.Runs, ContainerDiagnosticRunStatus{
+			RunGeneration:   run.RunGeneration,
+			Phase:           ConvertStatusCodeToPhase(run.StatusCode),
+			Message:         run.Result,
+			Location:        run.Download,
+			DownloadPath:    run.DownloadPath,
+			DownloadMissing: run.DownloadMissing,
+			UploadURL:       run.UploadURL,
+			StartTime:       run.StartTime,
+			CompletionTime:  run.CompletionTime,
+		})
+	}
+
+	for _, watch := range status.Watches {
+		dst.Status.Watches = append(dst.Status.Watches, ContainerWatchStatus(watch))
+	}
+
+	return nil
+}
+
+// ConvertStepsToV1 converts typed steps to the command and arguments of v1 steps
+func ConvertStepsToV1(steps []ContainerDiagnosticStep) ([]v1.ContainerDiagnosticStep, error) {
+	if steps == nil {
+		return nil, nil
+	}
+
+	result := make([]v1.ContainerDiagnosticStep, 0, len(steps))
+	for index, step := range steps {
+		kinds := 0
+		var converted v1.ContainerDiagnosticStep
+
+		if step.Install != nil {
+			kinds++
+			converted = v1.ContainerDiagnosticStep{Command: "install", Arguments: step.Install.Tools}
+		}
+		if step.Execute != nil {
+			kinds++
+			converted = v1.ContainerDiagnosticStep{
+				Command:       "execute",
+				ExecCommand:   step.Execute.Command,
+				Args:          step.Execute.Args,
+				WorkingDir:    step.Execute.WorkingDir,
+				Background:    step.Execute.Background,
+				CaptureStdout: step.Execute.CaptureStdout,
+				IgnoreErrors:  step.Execute.IgnoreErrors,
+				OutputFile:    step.Execute.OutputFile,
+			}
+			if step.Execute.Env != nil {
+				converted.Env = make([]v1.StepEnvVar, 0, len(step.Execute.Env))
+				for _, envVar := range step.Execute.Env {
+					converted.Env = append(converted.Env, v1.StepEnvVar{Name: envVar.Name, Value: envVar.Value})
+				}
+			}
+			if step.Execute.Repeat != nil {
+				converted.Repeat = &v1.StepRepeat{
+					Count:           step.Execute.Repeat.Count,
+					IntervalSeconds: int32(ConvertDurationToSeconds(step.Execute.Repeat.Interval)),
+				}
+			}
+		}
+		if step.Package != nil {
+			kinds++
+			converted = v1.ContainerDiagnosticStep{Command: "package", Arguments: step.Package.Paths}
+			if step.Package.Filter != nil {
+				converted.Filter = &v1.PackageFilter{
+					Include:               step.Package.Filter.Include,
+					Exclude:               step.Package.Filter.Exclude,
+					ModifiedWithinMinutes: int(ConvertDurationToMinutes(step.Package.Filter.ModifiedWithin)),
+					MaxFileSizeMB:         step.Package.Filter.MaxFileSizeMB,
+					NewestFiles:           step.Package.Filter.NewestFiles,
+				}
+			}
+		}
+		if step.Clean != nil {
+			kinds++
+			converted = v1.ContainerDiagnosticStep{Command: "clean", Arguments: step.Clean.Paths}
+		}
+
+		if kinds != 1 {
+			return nil, fmt.Errorf("spec.steps[%d] must have exactly one of install, execute, package, or clean", index)
+		}
+
+		result = append(result, converted)
+	}
+
+	return result, nil
+}
+
+// ConvertStepsFromV1 converts v1 steps to typed steps. A step with an unknown command has no
+// kind set.
+func ConvertStepsFromV1(steps []v1.ContainerDiagnosticStep) []ContainerDiagnosticStep {
+	if steps == nil {
+		return nil
+	}
+
+	result := make([]ContainerDiagnosticStep, 0, len(steps))
+	for _, step := range steps {
+		var converted ContainerDiagnosticStep
+
+		switch step.Command {
+		case "install":
+			// Each argument may have multiple tools separated by spaces
+			tools := []string{}
+			for _, commandLine := range step.Arguments {
+				tools = append(tools, strings.Fields(commandLine)...)
+			}
+			converted.Install = &InstallStep{Tools: tools}
+		case "execute":
+			converted.Execute = &ExecuteStep{
+				Command:       step.ExecCommand,
+				Args:          step.Args,
+				WorkingDir:    step.WorkingDir,
+				Background:    step.Background,
+				CaptureStdout: step.CaptureStdout,
+				IgnoreErrors:  step.IgnoreErrors,
+				OutputFile:    step.OutputFile,
+			}
+			if len(step.ExecCommand) == 0 {
+				// Like the controller, the arguments are one command line with an optional " &"
+				commandLine := strings.TrimSpace(strings.Join(step.Arguments, " "))
+				if commandLine == "&" || strings.HasSuffix(commandLine, " &") {
+					converted.Execute.Background = true
+					commandLine = strings.TrimSuffix(commandLine, "&")
+				}
+				converted.Execute.Command = strings.Fields(commandLine)
+
+				// The exit code of a command line is ignored by default
+				if converted.Execute.IgnoreErrors == nil {
+					ignoreErrors := true
+					converted.Execute.IgnoreErrors = &ignoreErrors
+				}
+			}
+			if step.Env != nil {
+				converted.Execute.Env = make([]EnvVar, 0, len(step.Env))
+				for _, envVar := range step.Env {
+					converted.Execute.Env = append(converted.Execute.Env, EnvVar{Name: envVar.Name, Value: envVar.Value})
+				}
+			}
+			if step.Repeat != nil {
+				converted.Execute.Repeat = &ExecuteRepeat{
+					Count:    step.Repeat.Count,
+					Interval: ConvertSecondsToDuration(int64(step.Repeat.IntervalSeconds)),
+				}
+			}
+		case "package":
+			converted.Package = &PackageStep{Paths: step.Arguments}
+			if step.Filter != nil {
+				converted.Package.Filter = &PackageFilter{
+					Include:        step.Filter.Include,
+					Exclude:        step.Filter.Exclude,
+					ModifiedWithin: ConvertMinutesToDuration(int64(step.Filter.ModifiedWithinMinutes)),
+					MaxFileSizeMB:  step.Filter.MaxFileSizeMB,
+					NewestFiles:    step.Filter.NewestFiles,
+				}
+			}
+		case "clean":
+			converted.Clean = &CleanStep{Paths: step.Arguments}
+		}
+
+		result = append(result, converted)
+	}
+
+	return result
+}
+
+func ConvertTypeToCommand(diagnosticType DiagnosticType) string {
+	switch diagnosticType {
+	case DiagnosticTypeScript:
+		return "script"
+	case DiagnosticTypeVersion:
+		return "version"
+	default:
+		return string(diagnosticType)
+	}
+}
+
+func ConvertCommandToType(command string) DiagnosticType {
+	switch command {
+	case "script":
+		return DiagnosticTypeScript
+	case "version":
+		return DiagnosticTypeVersion
+	default:
+		return DiagnosticType(command)
+	}
+}
+
+// ConvertDirectoryToV1 adds the trailing slash that v1 requires
+func ConvertDirectoryToV1(directory string) string {
+	if len(directory) > 0 && !strings.HasSuffix(directory, "/") {
+		return directory + "/"
+	}
+	return directory
+}
+
+// ConvertDirectoryFromV1 removes the trailing slash (other than of /)
+func ConvertDirectoryFromV1(directory string) string {
+	if len(directory) > 1 {
+		return strings.TrimSuffix(directory, "/")
+	}
+	return directory
+}
+
+func ConvertPhaseToStatusCode(phase DiagnosticPhase) (int, string) {
+	for _, phaseStatusCode := range phaseStatusCodes {
+		if phaseStatusCode.phase == phase {
+			return phaseStatusCode.statusCode, phaseStatusCode.statusMessage
+		}
+	}
+	return 0, ""
+}
+
+func ConvertStatusCodeToPhase(statusCode int) DiagnosticPhase {
+	for _, phaseStatusCode := range phaseStatusCodes {
+		if phaseStatusCode.statusCode == statusCode {
+			return phaseStatusCode.phase
+		}
+	}
+	return DiagnosticPhasePending
+}
+
+// NewConditions returns the conditions of the current run of a v1 ContainerDiagnostic
+func NewConditions(containerDiagnostic *v1.ContainerDiagnostic) []metav1.Condition {
+	status := &containerDiagnostic.Status
+	phase := ConvertStatusCodeToPhase(status.StatusCode)
+
+	lastTransitionTime := containerDiagnostic.CreationTimestamp
+	if status.CompletionTime != nil {
+		lastTransitionTime = *status.CompletionTime
+	} else if status.StartTime != nil {
+		lastTransitionTime = *status.StartTime
+	}
+
+	completed := metav1.Condition{
+		Type:               ConditionCompleted,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: containerDiagnostic.Generation,
+		LastTransitionTime: lastTransitionTime,
+		Reason:             string(phase),
+		Message:            status.Result,
+	}
+	succeeded := completed
+	succeeded.Type = ConditionSucceeded
+	succeeded.Status = metav1.ConditionUnknown
+
+	switch phase {
+	case DiagnosticPhaseSucceeded:
+		completed.Status = metav1.ConditionTrue
+		succeeded.Status = metav1.ConditionTrue
+	case DiagnosticPhaseFailed, DiagnosticPhaseMixed:
+		completed.Status = metav1.ConditionTrue
+		succeeded.Status = metav1.ConditionFalse
+	}
+
+	return []metav1.Condition{completed, succeeded}
+}
+
+// ConvertSecondsToDuration returns nil for 0 which means the default in v1
+func ConvertSecondsToDuration(seconds int64) *metav1.Duration {
+	if seconds == 0 {
+		return nil
+	}
+	return &metav1.Duration{Duration: time.Duration(seconds) * time.Second}
+}
+
+// ConvertDurationToSeconds rounds up to seconds so that a short duration doesn't become 0 (which
+// means the default in v1)
+func ConvertDurationToSeconds(duration *metav1.Duration) int64 {
+	if duration == nil {
+		return 0
+	}
+	return RoundUpDuration(duration.Duration, time.Second)
+}
+
+func ConvertMinutesToDuration(minutes int64) *metav1.Duration {
+	if minutes == 0 {
+		return nil
+	}
+	return &metav1.Duration{Duration: time.Duration(minutes) * time.Minute}
+}
+
+// ConvertDurationToMinutes rounds up to minutes so that a short duration doesn't become 0 (which
+// means no limit in v1)
+func ConvertDurationToMinutes(duration *metav1.Duration) int64 {
+	if duration == nil {
+		return 0
+	}
+	return RoundUpDuration(duration.Duration, time.Minute)
+}
+
+// ConvertSeconds32ToDuration keeps nil (unset) and 0 distinct
+func ConvertSeconds32ToDuration(seconds *int32) *metav1.Duration {
+	if seconds == nil {
+		return nil
+	}
+	return &metav1.Duration{Duration: time.Duration(*seconds) * time.Second}
+}
+
+func ConvertDurationToSeconds32(duration *metav1.Duration) *int32 {
+	if duration == nil {
+		return nil
+	}
+	seconds := int32(RoundUpDuration(duration.Duration, time.Second))
+	return &seconds
+}
+
+// RoundUpDuration returns the number of units in a positive duration rounded up
+func RoundUpDuration(duration time.Duration, unit time.Duration) int64 {
+	units := int64(duration / unit)
+	if duration > 0 && duration%unit != 0 {
+		units++
+	}
+	return units
+}
@@ -0,0 +1,615 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	v1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+func int32Pointer(value int32) *int32 {
+	return &value
+}
+
+func int64Pointer(value int64) *int64 {
+	return &value
+}
+
+func boolPointer(value bool) *bool {
+	return &value
+}
+
+func duration(value time.Duration) *metav1.Duration {
+	return &metav1.Duration{Duration: value}
+}
+
+func newTime(seconds int64) *metav1.Time {
+	value := metav1.NewTime(time.Unix(seconds, 0))
+	return &value
+}
+
+// newV1ContainerDiagnostic returns a v1 ContainerDiagnostic with every field set
+func newV1ContainerDiagnostic() *v1.ContainerDiagnostic {
+	return &v1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "diag1",
+			Generation:        2,
+			CreationTimestamp: metav1.NewTime(time.Unix(1000, 0)),
+			Labels:            map[string]string{"app": "app1"},
+			Annotations:       map[string]string{"note": "test"},
+		},
+		Spec: v1.ContainerDiagnosticSpec{
+			Command:              "script",
+			Arguments:            []string{"unused"},
+			TargetObjects:        []corev1.ObjectReference{{Kind: "Deployment", Name: "app1", Namespace: "default"}},
+			TargetLabelSelectors: []metav1.LabelSelector{{MatchLabels: map[string]string{"app": "app1"}}},
+			Steps: []v1.ContainerDiagnosticStep{
+				{Command: "install", Arguments: []string{"top", "linperf.sh"}},
+				{
+					Command:       "execute",
+					ExecCommand:   []string{"top", "-b", "-H"},
+					Args:          []string{"-d", "5", "-n", "2"},
+					Env:           []v1.StepEnvVar{{Name: "COLUMNS", Value: "512"}},
+					WorkingDir:    "/tmp",
+					Background:    true,
+					CaptureStdout: boolPointer(false),
+					IgnoreErrors:  boolPointer(true),
+					Repeat:        &v1.StepRepeat{Count: 3, IntervalSeconds: 10},
+					OutputFile:    "top.txt",
+				},
+				{Command: "package", Arguments: []string{"/logs/"}, Filter: &v1.PackageFilter{
+					Include:               []string{"*.log"},
+					Exclude:               []string{"*.tmp"},
+					ModifiedWithinMinutes: 30,
+					MaxFileSizeMB:         100,
+					NewestFiles:           10,
+				}},
+				{Command: "clean", Arguments: []string{"/tmp/extra"}},
+			},
+			Containers:         []string{"app"},
+			ExcludeContainers:  []string{"istio-proxy"},
+			TemplateRef:        &v1.TemplateReference{Kind: "ClusterContainerDiagnosticTemplate", Name: "template1"},
+			Parameters:         map[string]string{"seconds": "30"},
+			Directory:          "/tmp/containerdiag/",
+			MinDiskSpaceFreeMB: 15,
+			UseUUID:            true,
+			Debug:              true,
+			ExpandArchives:     []string{"*.tar.gz"},
+			OutputFormat:       "tar.zst",
+			CompressionLevel:   19,
+			MaxBundleSizeMB:    500,
+			DisableRedaction:   true,
+			RedactPatterns:     []string{"(?i)pin=(\\d+)"},
+			DumpAllPods:        true,
+			Logs:               &v1.ContainerLogs{Disabled: true, TailLines: 1000, SinceSeconds: 3600, MaxSizeMB: 10},
+			Watch: &v1.ContainerWatch{
+				CPUThresholdMillicores: int64Pointer(1500),
+				MemoryThresholdMB:      int64Pointer(2048),
+				IntervalSeconds:        10,
+				ConsecutiveSamples:     3,
+				TimeoutSeconds:         7200,
+			},
+			Encryption: &v1.BundleEncryption{
+				SecretKeyRef:    &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "keys"}, Key: "public.asc"},
+				ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "keys"}, Key: "public.asc"},
+			},
+			S3: &v1.S3Upload{
+				Endpoint:             "minio.minio.svc:9000",
+				Bucket:               "diagnostics",
+				Prefix:               "cluster1/",
+				Region:               "us-east-1",
+				SecretRef:            corev1.LocalObjectReference{Name: "s3"},
+				Insecure:             true,
+				ServerSideEncryption: "aws:kms",
+				KMSKeyID:             "key1",
+				PresignExpirySeconds: 86400,
+			},
+			TTLSecondsAfterFinished: int32Pointer(172800),
+			RunGeneration:           3,
+			RunsHistoryLimit:        int32Pointer(2),
+		},
+		Status: v1.ContainerDiagnosticStatus{
+			StatusCode:        3,
+			StatusMessage:     "error",
+			Result:            "Error: no pods",
+			Log:               "log",
+			Download:          "kubectl cp ...",
+			DownloadPath:      "/tmp/containerdiagoutput/containerdiag_1.zip",
+			DownloadFileName:  "containerdiag_1.zip",
+			DownloadContainer: "manager",
+			DownloadNamespace: "containerdiagoperator-system",
+			DownloadPod:       "manager-1",
+			DownloadMissing:   true,
+			UploadURL:         "http://minio/diagnostics/containerdiag_1.zip",
+			DownloadURL:       "https://manager/download/default/diag1",
+			SkippedFiles:      4,
+			Redactions:        5,
+			StartTime:         newTime(2000),
+			CompletionTime:    newTime(3000),
+			RunGeneration:     3,
+			Runs: []v1.ContainerDiagnosticRunStatus{
+				{
+					RunGeneration:   2,
+					StatusCode:      2,
+					StatusMessage:   "success",
+					Result:          "Successfully finished on 1 container",
+					Download:        "kubectl cp ...",
+					DownloadPath:    "/tmp/containerdiagoutput/containerdiag_0.zip",
+					DownloadMissing: true,
+					UploadURL:       "http://minio/diagnostics/containerdiag_0.zip",
+					StartTime:       newTime(1000),
+					CompletionTime:  newTime(1500),
+				},
+			},
+			Watches: []v1.ContainerWatchStatus{
+				{
+					Namespace:      "testns1",
+					Pod:            "liberty1-774c5fccc6-f7mjt",
+					Container:      "liberty1",
+					Directory:      "/tmp/containerdiag/tmp123/",
+					State:          "Failed",
+					Message:        "the pod no longer exists",
+					StartTime:      newTime(2000),
+					CompletionTime: newTime(2500),
+				},
+			},
+			Template: &v1.ContainerDiagnosticTemplateSpec{
+				Description: "Run top",
+				Parameters:  []v1.TemplateParameter{{Name: "seconds", Description: "The delay", Default: "10", Required: true}},
+				Steps: []v1.ContainerDiagnosticStep{
+					{Command: "install", Arguments: []string{"top"}},
+					{
+						Command:       "execute",
+						ExecCommand:   []string{"top", "-b", "-d", "${seconds}"},
+						Args:          []string{"-n", "2"},
+						Env:           []v1.StepEnvVar{{Name: "COLUMNS", Value: "512"}},
+						WorkingDir:    "/tmp",
+						Background:    true,
+						CaptureStdout: boolPointer(false),
+						IgnoreErrors:  boolPointer(true),
+						Repeat:        &v1.StepRepeat{Count: 3, IntervalSeconds: 10},
+						OutputFile:    "top.txt",
+					},
+					{Command: "package", Arguments: []string{"/logs/"}, Filter: &v1.PackageFilter{
+						Include:               []string{"*.log"},
+						Exclude:               []string{"*.tmp"},
+						ModifiedWithinMinutes: 30,
+						MaxFileSizeMB:         100,
+						NewestFiles:           10,
+					}},
+				},
+				Defaults: v1.ContainerDiagnosticTemplateDefaults{
+					ExpandArchives:   []string{"*.tar.gz"},
+					CompressionLevel: 19,
+					MaxBundleSizeMB:  500,
+					RedactPatterns:   []string{"(?i)pin=(\\d+)"},
+					Logs:             &v1.ContainerLogs{Disabled: true, TailLines: 1000, SinceSeconds: 3600, MaxSizeMB: 10},
+					Watch: &v1.ContainerWatch{
+						CPUThresholdMillicores: int64Pointer(1500),
+						MemoryThresholdMB:      int64Pointer(2048),
+						IntervalSeconds:        10,
+						ConsecutiveSamples:     3,
+						TimeoutSeconds:         7200,
+					},
+				},
+			},
+		},
+	}
+}
+
+// newV2ContainerDiagnostic returns a v2 ContainerDiagnostic with every field set
+func newV2ContainerDiagnostic() *ContainerDiagnostic {
+	return &ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "diag1",
+			Generation:        2,
+			CreationTimestamp: metav1.NewTime(time.Unix(1000, 0)),
+			Labels:            map[string]string{"app": "app1"},
+			Annotations:       map[string]string{"note": "test"},
+		},
+		Spec: ContainerDiagnosticSpec{
+			Type: DiagnosticTypeScript,
+			Targets: DiagnosticTargets{
+				Objects:           []corev1.ObjectReference{{Kind: "Deployment", Name: "app1", Namespace: "default"}},
+				LabelSelectors:    []metav1.LabelSelector{{MatchLabels: map[string]string{"app": "app1"}}},
+				Containers:        []string{"app"},
+				ExcludeContainers: []string{"istio-proxy"},
+			},
+			Steps: []ContainerDiagnosticStep{
+				{Install: &InstallStep{Tools: []string{"top", "linperf.sh"}}},
+				{Execute: &ExecuteStep{
+					Command:       []string{"top", "-b", "-H"},
+					Args:          []string{"-d", "5", "-n", "2"},
+					Env:           []EnvVar{{Name: "COLUMNS", Value: "512"}},
+					WorkingDir:    "/tmp",
+					Background:    true,
+					CaptureStdout: boolPointer(false),
+					IgnoreErrors:  boolPointer(true),
+					Repeat:        &ExecuteRepeat{Count: 3, Interval: &metav1.Duration{Duration: 10 * time.Second}},
+					OutputFile:    "top.txt",
+				}},
+				{Package: &PackageStep{Paths: []string{"/logs/"}, Filter: &PackageFilter{
+					Include:        []string{"*.log"},
+					Exclude:        []string{"*.tmp"},
+					ModifiedWithin: duration(30 * time.Minute),
+					MaxFileSizeMB:  100,
+					NewestFiles:    10,
+				}}},
+				{Clean: &CleanStep{Paths: []string{"/tmp/extra"}}},
+			},
+			TemplateRef:        &TemplateReference{Kind: "ClusterContainerDiagnosticTemplate", Name: "template1"},
+			Parameters:         map[string]string{"seconds": "30"},
+			Directory:          "/tmp/containerdiag",
+			UniqueDirectory:    boolPointer(false),
+			MinDiskSpaceFreeMB: 15,
+			Debug:              true,
+			Bundle: BundleOptions{
+				Format:           "tar.zst",
+				CompressionLevel: 19,
+				MaxSizeMB:        500,
+				ExpandArchives:   []string{"*.tar.gz"},
+			},
+			Redaction: RedactionOptions{
+				Disabled: true,
+				Patterns: []string{"(?i)pin=(\\d+)"},
+			},
+			DumpAllPods: true,
+			Logs:        &ContainerLogs{Disabled: true, TailLines: 1000, Since: duration(time.Hour), MaxSizeMB: 10},
+			Watch: &ContainerWatch{
+				CPUThresholdMillicores: int64Pointer(1500),
+				MemoryThresholdMB:      int64Pointer(2048),
+				Interval:               duration(10 * time.Second),
+				ConsecutiveSamples:     3,
+				Timeout:                duration(2 * time.Hour),
+			},
+			Encryption: &BundleEncryption{
+				SecretKeyRef:    &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "keys"}, Key: "public.asc"},
+				ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "keys"}, Key: "public.asc"},
+			},
+			S3: &S3Upload{
+				Endpoint:             "minio.minio.svc:9000",
+				Bucket:               "diagnostics",
+				Prefix:               "cluster1/",
+				Region:               "us-east-1",
+				SecretRef:            corev1.LocalObjectReference{Name: "s3"},
+				Insecure:             true,
+				ServerSideEncryption: "aws:kms",
+				KMSKeyID:             "key1",
+				PresignExpiry:        duration(24 * time.Hour),
+			},
+			TTLAfterFinished: duration(48 * time.Hour),
+			RunGeneration:    3,
+			RunsHistoryLimit: int32Pointer(2),
+		},
+		Status: ContainerDiagnosticStatus{
+			Phase:   DiagnosticPhaseMixed,
+			Message: "Mixed results; describe and review Events",
+			Conditions: []metav1.Condition{
+				{Type: ConditionCompleted, Status: metav1.ConditionTrue, ObservedGeneration: 2, LastTransitionTime: *newTime(3000), Reason: "Mixed", Message: "Mixed results; describe and review Events"},
+				{Type: ConditionSucceeded, Status: metav1.ConditionFalse, ObservedGeneration: 2, LastTransitionTime: *newTime(3000), Reason: "Mixed", Message: "Mixed results; describe and review Events"},
+			},
+			Log: "log",
+			Download: DownloadStatus{
+				Location:  "kubectl cp ...",
+				Path:      "/tmp/containerdiagoutput/containerdiag_1.zip",
+				FileName:  "containerdiag_1.zip",
+				Namespace: "containerdiagoperator-system",
+				Pod:       "manager-1",
+				Container: "manager",
+				Missing:   true,
+				URL:       "https://manager/download/default/diag1",
+				UploadURL: "http://minio/diagnostics/containerdiag_1.zip",
+			},
+			SkippedFiles:   4,
+			Redactions:     5,
+			StartTime:      newTime(2000),
+			CompletionTime: newTime(3000),
+			RunGeneration:  3,
+			Runs: []ContainerDiagnosticRunStatus{
+				{
+					RunGeneration:   2,
+					Phase:           DiagnosticPhaseSucceeded,
+					Message:         "Successfully finished on 1 container",
+					Location:        "kubectl cp ...",
+					DownloadPath:    "/tmp/containerdiagoutput/containerdiag_0.zip",
+					DownloadMissing: true,
+					UploadURL:       "http://minio/diagnostics/containerdiag_0.zip",
+					StartTime:       newTime(1000),
+					CompletionTime:  newTime(1500),
+				},
+			},
+			Watches: []ContainerWatchStatus{
+				{
+					Namespace:      "testns1",
+					Pod:            "liberty1-774c5fccc6-f7mjt",
+					Container:      "liberty1",
+					Directory:      "/tmp/containerdiag/tmp123/",
+					State:          "Failed",
+					Message:        "the pod no longer exists",
+					StartTime:      newTime(2000),
+					CompletionTime: newTime(2500),
+				},
+			},
+		},
+	}
+}
+
+// isOperatorType returns whether a type is one of the API types of this operator
+func isOperatorType(valueType reflect.Type) bool {
+	return valueType.Kind() == reflect.Struct && strings.HasPrefix(valueType.PkgPath(), "github.com/kgibm/containerdiagoperator/")
+}
+
+// fieldPaths adds the paths of the fields of the API types of this operator under a type
+func fieldPaths(path string, valueType reflect.Type, paths map[string]bool) {
+	switch valueType.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		fieldPaths(path, valueType.Elem(), paths)
+	case reflect.Struct:
+		if !isOperatorType(valueType) {
+			paths[path] = true
+			return
+		}
+		for i := 0; i < valueType.NumField(); i++ {
+			field := valueType.Field(i)
+			if field.Anonymous || field.Name == "ObjectMeta" {
+				continue
+			}
+			fieldPaths(path+"."+field.Name, field.Type, paths)
+		}
+	default:
+		paths[path] = true
+	}
+}
+
+// setFieldPaths adds the paths of the fields under a value that are set. Booleans count as set.
+func setFieldPaths(path string, value reflect.Value, paths map[string]bool) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			setFieldPaths(path, value.Elem(), paths)
+		}
+	case reflect.Slice:
+		for i := 0; i < value.Len(); i++ {
+			setFieldPaths(path, value.Index(i), paths)
+		}
+	case reflect.Struct:
+		if !isOperatorType(value.Type()) {
+			if !value.IsZero() {
+				paths[path] = true
+			}
+			return
+		}
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.Anonymous || field.Name == "ObjectMeta" {
+				continue
+			}
+			setFieldPaths(path+"."+field.Name, value.Field(i), paths)
+		}
+	default:
+		if !value.IsZero() || value.Kind() == reflect.Bool {
+			paths[path] = true
+		}
+	}
+}
+
+// checkAllFieldsSet fails if a field of the API types of this operator isn't set anywhere in
+// an object so that the round trips cover fields added in the future
+func checkAllFieldsSet(t *testing.T, object interface{}) {
+	all := map[string]bool{}
+	fieldPaths("", reflect.TypeOf(object), all)
+
+	set := map[string]bool{}
+	setFieldPaths("", reflect.ValueOf(object), set)
+
+	for path := range all {
+		if !set[path] {
+			t.Errorf("%T%s isn't set", object, path)
+		}
+	}
+}
+
+func TestConversionFixturesSetAllFields(t *testing.T) {
+	checkAllFieldsSet(t, newV1ContainerDiagnostic())
+	checkAllFieldsSet(t, newV2ContainerDiagnostic())
+}
+
+func TestConversionRoundTripFromV1(t *testing.T) {
+	original := newV1ContainerDiagnostic()
+	input := original.DeepCopy()
+
+	converted := &ContainerDiagnostic{}
+	if err := converted.ConvertFrom(input); err != nil {
+		t.Fatal(err)
+	}
+
+	if !equality.Semantic.DeepEqual(input, original) {
+		t.Errorf("ConvertFrom changed its input: %s", diff.ObjectReflectDiff(original, input))
+	}
+
+	result := &v1.ContainerDiagnostic{}
+	if err := converted.ConvertTo(result); err != nil {
+		t.Fatal(err)
+	}
+
+	if !equality.Semantic.DeepEqual(result, original) {
+		t.Errorf("v1 -> v2 -> v1 isn't the same: %s", diff.ObjectReflectDiff(original, result))
+	}
+}
+
+func TestConversionRoundTripFromV2(t *testing.T) {
+	original := newV2ContainerDiagnostic()
+	input := original.DeepCopy()
+
+	converted := &v1.ContainerDiagnostic{}
+	if err := input.ConvertTo(converted); err != nil {
+		t.Fatal(err)
+	}
+
+	if !equality.Semantic.DeepEqual(input, original) {
+		t.Errorf("ConvertTo changed its input: %s", diff.ObjectReflectDiff(original, input))
+	}
+
+	result := &ContainerDiagnostic{}
+	if err := result.ConvertFrom(converted); err != nil {
+		t.Fatal(err)
+	}
+
+	if !equality.Semantic.DeepEqual(result, original) {
+		t.Errorf("v2 -> v1 -> v2 isn't the same: %s", diff.ObjectReflectDiff(original, result))
+	}
+}
+
+func TestConversionToV2(t *testing.T) {
+	converted := &ContainerDiagnostic{}
+	if err := converted.ConvertFrom(newV1ContainerDiagnostic()); err != nil {
+		t.Fatal(err)
+	}
+
+	if converted.Spec.Type != DiagnosticTypeScript || converted.Spec.Directory != "/tmp/containerdiag" {
+		t.Errorf("unexpected type %s and directory %s", converted.Spec.Type, converted.Spec.Directory)
+	}
+
+	execute := converted.Spec.Steps[1].Execute
+	if execute == nil || len(execute.Command) != 3 || len(execute.Args) != 4 || !execute.Background || execute.Repeat.Interval.Duration != 10*time.Second {
+		t.Errorf("unexpected execute step: %+v", execute)
+	}
+
+	if converted.Status.Phase != DiagnosticPhaseFailed || converted.Status.Runs[0].Phase != DiagnosticPhaseSucceeded {
+		t.Errorf("unexpected phases %s and %s", converted.Status.Phase, converted.Status.Runs[0].Phase)
+	}
+
+	for _, condition := range converted.Status.Conditions {
+		want := metav1.ConditionTrue
+		if condition.Type == ConditionSucceeded {
+			want = metav1.ConditionFalse
+		}
+		if condition.Status != want || !condition.LastTransitionTime.Equal(newTime(3000)) {
+			t.Errorf("unexpected condition: %+v", condition)
+		}
+	}
+
+	// Only the deprecated spec arguments and the template of the run need the annotation
+	data := &V1ConversionData{}
+	if err := json.Unmarshal([]byte(converted.Annotations[V1ConversionDataAnnotation]), data); err != nil {
+		t.Fatal(err)
+	}
+	want := &V1ConversionData{Arguments: []string{"unused"}, Template: newV1ContainerDiagnostic().Status.Template}
+	if !equality.Semantic.DeepEqual(data, want) {
+		t.Errorf("unexpected %s: %s", V1ConversionDataAnnotation, diff.ObjectReflectDiff(want, data))
+	}
+}
+
+func TestConversionKeepsV1Steps(t *testing.T) {
+	original := newV1ContainerDiagnostic()
+	original.Spec.Arguments = nil
+	original.Spec.Steps = []v1.ContainerDiagnosticStep{
+		{Command: "install", Arguments: []string{"top ps"}},
+		{Command: "execute", Arguments: []string{"top", "-b", "-n", "1", "&"}},
+	}
+
+	converted := &ContainerDiagnostic{}
+	if err := converted.ConvertFrom(original.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := converted.Annotations[V1ConversionDataAnnotation]; !ok {
+		t.Fatalf("missing %s", V1ConversionDataAnnotation)
+	}
+	if tools := converted.Spec.Steps[0].Install.Tools; len(tools) != 2 {
+		t.Errorf("unexpected tools: %v", tools)
+	}
+	if execute := converted.Spec.Steps[1].Execute; len(execute.Command) != 4 || !execute.Background || !*execute.IgnoreErrors {
+		t.Errorf("unexpected execute step: %+v", execute)
+	}
+
+	// Unchanged steps are converted back to the original arguments
+	result := &v1.ContainerDiagnostic{}
+	if err := converted.DeepCopy().ConvertTo(result); err != nil {
+		t.Fatal(err)
+	}
+	if !equality.Semantic.DeepEqual(result, original) {
+		t.Errorf("v1 -> v2 -> v1 isn't the same: %s", diff.ObjectReflectDiff(original, result))
+	}
+
+	// Changed steps are converted from v2
+	converted.Spec.Steps[1].Execute.Command = []string{"top", "-b", "-n", "2"}
+	result = &v1.ContainerDiagnostic{}
+	if err := converted.ConvertTo(result); err != nil {
+		t.Fatal(err)
+	}
+	want := []v1.ContainerDiagnosticStep{
+		{Command: "install", Arguments: []string{"top", "ps"}},
+		{Command: "execute", ExecCommand: []string{"top", "-b", "-n", "2"}, Background: true, IgnoreErrors: boolPointer(true)},
+	}
+	if !equality.Semantic.DeepEqual(result.Spec.Steps, want) {
+		t.Errorf("unexpected steps: %s", diff.ObjectReflectDiff(want, result.Spec.Steps))
+	}
+	if _, ok := result.Annotations[V1ConversionDataAnnotation]; ok {
+		t.Errorf("%s is stored", V1ConversionDataAnnotation)
+	}
+}
+
+func TestConversionRejectsInvalidSteps(t *testing.T) {
+	for _, step := range []ContainerDiagnosticStep{
+		{},
+		{Install: &InstallStep{Tools: []string{"top"}}, Clean: &CleanStep{}},
+	} {
+		containerDiagnostic := &ContainerDiagnostic{Spec: ContainerDiagnosticSpec{Steps: []ContainerDiagnosticStep{step}}}
+		if err := containerDiagnostic.ConvertTo(&v1.ContainerDiagnostic{}); err == nil {
+			t.Errorf("no error for step %+v", step)
+		}
+	}
+}
+
+func TestConversionRoundsUpDurations(t *testing.T) {
+	original := newV2ContainerDiagnostic()
+	original.Spec.Watch.Interval = &metav1.Duration{Duration: 500 * time.Millisecond}
+	original.Spec.Logs.Since = &metav1.Duration{Duration: 90500 * time.Millisecond}
+	original.Spec.TTLAfterFinished = &metav1.Duration{Duration: 500 * time.Millisecond}
+	original.Spec.Steps[2].Package.Filter.ModifiedWithin = &metav1.Duration{Duration: 30 * time.Second}
+
+	converted := &v1.ContainerDiagnostic{}
+	if err := original.ConvertTo(converted); err != nil {
+		t.Fatal(err)
+	}
+
+	if converted.Spec.Watch.IntervalSeconds != 1 {
+		t.Errorf("unexpected watch.intervalSeconds: %d", converted.Spec.Watch.IntervalSeconds)
+	}
+	if converted.Spec.Logs.SinceSeconds != 91 {
+		t.Errorf("unexpected logs.sinceSeconds: %d", converted.Spec.Logs.SinceSeconds)
+	}
+	if *converted.Spec.TTLSecondsAfterFinished != 1 {
+		t.Errorf("unexpected ttlSecondsAfterFinished: %d", *converted.Spec.TTLSecondsAfterFinished)
+	}
+	if converted.Spec.Steps[2].Filter.ModifiedWithinMinutes != 1 {
+		t.Errorf("unexpected modifiedWithinMinutes: %d", converted.Spec.Steps[2].Filter.ModifiedWithinMinutes)
+	}
+}
@@ -0,0 +1,666 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DiagnosticType is what a ContainerDiagnostic does
+// +kubebuilder:validation:Enum=Script;Version
+type DiagnosticType string
+
+const (
+	// Run the steps in each target container and create a download
+	DiagnosticTypeScript DiagnosticType = "Script"
+
+	// Only report the version of the operator
+	DiagnosticTypeVersion DiagnosticType = "Version"
+)
+
+// DiagnosticPhase is the phase of the current run of a ContainerDiagnostic
+type DiagnosticPhase string
+
+const (
+	DiagnosticPhasePending   DiagnosticPhase = "Pending"
+	DiagnosticPhaseRunning   DiagnosticPhase = "Running"
+	DiagnosticPhaseSucceeded DiagnosticPhase = "Succeeded"
+	DiagnosticPhaseFailed    DiagnosticPhase = "Failed"
+
+	// Some target containers succeeded and some failed
+	DiagnosticPhaseMixed DiagnosticPhase = "Mixed"
+)
+
+// The types of Status.Conditions
+const (
+	// True when the current run has finished (successfully or not)
+	ConditionCompleted = "Completed"
+
+	// True when the current run finished successfully, False when it finished with errors,
+	// and Unknown while it's running
+	ConditionSucceeded = "Succeeded"
+)
+
+// ContainerDiagnosticStep is a step of a Script. Exactly one kind of step must be specified.
+type ContainerDiagnosticStep struct {
+	// Upload tools of the operator image to each target container.
+	// +kubebuilder:validation:Optional
+	Install *InstallStep `json:"install,omitempty"`
+
+	// Run a tool in each target container and collect its output.
+	// +kubebuilder:validation:Optional
+	Execute *ExecuteStep `json:"execute,omitempty"`
+
+	// Collect files from each target container.
+	// +kubebuilder:validation:Optional
+	Package *PackageStep `json:"package,omitempty"`
+
+	// Remove the uploaded tools and output from each target container.
+	// +kubebuilder:validation:Optional
+	Clean *CleanStep `json:"clean,omitempty"`
+}
+
+// InstallStep uploads tools of the operator image (with the shared libraries they need)
+type InstallStep struct {
+	// The names of the tools (e.g. top or linperf.sh).
+	// +kubebuilder:validation:MinItems=1
+	Tools []string `json:"tools"`
+}
+
+// ExecuteStep runs a tool and writes its output to a file that's collected
+type ExecuteStep struct {
+	// The tool and its arguments (e.g. ["top", "-b", "-H", "-n", "1"]). Each element is passed
+	// to the tool as-is without shell parsing.
+	// +kubebuilder:validation:MinItems=1
+	Command []string `json:"command"`
+
+	// Optional. More arguments appended to the command.
+	// +kubebuilder:validation:Optional
+	Args []string `json:"args,omitempty"`
+
+	// Optional. Environment variables set for the tool.
+	// +kubebuilder:validation:Optional
+	Env []EnvVar `json:"env,omitempty"`
+
+	// Optional. The directory the tool runs in. Defaults to the directory of the
+	// ContainerDiagnostic.
+	// +kubebuilder:validation:Optional
+	WorkingDir string `json:"workingDir,omitempty"`
+
+	// Optional. Whether to run the tool in the background and continue with the next step.
+	// Defaults to false.
+	// +kubebuilder:validation:Optional
+	Background bool `json:"background,omitempty"`
+
+	// Optional. Whether the standard output of the tool is written to the output file.
+	// Standard error is always written. Defaults to true.
+	// +kubebuilder:validation:Optional
+	CaptureStdout *bool `json:"captureStdout,omitempty"`
+
+	// Optional. Whether a non-zero exit code of the tool is ignored. Otherwise, the failure is
+	// reported in the status and the output is still collected. Defaults to false.
+	// +kubebuilder:validation:Optional
+	IgnoreErrors *bool `json:"ignoreErrors,omitempty"`
+
+	// Optional. Run the tool more than once.
+	// +kubebuilder:validation:Optional
+	Repeat *ExecuteRepeat `json:"repeat,omitempty"`
+
+	// Optional. The file name (in the directory) that the output is written to and collected.
+	// Defaults to containerdiag_<time>_<step>.txt.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^[^/]+$`
+	OutputFile string `json:"outputFile,omitempty"`
+}
+
+// EnvVar is an environment variable of an execute step
+type EnvVar struct {
+	// The name of the environment variable.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[A-Za-z_][A-Za-z0-9_]*$`
+	Name string `json:"name"`
+
+	// Optional. The value of the environment variable.
+	// +kubebuilder:validation:Optional
+	Value string `json:"value,omitempty"`
+}
+
+// ExecuteRepeat runs the tool of an execute step more than once
+type ExecuteRepeat struct {
+	// The number of times the tool is run.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	Count int32 `json:"count"`
+
+	// Optional. How long to wait between runs (e.g. 30s). Rounded up to seconds. Defaults
+	// to no wait.
+	// +kubebuilder:validation:Optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// PackageStep collects files and directories
+type PackageStep struct {
+	// The files or directories to collect.
+	// +kubebuilder:validation:MinItems=1
+	Paths []string `json:"paths"`
+
+	// Optional. Limits the files that are collected.
+	// +kubebuilder:validation:Optional
+	Filter *PackageFilter `json:"filter,omitempty"`
+}
+
+// CleanStep removes the uploaded tools and output
+type CleanStep struct {
+	// Optional. Additional files or directories to remove.
+	// +kubebuilder:validation:Optional
+	Paths []string `json:"paths,omitempty"`
+}
+
+// PackageFilter limits the files collected by a package step. The paths of the step are
+// searched for regular files and then the filters are applied in the order below. Skipped
+// files are listed in the bundle manifest.
+type PackageFilter struct {
+	// Optional. Only package files whose path or file name matches one of these
+	// patterns (e.g. "*.log"). Defaults to all files.
+	// +kubebuilder:validation:Optional
+	Include []string `json:"include,omitempty"`
+
+	// Optional. Skip files whose path or file name matches one of these patterns (e.g. "*.tmp").
+	// +kubebuilder:validation:Optional
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Optional. Skip files that were last modified longer ago than this (e.g. 30m). Rounded
+	// up to minutes.
+	// +kubebuilder:validation:Optional
+	ModifiedWithin *metav1.Duration `json:"modifiedWithin,omitempty"`
+
+	// Optional. Skip files larger than this many MB.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxFileSizeMB int `json:"maxFileSizeMB,omitempty"`
+
+	// Optional. Only package this many of the most recently modified files.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	NewestFiles int `json:"newestFiles,omitempty"`
+}
+
+// DiagnosticTargets selects the pods and containers that the steps run on
+type DiagnosticTargets struct {
+	// Optional. Pods or workloads (e.g. Deployments) whose pods are targeted.
+	// +kubebuilder:validation:Optional
+	Objects []corev1.ObjectReference `json:"objects,omitempty"`
+
+	// Optional. Pods with labels matching any of these selectors are targeted.
+	// +kubebuilder:validation:Optional
+	LabelSelectors []metav1.LabelSelector `json:"labelSelectors,omitempty"`
+
+	// Optional. The names of the containers of each target pod to run the steps on. Defaults
+	// to all containers.
+	// +kubebuilder:validation:Optional
+	Containers []string `json:"containers,omitempty"`
+
+	// Optional. The names of containers of each target pod to skip (e.g. sidecars). If
+	// neither Containers nor ExcludeContainers is specified, the defaults of the operator
+	// (e.g. istio-proxy) are set when the ContainerDiagnostic is created.
+	// +kubebuilder:validation:Optional
+	ExcludeContainers []string `json:"excludeContainers,omitempty"`
+}
+
+// TemplateReference refers to a ContainerDiagnosticTemplate in the namespace of the
+// ContainerDiagnostic or a ClusterContainerDiagnosticTemplate
+type TemplateReference struct {
+	// Optional. ContainerDiagnosticTemplate or ClusterContainerDiagnosticTemplate. Defaults to
+	// ContainerDiagnosticTemplate.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=ContainerDiagnosticTemplate;ClusterContainerDiagnosticTemplate
+	Kind string `json:"kind,omitempty"`
+
+	// The name of the template.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// BundleOptions configures the final download
+type BundleOptions struct {
+	// Optional. Format of the final archive: zip, tar.gz, or tar.zst. Defaults to zip.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=zip;tar.gz;tar.zst
+	// +kubebuilder:default=zip
+	Format string `json:"format,omitempty"`
+
+	// Optional. Compression level of the final archive. For zip and tar.gz, 1 (fastest)
+	// to 9 (smallest); for tar.zst, a zstd level of 1 (fastest) to 22 (smallest).
+	// Defaults to 0 which uses the default level of the Format.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=22
+	CompressionLevel int `json:"compressionLevel,omitempty"`
+
+	// Optional. The maximum total size (in MB, before compression) of the files collected
+	// by package steps across all containers. When the limit is reached, the most recently
+	// modified files are kept and the rest are skipped. Defaults to 0 which is unlimited.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxSizeMB int `json:"maxSizeMB,omitempty"`
+
+	// Optional. File name patterns (e.g. "*.tar.gz") of archives within the collected
+	// files to expand in the final archive. The archive of each container and
+	// linperf_RESULTS.tar.gz are always expanded.
+	// +kubebuilder:validation:Optional
+	ExpandArchives []string `json:"expandArchives,omitempty"`
+}
+
+// RedactionOptions configures the redaction of secrets from collected text files
+type RedactionOptions struct {
+	// Optional. Whether to disable redaction of secrets (e.g. passwords in environment
+	// variables, Liberty server.xml password attributes, and {xor} strings). Defaults to false.
+	// +kubebuilder:validation:Optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Optional. Additional regular expressions (RE2 syntax) of values to redact. If a regular
+	// expression has a capture group, only the first group is redacted (e.g. "(?i)pin=(\\d+)").
+	// +kubebuilder:validation:Optional
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// ContainerLogs configures collecting the logs of each target container through the
+// Kubernetes API (like kubectl logs)
+type ContainerLogs struct {
+	// Optional. Whether to skip collecting container logs. Defaults to false.
+	// +kubebuilder:validation:Optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Optional. The number of lines from the end of each log. Defaults to 0 which is all lines.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	TailLines int64 `json:"tailLines,omitempty"`
+
+	// Optional. Only lines written within this long before collection (e.g. 1h). Defaults to
+	// all lines.
+	// +kubebuilder:validation:Optional
+	Since *metav1.Duration `json:"since,omitempty"`
+
+	// Optional. The maximum size of each log in MB; the beginning of a larger log is collected.
+	// Defaults to 0 which is the operator's default of 50 MB.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxSizeMB int64 `json:"maxSizeMB,omitempty"`
+}
+
+// ContainerWatch waits in each target container until its CPU or memory usage crosses a
+// threshold before running the execute steps. Usage is sampled from the container's cgroup
+// (v1 or v2).
+type ContainerWatch struct {
+	// Optional. The CPU usage (in millicores, e.g. 1500 for 1.5 CPUs) averaged over each
+	// interval at or above which the threshold is crossed.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	CPUThresholdMillicores *int64 `json:"cpuThresholdMillicores,omitempty"`
+
+	// Optional. The memory usage (in MB, excluding inactive page cache like the kubelet's
+	// working set) at or above which the threshold is crossed.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	MemoryThresholdMB *int64 `json:"memoryThresholdMB,omitempty"`
+
+	// Optional. The time between samples (e.g. 10s). Defaults to 5s.
+	// +kubebuilder:validation:Optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Optional. The number of consecutive samples that must cross a threshold. Defaults to 1.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	ConsecutiveSamples int `json:"consecutiveSamples,omitempty"`
+
+	// Optional. The maximum time to wait (e.g. 2h). If no threshold is crossed, the execute
+	// steps are skipped and the samples are still collected. Defaults to 24h.
+	// +kubebuilder:validation:Optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// BundleEncryption configures encrypting the final download with OpenPGP public keys
+type BundleEncryption struct {
+	// Optional. A key of a Secret in the namespace of the ContainerDiagnostic with one or more
+	// ASCII-armored OpenPGP public keys of the recipients.
+	// +kubebuilder:validation:Optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+
+	// Optional. A key of a ConfigMap in the namespace of the ContainerDiagnostic with one or
+	// more ASCII-armored OpenPGP public keys of the recipients.
+	// +kubebuilder:validation:Optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// S3Upload configures uploading the final download to S3-compatible object storage
+type S3Upload struct {
+	// The host and optional port of the S3-compatible endpoint (e.g. s3.us-east-1.amazonaws.com or minio.minio.svc:9000).
+	// +kubebuilder:validation:Required
+	Endpoint string `json:"endpoint"`
+
+	// The name of the bucket.
+	// +kubebuilder:validation:Required
+	Bucket string `json:"bucket"`
+
+	// Optional. A prefix for the object name (e.g. "diagnostics/").
+	// +kubebuilder:validation:Optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// Optional. The region of the bucket. If not specified, it is looked up.
+	// +kubebuilder:validation:Optional
+	Region string `json:"region,omitempty"`
+
+	// A Secret in the namespace of the ContainerDiagnostic with the keys accessKeyID,
+	// secretAccessKey, and optionally sessionToken.
+	// +kubebuilder:validation:Required
+	SecretRef corev1.LocalObjectReference `json:"secretRef"`
+
+	// Optional. Whether to use plain HTTP instead of HTTPS. Defaults to false.
+	// +kubebuilder:validation:Optional
+	Insecure bool `json:"insecure,omitempty"`
+
+	// Optional. Server-side encryption of the object: AES256 (SSE-S3) or aws:kms (SSE-KMS).
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=AES256;"aws:kms"
+	ServerSideEncryption string `json:"serverSideEncryption,omitempty"`
+
+	// Optional. The KMS key ID if ServerSideEncryption is aws:kms.
+	// +kubebuilder:validation:Optional
+	KMSKeyID string `json:"kmsKeyID,omitempty"`
+
+	// Optional. If set, Status.Download.Location is a presigned link to the object that
+	// expires after this long (e.g. 24h, maximum 7 days). Otherwise, it is the object URL.
+	// +kubebuilder:validation:Optional
+	PresignExpiry *metav1.Duration `json:"presignExpiry,omitempty"`
+}
+
+// ContainerDiagnosticSpec defines the desired state of ContainerDiagnostic
+type ContainerDiagnosticSpec struct {
+
+	// Optional. Script or Version. Defaults to Script.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Script
+	Type DiagnosticType `json:"type,omitempty"`
+
+	// Optional. The pods and containers that the steps run on.
+	// +kubebuilder:validation:Optional
+	Targets DiagnosticTargets `json:"targets,omitempty"`
+
+	// Optional. The steps of a Script. If not specified, the steps of TemplateRef are used.
+	// +kubebuilder:validation:Optional
+	Steps []ContainerDiagnosticStep `json:"steps,omitempty"`
+
+	// Optional. A template with the steps and defaults of this ContainerDiagnostic. Options
+	// specified here override the defaults of the template.
+	// +kubebuilder:validation:Optional
+	TemplateRef *TemplateReference `json:"templateRef,omitempty"`
+
+	// Optional. Values of the parameters of TemplateRef that are substituted for ${name} in
+	// its steps.
+	// +kubebuilder:validation:Optional
+	Parameters map[string]string `json:"parameters,omitempty"`
+
+	// Optional. The directory in each target container for the uploaded tools and output.
+	// Defaults to /tmp/containerdiag.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default="/tmp/containerdiag"
+	Directory string `json:"directory,omitempty"`
+
+	// Optional. Whether to use a unique subdirectory of Directory for each run. Defaults to true.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=true
+	UniqueDirectory *bool `json:"uniqueDirectory,omitempty"`
+
+	// Optional. Minimum required disk space free (in MB) in the Directory. Defaults to 15.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=15
+	MinDiskSpaceFreeMB int `json:"minDiskSpaceFreeMB,omitempty"`
+
+	// Optional. Whether or not to debug the operator itself. Defaults to false.
+	// +kubebuilder:validation:Optional
+	Debug bool `json:"debug,omitempty"`
+
+	// Optional. Options of the final download.
+	// +kubebuilder:validation:Optional
+	Bundle BundleOptions `json:"bundle,omitempty"`
+
+	// Optional. Options for redacting secrets from collected text files before the final
+	// download is created.
+	// +kubebuilder:validation:Optional
+	Redaction RedactionOptions `json:"redaction,omitempty"`
+
+	// Optional. Whether to write every pod in the cluster to cluster/pods.txt rather than only
+	// the target pods. Defaults to false.
+	// +kubebuilder:validation:Optional
+	DumpAllPods bool `json:"dumpAllPods,omitempty"`
+
+	// Optional. Options for collecting the current and previous (if restarted) logs of each
+	// target container. By default, all of the logs are collected.
+	// +kubebuilder:validation:Optional
+	Logs *ContainerLogs `json:"logs,omitempty"`
+
+	// Optional. Wait in each target container until a CPU or memory threshold is crossed
+	// before running the execute steps.
+	// +kubebuilder:validation:Optional
+	Watch *ContainerWatch `json:"watch,omitempty"`
+
+	// Optional. Encrypt the final download with OpenPGP public keys so that only the recipients
+	// can open it.
+	// +kubebuilder:validation:Optional
+	Encryption *BundleEncryption `json:"encryption,omitempty"`
+
+	// Optional. Upload the final download to S3-compatible object storage.
+	// +kubebuilder:validation:Optional
+	S3 *S3Upload `json:"s3,omitempty"`
+
+	// Optional. If set, the ContainerDiagnostic and its download are deleted this long after
+	// it finishes (e.g. 24h). Rounded up to seconds.
+	// +kubebuilder:validation:Optional
+	TTLAfterFinished *metav1.Duration `json:"ttlAfterFinished,omitempty"`
+
+	// Optional. Increment to run a finished ContainerDiagnostic again. The finished run is
+	// added to Status.Runs.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	RunGeneration int64 `json:"runGeneration,omitempty"`
+
+	// Optional. The number of previous runs (and their downloads) kept in Status.Runs.
+	// Defaults to 5.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	RunsHistoryLimit *int32 `json:"runsHistoryLimit,omitempty"`
+}
+
+// DownloadStatus describes the final download of a run
+type DownloadStatus struct {
+	// How to get the download: a kubectl cp command, or the URL of the uploaded object if
+	// Spec.S3 is specified.
+	// +kubebuilder:validation:Optional
+	Location string `json:"location,omitempty"`
+
+	// The path of the download in the manager container.
+	// +kubebuilder:validation:Optional
+	Path string `json:"path,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	FileName string `json:"fileName,omitempty"`
+
+	// The namespace, pod, and container of the manager with the download.
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Pod string `json:"pod,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Container string `json:"container,omitempty"`
+
+	// Whether the download file no longer exists.
+	// +kubebuilder:validation:Optional
+	Missing bool `json:"missing,omitempty"`
+
+	// The URL of the download on the manager's download server (if enabled).
+	// +kubebuilder:validation:Optional
+	URL string `json:"url,omitempty"`
+
+	// The URL of the uploaded object if Spec.S3 is specified.
+	// +kubebuilder:validation:Optional
+	UploadURL string `json:"uploadURL,omitempty"`
+}
+
+// ContainerDiagnosticStatus defines the observed state of ContainerDiagnostic
+type ContainerDiagnosticStatus struct {
+
+	// The phase of the current run.
+	// +kubebuilder:validation:Optional
+	Phase DiagnosticPhase `json:"phase,omitempty"`
+
+	// A description of the result of the current run.
+	// +kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+
+	// The Completed and Succeeded conditions of the current run.
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Log string `json:"log,omitempty"`
+
+	// The final download of the current run.
+	// +kubebuilder:validation:Optional
+	Download DownloadStatus `json:"download,omitempty"`
+
+	// The number of files that weren't collected because of package step filters
+	// or Bundle.MaxSizeMB.
+	// +kubebuilder:validation:Optional
+	SkippedFiles int `json:"skippedFiles,omitempty"`
+
+	// The number of values redacted from the download.
+	// +kubebuilder:validation:Optional
+	Redactions int `json:"redactions,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// The Spec.RunGeneration of the current run.
+	// +kubebuilder:validation:Optional
+	RunGeneration int64 `json:"runGeneration,omitempty"`
+
+	// The previous runs (newest first) up to Spec.RunsHistoryLimit.
+	// +kubebuilder:validation:Optional
+	Runs []ContainerDiagnosticRunStatus `json:"runs,omitempty"`
+
+	// The watches of the target containers while waiting for a spec.watch threshold.
+	// +kubebuilder:validation:Optional
+	Watches []ContainerWatchStatus `json:"watches,omitempty"`
+}
+
+// ContainerWatchStatus is the state of the watch of a target container
+type ContainerWatchStatus struct {
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace"`
+
+	// +kubebuilder:validation:Optional
+	Pod string `json:"pod"`
+
+	// +kubebuilder:validation:Optional
+	Container string `json:"container"`
+
+	// The directory in the container with the watch script and its output.
+	// +kubebuilder:validation:Optional
+	Directory string `json:"directory,omitempty"`
+
+	// Watching, Crossed, TimedOut, or Failed.
+	// +kubebuilder:validation:Optional
+	State string `json:"state"`
+
+	// Why the watch failed (if it did).
+	// +kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// ContainerDiagnosticRunStatus is the status of a previous run of a ContainerDiagnostic
+type ContainerDiagnosticRunStatus struct {
+	// +kubebuilder:validation:Optional
+	RunGeneration int64 `json:"runGeneration,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Phase DiagnosticPhase `json:"phase,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Location string `json:"location,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	DownloadPath string `json:"downloadPath,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	DownloadMissing bool `json:"downloadMissing,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	UploadURL string `json:"uploadURL,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// ContainerDiagnostic is the Schema for the containerdiagnostics API. It's only served once the
+// conversion webhook is configured (see config/crd/kustomization.yaml) because without it the API
+// server would store v2 objects as v1 without converting them.
+// +kubebuilder:object:root=true
+// +kubebuilder:unservedversion
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.type`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Message",type=string,JSONPath=`.status.message`
+// +kubebuilder:printcolumn:name="Download",type=string,JSONPath=`.status.download.location`
+type ContainerDiagnostic struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ContainerDiagnosticSpec   `json:"spec,omitempty"`
+	Status ContainerDiagnosticStatus `json:"status,omitempty"`
+}
+
+// ContainerDiagnosticList contains a list of ContainerDiagnostic
+// +kubebuilder:object:root=true
+type ContainerDiagnosticList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ContainerDiagnostic `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ContainerDiagnostic{}, &ContainerDiagnosticList{})
+}
@@ -0,0 +1,701 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v2
+
+import (
+	"github.com/kgibm/containerdiagoperator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BundleEncryption) DeepCopyInto(out *BundleEncryption) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(corev1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BundleEncryption.
+func (in *BundleEncryption) DeepCopy() *BundleEncryption {
+	if in == nil {
+		return nil
+	}
+	out := new(BundleEncryption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BundleOptions) DeepCopyInto(out *BundleOptions) {
+	*out = *in
+	if in.ExpandArchives != nil {
+		in, out := &in.ExpandArchives, &out.ExpandArchives
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BundleOptions.
+func (in *BundleOptions) DeepCopy() *BundleOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(BundleOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CleanStep) DeepCopyInto(out *CleanStep) {
+	*out = *in
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CleanStep.
+func (in *CleanStep) DeepCopy() *CleanStep {
+	if in == nil {
+		return nil
+	}
+	out := new(CleanStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnostic) DeepCopyInto(out *ContainerDiagnostic) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnostic.
+func (in *ContainerDiagnostic) DeepCopy() *ContainerDiagnostic {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnostic)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ContainerDiagnostic) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticList) DeepCopyInto(out *ContainerDiagnosticList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ContainerDiagnostic, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticList.
+func (in *ContainerDiagnosticList) DeepCopy() *ContainerDiagnosticList {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ContainerDiagnosticList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticRunStatus) DeepCopyInto(out *ContainerDiagnosticRunStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticRunStatus.
+func (in *ContainerDiagnosticRunStatus) DeepCopy() *ContainerDiagnosticRunStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticRunStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticSpec) DeepCopyInto(out *ContainerDiagnosticSpec) {
+	*out = *in
+	in.Targets.DeepCopyInto(&out.Targets)
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]ContainerDiagnosticStep, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TemplateRef != nil {
+		in, out := &in.TemplateRef, &out.TemplateRef
+		*out = new(TemplateReference)
+		**out = **in
+	}
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.UniqueDirectory != nil {
+		in, out := &in.UniqueDirectory, &out.UniqueDirectory
+		*out = new(bool)
+		**out = **in
+	}
+	in.Bundle.DeepCopyInto(&out.Bundle)
+	in.Redaction.DeepCopyInto(&out.Redaction)
+	if in.Logs != nil {
+		in, out := &in.Logs, &out.Logs
+		*out = new(ContainerLogs)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Watch != nil {
+		in, out := &in.Watch, &out.Watch
+		*out = new(ContainerWatch)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Encryption != nil {
+		in, out := &in.Encryption, &out.Encryption
+		*out = new(BundleEncryption)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3Upload)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TTLAfterFinished != nil {
+		in, out := &in.TTLAfterFinished, &out.TTLAfterFinished
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RunsHistoryLimit != nil {
+		in, out := &in.RunsHistoryLimit, &out.RunsHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticSpec.
+func (in *ContainerDiagnosticSpec) DeepCopy() *ContainerDiagnosticSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticStatus) DeepCopyInto(out *ContainerDiagnosticStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	out.Download = in.Download
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Runs != nil {
+		in, out := &in.Runs, &out.Runs
+		*out = make([]ContainerDiagnosticRunStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Watches != nil {
+		in, out := &in.Watches, &out.Watches
+		*out = make([]ContainerWatchStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticStatus.
+func (in *ContainerDiagnosticStatus) DeepCopy() *ContainerDiagnosticStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerDiagnosticStep) DeepCopyInto(out *ContainerDiagnosticStep) {
+	*out = *in
+	if in.Install != nil {
+		in, out := &in.Install, &out.Install
+		*out = new(InstallStep)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Execute != nil {
+		in, out := &in.Execute, &out.Execute
+		*out = new(ExecuteStep)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Package != nil {
+		in, out := &in.Package, &out.Package
+		*out = new(PackageStep)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Clean != nil {
+		in, out := &in.Clean, &out.Clean
+		*out = new(CleanStep)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerDiagnosticStep.
+func (in *ContainerDiagnosticStep) DeepCopy() *ContainerDiagnosticStep {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerDiagnosticStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerLogs) DeepCopyInto(out *ContainerLogs) {
+	*out = *in
+	if in.Since != nil {
+		in, out := &in.Since, &out.Since
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerLogs.
+func (in *ContainerLogs) DeepCopy() *ContainerLogs {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerLogs)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerWatch) DeepCopyInto(out *ContainerWatch) {
+	*out = *in
+	if in.CPUThresholdMillicores != nil {
+		in, out := &in.CPUThresholdMillicores, &out.CPUThresholdMillicores
+		*out = new(int64)
+		**out = **in
+	}
+	if in.MemoryThresholdMB != nil {
+		in, out := &in.MemoryThresholdMB, &out.MemoryThresholdMB
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerWatch.
+func (in *ContainerWatch) DeepCopy() *ContainerWatch {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerWatch)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContainerWatchStatus) DeepCopyInto(out *ContainerWatchStatus) {
+	*out = *in
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContainerWatchStatus.
+func (in *ContainerWatchStatus) DeepCopy() *ContainerWatchStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ContainerWatchStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DiagnosticTargets) DeepCopyInto(out *DiagnosticTargets) {
+	*out = *in
+	if in.Objects != nil {
+		in, out := &in.Objects, &out.Objects
+		*out = make([]corev1.ObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.LabelSelectors != nil {
+		in, out := &in.LabelSelectors, &out.LabelSelectors
+		*out = make([]metav1.LabelSelector, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Containers != nil {
+		in, out := &in.Containers, &out.Containers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludeContainers != nil {
+		in, out := &in.ExcludeContainers, &out.ExcludeContainers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DiagnosticTargets.
+func (in *DiagnosticTargets) DeepCopy() *DiagnosticTargets {
+	if in == nil {
+		return nil
+	}
+	out := new(DiagnosticTargets)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DownloadStatus) DeepCopyInto(out *DownloadStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DownloadStatus.
+func (in *DownloadStatus) DeepCopy() *DownloadStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DownloadStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnvVar) DeepCopyInto(out *EnvVar) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvVar.
+func (in *EnvVar) DeepCopy() *EnvVar {
+	if in == nil {
+		return nil
+	}
+	out := new(EnvVar)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExecuteRepeat) DeepCopyInto(out *ExecuteRepeat) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExecuteRepeat.
+func (in *ExecuteRepeat) DeepCopy() *ExecuteRepeat {
+	if in == nil {
+		return nil
+	}
+	out := new(ExecuteRepeat)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExecuteStep) DeepCopyInto(out *ExecuteStep) {
+	*out = *in
+	if in.Command != nil {
+		in, out := &in.Command, &out.Command
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Args != nil {
+		in, out := &in.Args, &out.Args
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]EnvVar, len(*in))
+		copy(*out, *in)
+	}
+	if in.CaptureStdout != nil {
+		in, out := &in.CaptureStdout, &out.CaptureStdout
+		*out = new(bool)
+		**out = **in
+	}
+	if in.IgnoreErrors != nil {
+		in, out := &in.IgnoreErrors, &out.IgnoreErrors
+		*out = new(bool)
+		**out = **in
+	}
+	if in.Repeat != nil {
+		in, out := &in.Repeat, &out.Repeat
+		*out = new(ExecuteRepeat)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExecuteStep.
+func (in *ExecuteStep) DeepCopy() *ExecuteStep {
+	if in == nil {
+		return nil
+	}
+	out := new(ExecuteStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InstallStep) DeepCopyInto(out *InstallStep) {
+	*out = *in
+	if in.Tools != nil {
+		in, out := &in.Tools, &out.Tools
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InstallStep.
+func (in *InstallStep) DeepCopy() *InstallStep {
+	if in == nil {
+		return nil
+	}
+	out := new(InstallStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PackageFilter) DeepCopyInto(out *PackageFilter) {
+	*out = *in
+	if in.Include != nil {
+		in, out := &in.Include, &out.Include
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Exclude != nil {
+		in, out := &in.Exclude, &out.Exclude
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ModifiedWithin != nil {
+		in, out := &in.ModifiedWithin, &out.ModifiedWithin
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageFilter.
+func (in *PackageFilter) DeepCopy() *PackageFilter {
+	if in == nil {
+		return nil
+	}
+	out := new(PackageFilter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PackageStep) DeepCopyInto(out *PackageStep) {
+	*out = *in
+	if in.Paths != nil {
+		in, out := &in.Paths, &out.Paths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Filter != nil {
+		in, out := &in.Filter, &out.Filter
+		*out = new(PackageFilter)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PackageStep.
+func (in *PackageStep) DeepCopy() *PackageStep {
+	if in == nil {
+		return nil
+	}
+	out := new(PackageStep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RedactionOptions) DeepCopyInto(out *RedactionOptions) {
+	*out = *in
+	if in.Patterns != nil {
+		in, out := &in.Patterns, &out.Patterns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RedactionOptions.
+func (in *RedactionOptions) DeepCopy() *RedactionOptions {
+	if in == nil {
+		return nil
+	}
+	out := new(RedactionOptions)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3Upload) DeepCopyInto(out *S3Upload) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	if in.PresignExpiry != nil {
+		in, out := &in.PresignExpiry, &out.PresignExpiry
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3Upload.
+func (in *S3Upload) DeepCopy() *S3Upload {
+	if in == nil {
+		return nil
+	}
+	out := new(S3Upload)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TemplateReference) DeepCopyInto(out *TemplateReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TemplateReference.
+func (in *TemplateReference) DeepCopy() *TemplateReference {
+	if in == nil {
+		return nil
+	}
+	out := new(TemplateReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *V1ConversionData) DeepCopyInto(out *V1ConversionData) {
+	*out = *in
+	if in.Arguments != nil {
+		in, out := &in.Arguments, &out.Arguments
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]v1.ContainerDiagnosticStep, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Template != nil {
+		in, out := &in.Template, &out.Template
+		*out = new(v1.ContainerDiagnosticTemplateSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new V1ConversionData.
+func (in *V1ConversionData) DeepCopy() *V1ConversionData {
+	if in == nil {
+		return nil
+	}
+	out := new(V1ConversionData)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// The path of the Alertmanager webhook receiver
+const AlertReceiverPath = "/alerts"
+
+// The alert label (or annotation) with the name of the ContainerDiagnosticTrigger to fire
+const AlertTriggerLabel = "containerdiag_trigger"
+
+// The alert labels with the target pod (as set by kube-state-metrics and cAdvisor)
+const (
+	AlertNamespaceLabel = "namespace"
+	AlertPodLabel       = "pod"
+	AlertContainerLabel = "container"
+)
+
+// The maximum size of a webhook payload
+const MaxAlertPayloadBytes = 10 * 1024 * 1024
+
+// AlertmanagerPayload is the body of an Alertmanager webhook (version 4).
+// See https://prometheus.io/docs/alerting/latest/configuration/#webhook_config
+type AlertmanagerPayload struct {
+	Version  string  `json:"version"`
+	GroupKey string  `json:"groupKey"`
+	Status   string  `json:"status"`
+	Receiver string  `json:"receiver"`
+	Alerts   []Alert `json:"alerts"`
+}
+
+// Alert is an alert of an Alertmanager webhook
+type Alert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// AlertReceiverResponse is the body of the response to a webhook
+type AlertReceiverResponse struct {
+	Accepted []AlertResult `json:"accepted"`
+	Ignored  []AlertResult `json:"ignored"`
+}
+
+// AlertResult describes what happened to an alert of a webhook
+type AlertResult struct {
+	Alert   string `json:"alert"`
+	Trigger string `json:"trigger,omitempty"`
+	Pod     string `json:"pod,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// AlertReceiver accepts Alertmanager webhooks and fires the ContainerDiagnosticTrigger named by
+// each firing alert for the pod in the alert's labels. The trigger's cooldown and maximum runs
+// per hour apply.
+type AlertReceiver struct {
+	Client      client.Client
+	Triggers    *ContainerDiagnosticTriggerReconciler
+	BindAddress string
+	Logger      logr.Logger
+
+	// If set, requests must have this bearer token
+	Token string
+}
+
+// Start implements manager.Runnable
+func (s *AlertReceiver) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(AlertReceiverPath, s.HandleAlerts)
+
+	server := &http.Server{
+		Addr:    s.BindAddress,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	s.Logger.Info(fmt.Sprintf("alert receiver is starting to listen on %s", s.BindAddress))
+	err := server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Alerts are queued for the
+// trigger controller which only runs in the leader so the other replicas don't listen.
+func (s *AlertReceiver) NeedLeaderElection() bool {
+	return true
+}
+
+func (s *AlertReceiver) HandleAlerts(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(s.Token) > 0 {
+		token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	payload := &AlertmanagerPayload{}
+	err := json.NewDecoder(http.MaxBytesReader(w, req.Body, MaxAlertPayloadBytes)).Decode(payload)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not parse the Alertmanager payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response := &AlertReceiverResponse{Accepted: []AlertResult{}, Ignored: []AlertResult{}}
+
+	for _, alert := range payload.Alerts {
+		result, accepted, err := s.ProcessAlert(req.Context(), alert)
+		if err != nil {
+			// Alertmanager retries the whole notification
+			s.Logger.Error(err, fmt.Sprintf("alert receiver could not process alert %s", result.Alert))
+			http.Error(w, fmt.Sprintf("Could not process alert %s: %v", result.Alert, err), http.StatusInternalServerError)
+			return
+		}
+
+		if accepted {
+			s.Logger.Info(fmt.Sprintf("alert receiver fired ContainerDiagnosticTrigger %s for alert %s and pod %s", result.Trigger, result.Alert, result.Pod))
+			response.Accepted = append(response.Accepted, result)
+		} else {
+			s.Logger.Info(fmt.Sprintf("alert receiver ignored alert %s: %s", result.Alert, result.Reason))
+			response.Ignored = append(response.Ignored, result)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ProcessAlert queues an event for the trigger named by a firing alert. Alerts that don't name
+// a trigger or pod, and resolved alerts, are ignored.
+func (s *AlertReceiver) ProcessAlert(ctx context.Context, alert Alert) (result AlertResult, accepted bool, err error) {
+	result.Alert = alert.Labels["alertname"]
+
+	if alert.Status != "firing" {
+		result.Reason = fmt.Sprintf("status is %s", alert.Status)
+		return result, false, nil
+	}
+
+	triggerName := GetAlertValue(alert, AlertTriggerLabel)
+	namespace := alert.Labels[AlertNamespaceLabel]
+	podName := alert.Labels[AlertPodLabel]
+
+	if len(triggerName) == 0 {
+		result.Reason = fmt.Sprintf("no %s label or annotation", AlertTriggerLabel)
+		return result, false, nil
+	}
+	result.Trigger = triggerName
+
+	if len(namespace) == 0 || len(podName) == 0 {
+		result.Reason = fmt.Sprintf("no %s and %s labels", AlertNamespaceLabel, AlertPodLabel)
+		return result, false, nil
+	}
+	result.Pod = namespace + "/" + podName
+
+	trigger := &diagnosticv1.ContainerDiagnosticTrigger{}
+	err = s.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: triggerName}, trigger)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			result.Reason = fmt.Sprintf("ContainerDiagnosticTrigger %s not found in namespace %s", triggerName, namespace)
+			return result, false, nil
+		}
+		return result, false, err
+	}
+
+	if trigger.Spec.Suspend {
+		result.Reason = fmt.Sprintf("ContainerDiagnosticTrigger %s is suspended", triggerName)
+		return result, false, nil
+	}
+
+	pod := &corev1.Pod{}
+	err = s.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: podName}, pod)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			result.Reason = fmt.Sprintf("pod %s not found", result.Pod)
+			return result, false, nil
+		}
+		return result, false, err
+	}
+
+	// An alert can't target pods that the trigger doesn't watch
+	if len(trigger.Spec.TargetLabelSelectors) > 0 && !MatchesAnyLabelSelector(trigger.Spec.TargetLabelSelectors, pod.Labels) {
+		result.Reason = fmt.Sprintf("pod %s doesn't match the targetLabelSelectors of ContainerDiagnosticTrigger %s", result.Pod, triggerName)
+		return result, false, nil
+	}
+
+	// The run only targets the container of the alert if the pod has it (e.g. not the POD
+	// container of cAdvisor metrics)
+	reason := fmt.Sprintf("alert %s fired for pod %s", result.Alert, podName)
+	var container string
+	for _, podContainer := range pod.Spec.Containers {
+		if podContainer.Name == alert.Labels[AlertContainerLabel] {
+			container = podContainer.Name
+			reason = fmt.Sprintf("alert %s fired for container %s of pod %s", result.Alert, container, podName)
+		}
+	}
+
+	err = s.Triggers.QueueAlertEvents(ctx, trigger, []PodEvent{
+		{
+			Pod:       types.NamespacedName{Namespace: namespace, Name: podName},
+			Workload:  GetWorkload(pod),
+			Container: container,
+			Event:     TriggerAlert,
+			Reason:    reason,
+		},
+	})
+	if err != nil {
+		return result, false, err
+	}
+
+	return result, true, nil
+}
+
+// GetAlertValue returns the value of an alert label or, if there is no such label, annotation
+func GetAlertValue(alert Alert, name string) string {
+	if value, ok := alert.Labels[name]; ok {
+		return value
+	}
+	return alert.Annotations[name]
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// newAlertReceiverTest creates a receiver with a fake client containing a trigger for the pods of
+// Deployment app1 and one of its pods
+func newAlertReceiverTest(t *testing.T, token string) (*AlertReceiver, client.Client) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := diagnosticv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	trigger := &diagnosticv1.ContainerDiagnosticTrigger{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app1-trigger"},
+		Spec: diagnosticv1.ContainerDiagnosticTriggerSpec{
+			TargetLabelSelectors: []metav1.LabelSelector{
+				{MatchLabels: map[string]string{"app": "app1"}},
+			},
+			Template: diagnosticv1.ContainerDiagnosticRunTemplate{
+				Spec: diagnosticv1.ContainerDiagnosticSpec{
+					Command: "script",
+				},
+			},
+		},
+	}
+
+	isController := true
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "app1-5d8f7c9b4-x2x7k",
+			Labels:    map[string]string{"app": "app1", "pod-template-hash": "5d8f7c9b4"},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app1-5d8f7c9b4", UID: "1", Controller: &isController},
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app"}, {Name: "sidecar"}},
+		},
+	}
+
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(trigger, pod).Build()
+
+	triggers := &ContainerDiagnosticTriggerReconciler{
+		Client:        fakeClient,
+		Scheme:        scheme,
+		EventRecorder: record.NewFakeRecorder(10),
+	}
+
+	receiver := &AlertReceiver{
+		Client:   fakeClient,
+		Triggers: triggers,
+		Token:    token,
+		Logger:   ctrl.Log.WithName("alerts"),
+	}
+
+	return receiver, fakeClient
+}
+
+func postAlerts(t *testing.T, receiver *AlertReceiver, body []byte, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, AlertReceiverPath, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	receiver.HandleAlerts(recorder, req)
+	return recorder
+}
+
+func TestAlertReceiverFiresTrigger(t *testing.T) {
+	receiver, fakeClient := newAlertReceiverTest(t, "")
+
+	payload, err := ioutil.ReadFile(filepath.Join("testdata", "alertmanager_payload.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := postAlerts(t, receiver, payload, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	response := &AlertReceiverResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(response.Accepted) != 1 || response.Accepted[0].Pod != "default/app1-5d8f7c9b4-x2x7k" {
+		t.Fatalf("unexpected accepted alerts: %+v", response.Accepted)
+	}
+
+	// The resolved alert, the alert without a trigger, and the alert for a missing pod
+	if len(response.Ignored) != 3 {
+		t.Fatalf("unexpected ignored alerts: %+v", response.Ignored)
+	}
+	if !strings.Contains(response.Ignored[2].Reason, "not found") {
+		t.Errorf("unexpected reason for the missing pod: %s", response.Ignored[2].Reason)
+	}
+
+	key := types.NamespacedName{Namespace: "default", Name: "app1-trigger"}
+
+	// The receiver isn't running in a manager so the events are only pending
+	events := receiver.Triggers.TakePendingEvents(key)
+	if len(events) != 1 {
+		t.Fatalf("got %d pending events, want 1", len(events))
+	}
+	if events[0].Event != TriggerAlert || events[0].Workload != "Deployment/app1" || events[0].Container != "app" {
+		t.Errorf("unexpected event: %+v", events[0])
+	}
+	receiver.Triggers.AddPendingEvents(key, events)
+
+	ctx := context.Background()
+	_, err = receiver.Triggers.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containerDiagnostics := &diagnosticv1.ContainerDiagnosticList{}
+	err = fakeClient.List(ctx, containerDiagnostics, client.InNamespace("default"), client.MatchingLabels{TriggeredByLabel: "app1-trigger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(containerDiagnostics.Items) != 1 {
+		t.Fatalf("got %d ContainerDiagnostics, want 1", len(containerDiagnostics.Items))
+	}
+
+	containerDiagnostic := containerDiagnostics.Items[0]
+	if len(containerDiagnostic.Spec.TargetObjects) != 1 || containerDiagnostic.Spec.TargetObjects[0].Name != "app1-5d8f7c9b4-x2x7k" {
+		t.Errorf("unexpected targetObjects: %+v", containerDiagnostic.Spec.TargetObjects)
+	}
+	if len(containerDiagnostic.Spec.Containers) != 1 || containerDiagnostic.Spec.Containers[0] != "app" {
+		t.Errorf("unexpected containers: %+v", containerDiagnostic.Spec.Containers)
+	}
+	if reason := containerDiagnostic.Annotations[TriggerReasonAnnotation]; !strings.Contains(reason, "ContainerHighMemory") {
+		t.Errorf("unexpected reason: %s", reason)
+	}
+
+	trigger := &diagnosticv1.ContainerDiagnosticTrigger{}
+	if err := fakeClient.Get(ctx, key, trigger); err != nil {
+		t.Fatal(err)
+	}
+	if trigger.Status.Runs != 1 {
+		t.Errorf("got %d runs, want 1", trigger.Status.Runs)
+	}
+
+	// A second alert for the workload is suppressed by the cooldown
+	recorder = postAlerts(t, receiver, payload, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	_, err = receiver.Triggers.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fakeClient.Get(ctx, key, trigger); err != nil {
+		t.Fatal(err)
+	}
+	if trigger.Status.Runs != 1 || trigger.Status.Suppressed != 1 {
+		t.Errorf("got %d runs and %d suppressed, want 1 and 1", trigger.Status.Runs, trigger.Status.Suppressed)
+	}
+}
+
+func TestAlertReceiverIgnoresPodsNotSelected(t *testing.T) {
+	receiver, _ := newAlertReceiverTest(t, "")
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "app2-7c9b4d8f5-k2j3h",
+			Labels:    map[string]string{"app": "app2"},
+		},
+	}
+	if err := receiver.Client.Create(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+
+	result, accepted, err := receiver.ProcessAlert(context.Background(), Alert{
+		Status: "firing",
+		Labels: map[string]string{
+			"alertname":         "ContainerHighCPU",
+			AlertNamespaceLabel: "default",
+			AlertPodLabel:       "app2-7c9b4d8f5-k2j3h",
+			AlertTriggerLabel:   "app1-trigger",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted || !strings.Contains(result.Reason, "targetLabelSelectors") {
+		t.Errorf("unexpected result: %+v", result)
+	}
+}
+
+func TestAlertReceiverIgnoresUnknownContainers(t *testing.T) {
+	receiver, _ := newAlertReceiverTest(t, "")
+
+	// cAdvisor metrics of the pod's sandbox have container="POD"
+	_, accepted, err := receiver.ProcessAlert(context.Background(), Alert{
+		Status: "firing",
+		Labels: map[string]string{
+			"alertname":         "ContainerHighCPU",
+			AlertNamespaceLabel: "default",
+			AlertPodLabel:       "app1-5d8f7c9b4-x2x7k",
+			AlertContainerLabel: "POD",
+			AlertTriggerLabel:   "app1-trigger",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !accepted {
+		t.Fatal("the alert wasn't accepted")
+	}
+
+	events := receiver.Triggers.TakePendingEvents(types.NamespacedName{Namespace: "default", Name: "app1-trigger"})
+	if len(events) != 1 || len(events[0].Container) != 0 {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestAlertReceiverRequests(t *testing.T) {
+	receiver, _ := newAlertReceiverTest(t, "secret")
+
+	recorder := postAlerts(t, receiver, []byte(`{"alerts":[]}`), "")
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d without a token, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+
+	recorder = postAlerts(t, receiver, []byte(`{"alerts":[]}`), "wrong")
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d with the wrong token, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+
+	recorder = postAlerts(t, receiver, []byte(`{"alerts":`), "secret")
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for a bad payload, want %d", recorder.Code, http.StatusBadRequest)
+	}
+
+	recorder = postAlerts(t, receiver, []byte(`{"alerts":[]}`), "secret")
+	if recorder.Code != http.StatusOK {
+		t.Errorf("got status %d for an empty payload, want %d", recorder.Code, http.StatusOK)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, AlertReceiverPath, nil)
+	getRecorder := httptest.NewRecorder()
+	receiver.HandleAlerts(getRecorder, req)
+	if getRecorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d for GET, want %d", getRecorder.Code, http.StatusMethodNotAllowed)
+	}
+}
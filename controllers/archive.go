@@ -0,0 +1,600 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Archives downloaded from containers are expanded in the operator so a
+// malicious or unexpectedly large archive must not be able to escape the
+// output directory or exhaust the operator's disk.
+
+// The maximum nesting of archives that will be expanded. The per-container
+// zip is depth 1, an archive inside of it (e.g. linperf_RESULTS.tar.gz) is
+// depth 2, and so on.
+const MaxArchiveDepth = 3
+
+// The maximum number of bytes expanded from all archives in a single run.
+const MaxArchiveExpandedBytes int64 = 8 * 1024 * 1024 * 1024
+
+// The maximum number of bytes of any single archive entry.
+const MaxArchiveEntryBytes int64 = 4 * 1024 * 1024 * 1024
+
+// The maximum number of entries expanded from all archives in a single run.
+const MaxArchiveEntries = 200000
+
+// File name patterns (see filepath.Match) of archives found within the
+// collected files that are always expanded, in addition to the per-container
+// zip and any patterns in the spec's ExpandArchives. Anything else (e.g. a
+// user's application zip picked up by a package step) is left as-is.
+var DefaultExpandArchives = []string{
+	"linperf_RESULTS.tar.gz",
+}
+
+type ArchiveType int
+
+const (
+	ArchiveNone ArchiveType = iota
+	ArchiveZip
+	ArchiveTar
+	ArchiveTarGz
+)
+
+// GetArchiveType returns the type of archive based on the file name extension
+func GetArchiveType(name string) ArchiveType {
+	lower := strings.ToLower(name)
+	if strings.HasSuffix(lower, ".zip") {
+		return ArchiveZip
+	} else if strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz") {
+		return ArchiveTarGz
+	} else if strings.HasSuffix(lower, ".tar") {
+		return ArchiveTar
+	}
+	return ArchiveNone
+}
+
+// ArchiveExpander tracks the limits across all of the archives expanded in a single run
+type ArchiveExpander struct {
+	logger        *CustomLogger
+	patterns      []string
+	expandedBytes int64
+	entries       int
+
+	// The limits default to the constants above
+	maxDepth         int
+	maxExpandedBytes int64
+	maxEntryBytes    int64
+	maxEntries       int
+
+	// Nested archives that were left as-is because they don't match the expansion patterns
+	// or exceed the maximum depth. Their contents aren't redacted.
+	NotExpanded []string
+}
+
+func NewArchiveExpander(logger *CustomLogger, patterns []string) *ArchiveExpander {
+	allPatterns := append([]string{}, DefaultExpandArchives...)
+	allPatterns = append(allPatterns, patterns...)
+	return &ArchiveExpander{
+		logger:           logger,
+		patterns:         allPatterns,
+		maxDepth:         MaxArchiveDepth,
+		maxExpandedBytes: MaxArchiveExpandedBytes,
+		maxEntryBytes:    MaxArchiveEntryBytes,
+		maxEntries:       MaxArchiveEntries,
+	}
+}
+
+// ShouldExpand returns whether a nested archive found in the collected files should be expanded
+func (e *ArchiveExpander) ShouldExpand(path string) bool {
+	if GetArchiveType(path) == ArchiveNone {
+		return false
+	}
+	name := filepath.Base(path)
+	for _, pattern := range e.patterns {
+		matched, err := filepath.Match(pattern, name)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand expands the archive into the directory that contains it, deletes the archive,
+// and then recursively expands any nested archives that match the expansion patterns.
+func (e *ArchiveExpander) Expand(archive string, depth int) error {
+	if depth > e.maxDepth {
+		e.logger.Info(fmt.Sprintf("ArchiveExpander not expanding %s because it exceeds the maximum depth of %d", archive, e.maxDepth))
+		e.NotExpanded = append(e.NotExpanded, archive)
+		return nil
+	}
+
+	e.logger.Info(fmt.Sprintf("Uncompressing %s", archive))
+
+	var extracted []string
+	var err error
+
+	switch GetArchiveType(archive) {
+	case ArchiveZip:
+		extracted, err = e.ExtractZip(archive, filepath.Dir(archive))
+	case ArchiveTar:
+		extracted, err = e.ExtractTar(archive, filepath.Dir(archive), false)
+	case ArchiveTarGz:
+		extracted, err = e.ExtractTar(archive, filepath.Dir(archive), true)
+	default:
+		return fmt.Errorf("unknown archive type: %s", archive)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	os.Remove(archive)
+
+	for _, file := range extracted {
+		if e.ShouldExpand(file) {
+			err = e.Expand(file, depth+1)
+			if err != nil {
+				return err
+			}
+		} else if GetArchiveType(file) != ArchiveNone {
+			e.NotExpanded = append(e.NotExpanded, file)
+		}
+	}
+
+	return nil
+}
+
+// ExtractZip extracts a zip file into destination and returns the list of regular files extracted
+func (e *ArchiveExpander) ExtractZip(archive string, destination string) ([]string, error) {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var extracted []string
+
+	for _, entry := range reader.File {
+		target, err := SafeArchiveJoin(destination, entry.Name)
+		if err != nil {
+			return extracted, err
+		}
+
+		err = e.CheckEntry(entry.Name, int64(entry.UncompressedSize64))
+		if err != nil {
+			return extracted, err
+		}
+
+		mode := entry.Mode()
+		if mode.IsDir() {
+			err = os.MkdirAll(target, os.ModePerm)
+			if err != nil {
+				return extracted, err
+			}
+			continue
+		} else if !mode.IsRegular() {
+			e.logger.Info(fmt.Sprintf("ArchiveExpander skipping non-regular file %s in %s", entry.Name, archive))
+			continue
+		}
+
+		entryReader, err := entry.Open()
+		if err != nil {
+			return extracted, err
+		}
+
+		err = e.WriteEntry(target, entryReader, entry.Name)
+
+		entryReader.Close()
+
+		if err != nil {
+			return extracted, err
+		}
+
+		extracted = append(extracted, target)
+	}
+
+	return extracted, nil
+}
+
+// ExtractTar extracts a tar (optionally gzipped) file into destination and returns the list of regular files extracted
+func (e *ArchiveExpander) ExtractTar(archive string, destination string, gzipped bool) ([]string, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+
+	if gzipped {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	var extracted []string
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return extracted, err
+		}
+
+		target, err := SafeArchiveJoin(destination, header.Name)
+		if err != nil {
+			return extracted, err
+		}
+
+		err = e.CheckEntry(header.Name, header.Size)
+		if err != nil {
+			return extracted, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, os.ModePerm)
+			if err != nil {
+				return extracted, err
+			}
+		case tar.TypeReg:
+			err = e.WriteEntry(target, tarReader, header.Name)
+			if err != nil {
+				return extracted, err
+			}
+			extracted = append(extracted, target)
+		default:
+			// Symlinks and hardlinks could point outside of the destination so we don't create them
+			e.logger.Info(fmt.Sprintf("ArchiveExpander skipping non-regular file %s in %s", header.Name, archive))
+		}
+	}
+
+	return extracted, nil
+}
+
+// CheckEntry checks the declared size of an entry against the limits before anything is written
+func (e *ArchiveExpander) CheckEntry(name string, size int64) error {
+	e.entries++
+	if e.entries > e.maxEntries {
+		return fmt.Errorf("archives contain more than the maximum of %d entries", e.maxEntries)
+	}
+	if size > e.maxEntryBytes {
+		return fmt.Errorf("archive entry %s of %d bytes exceeds the maximum of %d bytes", name, size, e.maxEntryBytes)
+	}
+	if e.expandedBytes+size > e.maxExpandedBytes {
+		return fmt.Errorf("archive entry %s would exceed the maximum total expanded size of %d bytes", name, e.maxExpandedBytes)
+	}
+	return nil
+}
+
+// WriteEntry writes an archive entry to target. The declared size of an entry can't be
+// trusted so the number of bytes actually written is also limited.
+func (e *ArchiveExpander) WriteEntry(target string, reader io.Reader, name string) error {
+	err := os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	outFile, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	limit := e.maxEntryBytes
+	remaining := e.maxExpandedBytes - e.expandedBytes
+	if remaining < limit {
+		limit = remaining
+	}
+
+	written, err := io.Copy(outFile, io.LimitReader(reader, limit+1))
+	e.expandedBytes += written
+	if err != nil {
+		return err
+	}
+	if written > limit {
+		return fmt.Errorf("archive entry %s exceeds the maximum expanded size", name)
+	}
+	return nil
+}
+
+// SafeArchiveJoin joins an archive entry name to destination and makes sure that the
+// result doesn't escape destination (i.e. "zip slip")
+func SafeArchiveJoin(destination string, name string) (string, error) {
+	destination = filepath.Clean(destination)
+	target := filepath.Join(destination, name)
+	if target != destination && !strings.HasPrefix(target, destination+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %s is outside of the destination directory", name)
+	}
+	return target, nil
+}
+
+// Formats of the final archive
+const (
+	OutputFormatZip    = "zip"
+	OutputFormatTarGz  = "tar.gz"
+	OutputFormatTarZst = "tar.zst"
+)
+
+// CreateArchiveFromDirectory creates an archive of everything under sourceDirectory with relative names.
+// A compressionLevel of 0 uses the default level of the format.
+func CreateArchiveFromDirectory(sourceDirectory string, archiveFile string, format string, compressionLevel int) error {
+	switch format {
+	case "", OutputFormatZip:
+		return CreateZipFromDirectory(sourceDirectory, archiveFile, compressionLevel)
+	case OutputFormatTarGz, OutputFormatTarZst:
+		return CreateCompressedTarFromDirectory(sourceDirectory, archiveFile, format, compressionLevel)
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// GetOutputFormatExtension returns the file name extension (without the leading period) of an output format
+func GetOutputFormatExtension(format string) string {
+	if format == "" {
+		return OutputFormatZip
+	}
+	return format
+}
+
+// GetFlateCompressionLevel maps a user compression level onto the flate levels used by zip and gzip
+func GetFlateCompressionLevel(compressionLevel int) int {
+	if compressionLevel <= 0 {
+		return flate.DefaultCompression
+	} else if compressionLevel > flate.BestCompression {
+		return flate.BestCompression
+	}
+	return compressionLevel
+}
+
+// GetZstdEncoderLevel maps a user compression level, which is a zstd command line level (1-22),
+// onto the closest supported zstd encoder level
+func GetZstdEncoderLevel(compressionLevel int) zstd.EncoderLevel {
+	if compressionLevel <= 0 {
+		return zstd.SpeedDefault
+	}
+	return zstd.EncoderLevelFromZstd(compressionLevel)
+}
+
+// WalkDirectoryForArchive calls addEntry for every directory and regular file under sourceDirectory
+// with the relative name to use in the archive
+func WalkDirectoryForArchive(sourceDirectory string, addEntry func(path string, name string, info os.FileInfo) error) error {
+	return filepath.Walk(sourceDirectory,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			relativePath, err := filepath.Rel(sourceDirectory, path)
+			if err != nil {
+				return err
+			}
+
+			if relativePath == "." {
+				return nil
+			}
+
+			if !info.IsDir() && !info.Mode().IsRegular() {
+				return nil
+			}
+
+			return addEntry(path, filepath.ToSlash(relativePath), info)
+		})
+}
+
+// CreateZipFromDirectory creates a zip file of everything under sourceDirectory with relative names
+func CreateZipFromDirectory(sourceDirectory string, zipFile string, compressionLevel int) error {
+	outFile, err := os.Create(zipFile)
+	if err != nil {
+		return err
+	}
+
+	zipWriter := zip.NewWriter(outFile)
+
+	level := GetFlateCompressionLevel(compressionLevel)
+	zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, level)
+	})
+
+	err = WalkDirectoryForArchive(sourceDirectory,
+		func(path string, name string, info os.FileInfo) error {
+			header, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+
+			header.Name = name
+
+			if info.IsDir() {
+				header.Name += "/"
+				_, err = zipWriter.CreateHeader(header)
+				return err
+			}
+
+			header.Method = zip.Deflate
+
+			entryWriter, err := zipWriter.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+
+			return CopyFileTo(path, entryWriter)
+		})
+
+	closeErr := zipWriter.Close()
+	outFile.Close()
+
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(zipFile)
+	}
+	return err
+}
+
+// CreateCompressedTarFromDirectory creates a tar.gz or tar.zst file of everything under sourceDirectory with relative names
+func CreateCompressedTarFromDirectory(sourceDirectory string, archiveFile string, format string, compressionLevel int) error {
+	outFile, err := os.Create(archiveFile)
+	if err != nil {
+		return err
+	}
+
+	var compressor io.WriteCloser
+
+	if format == OutputFormatTarZst {
+		compressor, err = zstd.NewWriter(outFile, zstd.WithEncoderLevel(GetZstdEncoderLevel(compressionLevel)))
+	} else {
+		compressor, err = gzip.NewWriterLevel(outFile, GetFlateCompressionLevel(compressionLevel))
+	}
+
+	if err != nil {
+		outFile.Close()
+		os.Remove(archiveFile)
+		return err
+	}
+
+	tarWriter := tar.NewWriter(compressor)
+
+	err = WalkDirectoryForArchive(sourceDirectory,
+		func(path string, name string, info os.FileInfo) error {
+			header, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+
+			header.Name = name
+
+			if info.IsDir() {
+				header.Name += "/"
+				return tarWriter.WriteHeader(header)
+			}
+
+			err = tarWriter.WriteHeader(header)
+			if err != nil {
+				return err
+			}
+
+			return CopyFileTo(path, tarWriter)
+		})
+
+	closeErr := tarWriter.Close()
+	compressorCloseErr := compressor.Close()
+	outFile.Close()
+
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = compressorCloseErr
+	}
+	if err != nil {
+		os.Remove(archiveFile)
+	}
+	return err
+}
+
+// CopyFileTo copies the contents of the file at path into writer
+func CopyFileTo(path string, writer io.Writer) error {
+	inFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	_, err = io.Copy(writer, inFile)
+	return err
+}
+
+// CreateTarFromFiles creates a tar file of the specified absolute paths. Like tar, the leading
+// slash is removed from names and symlinks are stored as symlinks rather than followed.
+func CreateTarFromFiles(files []string, tarFile string) error {
+	outFile, err := os.Create(tarFile)
+	if err != nil {
+		return err
+	}
+
+	tarWriter := tar.NewWriter(outFile)
+
+	for _, file := range files {
+		err = AddFileToTar(tarWriter, file)
+		if err != nil {
+			break
+		}
+	}
+
+	closeErr := tarWriter.Close()
+	outFile.Close()
+
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tarFile)
+	}
+	return err
+}
+
+func AddFileToTar(tarWriter *tar.Writer, file string) error {
+	info, err := os.Lstat(file)
+	if err != nil {
+		return err
+	}
+
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err = os.Readlink(file)
+		if err != nil {
+			return err
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+
+	header.Name = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(file)), "/")
+
+	err = tarWriter.WriteHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	return CopyFileTo(file, tarWriter)
+}
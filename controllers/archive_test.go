@@ -0,0 +1,621 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// archiveEntry is an entry of a test archive
+type archiveEntry struct {
+	name    string
+	content []byte
+}
+
+func newArchiveExpanderTest(patterns []string) *ArchiveExpander {
+	return NewArchiveExpander(&CustomLogger{logger: ctrl.Log.WithName("archive")}, patterns)
+}
+
+// createZip returns a zip of the entries
+func createZip(t *testing.T, entries []archiveEntry) []byte {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, entry := range entries {
+		entryWriter, err := writer.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entryWriter.Write(entry.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// createTarGz returns a gzipped tar of the headers with the content of each regular file
+func createTarGz(t *testing.T, headers []*tar.Header, contents map[string][]byte) []byte {
+	var buffer bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	writer := tar.NewWriter(gzipWriter)
+	for _, header := range headers {
+		var content []byte
+		if header.Typeflag == tar.TypeReg {
+			content = contents[header.Name]
+			header.Size = int64(len(content))
+		}
+		if header.Mode == 0 {
+			header.Mode = 0600
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+// writeArchive writes an archive to a new temporary directory and returns its path
+func writeArchive(t *testing.T, name string, content []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestArchiveExpanderNotExpanded(t *testing.T) {
+	nested := createZip(t, []archiveEntry{{"application.properties", []byte("DB_PASSWORD=hunter2\n")}})
+
+	archive := writeArchive(t, "container.zip", createZip(t, []archiveEntry{
+		{"logs/messages.log", []byte("started\n")},
+		{"app/application.zip", nested},
+		{"app/config.zip", nested},
+	}))
+	directory := filepath.Dir(archive)
+
+	expander := newArchiveExpanderTest([]string{"config.zip"})
+	if err := expander.Expand(archive, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{filepath.Join(directory, "app", "application.zip")}
+	if !reflect.DeepEqual(expander.NotExpanded, want) {
+		t.Errorf("got not expanded %v, want %v", expander.NotExpanded, want)
+	}
+
+	if _, err := os.Stat(filepath.Join(directory, "app", "application.properties")); err != nil {
+		t.Errorf("config.zip wasn't expanded: %v", err)
+	}
+}
+
+func TestGetArchiveType(t *testing.T) {
+	tests := []struct {
+		name string
+		want ArchiveType
+	}{
+		{"container.zip", ArchiveZip},
+		{"CONTAINER.ZIP", ArchiveZip},
+		{"linperf_RESULTS.tar.gz", ArchiveTarGz},
+		{"logs.tgz", ArchiveTarGz},
+		{"logs.tar", ArchiveTar},
+		{"javacore.txt", ArchiveNone},
+		{"heapdump.phd.gz", ArchiveNone},
+	}
+
+	for _, test := range tests {
+		if got := GetArchiveType(test.name); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSafeArchiveJoin(t *testing.T) {
+	destination := filepath.Join(string(os.PathSeparator), "output", "run")
+
+	tests := []struct {
+		name    string
+		entry   string
+		want    string
+		wantErr bool
+	}{
+		{"file", "logs/messages.log", filepath.Join(destination, "logs", "messages.log"), false},
+		{"directory", "logs/", filepath.Join(destination, "logs"), false},
+		{"current directory", "./", destination, false},
+		{"parent within the destination", "logs/../trace.log", filepath.Join(destination, "trace.log"), false},
+		{"absolute path is relative to the destination", "/etc/passwd", filepath.Join(destination, "etc", "passwd"), false},
+		{"parent directory", "../evil.txt", "", true},
+		{"nested parent directory", "logs/../../evil.txt", "", true},
+		{"sibling with the same prefix", "../run2/evil.txt", "", true},
+		{"parent of the root", "../../../../etc/cron.d/evil", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := SafeArchiveJoin(destination, test.entry)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got %s, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestArchiveExpanderRejectsEscapingEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive string
+		content func(t *testing.T) []byte
+	}{
+		{"zip", "container.zip", func(t *testing.T) []byte {
+			return createZip(t, []archiveEntry{{"ok.txt", []byte("ok")}, {"../evil.txt", []byte("evil")}})
+		}},
+		{"tar.gz", "linperf_RESULTS.tar.gz", func(t *testing.T) []byte {
+			return createTarGz(t, []*tar.Header{
+				{Name: "ok.txt", Typeflag: tar.TypeReg},
+				{Name: "../evil.txt", Typeflag: tar.TypeReg},
+			}, map[string][]byte{"ok.txt": []byte("ok"), "../evil.txt": []byte("evil")})
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := t.TempDir()
+			directory := filepath.Join(parent, "run")
+			if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(directory, test.archive)
+			if err := ioutil.WriteFile(archive, test.content(t), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			err := newArchiveExpanderTest(nil).Expand(archive, 1)
+			if err == nil || !strings.Contains(err.Error(), "outside of the destination") {
+				t.Errorf("got error %v, want an entry outside of the destination", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(parent, "evil.txt")); !os.IsNotExist(err) {
+				t.Errorf("the entry escaped the destination: %v", err)
+			}
+		})
+	}
+}
+
+func TestArchiveExpanderAbsolutePaths(t *testing.T) {
+	archive := writeArchive(t, "linperf_RESULTS.tar.gz", createTarGz(t, []*tar.Header{
+		{Name: "/tmp/linperf/vmstat.out", Typeflag: tar.TypeReg},
+	}, map[string][]byte{"/tmp/linperf/vmstat.out": []byte("vmstat")}))
+	directory := filepath.Dir(archive)
+
+	if err := newArchiveExpanderTest(nil).Expand(archive, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(directory, "tmp", "linperf", "vmstat.out"))
+	if err != nil || string(content) != "vmstat" {
+		t.Errorf("got %q (%v), want the absolute entry under the destination", content, err)
+	}
+}
+
+func TestArchiveExpanderSkipsLinks(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "target.txt")
+	if err := ioutil.WriteFile(target, []byte("target"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("tar.gz", func(t *testing.T) {
+		archive := writeArchive(t, "linperf_RESULTS.tar.gz", createTarGz(t, []*tar.Header{
+			{Name: "symlink.txt", Typeflag: tar.TypeSymlink, Linkname: target},
+			{Name: "hardlink.txt", Typeflag: tar.TypeLink, Linkname: target},
+			// A regular file with the name of a symlink must not write through it
+			{Name: "symlink.txt", Typeflag: tar.TypeReg},
+			{Name: "ok.txt", Typeflag: tar.TypeReg},
+		}, map[string][]byte{"symlink.txt": []byte("evil"), "ok.txt": []byte("ok")}))
+		directory := filepath.Dir(archive)
+
+		if err := newArchiveExpanderTest(nil).Expand(archive, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Lstat(filepath.Join(directory, "symlink.txt"))
+		if err != nil || !info.Mode().IsRegular() {
+			t.Errorf("symlink.txt isn't a regular file: %v", err)
+		}
+		if _, err := os.Lstat(filepath.Join(directory, "hardlink.txt")); !os.IsNotExist(err) {
+			t.Errorf("hardlink.txt was created: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(directory, "ok.txt")); err != nil {
+			t.Errorf("ok.txt wasn't extracted: %v", err)
+		}
+		if content, err := ioutil.ReadFile(target); err != nil || string(content) != "target" {
+			t.Errorf("the link target was changed to %q (%v)", content, err)
+		}
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		var buffer bytes.Buffer
+		writer := zip.NewWriter(&buffer)
+		header := &zip.FileHeader{Name: "symlink.txt"}
+		header.SetMode(os.ModeSymlink | 0777)
+		entryWriter, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := entryWriter.Write([]byte(target)); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		archive := writeArchive(t, "container.zip", buffer.Bytes())
+		directory := filepath.Dir(archive)
+
+		if err := newArchiveExpanderTest(nil).Expand(archive, 1); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Lstat(filepath.Join(directory, "symlink.txt")); !os.IsNotExist(err) {
+			t.Errorf("symlink.txt was created: %v", err)
+		}
+	})
+}
+
+func TestArchiveExpanderLimits(t *testing.T) {
+	entries := []archiveEntry{
+		{"a.txt", bytes.Repeat([]byte("a"), 100)},
+		{"b.txt", bytes.Repeat([]byte("b"), 100)},
+		{"c.txt", bytes.Repeat([]byte("c"), 100)},
+	}
+
+	tests := []struct {
+		name             string
+		maxEntries       int
+		maxEntryBytes    int64
+		maxExpandedBytes int64
+		wantErr          string
+	}{
+		{"within the limits", 3, 100, 300, ""},
+		{"too many entries", 2, 100, 300, "maximum of 2 entries"},
+		{"entry too large", 3, 99, 300, "exceeds the maximum of 99 bytes"},
+		{"expanded size too large", 3, 100, 299, "maximum total expanded size"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archive := writeArchive(t, "container.zip", createZip(t, entries))
+
+			expander := newArchiveExpanderTest(nil)
+			expander.maxEntries = test.maxEntries
+			expander.maxEntryBytes = test.maxEntryBytes
+			expander.maxExpandedBytes = test.maxExpandedBytes
+
+			err := expander.Expand(archive, 1)
+			if len(test.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("got error %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("got error %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+
+	// The limits apply across all of the archives of a run
+	t.Run("across archives", func(t *testing.T) {
+		expander := newArchiveExpanderTest(nil)
+		expander.maxEntries = 4
+
+		if err := expander.Expand(writeArchive(t, "container1.zip", createZip(t, entries)), 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := expander.Expand(writeArchive(t, "container2.zip", createZip(t, entries)), 1); err == nil {
+			t.Error("the second archive exceeded the maximum entries without an error")
+		}
+	})
+}
+
+func TestArchiveExpanderWriteEntryLimit(t *testing.T) {
+	// The declared size of an entry can't be trusted so the bytes written are also limited
+	expander := newArchiveExpanderTest(nil)
+	expander.maxEntryBytes = 10
+
+	target := filepath.Join(t.TempDir(), "entry.txt")
+	err := expander.WriteEntry(target, bytes.NewReader(bytes.Repeat([]byte("a"), 100)), "entry.txt")
+	if err == nil {
+		t.Error("an entry larger than its limit was written without an error")
+	}
+
+	info, err := os.Stat(target)
+	if err != nil || info.Size() > 11 {
+		t.Errorf("got %+v (%v), want at most 11 bytes written", info, err)
+	}
+}
+
+func TestArchiveExpanderMaxDepth(t *testing.T) {
+	maxDepth := 2
+
+	// Each level is nested in the previous one and the container zip is depth 1
+	archive := createZip(t, []archiveEntry{{"deepest.txt", []byte("deepest")}})
+	for depth := maxDepth; depth >= 1; depth-- {
+		archive = createZip(t, []archiveEntry{{fmt.Sprintf("depth%d.zip", depth+1), archive}})
+	}
+
+	path := writeArchive(t, "depth1.zip", archive)
+	directory := filepath.Dir(path)
+
+	expander := newArchiveExpanderTest([]string{"*.zip"})
+	expander.maxDepth = maxDepth
+	if err := expander.Expand(path, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(directory, fmt.Sprintf("depth%d.zip", maxDepth))); !os.IsNotExist(err) {
+		t.Errorf("the archive at the maximum depth wasn't expanded: %v", err)
+	}
+
+	deepest := filepath.Join(directory, fmt.Sprintf("depth%d.zip", maxDepth+1))
+	if _, err := os.Stat(deepest); err != nil {
+		t.Errorf("the archive beyond the maximum depth wasn't kept: %v", err)
+	}
+	if !reflect.DeepEqual(expander.NotExpanded, []string{deepest}) {
+		t.Errorf("got not expanded %v, want %s", expander.NotExpanded, deepest)
+	}
+}
+
+// writeArchiveSource writes the files to a new temporary directory and returns it
+func writeArchiveSource(t *testing.T, files map[string][]byte) string {
+	directory := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(directory, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directory
+}
+
+// readArchive returns the contents of the regular files and the names of the directories in an
+// archive created by CreateArchiveFromDirectory
+func readArchive(t *testing.T, archiveFile string, format string) (map[string][]byte, []string) {
+	files := map[string][]byte{}
+	var directories []string
+
+	if format == OutputFormatZip {
+		reader, err := zip.OpenReader(archiveFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+
+		for _, entry := range reader.File {
+			if entry.Mode().IsDir() {
+				directories = append(directories, entry.Name)
+				continue
+			}
+			entryReader, err := entry.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadAll(entryReader)
+			entryReader.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[entry.Name] = content
+		}
+		return files, directories
+	}
+
+	file, err := os.Open(archiveFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var reader io.Reader
+	if format == OutputFormatTarZst {
+		decoder, err := zstd.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer decoder.Close()
+		reader = decoder
+	} else {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			directories = append(directories, header.Name)
+			continue
+		}
+		content, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = content
+	}
+	return files, directories
+}
+
+func TestCreateArchiveFromDirectory(t *testing.T) {
+	want := map[string][]byte{
+		"manifest.json":                     []byte(`{"version": 1}`),
+		"pod1/app/javacore.20210601.txt":    bytes.Repeat([]byte("3XMTHREADINFO \"main\"\n"), 1000),
+		"pod1/app/logs/messages.log":        []byte("started\n"),
+		"pod1/app/empty.txt":                {},
+		"cluster/pods with spaces/pods.txt": []byte("Pod 1:\n"),
+	}
+	wantDirectories := []string{"cluster/", "cluster/pods with spaces/", "pod1/", "pod1/app/", "pod1/app/logs/"}
+
+	source := writeArchiveSource(t, want)
+
+	for _, format := range []string{"", OutputFormatZip, OutputFormatTarGz, OutputFormatTarZst} {
+		for _, compressionLevel := range []int{0, 1, 9, 19} {
+			t.Run(fmt.Sprintf("%s level %d", GetOutputFormatExtension(format), compressionLevel), func(t *testing.T) {
+				archiveFile := filepath.Join(t.TempDir(), "containerdiag."+GetOutputFormatExtension(format))
+				if err := CreateArchiveFromDirectory(source, archiveFile, format, compressionLevel); err != nil {
+					t.Fatal(err)
+				}
+
+				files, directories := readArchive(t, archiveFile, GetOutputFormatExtension(format))
+				if !reflect.DeepEqual(files, want) {
+					t.Errorf("got files %v, want %v", reflect.ValueOf(files).MapKeys(), reflect.ValueOf(want).MapKeys())
+				}
+				sort.Strings(directories)
+				if !reflect.DeepEqual(directories, wantDirectories) {
+					t.Errorf("got directories %v, want %v", directories, wantDirectories)
+				}
+			})
+		}
+	}
+}
+
+func TestCreateArchiveFromDirectoryUnknownFormat(t *testing.T) {
+	source := writeArchiveSource(t, map[string][]byte{"file.txt": []byte("file")})
+	archiveFile := filepath.Join(t.TempDir(), "containerdiag.rar")
+
+	if err := CreateArchiveFromDirectory(source, archiveFile, "rar", 0); err == nil {
+		t.Error("an unknown format was accepted")
+	}
+	if _, err := os.Stat(archiveFile); !os.IsNotExist(err) {
+		t.Errorf("an archive was created: %v", err)
+	}
+}
+
+func TestCreateArchiveFromDirectoryCompressionLevel(t *testing.T) {
+	// Text that compresses differently at the fastest and best levels
+	var content bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		content.WriteString(fmt.Sprintf("[6/1/21 12:00:%02d:%03d UTC] %08x SystemOut O request %d took %d ms\n", i%60, i%1000, i*7919, i, (i*31)%977))
+	}
+	source := writeArchiveSource(t, map[string][]byte{"trace.log": content.Bytes()})
+
+	// The zstd encoder levels don't always produce smaller output so its mapping is tested by
+	// TestGetZstdEncoderLevel
+	for _, format := range []string{OutputFormatZip, OutputFormatTarGz} {
+		t.Run(format, func(t *testing.T) {
+			sizes := map[int]int64{}
+			for _, compressionLevel := range []int{1, 19} {
+				archiveFile := filepath.Join(t.TempDir(), "containerdiag."+format)
+				if err := CreateArchiveFromDirectory(source, archiveFile, format, compressionLevel); err != nil {
+					t.Fatal(err)
+				}
+				info, err := os.Stat(archiveFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sizes[compressionLevel] = info.Size()
+			}
+
+			if sizes[19] >= sizes[1] {
+				t.Errorf("level 19 (%d bytes) isn't smaller than level 1 (%d bytes)", sizes[19], sizes[1])
+			}
+		})
+	}
+}
+
+func TestGetFlateCompressionLevel(t *testing.T) {
+	tests := []struct {
+		compressionLevel int
+		want             int
+	}{
+		{0, flate.DefaultCompression},
+		{-1, flate.DefaultCompression},
+		{1, flate.BestSpeed},
+		{6, 6},
+		{9, flate.BestCompression},
+		{19, flate.BestCompression},
+	}
+
+	for _, test := range tests {
+		if got := GetFlateCompressionLevel(test.compressionLevel); got != test.want {
+			t.Errorf("level %d: got %d, want %d", test.compressionLevel, got, test.want)
+		}
+	}
+}
+
+func TestGetZstdEncoderLevel(t *testing.T) {
+	tests := []struct {
+		compressionLevel int
+		want             zstd.EncoderLevel
+	}{
+		{0, zstd.SpeedDefault},
+		{1, zstd.SpeedFastest},
+		{3, zstd.SpeedDefault},
+		{6, zstd.SpeedBetterCompression},
+		{19, zstd.SpeedBetterCompression},
+	}
+
+	for _, test := range tests {
+		if got := GetZstdEncoderLevel(test.compressionLevel); got != test.want {
+			t.Errorf("level %d: got %s, want %s", test.compressionLevel, got, test.want)
+		}
+	}
+}
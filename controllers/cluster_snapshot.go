@@ -0,0 +1,312 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// +kubebuilder:rbac:groups=core,resources=events;services;endpoints;resourcequotas,verbs=list
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get
+// +kubebuilder:rbac:groups=apps,resources=deployments;replicasets;statefulsets;daemonsets,verbs=get
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get
+// +kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=list
+
+// ContainerRestart is the last termination of a restarted container in a target pod
+type ContainerRestart struct {
+	Namespace    string      `json:"namespace"`
+	Pod          string      `json:"pod"`
+	Container    string      `json:"container"`
+	RestartCount int32       `json:"restartCount"`
+	Reason       string      `json:"reason,omitempty"`
+	Message      string      `json:"message,omitempty"`
+	ExitCode     int32       `json:"exitCode"`
+	Signal       int32       `json:"signal,omitempty"`
+	StartedAt    metav1.Time `json:"startedAt,omitempty"`
+	FinishedAt   metav1.Time `json:"finishedAt,omitempty"`
+}
+
+// ContainerRestartList is written to containerrestarts.yaml
+type ContainerRestartList struct {
+	Items []ContainerRestart `json:"items"`
+}
+
+// ClusterSnapshot writes the resources related to the target pods into the cluster directory
+// of the bundle with one YAML file per resource kind: nodes.yaml for the nodes hosting the target
+// pods and, for each target namespace, namespaces/<namespace>/<kind>.yaml for events, services,
+// endpoints, resource quotas, HPAs, the workloads owning the target pods, and container restarts.
+// Errors are logged and don't stop processing.
+type ClusterSnapshot struct {
+	clientset kubernetes.Interface
+	directory string
+	redactor  *Redactor
+	logger    *CustomLogger
+}
+
+func NewClusterSnapshot(clientset kubernetes.Interface, directory string, redactor *Redactor, logger *CustomLogger) *ClusterSnapshot {
+	return &ClusterSnapshot{clientset: clientset, directory: directory, redactor: redactor, logger: logger}
+}
+
+// Write writes the snapshot for the target pods
+func (s *ClusterSnapshot) Write(ctx context.Context, pods []*corev1.Pod) {
+	podsByNamespace := map[string][]*corev1.Pod{}
+	nodeNames := map[string]bool{}
+	for _, pod := range pods {
+		podsByNamespace[pod.Namespace] = append(podsByNamespace[pod.Namespace], pod)
+		if len(pod.Spec.NodeName) > 0 {
+			nodeNames[pod.Spec.NodeName] = true
+		}
+	}
+
+	s.WriteNodes(ctx, nodeNames)
+
+	for namespace, namespacePods := range podsByNamespace {
+		s.WriteNamespace(ctx, namespace, namespacePods)
+	}
+}
+
+func (s *ClusterSnapshot) WriteNodes(ctx context.Context, nodeNames map[string]bool) {
+	nodes := &corev1.NodeList{TypeMeta: metav1.TypeMeta{Kind: "NodeList", APIVersion: "v1"}}
+	for _, nodeName := range SortedKeys(nodeNames) {
+		node, err := s.clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			s.logger.Info(fmt.Sprintf("ClusterSnapshot could not get node %s: %+v", nodeName, err))
+			continue
+		}
+		nodes.Items = append(nodes.Items, *node)
+	}
+	s.WriteYAML(filepath.Join(s.directory, "nodes.yaml"), nodes)
+}
+
+func (s *ClusterSnapshot) WriteNamespace(ctx context.Context, namespace string, pods []*corev1.Pod) {
+	directory := filepath.Join(s.directory, "namespaces", namespace)
+	err := os.MkdirAll(directory, os.ModePerm)
+	if err != nil {
+		s.logger.Info(fmt.Sprintf("ClusterSnapshot could not create %s: %+v", directory, err))
+		return
+	}
+
+	listOptions := metav1.ListOptions{}
+
+	if events, err := s.clientset.CoreV1().Events(namespace).List(ctx, listOptions); s.check(err, "events", namespace) {
+		events.TypeMeta = metav1.TypeMeta{Kind: "EventList", APIVersion: "v1"}
+		s.WriteYAML(filepath.Join(directory, "events.yaml"), events)
+	}
+
+	if services, err := s.clientset.CoreV1().Services(namespace).List(ctx, listOptions); s.check(err, "services", namespace) {
+		services.TypeMeta = metav1.TypeMeta{Kind: "ServiceList", APIVersion: "v1"}
+		s.WriteYAML(filepath.Join(directory, "services.yaml"), services)
+	}
+
+	if endpoints, err := s.clientset.CoreV1().Endpoints(namespace).List(ctx, listOptions); s.check(err, "endpoints", namespace) {
+		endpoints.TypeMeta = metav1.TypeMeta{Kind: "EndpointsList", APIVersion: "v1"}
+		s.WriteYAML(filepath.Join(directory, "endpoints.yaml"), endpoints)
+	}
+
+	if quotas, err := s.clientset.CoreV1().ResourceQuotas(namespace).List(ctx, listOptions); s.check(err, "resourcequotas", namespace) {
+		quotas.TypeMeta = metav1.TypeMeta{Kind: "ResourceQuotaList", APIVersion: "v1"}
+		s.WriteYAML(filepath.Join(directory, "resourcequotas.yaml"), quotas)
+	}
+
+	if hpas, err := s.clientset.AutoscalingV1().HorizontalPodAutoscalers(namespace).List(ctx, listOptions); s.check(err, "horizontalpodautoscalers", namespace) {
+		hpas.TypeMeta = metav1.TypeMeta{Kind: "HorizontalPodAutoscalerList", APIVersion: "autoscaling/v1"}
+		s.WriteYAML(filepath.Join(directory, "horizontalpodautoscalers.yaml"), hpas)
+	}
+
+	s.WriteWorkloads(ctx, directory, namespace, pods)
+
+	restarts := &ContainerRestartList{Items: []ContainerRestart{}}
+	for _, pod := range pods {
+		restarts.Items = append(restarts.Items, GetContainerRestarts(pod)...)
+	}
+	s.WriteYAML(filepath.Join(directory, "containerrestarts.yaml"), restarts)
+}
+
+// WriteWorkloads writes the objects that own the pods by following owner references
+// (e.g. Pod -> ReplicaSet -> Deployment)
+func (s *ClusterSnapshot) WriteWorkloads(ctx context.Context, directory string, namespace string, pods []*corev1.Pod) {
+	deployments := &appsv1.DeploymentList{TypeMeta: metav1.TypeMeta{Kind: "DeploymentList", APIVersion: "apps/v1"}}
+	replicaSets := &appsv1.ReplicaSetList{TypeMeta: metav1.TypeMeta{Kind: "ReplicaSetList", APIVersion: "apps/v1"}}
+	statefulSets := &appsv1.StatefulSetList{TypeMeta: metav1.TypeMeta{Kind: "StatefulSetList", APIVersion: "apps/v1"}}
+	daemonSets := &appsv1.DaemonSetList{TypeMeta: metav1.TypeMeta{Kind: "DaemonSetList", APIVersion: "apps/v1"}}
+	jobs := &batchv1.JobList{TypeMeta: metav1.TypeMeta{Kind: "JobList", APIVersion: "batch/v1"}}
+
+	seen := map[string]bool{}
+	owners := []metav1.OwnerReference{}
+	for _, pod := range pods {
+		owners = append(owners, pod.OwnerReferences...)
+	}
+
+	for len(owners) > 0 {
+		owner := owners[0]
+		owners = owners[1:]
+
+		key := owner.Kind + "/" + owner.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		var err error
+		switch owner.Kind {
+		case "Deployment":
+			var deployment *appsv1.Deployment
+			deployment, err = s.clientset.AppsV1().Deployments(namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+			if err == nil {
+				s.redactPodTemplate(&deployment.ObjectMeta, &deployment.Spec.Template, directory, "deployments.yaml")
+				deployments.Items = append(deployments.Items, *deployment)
+			}
+		case "ReplicaSet":
+			var replicaSet *appsv1.ReplicaSet
+			replicaSet, err = s.clientset.AppsV1().ReplicaSets(namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+			if err == nil {
+				s.redactPodTemplate(&replicaSet.ObjectMeta, &replicaSet.Spec.Template, directory, "replicasets.yaml")
+				replicaSets.Items = append(replicaSets.Items, *replicaSet)
+				owners = append(owners, replicaSet.OwnerReferences...)
+			}
+		case "StatefulSet":
+			var statefulSet *appsv1.StatefulSet
+			statefulSet, err = s.clientset.AppsV1().StatefulSets(namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+			if err == nil {
+				s.redactPodTemplate(&statefulSet.ObjectMeta, &statefulSet.Spec.Template, directory, "statefulsets.yaml")
+				statefulSets.Items = append(statefulSets.Items, *statefulSet)
+			}
+		case "DaemonSet":
+			var daemonSet *appsv1.DaemonSet
+			daemonSet, err = s.clientset.AppsV1().DaemonSets(namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+			if err == nil {
+				s.redactPodTemplate(&daemonSet.ObjectMeta, &daemonSet.Spec.Template, directory, "daemonsets.yaml")
+				daemonSets.Items = append(daemonSets.Items, *daemonSet)
+			}
+		case "Job":
+			var job *batchv1.Job
+			job, err = s.clientset.BatchV1().Jobs(namespace).Get(ctx, owner.Name, metav1.GetOptions{})
+			if err == nil {
+				s.redactPodTemplate(&job.ObjectMeta, &job.Spec.Template, directory, "jobs.yaml")
+				jobs.Items = append(jobs.Items, *job)
+			}
+		default:
+			s.logger.Info(fmt.Sprintf("ClusterSnapshot skipping owner %s in namespace %s", key, namespace))
+		}
+
+		if err != nil && !k8serrors.IsNotFound(err) {
+			s.logger.Info(fmt.Sprintf("ClusterSnapshot could not get %s in namespace %s: %+v", key, namespace, err))
+		}
+	}
+
+	if len(deployments.Items) > 0 {
+		s.WriteYAML(filepath.Join(directory, "deployments.yaml"), deployments)
+	}
+	if len(replicaSets.Items) > 0 {
+		s.WriteYAML(filepath.Join(directory, "replicasets.yaml"), replicaSets)
+	}
+	if len(statefulSets.Items) > 0 {
+		s.WriteYAML(filepath.Join(directory, "statefulsets.yaml"), statefulSets)
+	}
+	if len(daemonSets.Items) > 0 {
+		s.WriteYAML(filepath.Join(directory, "daemonsets.yaml"), daemonSets)
+	}
+	if len(jobs.Items) > 0 {
+		s.WriteYAML(filepath.Join(directory, "jobs.yaml"), jobs)
+	}
+}
+
+// Pod templates have the same environment variables as pods so they're redacted the same way
+func (s *ClusterSnapshot) redactPodTemplate(objectMeta *metav1.ObjectMeta, template *corev1.PodTemplateSpec, directory string, fileName string) {
+	if s.redactor == nil {
+		return
+	}
+	path, err := filepath.Rel(filepath.Dir(s.directory), filepath.Join(directory, fileName))
+	if err != nil {
+		path = fileName
+	}
+	s.redactor.RedactPodSpec(objectMeta, &template.Spec, filepath.ToSlash(path))
+}
+
+func (s *ClusterSnapshot) check(err error, resource string, namespace string) bool {
+	if err != nil {
+		s.logger.Info(fmt.Sprintf("ClusterSnapshot could not list %s in namespace %s: %+v", resource, namespace, err))
+		return false
+	}
+	return true
+}
+
+func (s *ClusterSnapshot) WriteYAML(path string, obj interface{}) {
+	yamlBytes, err := yaml.Marshal(obj)
+	if err != nil {
+		s.logger.Info(fmt.Sprintf("ClusterSnapshot could not generate YAML for %s: %+v", path, err))
+		return
+	}
+
+	err = ioutil.WriteFile(path, yamlBytes, os.ModePerm)
+	if err != nil {
+		s.logger.Info(fmt.Sprintf("ClusterSnapshot could not write %s: %+v", path, err))
+	}
+}
+
+// GetContainerRestarts returns the last termination of each container in the pod that restarted
+func GetContainerRestarts(pod *corev1.Pod) []ContainerRestart {
+	restarts := []ContainerRestart{}
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, status := range statuses {
+			if status.RestartCount == 0 {
+				continue
+			}
+
+			restart := ContainerRestart{
+				Namespace:    pod.Namespace,
+				Pod:          pod.Name,
+				Container:    status.Name,
+				RestartCount: status.RestartCount,
+			}
+
+			if terminated := status.LastTerminationState.Terminated; terminated != nil {
+				restart.Reason = terminated.Reason
+				restart.Message = terminated.Message
+				restart.ExitCode = terminated.ExitCode
+				restart.Signal = terminated.Signal
+				restart.StartedAt = terminated.StartedAt
+				restart.FinishedAt = terminated.FinishedAt
+			}
+
+			restarts = append(restarts, restart)
+		}
+	}
+	return restarts
+}
+
+func SortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/yaml"
+)
+
+func readSnapshotYAML(t *testing.T, path string, obj interface{}) {
+	t.Helper()
+	yamlBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(yamlBytes, obj); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterSnapshotWrite(t *testing.T) {
+	podTemplate := corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "app", Env: []corev1.EnvVar{
+					{Name: "DB_PASSWORD", Value: "hunter2"},
+					{Name: "DB_USER", Value: "app"},
+				}},
+			},
+		},
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app"},
+		Spec:       appsv1.DeploymentSpec{Template: podTemplate},
+	}
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "app-1234",
+			Namespace:       "app",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Deployment", Name: "app"}},
+		},
+		Spec: appsv1.ReplicaSetSpec{Template: podTemplate},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "app-1234-abcd",
+			Namespace:       "app",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "app-1234"}},
+		},
+		Spec: corev1.PodSpec{NodeName: "node1"},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", RestartCount: 2, LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}}},
+			},
+		},
+	}
+	// A pod on a node that no longer exists and owned by a ReplicaSet that was deleted
+	orphan := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "other",
+			Namespace:       "app",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "deleted"}},
+		},
+		Spec: corev1.PodSpec{NodeName: "node2"},
+	}
+
+	clientset := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "elsewhere", Namespace: "other"}},
+		deployment,
+		replicaSet,
+	)
+
+	redactor, err := NewRedactor(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory := filepath.Join(t.TempDir(), "cluster")
+	if err := os.MkdirAll(directory, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := NewClusterSnapshot(clientset, directory, redactor, &CustomLogger{logger: ctrl.Log.WithName("cluster_snapshot_test")})
+	snapshot.Write(context.Background(), []*corev1.Pod{pod, orphan})
+
+	var nodes corev1.NodeList
+	readSnapshotYAML(t, filepath.Join(directory, "nodes.yaml"), &nodes)
+	if len(nodes.Items) != 1 || nodes.Items[0].Name != "node1" {
+		t.Errorf("got nodes %+v", nodes.Items)
+	}
+
+	namespaceDirectory := filepath.Join(directory, "namespaces", "app")
+
+	var services corev1.ServiceList
+	readSnapshotYAML(t, filepath.Join(namespaceDirectory, "services.yaml"), &services)
+	if len(services.Items) != 1 || services.Items[0].Name != "app" {
+		t.Errorf("got services %+v", services.Items)
+	}
+
+	for _, name := range []string{"events.yaml", "endpoints.yaml", "resourcequotas.yaml", "horizontalpodautoscalers.yaml"} {
+		if _, err := os.Stat(filepath.Join(namespaceDirectory, name)); err != nil {
+			t.Errorf("%s was not written: %+v", name, err)
+		}
+	}
+
+	// Workloads that don't own a target pod aren't written
+	for _, name := range []string{"statefulsets.yaml", "daemonsets.yaml", "jobs.yaml"} {
+		if _, err := os.Stat(filepath.Join(namespaceDirectory, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was written", name)
+		}
+	}
+
+	var replicaSets appsv1.ReplicaSetList
+	readSnapshotYAML(t, filepath.Join(namespaceDirectory, "replicasets.yaml"), &replicaSets)
+	var deployments appsv1.DeploymentList
+	readSnapshotYAML(t, filepath.Join(namespaceDirectory, "deployments.yaml"), &deployments)
+
+	if len(replicaSets.Items) != 1 || len(deployments.Items) != 1 {
+		t.Fatalf("got replica sets %+v and deployments %+v", replicaSets.Items, deployments.Items)
+	}
+	for _, env := range [][]corev1.EnvVar{replicaSets.Items[0].Spec.Template.Spec.Containers[0].Env, deployments.Items[0].Spec.Template.Spec.Containers[0].Env} {
+		if env[0].Value != RedactedText || env[1].Value != "app" {
+			t.Errorf("got environment %+v", env)
+		}
+	}
+
+	want := []RedactedFile{
+		{Path: "cluster/namespaces/app/replicasets.yaml", Redactions: map[string]int{"pod-environment": 1}},
+		{Path: "cluster/namespaces/app/deployments.yaml", Redactions: map[string]int{"pod-environment": 1}},
+	}
+	if !reflect.DeepEqual(redactor.report.Files, want) {
+		t.Errorf("got report files %+v, want %+v", redactor.report.Files, want)
+	}
+
+	var restarts ContainerRestartList
+	readSnapshotYAML(t, filepath.Join(namespaceDirectory, "containerrestarts.yaml"), &restarts)
+	if len(restarts.Items) != 1 || restarts.Items[0].Pod != "app-1234-abcd" || restarts.Items[0].Reason != "OOMKilled" {
+		t.Errorf("got restarts %+v", restarts.Items)
+	}
+
+	if _, err := os.Stat(filepath.Join(directory, "namespaces", "other")); !os.IsNotExist(err) {
+		t.Error("a namespace without target pods was written")
+	}
+}
+
+func TestGetContainerRestarts(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app"},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: "init", RestartCount: 1, LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}}},
+			},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "stable"},
+				{Name: "crashing", RestartCount: 5, LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 143, Signal: 15}}},
+				{Name: "no-termination", RestartCount: 1},
+			},
+		},
+	}
+
+	want := []ContainerRestart{
+		{Namespace: "app", Pod: "app", Container: "init", RestartCount: 1, Reason: "Error", ExitCode: 1},
+		{Namespace: "app", Pod: "app", Container: "crashing", RestartCount: 5, Reason: "Error", ExitCode: 143, Signal: 15},
+		{Namespace: "app", Pod: "app", Container: "no-termination", RestartCount: 1},
+	}
+
+	if got := GetContainerRestarts(pod); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// The names of the log files in the directory of each container in the bundle
+const (
+	ContainerLogFileName         = "logs.txt"
+	PreviousContainerLogFileName = "logs_previous.txt"
+)
+
+// The default maximum size of each container log if Spec.Logs.MaxSizeMB isn't specified
+const DefaultContainerLogMaxSizeMB = 50
+
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+
+// CollectContainerLogs writes the current logs and, if the container restarted, the logs of the
+// previous instance of the container into namespaces/<ns>/pods/<pod>/containers/<container>/ in the
+// bundle. Not having enough disk space for the logs is reported in the status and other errors are
+// logged; neither stops processing.
+func (r *ContainerDiagnosticReconciler) CollectContainerLogs(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger, pod *corev1.Pod, container corev1.Container, contextTracker *ContextTracker) {
+	logOptions := containerDiagnostic.Spec.Logs
+	if logOptions != nil && logOptions.Disabled {
+		return
+	}
+
+	directory := filepath.Join(contextTracker.localPermanentDirectory, "namespaces", pod.Namespace, "pods", pod.Name, "containers", container.Name)
+	err := os.MkdirAll(directory, os.ModePerm)
+	if err != nil {
+		logger.Info(fmt.Sprintf("CollectContainerLogs could not create %s: %+v", directory, err))
+		return
+	}
+
+	podLogOptions := GetPodLogOptions(container.Name, logOptions)
+
+	// Asking for the previous log of a container that hasn't restarted is an error
+	previous := false
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container.Name && status.RestartCount > 0 {
+			previous = true
+		}
+	}
+
+	// The logs are written after the disk space checks of the container's files so each log
+	// is counted at its maximum size
+	logs := int64(1)
+	if previous {
+		logs++
+	}
+	err = CheckDiskSpace(map[string]uint64{directory: uint64(logs * *podLogOptions.LimitBytes)}, r.MinDiskSpaceFreeMB)
+	if err != nil {
+		r.SetStatus(StatusError, fmt.Sprintf("Not enough disk space in the operator for the logs of pod: %s container: %s: %+v", pod.Name, container.Name, err), containerDiagnostic, logger)
+		return
+	}
+
+	clientset, err := kubernetes.NewForConfig(r.Config)
+	if err != nil {
+		logger.Info(fmt.Sprintf("CollectContainerLogs could not create client: %+v", err))
+		return
+	}
+
+	WriteContainerLog(ctx, clientset, logger, pod, podLogOptions, filepath.Join(directory, ContainerLogFileName))
+
+	if previous {
+		previousLogOptions := *podLogOptions
+		previousLogOptions.Previous = true
+		WriteContainerLog(ctx, clientset, logger, pod, &previousLogOptions, filepath.Join(directory, PreviousContainerLogFileName))
+	}
+}
+
+// GetPodLogOptions returns the options to get the log of a container. LimitBytes is always set.
+func GetPodLogOptions(containerName string, logOptions *diagnosticv1.ContainerLogs) *corev1.PodLogOptions {
+	limitBytes := int64(DefaultContainerLogMaxSizeMB) * 1024 * 1024
+
+	podLogOptions := &corev1.PodLogOptions{
+		Container:  containerName,
+		Timestamps: true,
+		LimitBytes: &limitBytes,
+	}
+	if logOptions != nil {
+		if logOptions.TailLines > 0 {
+			podLogOptions.TailLines = &logOptions.TailLines
+		}
+		if logOptions.SinceSeconds > 0 {
+			podLogOptions.SinceSeconds = &logOptions.SinceSeconds
+		}
+		if logOptions.MaxSizeMB > 0 {
+			limitBytes = logOptions.MaxSizeMB * 1024 * 1024
+		}
+	}
+	return podLogOptions
+}
+
+// WriteContainerLog streams a container log into a file. The API server stops at LimitBytes
+// and the stream is also cut off there in case it doesn't.
+func WriteContainerLog(ctx context.Context, clientset kubernetes.Interface, logger *CustomLogger, pod *corev1.Pod, podLogOptions *corev1.PodLogOptions, fileName string) {
+	logger.Info(fmt.Sprintf("WriteContainerLog pod: %s, container: %s, previous: %t to %s", pod.Name, podLogOptions.Container, podLogOptions.Previous, fileName))
+
+	stream, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, podLogOptions).Stream(ctx)
+	if err != nil {
+		logger.Info(fmt.Sprintf("WriteContainerLog could not get the log of pod: %s container: %s: %+v", pod.Name, podLogOptions.Container, err))
+		return
+	}
+	defer stream.Close()
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		logger.Info(fmt.Sprintf("WriteContainerLog could not create %s: %+v", fileName, err))
+		return
+	}
+	defer file.Close()
+
+	var reader io.Reader = stream
+	if podLogOptions.LimitBytes != nil {
+		reader = io.LimitReader(stream, *podLogOptions.LimitBytes)
+	}
+
+	written, err := io.Copy(file, reader)
+	if err != nil {
+		logger.Info(fmt.Sprintf("WriteContainerLog could not write %s: %+v", fileName, err))
+		return
+	}
+
+	if podLogOptions.LimitBytes != nil && written >= *podLogOptions.LimitBytes {
+		logger.Info(fmt.Sprintf("WriteContainerLog truncated %s at %d bytes", fileName, written))
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func int64Pointer(value int64) *int64 {
+	return &value
+}
+
+func TestGetPodLogOptions(t *testing.T) {
+	defaultLimit := int64(DefaultContainerLogMaxSizeMB) * 1024 * 1024
+
+	tests := []struct {
+		name       string
+		logOptions *diagnosticv1.ContainerLogs
+		want       corev1.PodLogOptions
+	}{
+		{
+			"defaults",
+			nil,
+			corev1.PodLogOptions{Container: "app", Timestamps: true, LimitBytes: &defaultLimit},
+		},
+		{
+			"empty",
+			&diagnosticv1.ContainerLogs{},
+			corev1.PodLogOptions{Container: "app", Timestamps: true, LimitBytes: &defaultLimit},
+		},
+		{
+			"tail and since",
+			&diagnosticv1.ContainerLogs{TailLines: 100, SinceSeconds: 3600},
+			corev1.PodLogOptions{Container: "app", Timestamps: true, TailLines: int64Pointer(100), SinceSeconds: int64Pointer(3600), LimitBytes: &defaultLimit},
+		},
+		{
+			"maximum size",
+			&diagnosticv1.ContainerLogs{MaxSizeMB: 2},
+			corev1.PodLogOptions{Container: "app", Timestamps: true, LimitBytes: int64Pointer(2 * 1024 * 1024)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := GetPodLogOptions("app", test.logOptions)
+			if !reflect.DeepEqual(*got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteContainerLog(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "app"}}
+	clientset := fake.NewSimpleClientset(pod)
+	logger := &CustomLogger{logger: ctrl.Log.WithName("container_logs_test")}
+
+	// The fake clientset returns "fake logs" and ignores the options
+	tests := []struct {
+		name       string
+		limitBytes *int64
+		want       string
+	}{
+		{"no limit", nil, "fake logs"},
+		{"under the limit", int64Pointer(100), "fake logs"},
+		{"over the limit", int64Pointer(4), "fake"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), ContainerLogFileName)
+			WriteContainerLog(context.Background(), clientset, logger, pod, &corev1.PodLogOptions{Container: "app", LimitBytes: test.limitBytes}, fileName)
+
+			got, err := ioutil.ReadFile(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
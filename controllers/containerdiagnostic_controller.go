@@ -22,9 +22,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/go-logr/logr"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
 	"strings"
@@ -33,6 +33,7 @@ import (
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -44,11 +45,14 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
 
 	"math/rand"
 	"path/filepath"
+	"sort"
 	"strconv"
 
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	"encoding/json"
@@ -68,6 +72,13 @@ const ResultProcessing = "Processing..."
 
 const FinalizerName = "diagnostic.ibm.com/finalizer"
 
+// The default directory for the final downloads. The manager runs as 'nobody'
+// so /tmp is really the only place unless a volume is mounted (see --output-directory)
+const DefaultOutputDirectory = "/tmp/containerdiagoutput"
+
+// The directory for the local scratch space of each container
+const LocalScratchSpaceRoot = "/tmp/"
+
 type StatusEnum int
 
 const (
@@ -97,21 +108,37 @@ func (se StatusEnum) Value() int {
 // ContainerDiagnosticReconciler reconciles a ContainerDiagnostic object
 type ContainerDiagnosticReconciler struct {
 	client.Client
-	Scheme        *runtime.Scheme
-	Config        *rest.Config
-	EventRecorder record.EventRecorder
+	Scheme          *runtime.Scheme
+	Config          *rest.Config
+	EventRecorder   record.EventRecorder
+	OutputDirectory string
+	DownloadBaseURL string
+
+	// Minimum disk space free (in MB) in the scratch space and output directory of the manager
+	MinDiskSpaceFreeMB int
+
+	// The maximum number of ContainerDiagnostics processed at the same time (e.g. while one waits
+	// for a watch threshold). Defaults to 1.
+	MaxConcurrentReconciles int
 }
 
 type ContextTracker struct {
 	visited                 int
 	successes               int
 	localPermanentDirectory string
+	containerArchives       []string
+	manifest                *BundleManifest
+	targetPods              []*corev1.Pod
+
+	// The total size of the files collected by filtered package steps for MaxBundleSizeMB
+	packagedBytes int64
 }
 
 type CustomLogger struct {
 	logger     logr.Logger
 	outputFile *os.File
 	buffer     string
+	errors     []string
 }
 
 func (l *CustomLogger) Info(str string) {
@@ -180,6 +207,7 @@ func (l *CustomLogger) AppendToLocalFile(str string) {
 // +kubebuilder:rbac:groups=core,resources=pods/status,verbs=get
 // +kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
 // +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -252,6 +280,15 @@ func (r *ContainerDiagnosticReconciler) Reconcile(ctx context.Context, req ctrl.
 		}
 	}
 
+	// When the operator starts, every CR is reconciled so this also verifies that the downloads
+	// of finished runs still exist (e.g. if the output directory isn't on a persistent volume)
+	if IsFinishedStatus(containerDiagnostic) {
+		if rerun, reason := IsRerunRequested(containerDiagnostic); rerun {
+			return r.StartRerun(ctx, containerDiagnostic, reason, logger)
+		}
+		return r.CheckDownloadExists(ctx, containerDiagnostic, logger)
+	}
+
 	logger.Info(fmt.Sprintf("Started normal processing"))
 
 	// This is just a marker status
@@ -264,6 +301,9 @@ func (r *ContainerDiagnosticReconciler) Reconcile(ctx context.Context, req ctrl.
 
 		// We make a quick transition from uninitialized to processing just so
 		// that we can show a processing status in the get
+		now := metav1.Now()
+		containerDiagnostic.Status.StartTime = &now
+		containerDiagnostic.Status.RunGeneration = containerDiagnostic.Spec.RunGeneration
 		r.SetStatus(StatusProcessing, fmt.Sprintf("Started processing. Operator version %s", OperatorVersion), containerDiagnostic, logger)
 
 	} else if containerDiagnostic.Status.StatusCode == StatusProcessing.Value() {
@@ -281,6 +321,11 @@ func (r *ContainerDiagnosticReconciler) Reconcile(ctx context.Context, req ctrl.
 }
 
 func (r *ContainerDiagnosticReconciler) SetStatus(status StatusEnum, message string, containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger) {
+	if status == StatusError {
+		// Errors are also reported in the bundle manifest
+		logger.errors = append(logger.errors, message)
+	}
+
 	r.RecordEventInfo(fmt.Sprintf("Status update (%s): %s @ %s", status.ToString(), message, CurrentTimeAsString()), containerDiagnostic, logger)
 	if IsInitialStatus(containerDiagnostic) {
 		containerDiagnostic.Status.StatusCode = int(status)
@@ -306,12 +351,64 @@ func (r *ContainerDiagnosticReconciler) Finalize(logger *CustomLogger, container
 		}
 	}
 
+	for _, run := range containerDiagnostic.Status.Runs {
+		RemoveRunDownload(run, logger)
+	}
+
 	r.RecordEventInfo(fmt.Sprintf("Finalized and deleted @ %s", CurrentTimeAsString()), containerDiagnostic, logger)
 
 	logger.Info("Successfully finalized")
 	return nil
 }
 
+// CheckDownloadExists marks the status if the download file of a finished run no longer exists
+func (r *ContainerDiagnosticReconciler) CheckDownloadExists(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger) (ctrl.Result, error) {
+	if len(containerDiagnostic.Status.DownloadPath) == 0 || containerDiagnostic.Status.DownloadMissing {
+		return ctrl.Result{}, nil
+	}
+
+	exists, err := DoesFileExist(containerDiagnostic.Status.DownloadPath)
+	if err != nil {
+		logger.Info(fmt.Sprintf("Could not check if %s exists: %+v", containerDiagnostic.Status.DownloadPath, err))
+		return ctrl.Result{}, nil
+	}
+
+	if exists {
+		return ctrl.Result{}, nil
+	}
+
+	containerDiagnostic.Status.DownloadMissing = true
+	containerDiagnostic.Status.Download = fmt.Sprintf("Download file no longer exists: %s", containerDiagnostic.Status.DownloadPath)
+
+	r.RecordEventInfo(fmt.Sprintf("%s @ %s", containerDiagnostic.Status.Download, CurrentTimeAsString()), containerDiagnostic, logger)
+
+	err = r.Status().Update(ctx, containerDiagnostic)
+	if err != nil {
+		logger.Error(err, fmt.Sprintf("Failed to update ContainerDiagnostic status: %v", err))
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+func IsFinishedStatus(containerDiagnostic *diagnosticv1.ContainerDiagnostic) bool {
+	switch StatusEnum(containerDiagnostic.Status.StatusCode) {
+	case StatusSuccess, StatusError, StatusMixed:
+		return true
+	default:
+		return false
+	}
+}
+
+// GetStartTime returns when the current run started. Runs that started before Status.StartTime
+// was added use the creation time.
+func GetStartTime(containerDiagnostic *diagnosticv1.ContainerDiagnostic) time.Time {
+	if containerDiagnostic.Status.StartTime != nil {
+		return containerDiagnostic.Status.StartTime.Time
+	}
+	return containerDiagnostic.CreationTimestamp.Time
+}
+
 func IsInitialStatus(containerDiagnostic *diagnosticv1.ContainerDiagnostic) bool {
 	if strings.HasPrefix(containerDiagnostic.Status.Result, ResultProcessing) {
 		return true
@@ -332,6 +429,15 @@ func (r *ContainerDiagnosticReconciler) ProcessResult(result ctrl.Result, err er
 		r.RecordEventWarning(err, fmt.Sprintf("Finished reconciling with error %v @ %s", err, CurrentTimeAsString()), containerDiagnostic, logger)
 	}
 
+	if IsFinishedStatus(containerDiagnostic) && containerDiagnostic.Status.CompletionTime == nil {
+		now := metav1.Now()
+		containerDiagnostic.Status.CompletionTime = &now
+
+		outcome := StatusEnum(containerDiagnostic.Status.StatusCode).ToString()
+		RunsTotal.WithLabelValues(containerDiagnostic.Spec.Command, outcome).Inc()
+		RunDurationSeconds.WithLabelValues(containerDiagnostic.Spec.Command, outcome).Observe(now.Sub(GetStartTime(containerDiagnostic)).Seconds())
+	}
+
 	if !strings.HasPrefix(containerDiagnostic.Status.Result, ResultProcessing) {
 		statusErr := r.Status().Update(ctx, containerDiagnostic)
 		if statusErr != nil {
@@ -374,21 +480,118 @@ func (r *ContainerDiagnosticReconciler) CommandVersion(ctx context.Context, req
 func (r *ContainerDiagnosticReconciler) CommandScript(ctx context.Context, req ctrl.Request, containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger) (ctrl.Result, error) {
 	logger.Info("Processing command: script")
 
+	ActiveRuns.Inc()
+	defer ActiveRuns.Dec()
+
+	if containerDiagnostic.Spec.TemplateRef != nil {
+		readTemplate := containerDiagnostic.Status.Template == nil
+		template, err := r.GetRunTemplate(ctx, containerDiagnostic)
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				r.SetStatus(StatusError, fmt.Sprintf("Template not found: %s", containerDiagnostic.Spec.TemplateRef.Name), containerDiagnostic, logger)
+				return ctrl.Result{}, nil
+			}
+			return ctrl.Result{}, err
+		}
+
+		err = ApplyTemplate(&containerDiagnostic.Spec, template)
+		if err != nil {
+			r.SetStatus(StatusError, fmt.Sprintf("Could not apply template %s: %+v", containerDiagnostic.Spec.TemplateRef.Name, err), containerDiagnostic, logger)
+			return ctrl.Result{}, nil
+		}
+
+		if readTemplate {
+			r.RecordEventInfo(fmt.Sprintf("Using the steps of template %s", containerDiagnostic.Spec.TemplateRef.Name), containerDiagnostic, logger)
+		}
+	} else if len(containerDiagnostic.Spec.Parameters) > 0 {
+		r.SetStatus(StatusError, fmt.Sprintf("Parameters can only be specified with a templateRef"), containerDiagnostic, logger)
+		return ctrl.Result{}, nil
+	}
+
 	if len(containerDiagnostic.Spec.Steps) == 0 {
 		r.SetStatus(StatusError, fmt.Sprintf("You must specify an array of steps to perform for the script command"), containerDiagnostic, logger)
 		return ctrl.Result{}, nil
 	}
 
+	// The steps of a template aren't checked by the webhook and the parameters might make them
+	// invalid. The steps are checked again in case the webhook isn't deployed.
+	stepErrs := diagnosticv1.ValidateSteps(containerDiagnostic.Spec.Steps, field.NewPath("spec", "steps"))
+	if len(stepErrs) > 0 {
+		r.SetStatus(StatusError, fmt.Sprintf("Invalid steps: %+v", stepErrs.ToAggregate()), containerDiagnostic, logger)
+		return ctrl.Result{}, nil
+	}
+
+	if containerDiagnostic.Spec.TargetObjects == nil && containerDiagnostic.Spec.TargetLabelSelectors == nil {
+		r.SetStatus(StatusError, fmt.Sprintf("You must specify targetLabelSelectors and/or targetObjects to target a set of pods"), containerDiagnostic, logger)
+		return ctrl.Result{}, nil
+	}
+
+	// The watch of a template isn't checked by the webhook
+	if containerDiagnostic.Spec.Watch != nil {
+		allErrs := diagnosticv1.ValidateWatch(containerDiagnostic.Spec.Watch, field.NewPath("spec", "watch"))
+		if len(allErrs) > 0 {
+			r.SetStatus(StatusError, fmt.Sprintf("Invalid watch: %+v", allErrs.ToAggregate()), containerDiagnostic, logger)
+			return ctrl.Result{}, nil
+		}
+	}
+
+	var redactor *Redactor
+	if !containerDiagnostic.Spec.DisableRedaction {
+		var err error
+		redactor, err = NewRedactor(containerDiagnostic.Spec.RedactPatterns)
+		if err != nil {
+			r.SetStatus(StatusError, fmt.Sprintf("Could not process redactPatterns: %+v", err), containerDiagnostic, logger)
+			return ctrl.Result{}, nil
+		}
+	}
+
+	var recipients openpgp.EntityList
+	if containerDiagnostic.Spec.Encryption != nil {
+		var err error
+		recipients, err = r.GetEncryptionRecipients(ctx, containerDiagnostic)
+		if err != nil {
+			r.SetStatus(StatusError, fmt.Sprintf("Could not get encryption keys: %+v", err), containerDiagnostic, logger)
+			return ctrl.Result{}, nil
+		}
+	}
+
+	// The steps run after every watch has finished
+	if containerDiagnostic.Spec.Watch != nil {
+		finished, result, err := r.ReconcileWatches(ctx, containerDiagnostic, logger)
+		if !finished {
+			return result, err
+		}
+	}
+
+	// Check that the manager itself has enough disk space before writing anything
+	err := os.MkdirAll(r.GetOutputDirectory(), os.ModePerm)
+	if err != nil {
+		r.SetStatus(StatusError, fmt.Sprintf("Could not create output directory %s: %+v", r.GetOutputDirectory(), err), containerDiagnostic, logger)
+		return ctrl.Result{}, err
+	}
+
+	err = CheckDiskSpace(map[string]uint64{r.GetOutputDirectory(): 0, LocalScratchSpaceRoot: 0}, r.MinDiskSpaceFreeMB)
+	if err != nil {
+		r.SetStatus(StatusError, fmt.Sprintf("Not enough disk space in the operator: %+v", err), containerDiagnostic, logger)
+		return ctrl.Result{}, nil
+	}
+
 	// Create a permanent directory for this run
-	// user is 'nobody' so /tmp is really the only place
 	uuid := GetUniqueIdentifier()
-	localPermanentDirectory := filepath.Join("/tmp/containerdiagoutput", uuid)
-	err := os.MkdirAll(localPermanentDirectory, os.ModePerm)
+	localPermanentDirectory := filepath.Join(r.GetOutputDirectory(), uuid)
+	err = os.MkdirAll(localPermanentDirectory, os.ModePerm)
 	if err != nil {
 		r.SetStatus(StatusError, fmt.Sprintf("Could not create local permanent output space in %s: %+v", localPermanentDirectory, err), containerDiagnostic, logger)
 		return ctrl.Result{}, err
 	}
 
+	// The collected files may be unredacted and unencrypted so they're never left behind,
+	// whether the final archive is created or not
+	defer func() {
+		logger.CloseLocalFile()
+		os.RemoveAll(localPermanentDirectory)
+	}()
+
 	localPermanentDirectoryCluster := filepath.Join(localPermanentDirectory, "cluster")
 	err = os.MkdirAll(localPermanentDirectoryCluster, os.ModePerm)
 	if err != nil {
@@ -402,12 +605,7 @@ func (r *ContainerDiagnosticReconciler) CommandScript(ctx context.Context, req c
 		return ctrl.Result{}, err
 	}
 
-	contextTracker := ContextTracker{localPermanentDirectory: localPermanentDirectory}
-
-	if containerDiagnostic.Spec.TargetObjects == nil && containerDiagnostic.Spec.TargetLabelSelectors == nil {
-		r.SetStatus(StatusError, fmt.Sprintf("You must specify targetLabelSelectors and/or targetObjects to target a set of pods"), containerDiagnostic, logger)
-		return ctrl.Result{}, nil
-	}
+	contextTracker := ContextTracker{localPermanentDirectory: localPermanentDirectory, manifest: NewBundleManifest(containerDiagnostic, uuid)}
 
 	if containerDiagnostic.Spec.TargetObjects != nil {
 		for _, targetObject := range containerDiagnostic.Spec.TargetObjects {
@@ -465,70 +663,22 @@ func (r *ContainerDiagnosticReconciler) CommandScript(ctx context.Context, req c
 		}
 	}
 
+	// The output directories are removed by the deferred cleanup above
 	if IsInitialStatus(containerDiagnostic) && contextTracker.visited == 0 {
 		r.SetStatus(StatusError, fmt.Sprintf("The specified targetLabelSelectors and/or targetObjects did not evaluate to any pods"), containerDiagnostic, logger)
 		return ctrl.Result{}, nil
 	}
 
-	logger.Info("CommandScript: walking " + localPermanentDirectory)
+	logger.Info(fmt.Sprintf("CommandScript: uncompressing files: %+v", contextTracker.containerArchives))
 
-	checkForCompressedFiles := true
+	archiveExpander := NewArchiveExpander(logger, containerDiagnostic.Spec.ExpandArchives)
 
-	for checkForCompressedFiles {
-		// Walk our perm dir and uncompress anything in place
-		var filesToUncompress []string = []string{}
-
-		err = filepath.Walk(localPermanentDirectory,
-			func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				if strings.HasSuffix(info.Name(), "zip") || strings.HasSuffix(info.Name(), "tar") || strings.HasSuffix(info.Name(), "tar.gz") {
-					// path is the absolute path since we call Walk with an absolute path
-					// https://pkg.go.dev/path/filepath#WalkFunc
-					filesToUncompress = append(filesToUncompress, path)
-				}
-				return nil
-			})
+	for _, containerArchive := range contextTracker.containerArchives {
+		err = archiveExpander.Expand(containerArchive, 1)
 		if err != nil {
-			r.SetStatus(StatusError, fmt.Sprintf("Could not walk local permanent output space in %s: %+v", localPermanentDirectory, err), containerDiagnostic, logger)
+			r.SetStatus(StatusError, fmt.Sprintf("Could not uncompress %s: %+v", containerArchive, err), containerDiagnostic, logger)
 			return ctrl.Result{}, err
 		}
-
-		if len(filesToUncompress) == 0 {
-			break
-		}
-
-		logger.Info(fmt.Sprintf("CommandScript: uncompressing files: %+v", filesToUncompress))
-
-		for _, fileToUncompress := range filesToUncompress {
-			logger.Info(fmt.Sprintf("Uncompressing %s", fileToUncompress))
-
-			var command string
-			var arguments []string
-
-			if strings.HasSuffix(fileToUncompress, "zip") {
-				command = "unzip"
-				arguments = []string{fileToUncompress, "-d", filepath.Dir(fileToUncompress)}
-			} else if strings.HasSuffix(fileToUncompress, "tar") {
-				command = "tar"
-				arguments = []string{"-C", filepath.Dir(fileToUncompress), "-xvf", fileToUncompress}
-			} else if strings.HasSuffix(fileToUncompress, "tar.gz") {
-				command = "tar"
-				arguments = []string{"-C", filepath.Dir(fileToUncompress), "-xzvf", fileToUncompress}
-			}
-
-			outputBytes, err := r.ExecuteLocalCommand(logger, containerDiagnostic, command, arguments...)
-			var outputStr string = string(outputBytes[:])
-			if err != nil {
-				r.SetStatus(StatusError, fmt.Sprintf("Could not uncompress %s: %+v %s", fileToUncompress, err, outputStr), containerDiagnostic, logger)
-				return ctrl.Result{}, err
-			}
-
-			logger.Debug1(fmt.Sprintf("CommandScript uncompress output: %v", outputStr))
-
-			os.Remove(fileToUncompress)
-		}
 	}
 
 	logger.Info("CommandScript: Finished pre-processing zip for download.")
@@ -536,35 +686,46 @@ func (r *ContainerDiagnosticReconciler) CommandScript(ctx context.Context, req c
 	logger.CloseLocalFile()
 
 	// Manager container name
-	hostnameBytes, err := ioutil.ReadFile("/etc/hostname")
+	managerPodName, err := GetManagerPodName()
 	if err != nil {
-		r.SetStatus(StatusError, fmt.Sprintf("Could not read /etc/hostname: %+v", err), containerDiagnostic, logger)
+		r.SetStatus(StatusError, fmt.Sprintf("Could not find the manager pod name: %+v", err), containerDiagnostic, logger)
 		return ctrl.Result{}, err
 	}
 
-	managerPodName := string(hostnameBytes)
-	managerPodName = strings.ReplaceAll(managerPodName, "\n", "")
-	managerPodName = strings.ReplaceAll(managerPodName, "\r", "")
+	containerDiagnostic.Status.DownloadNamespace, err = GetManagerPodNamespace()
+	if err != nil {
+		r.SetStatus(StatusError, fmt.Sprintf("Could not find the manager pod namespace: %+v", err), containerDiagnostic, logger)
+		return ctrl.Result{}, err
+	}
 
-	logger.Info("CommandScript: processing all pods")
+	logger.Info(fmt.Sprintf("manager pod namespace: %s", containerDiagnostic.Status.DownloadNamespace))
 
-	// Find the namespace of the manager container pod
 	clientset, err := kubernetes.NewForConfig(r.Config)
 	if err != nil {
 		r.SetStatus(StatusError, fmt.Sprintf("Could not create client: %+v", err), containerDiagnostic, logger)
 		return ctrl.Result{}, err
 	}
 
-	// https://github.com/kubernetes/client-go/blob/master/kubernetes/typed/core/v1/pod.go#L43
-	allpods, err := clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+	// Listing every pod in the cluster is slow on large clusters so by default only the
+	// target pods are written
+	var pods []corev1.Pod
+	if containerDiagnostic.Spec.DumpAllPods {
+		logger.Info("CommandScript: processing all pods")
 
-	if err != nil {
-		r.SetStatus(StatusError, fmt.Sprintf("Could not list all pods: %+v", err), containerDiagnostic, logger)
-		return ctrl.Result{}, err
-	}
+		// https://github.com/kubernetes/client-go/blob/master/kubernetes/typed/core/v1/pod.go#L43
+		allpods, err := clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{})
+
+		if err != nil {
+			r.SetStatus(StatusError, fmt.Sprintf("Could not list all pods: %+v", err), containerDiagnostic, logger)
+			return ctrl.Result{}, err
+		}
 
-	// We're searching for our manager pod namespace, but while we're at it, let's note
-	// down all pods and their details
+		pods = allpods.Items
+	} else {
+		for _, pod := range contextTracker.targetPods {
+			pods = append(pods, *pod)
+		}
+	}
 
 	podsFile, err := os.OpenFile(filepath.Join(localPermanentDirectoryCluster, "pods.txt"), os.O_CREATE|os.O_WRONLY, os.ModePerm)
 	if err != nil {
@@ -572,47 +733,134 @@ func (r *ContainerDiagnosticReconciler) CommandScript(ctx context.Context, req c
 		return ctrl.Result{}, err
 	}
 
-	for podIndex, pod := range allpods.Items {
+	for podIndex, pod := range pods {
+		if redactor != nil {
+			pod = *pod.DeepCopy()
+			redactor.RedactPod(&pod, filepath.ToSlash(filepath.Join("cluster", "pods.txt")))
+		}
+
 		jsonBytes, err := json.MarshalIndent(pod, "  ", "  ")
 		if err != nil {
 			r.SetStatus(StatusError, fmt.Sprintf("Could not generate JSON for %s: %+v", pod.Name, err), containerDiagnostic, logger)
+			podsFile.Close()
 			return ctrl.Result{}, err
 		}
 		podsFile.WriteString(fmt.Sprintf("Pod %d:\n%s\n", (podIndex + 1), string(jsonBytes)))
-		if pod.Name == managerPodName {
-			containerDiagnostic.Status.DownloadNamespace = pod.Namespace
-		}
 	}
 
 	podsFile.Close()
 
-	logger.Info(fmt.Sprintf("manager pod namespace: %s", containerDiagnostic.Status.DownloadNamespace))
+	logger.Info("CommandScript: writing cluster snapshot")
+
+	NewClusterSnapshot(clientset, localPermanentDirectoryCluster, redactor, logger).Write(ctx, contextTracker.targetPods)
+
+	if redactor != nil {
+		logger.Info("CommandScript: redacting files")
+
+		err = redactor.RedactDirectory(localPermanentDirectory, logger)
+		if err != nil {
+			r.SetStatus(StatusError, fmt.Sprintf("Could not redact files in %s: %+v", localPermanentDirectory, err), containerDiagnostic, logger)
+			return ctrl.Result{}, err
+		}
+
+		// The manifest is written after this pass so it's redacted separately
+		redactor.RedactManifest(contextTracker.manifest, logger)
+
+		err = redactor.WriteReport(localPermanentDirectory)
+		if err != nil {
+			r.SetStatus(StatusError, fmt.Sprintf("Could not write %s in %s: %+v", RedactionReportFileName, localPermanentDirectory, err), containerDiagnostic, logger)
+			return ctrl.Result{}, err
+		}
 
-	logger.Info("CommandScript: creating final zip")
+		containerDiagnostic.Status.Redactions = redactor.Total()
 
-	// Finally, zip up the files for final user download
-	finalZip := filepath.Join("/tmp/containerdiagoutput", fmt.Sprintf("containerdiag_%s_%s.zip", time.Now().Format("20060102_150405"), uuid))
-	outputBytes, err := r.ExecuteLocalCommand(logger, containerDiagnostic, "sh", "-c", fmt.Sprintf("cd %s; zip -r %s .", localPermanentDirectory, finalZip))
-	var outputStr string = string(outputBytes[:])
+		r.RecordEventInfo(fmt.Sprintf("Redacted %d values (see %s)", redactor.Total(), RedactionReportFileName), containerDiagnostic, logger)
+
+		// Nested archives that weren't expanded are compressed so their contents weren't redacted
+		if len(archiveExpander.NotExpanded) > 0 {
+			var notExpanded []string
+			for _, archive := range archiveExpander.NotExpanded {
+				name, err := filepath.Rel(localPermanentDirectory, archive)
+				if err != nil {
+					name = archive
+				}
+				notExpanded = append(notExpanded, filepath.ToSlash(name))
+			}
+			r.RecordEventInfo(fmt.Sprintf("%d nested archives were not expanded so they were not redacted (add them to expandArchives to redact them): %s", len(notExpanded), strings.Join(notExpanded, ", ")), containerDiagnostic, logger)
+		}
+	}
+
+	err = contextTracker.manifest.Write(localPermanentDirectory, logger)
+	if err != nil {
+		r.SetStatus(StatusError, fmt.Sprintf("Could not write %s in %s: %+v", ManifestFileName, localPermanentDirectory, err), containerDiagnostic, logger)
+		return ctrl.Result{}, err
+	}
+
+	// The final archive is no larger than the files it contains (plus headers)
+	permanentBytes, err := GetDirectorySize(localPermanentDirectory)
 	if err != nil {
-		r.SetStatus(StatusError, fmt.Sprintf("Could not zip %s: %+v %s", finalZip, err, outputStr), containerDiagnostic, logger)
+		r.SetStatus(StatusError, fmt.Sprintf("Could not get the size of %s: %+v", localPermanentDirectory, err), containerDiagnostic, logger)
 		return ctrl.Result{}, err
 	}
 
-	logger.Debug1(fmt.Sprintf("CommandScript zip output: %v", outputStr))
+	// Encryption writes a second copy of the final archive
+	if recipients != nil {
+		permanentBytes *= 2
+	}
+
+	err = CheckDiskSpace(map[string]uint64{r.GetOutputDirectory(): uint64(permanentBytes)}, r.MinDiskSpaceFreeMB)
+	if err != nil {
+		r.SetStatus(StatusError, fmt.Sprintf("Not enough disk space in the operator to create the final download: %+v", err), containerDiagnostic, logger)
+		return ctrl.Result{}, nil
+	}
+
+	logger.Info(fmt.Sprintf("CommandScript: creating final %s", GetOutputFormatExtension(containerDiagnostic.Spec.OutputFormat)))
+
+	// Finally, archive the files for final user download
+	finalZip := filepath.Join(r.GetOutputDirectory(), fmt.Sprintf("containerdiag_%s_%s.%s", time.Now().Format("20060102_150405"), uuid, GetOutputFormatExtension(containerDiagnostic.Spec.OutputFormat)))
+	err = CreateArchiveFromDirectory(localPermanentDirectory, finalZip, containerDiagnostic.Spec.OutputFormat, containerDiagnostic.Spec.CompressionLevel)
+	if err != nil {
+		r.SetStatus(StatusError, fmt.Sprintf("Could not create %s: %+v", finalZip, err), containerDiagnostic, logger)
+
+		// A partial archive is never a valid download
+		os.Remove(finalZip)
+		return ctrl.Result{}, err
+	}
 
-	// Now that we've created the zip, we can delete the actual directory to save space
+	// Now that we've created the zip, we can delete the actual directory to save space (before
+	// encryption writes another copy)
 	os.RemoveAll(localPermanentDirectory)
 
+	if recipients != nil {
+		encryptedZip := finalZip + "." + EncryptedFileExtension
+		err = EncryptFile(finalZip, encryptedZip, recipients)
+
+		// Never keep the unencrypted download
+		os.Remove(finalZip)
+
+		if err != nil {
+			r.SetStatus(StatusError, fmt.Sprintf("Could not encrypt %s: %+v", finalZip, err), containerDiagnostic, logger)
+			return ctrl.Result{}, err
+		}
+
+		finalZip = encryptedZip
+
+		r.RecordEventInfo(fmt.Sprintf("Encrypted download for %s", DescribeRecipients(recipients)), containerDiagnostic, logger)
+	}
+
 	containerDiagnostic.Status.DownloadPath = finalZip
 	containerDiagnostic.Status.DownloadFileName = filepath.Base(finalZip)
 	containerDiagnostic.Status.DownloadPod = managerPodName
 	containerDiagnostic.Status.DownloadContainer = "manager"
 
-	if containerDiagnostic.Status.DownloadNamespace == "" {
-		containerDiagnostic.Status.Download = fmt.Sprintf("kubectl cp %s:%s %s --container=%s", containerDiagnostic.Status.DownloadPod, containerDiagnostic.Status.DownloadPath, containerDiagnostic.Status.DownloadFileName, containerDiagnostic.Status.DownloadContainer)
-	} else {
-		containerDiagnostic.Status.Download = fmt.Sprintf("kubectl cp %s:%s %s --container=%s --namespace=%s", containerDiagnostic.Status.DownloadPod, containerDiagnostic.Status.DownloadPath, containerDiagnostic.Status.DownloadFileName, containerDiagnostic.Status.DownloadContainer, containerDiagnostic.Status.DownloadNamespace)
+	containerDiagnostic.Status.Download = fmt.Sprintf("kubectl cp %s:%s %s --container=%s --namespace=%s", containerDiagnostic.Status.DownloadPod, containerDiagnostic.Status.DownloadPath, containerDiagnostic.Status.DownloadFileName, containerDiagnostic.Status.DownloadContainer, containerDiagnostic.Status.DownloadNamespace)
+
+	if len(r.DownloadBaseURL) > 0 {
+		containerDiagnostic.Status.DownloadURL = GetDownloadURL(r.DownloadBaseURL, containerDiagnostic)
+	}
+
+	if containerDiagnostic.Spec.S3 != nil {
+		r.UploadToS3(ctx, containerDiagnostic, finalZip, logger)
 	}
 
 	r.RecordEventInfo(fmt.Sprintf("Download: %s", containerDiagnostic.Status.Download), containerDiagnostic, logger)
@@ -638,14 +886,73 @@ func (r *ContainerDiagnosticReconciler) CommandScript(ctx context.Context, req c
 	return ctrl.Result{}, nil
 }
 
+// GetOutputDirectory returns the directory for the final downloads
+func (r *ContainerDiagnosticReconciler) GetOutputDirectory() string {
+	if len(r.OutputDirectory) == 0 {
+		return DefaultOutputDirectory
+	}
+	return r.OutputDirectory
+}
+
+// UploadToS3 uploads the final download to object storage and, if successful, replaces the
+// kubectl cp command in Status.Download with a link to the object
+func (r *ContainerDiagnosticReconciler) UploadToS3(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic, finalZip string, logger *CustomLogger) {
+	logger.Info(fmt.Sprintf("CommandScript: uploading %s to %s bucket %s", finalZip, containerDiagnostic.Spec.S3.Endpoint, containerDiagnostic.Spec.S3.Bucket))
+
+	s3Config, err := r.GetS3UploadConfig(ctx, containerDiagnostic, finalZip)
+	if err != nil {
+		// The local download is still available so we don't stop processing
+		r.SetStatus(StatusError, fmt.Sprintf("Could not upload %s: %+v", finalZip, err), containerDiagnostic, logger)
+		return
+	}
+
+	result, err := UploadToS3(ctx, s3Config, finalZip)
+	if err != nil {
+		r.SetStatus(StatusError, fmt.Sprintf("Could not upload %s to %s bucket %s: %+v", finalZip, s3Config.Endpoint, s3Config.Bucket, err), containerDiagnostic, logger)
+		return
+	}
+
+	if fileInfo, err := os.Stat(finalZip); err == nil {
+		TransferredBytes.WithLabelValues(TransferDirectionUpload, TransferTargetS3).Add(float64(fileInfo.Size()))
+	}
+
+	containerDiagnostic.Status.UploadURL = result.ObjectURL
+
+	if len(result.PresignedURL) > 0 {
+		containerDiagnostic.Status.Download = result.PresignedURL
+	} else {
+		containerDiagnostic.Status.Download = result.ObjectURL
+	}
+
+	r.RecordEventInfo(fmt.Sprintf("Uploaded to %s", result.ObjectURL), containerDiagnostic, logger)
+}
+
 func (r *ContainerDiagnosticReconciler) RunScriptOnPod(ctx context.Context, req ctrl.Request, containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger, pod *corev1.Pod, contextTracker *ContextTracker) {
 	logger.Info(fmt.Sprintf("RunScriptOnPod containers: %d", len(pod.Spec.Containers)))
+
+	// For the cluster snapshot
+	contextTracker.targetPods = append(contextTracker.targetPods, pod.DeepCopy())
+
 	for _, container := range pod.Spec.Containers {
+		if !IsTargetContainer(&containerDiagnostic.Spec, container.Name) {
+			logger.Info(fmt.Sprintf("RunScriptOnPod skipping container: %s", container.Name))
+			continue
+		}
+
 		logger.Info(fmt.Sprintf("RunScriptOnPod container: %+v", container))
 		r.RunScriptOnContainer(ctx, req, containerDiagnostic, logger, pod, container, contextTracker)
+		r.CollectContainerLogs(ctx, containerDiagnostic, logger, pod, container, contextTracker)
 	}
 }
 
+// IsTargetContainer returns whether the steps run on a container of a target pod
+func IsTargetContainer(spec *diagnosticv1.ContainerDiagnosticSpec, containerName string) bool {
+	if len(spec.Containers) > 0 && !ContainsString(spec.Containers, containerName) {
+		return false
+	}
+	return !ContainsString(spec.ExcludeContainers, containerName)
+}
+
 func GetUniqueIdentifier() string {
 	// We don't use a UUID because it contains letters and
 	// that may accidentally contain a command such as "df"
@@ -667,8 +974,14 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 
 	logger.Info(fmt.Sprintf("RunScriptOnContainer UUID = %s", uuid))
 
+	manifestTarget := contextTracker.manifest.StartTarget(pod.Namespace, pod.Name, container.Name, pod.Spec.NodeName, uuid, logger)
+	previousSuccesses := contextTracker.successes
+	defer func() {
+		manifestTarget.Finish(contextTracker.successes > previousSuccesses, logger)
+	}()
+
 	// First create a local scratchspace
-	localScratchSpaceDirectory := filepath.Join("/tmp/", uuid)
+	localScratchSpaceDirectory := filepath.Join(LocalScratchSpaceRoot, uuid)
 	err := os.MkdirAll(localScratchSpaceDirectory, os.ModePerm)
 	if err != nil {
 		r.SetStatus(StatusError, fmt.Sprintf("Could not create local scratchspace in %s: %+v", localScratchSpaceDirectory, err), containerDiagnostic, logger)
@@ -680,7 +993,7 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 
 	logger.Info(fmt.Sprintf("RunScriptOnContainer Created local scratch space: %s", localScratchSpaceDirectory))
 
-	containerTmpFilesPrefix, ok := r.EnsureDirectoriesOnContainer(ctx, req, containerDiagnostic, logger, pod, container, contextTracker, uuid)
+	containerTmpFilesPrefix, ok := r.EnsureDirectoriesOnContainer(ctx, req, containerDiagnostic, logger, pod, container, contextTracker, manifestTarget, uuid)
 	if !ok {
 		// The error will have been logged within the above function.
 		// We don't stop processing other pods/containers, just return. If this is the
@@ -752,9 +1065,6 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 
 	filesToTar := make(map[string]bool)
 
-	// "--dereference" not needed because we tar up the symlink targets too
-	var tarArguments []string = []string{"-cv", "-f", localTarFile}
-
 	// First add in some basic commands that we'll always need
 	for _, command := range []string{
 		"/usr/bin/cp",
@@ -785,6 +1095,21 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 		}
 	}
 
+	// Filtered package steps list their files with find
+	for _, step := range containerDiagnostic.Spec.Steps {
+		if IsPackageFilterEnabled(containerDiagnostic, step) {
+			ok := r.ProcessInstallCommand("/usr/bin/find", filesToTar, containerDiagnostic, logger)
+			if !ok {
+				// The error will have been logged within the above function.
+				// We don't stop processing other pods/containers, just return. If this is the
+				// only error, status will show as error; otherwise, as mixed
+				Cleanup(logger, localScratchSpaceDirectory)
+				return
+			}
+			break
+		}
+	}
+
 	// Now add in any commands that the user has specified
 	for _, step := range containerDiagnostic.Spec.Steps {
 		if step.Command == "install" {
@@ -954,12 +1279,18 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 		}
 	}
 
+	// The arguments of package steps whose files are listed and filtered before zipping
+	filteredPackageSteps := make(map[int][]string)
+
 	// Create the execute script(s)
 	for stepIndex, step := range containerDiagnostic.Spec.Steps {
 		if step.Command == "execute" {
 
-			if step.Arguments == nil || len(step.Arguments) == 0 {
-				r.SetStatus(StatusError, fmt.Sprintf("Run command must have arguments including the binary name"), containerDiagnostic, logger)
+			// Build the command execution with arguments
+			command, arguments, background := GetExecuteCommand(step)
+
+			if len(command) == 0 {
+				r.SetStatus(StatusError, fmt.Sprintf("Run command must have arguments or execCommand including the binary name"), containerDiagnostic, logger)
 
 				// We don't stop processing other pods/containers, just return. If this is the
 				// only error, status will show as error; otherwise, as mixed
@@ -967,7 +1298,18 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 				return
 			}
 
-			remoteOutputFile := filepath.Join(containerTmpFilesPrefix, fmt.Sprintf("containerdiag_%s_%d.txt", time.Now().Format("20060102_150405"), (stepIndex+1)))
+			remoteOutputFile, err := GetExecuteOutputFile(containerTmpFilesPrefix, step, fmt.Sprintf("containerdiag_%s_%d.txt", time.Now().Format("20060102_150405"), (stepIndex+1)))
+			if err == nil {
+				err = CheckExecuteEnv(step)
+			}
+			if err != nil {
+				r.SetStatus(StatusError, fmt.Sprintf("Invalid execute step %d: %+v", (stepIndex+1), err), containerDiagnostic, logger)
+
+				// We don't stop processing other pods/containers, just return. If this is the
+				// only error, status will show as error; otherwise, as mixed
+				Cleanup(logger, localScratchSpaceDirectory)
+				return
+			}
 
 			remoteFilesToPackage[remoteOutputFile] = true
 
@@ -1002,31 +1344,7 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 			// Echo outputfile directly to stdout without redirecting to the output file because a user executing this script wants to know where the output goes
 			WriteExecutionLine(localExecuteFile, containerTmpFilesPrefix, "echo", fmt.Sprintf("\"Writing output to %s\"", remoteOutputFile), false, "", false)
 
-			// Build the command execution with arguments
-			command := step.Arguments[0]
-			arguments := ""
-
-			spaceIndex := strings.Index(strings.TrimSpace(command), " ")
-			if spaceIndex != -1 {
-				arguments = command[spaceIndex+1:]
-				command = command[:spaceIndex]
-			}
-
-			background := false
-
-			for index, arg := range step.Arguments {
-				if index > 0 {
-					if len(arguments) > 0 {
-						arguments += " "
-					}
-					arguments += arg
-				}
-			}
-
-			if strings.HasSuffix(arguments, " &") {
-				background = true
-				arguments = arguments[:len(arguments)-2]
-			}
+			WriteExecuteWorkingDir(localExecuteFile, step)
 
 			// Echo a simple prolog to the output file including free disk space
 			WriteExecutionLine(localExecuteFile, containerTmpFilesPrefix, "date", "", true, remoteOutputFile, false)
@@ -1045,18 +1363,26 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 
 			// Execute the command with arguments
 			if command == "linperf.sh" {
+				// linperf.sh gathers the Java processes unless args are specified
 				executionScript := filepath.Join(containerTmpFilesPrefix, localScratchSpaceDirectory, command)
-				localExecuteFile.WriteString(fmt.Sprintf("%s $(%s) >> %s 2>&1\n", executionScript, GetExecutionCommand(containerTmpFilesPrefix, "pgrep", "java"), remoteOutputFile))
+				linperfArguments := fmt.Sprintf("$(%s)", GetExecutionCommand(containerTmpFilesPrefix, "pgrep", "java"))
+				if len(step.ExecCommand) > 1 || len(step.Args) > 0 {
+					linperfArguments = arguments
+				}
+				WriteExecuteCommand(localExecuteFile, containerTmpFilesPrefix, step, executionScript+" "+linperfArguments, background, remoteOutputFile)
 
 				remoteFilesToPackage[filepath.Join(containerTmpFilesPrefix, "linperf_RESULTS.tar.gz")] = true
 			} else {
-				WriteExecutionLine(localExecuteFile, containerTmpFilesPrefix, command, arguments, true, remoteOutputFile, background)
+				WriteExecuteCommand(localExecuteFile, containerTmpFilesPrefix, step, GetExecutionCommand(containerTmpFilesPrefix, command, arguments), background, remoteOutputFile)
 			}
 
 			// Echo a simple epilog to the output file
 			WriteExecutionLine(localExecuteFile, containerTmpFilesPrefix, "echo", "\"\"", true, remoteOutputFile, false)
 			WriteExecutionLine(localExecuteFile, containerTmpFilesPrefix, "date", "", true, remoteOutputFile, false)
-			WriteExecutionLine(localExecuteFile, containerTmpFilesPrefix, "echo", fmt.Sprintf("\"containerdiag: Finished execution of %s\"", command), true, remoteOutputFile, false)
+			WriteExecutionLine(localExecuteFile, containerTmpFilesPrefix, "echo", fmt.Sprintf("\"containerdiag: Finished execution of %s with exit code ${RC}\"", command), true, remoteOutputFile, false)
+
+			// Report if the tool failed
+			WriteExecuteExit(localExecuteFile, containerTmpFilesPrefix, command)
 
 			localExecuteFile.Close()
 
@@ -1076,8 +1402,12 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 				return
 			}
 
-			for _, arg := range step.Arguments {
-				remoteFilesToPackage[arg] = true
+			if IsPackageFilterEnabled(containerDiagnostic, step) {
+				filteredPackageSteps[stepIndex] = step.Arguments
+			} else {
+				for _, arg := range step.Arguments {
+					remoteFilesToPackage[arg] = true
+				}
 			}
 
 			logger.Info(fmt.Sprintf("RunScriptOnContainer finished 'package' step"))
@@ -1092,6 +1422,16 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 		}
 	}
 
+	// The output of the watch of this container (if any) is packaged and its directory cleaned
+	var watchStatus *diagnosticv1.ContainerWatchStatus
+	if containerDiagnostic.Spec.Watch != nil {
+		watchStatus = FindWatch(&containerDiagnostic.Status, pod, container.Name)
+		if watchStatus != nil && len(watchStatus.Directory) > 0 {
+			remoteFilesToPackage[filepath.Join(watchStatus.Directory, WatchOutputFileName)] = true
+			remoteFilesToClean[watchStatus.Directory] = true
+		}
+	}
+
 	// Create the zip script
 	zipFileName := fmt.Sprintf("containerdiag_%s.zip", time.Now().Format("20060102_150405"))
 	remoteZipFile := filepath.Join(containerTmpFilesPrefix, zipFileName)
@@ -1119,6 +1459,14 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 	}
 	localZipScriptFile.WriteString("\n")
 
+	// The files of filtered package steps are passed on stdin
+	if len(filteredPackageSteps) > 0 {
+		localZipScriptFile.WriteString(fmt.Sprintf("%s %s -@\n", GetExecutionCommand(containerTmpFilesPrefix, "zip", ""), remoteZipFile))
+	}
+
+	// Print the size of the zip so that we can check there's enough local disk space before downloading it
+	localZipScriptFile.WriteString(fmt.Sprintf("%s %s\n", GetExecutionCommand(containerTmpFilesPrefix, "ls", "-ln"), remoteZipFile))
+
 	// Some files (e.g. in /proc) might not exist or we don't have permission but we don't want to fail because of that, so just return true
 	localZipScriptFile.WriteString("exit 0\n")
 
@@ -1132,6 +1480,32 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 		remoteFilesToPackage[filepath.Join(containerTmpFilesPrefix, localScratchSpaceDirectory, "zip.sh")] = true
 	}
 
+	// Create the list script for filtered package steps
+	if len(filteredPackageSteps) > 0 {
+		localListScript := filepath.Join(localScratchSpaceDirectory, "list.sh")
+		localListScriptFile, err := os.OpenFile(localListScript, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+		if err != nil {
+			r.SetStatus(StatusError, fmt.Sprintf("Error writing local list.sh file %s error: %+v", localListScript, err), containerDiagnostic, logger)
+
+			// We don't stop processing other pods/containers, just return. If this is the
+			// only error, status will show as error; otherwise, as mixed
+			Cleanup(logger, localScratchSpaceDirectory)
+			return
+		}
+
+		WriteListScript(localListScriptFile, containerTmpFilesPrefix, filteredPackageSteps)
+
+		localListScriptFile.Close()
+
+		os.Chmod(localListScript, os.ModePerm)
+
+		filesToTar[localListScript] = true
+
+		if containerDiagnostic.Spec.Debug {
+			remoteFilesToPackage[filepath.Join(containerTmpFilesPrefix, localScratchSpaceDirectory, "list.sh")] = true
+		}
+	}
+
 	// Create the clean script
 	localCleanScript := filepath.Join(localScratchSpaceDirectory, "clean.sh")
 	localCleanScriptFile, err := os.OpenFile(localCleanScript, os.O_CREATE|os.O_WRONLY, os.ModePerm)
@@ -1170,43 +1544,17 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 
 	// Upload any files that are needed
 	if len(filesToTar) > 0 {
+		// Symlinks are stored as symlinks because we tar up the symlink targets too
+		var tarFiles []string
 		for key := range filesToTar {
-			tarArguments = append(tarArguments, key)
-		}
-
-		logger.Info(fmt.Sprintf("RunScriptOnContainer creating local tar..."))
-
-		outputBytes, err := r.ExecuteLocalCommand(logger, containerDiagnostic, "tar", tarArguments...)
-		if err != nil {
-			// The error will have been logged within the above function.
-			// We don't stop processing other pods/containers, just return. If this is the
-			// only error, status will show as error; otherwise, as mixed
-			Cleanup(logger, localScratchSpaceDirectory)
-			return
-		}
-
-		var outputStr string = string(outputBytes[:])
-		logger.Debug2(fmt.Sprintf("RunScriptOnContainer local tar output: %v", outputStr))
-
-		file, err := os.Open(localTarFile)
-		if err != nil {
-			r.SetStatus(StatusError, fmt.Sprintf("Error reading tar file %s error: %+v", localTarFile, err), containerDiagnostic, logger)
-
-			// We don't stop processing other pods/containers, just return. If this is the
-			// only error, status will show as error; otherwise, as mixed
-			Cleanup(logger, localScratchSpaceDirectory)
-			return
+			tarFiles = append(tarFiles, key)
 		}
 
-		fileReader := bufio.NewReader(file)
-		logger.Info(fmt.Sprintf("RunScriptOnContainer local tar file binary size: %d", fileReader.Size()))
-
 		var tarStdout, tarStderr bytes.Buffer
-		err = r.ExecInContainer(pod, container, []string{"tar", "-xmf", "-", "-C", containerTmpFilesPrefix}, &tarStdout, &tarStderr, fileReader, nil)
-
-		file.Close()
-
-		logger.Debug1(fmt.Sprintf("ExecInContainer results: stdout: %s\n\nstderr: %s\n", tarStdout.String(), tarStderr.String()))
+		uploadCommand := GetUploadCommand(containerTmpFilesPrefix)
+		manifestStep := manifestTarget.StartStep(0, "upload", tarFiles, uploadCommand)
+		err := r.UploadFiles(pod, container, tarFiles, localTarFile, containerTmpFilesPrefix, &tarStdout, &tarStderr, logger)
+		manifestStep.Finish(err)
 
 		if err != nil {
 			r.SetStatus(StatusError, fmt.Sprintf("Error uploading tar file to pod: %s container: %s error: %+v", pod.Name, container.Name, err), containerDiagnostic, logger)
@@ -1220,9 +1568,26 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 		logger.Debug2(fmt.Sprintf("RunScriptOnContainer tar results: stdout: %v stderr: %v", tarStdout.String(), tarStderr.String()))
 	}
 
+	// Only run the executions if a threshold was crossed
+	runExecutions := true
+	if containerDiagnostic.Spec.Watch != nil {
+		if watchStatus == nil {
+			r.RecordEventInfo(fmt.Sprintf("Pod: %s container: %s was not watched (e.g. it started after the watch) so the execute steps were skipped", pod.Name, container.Name), containerDiagnostic, logger)
+			runExecutions = false
+		} else if watchStatus.State == WatchStateTimedOut {
+			// The samples are still packaged
+			r.RecordEventInfo(fmt.Sprintf("No threshold was crossed in pod: %s container: %s so the execute steps were skipped", pod.Name, container.Name), containerDiagnostic, logger)
+			runExecutions = false
+		} else if watchStatus.State != WatchStateCrossed {
+			// The samples are still packaged
+			r.SetStatus(StatusError, fmt.Sprintf("Error watching for a threshold on pod: %s container: %s error: %s", pod.Name, container.Name, watchStatus.Message), containerDiagnostic, logger)
+			runExecutions = false
+		}
+	}
+
 	// Run any executions
 	for stepIndex, step := range containerDiagnostic.Spec.Steps {
-		if step.Command == "execute" {
+		if step.Command == "execute" && runExecutions {
 
 			logger.Info(fmt.Sprintf("RunScriptOnContainer running 'execute' step"))
 
@@ -1231,7 +1596,9 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 			logger.Info(fmt.Sprintf("RunScriptOnContainer Running script %v", remoteExecutionScript))
 
 			var stdout, stderr bytes.Buffer
+			manifestStep := manifestTarget.StartStep(stepIndex+1, step.Command, GetStepArguments(step), []string{remoteExecutionScript})
 			err := r.ExecInContainer(pod, container, []string{remoteExecutionScript}, &stdout, &stderr, nil, nil)
+			manifestStep.Finish(err)
 
 			logger.Debug1(fmt.Sprintf("ExecInContainer results: err: %v, stdout: %s\n\nstderr: %s\n", err, stdout.String(), stderr.String()))
 
@@ -1251,6 +1618,14 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 
 				containerDiagnostic.Status.Log += log
 
+				var exitError utilexec.ExitError
+				if errors.As(err, &exitError) && exitError.ExitStatus() == ExecuteToolFailedExitCode {
+					// The tool failed but its output (and the output of the other steps) is
+					// still collected
+					r.SetStatus(StatusError, fmt.Sprintf("The tool of 'execute' step %d failed on pod (review Status Log): %s container: %s", stepIndex+1, pod.Name, container.Name), containerDiagnostic, logger)
+					continue
+				}
+
 				r.SetStatus(StatusError, fmt.Sprintf("Error running 'execute' step on pod (review Status Log): %s container: %s error: %+v", pod.Name, container.Name, err), containerDiagnostic, logger)
 
 				// TODO run clean.sh
@@ -1273,6 +1648,20 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 		}
 	}
 
+	// List and filter the files of filtered package steps
+	var zipStdin *bufio.Reader
+	if len(filteredPackageSteps) > 0 {
+		packageFiles, ok := r.ListPackageFiles(containerDiagnostic, logger, pod, container, contextTracker, manifestTarget, filteredPackageSteps, filepath.Join(containerTmpFilesPrefix, localScratchSpaceDirectory, "list.sh"))
+		if !ok {
+			// The error will have been logged within the above function.
+			// We don't stop processing other pods/containers, just return. If this is the
+			// only error, status will show as error; otherwise, as mixed
+			Cleanup(logger, localScratchSpaceDirectory)
+			return
+		}
+		zipStdin = bufio.NewReader(strings.NewReader(strings.Join(packageFiles, "\n") + "\n"))
+	}
+
 	// Execute the final zip
 
 	var zipStdout, zipStderr bytes.Buffer
@@ -1280,7 +1669,9 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 	zipScript := filepath.Join(containerTmpFilesPrefix, localScratchSpaceDirectory, "zip.sh")
 	logger.Info(fmt.Sprintf("RunScriptOnContainer zipping up remote files: %s", zipScript))
 
-	err = r.ExecInContainer(pod, container, []string{zipScript}, &zipStdout, &zipStderr, nil, nil)
+	manifestStep := manifestTarget.StartStep(0, "zip", nil, []string{zipScript})
+	err = r.ExecInContainer(pod, container, []string{zipScript}, &zipStdout, &zipStderr, zipStdin, nil)
+	manifestStep.Finish(err)
 	logger.Debug1(fmt.Sprintf("ExecInContainer results: stdout: %s\n\nstderr: %s\n", zipStdout.String(), zipStderr.String()))
 
 	if err != nil {
@@ -1292,6 +1683,22 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 		return
 	}
 
+	// Check that the manager has enough disk space to download and process the zip
+	remoteZipSize, ok := ParseRemoteFileSize(zipStdout.String(), remoteZipFile)
+	if !ok {
+		logger.Info(fmt.Sprintf("RunScriptOnContainer could not determine the size of %s; only checking the minimum disk space free", remoteZipFile))
+	}
+
+	err = CheckDiskSpace(map[string]uint64{localScratchSpaceDirectory: remoteZipSize * ScratchSpaceFactor, contextTracker.localPermanentDirectory: remoteZipSize * OutputSpaceFactor}, r.MinDiskSpaceFreeMB)
+	if err != nil {
+		r.SetStatus(StatusError, fmt.Sprintf("Not enough disk space in the operator to download %s (%d MB) from pod: %s container: %s: %+v", remoteZipFile, ToMBRoundedUp(remoteZipSize), pod.Name, container.Name, err), containerDiagnostic, logger)
+
+		// We don't stop processing other pods/containers, just return. If this is the
+		// only error, status will show as error; otherwise, as mixed
+		Cleanup(logger, localScratchSpaceDirectory)
+		return
+	}
+
 	// Download the files locally
 	localDownloadedTarFile := filepath.Join(localScratchSpaceDirectory, strings.ReplaceAll(zipFileName, ".zip", ".tar"))
 	localZipFile := filepath.Join(localScratchSpaceDirectory, zipFileName)
@@ -1312,7 +1719,9 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 
 	var tarStderr bytes.Buffer
 	args := []string{"tar", "-C", filepath.Dir(remoteZipFile), "-cf", "-", filepath.Base(remoteZipFile)}
+	manifestStep = manifestTarget.StartStep(0, "download", nil, args)
 	err = r.ExecInContainer(pod, container, args, nil, &tarStderr, nil, fileWriter)
+	manifestStep.Finish(err)
 
 	fileWriter.Flush()
 	file.Close()
@@ -1328,13 +1737,16 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 		return
 	}
 
+	if fileInfo, err := os.Stat(localDownloadedTarFile); err == nil {
+		TransferredBytes.WithLabelValues(TransferDirectionDownload, TransferTargetContainer).Add(float64(fileInfo.Size()))
+	}
+
 	// Now untar the tar file which will expand the zip file
 	logger.Info(fmt.Sprintf("RunScriptOnContainer Untarring downloaded file: %s", localDownloadedTarFile))
 
-	outputBytes, err := r.ExecuteLocalCommand(logger, containerDiagnostic, "tar", "-C", localScratchSpaceDirectory, "-xvf", localDownloadedTarFile)
-	var outputStr string = string(outputBytes[:])
+	_, err = NewArchiveExpander(logger, nil).ExtractTar(localDownloadedTarFile, localScratchSpaceDirectory, false)
 	if err != nil {
-		r.SetStatus(StatusError, fmt.Sprintf("Could not untar %s: %+v %s", localDownloadedTarFile, err, outputStr), containerDiagnostic, logger)
+		r.SetStatus(StatusError, fmt.Sprintf("Could not untar %s: %+v", localDownloadedTarFile, err), containerDiagnostic, logger)
 
 		// We don't stop processing other pods/containers, just return. If this is the
 		// only error, status will show as error; otherwise, as mixed
@@ -1342,8 +1754,6 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 		return
 	}
 
-	logger.Debug1(fmt.Sprintf("RunScriptOnContainer untar output: %v", outputStr))
-
 	// Delete the tar file
 	os.Remove(localDownloadedTarFile)
 
@@ -1372,7 +1782,8 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 	}
 
 	// Finally copy the zip file over
-	err = CopyFile(localZipFile, filepath.Join(permdir, zipFileName))
+	permanentZipFile := filepath.Join(permdir, zipFileName)
+	err = CopyFile(localZipFile, permanentZipFile)
 	if err != nil {
 		r.SetStatus(StatusError, fmt.Sprintf("Could not copy file file to permanent directory %s: %+v", permdir, err), containerDiagnostic, logger)
 
@@ -1382,17 +1793,22 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 		return
 	}
 
+	// This will be expanded into the final zip after all containers are processed
+	contextTracker.containerArchives = append(contextTracker.containerArchives, permanentZipFile)
+
 	logger.Info(fmt.Sprintf("RunScriptOnContainer Copied zip file to: %s", permdir))
 
 	// Cleanup if requested
-	for _, step := range containerDiagnostic.Spec.Steps {
+	for stepIndex, step := range containerDiagnostic.Spec.Steps {
 		if step.Command == "clean" {
 
 			cleanScript := filepath.Join(containerTmpFilesPrefix, localScratchSpaceDirectory, "clean.sh")
 			logger.Info(fmt.Sprintf("RunScriptOnContainer running 'clean' step"))
 
 			var stdout, stderr bytes.Buffer
+			manifestStep := manifestTarget.StartStep(stepIndex+1, step.Command, step.Arguments, []string{cleanScript})
 			err := r.ExecInContainer(pod, container, []string{cleanScript}, &stdout, &stderr, nil, nil)
+			manifestStep.Finish(err)
 
 			logger.Debug1(fmt.Sprintf("ExecInContainer results: stdout: %s\n\nstderr: %s\n", stdout.String(), stderr.String()))
 
@@ -1414,6 +1830,77 @@ func (r *ContainerDiagnosticReconciler) RunScriptOnContainer(ctx context.Context
 	Cleanup(logger, localScratchSpaceDirectory)
 }
 
+// ListPackageFiles runs the list script and returns the files of filtered package steps that should be
+// zipped. Skipped files are recorded in the manifest and Status.SkippedFiles.
+func (r *ContainerDiagnosticReconciler) ListPackageFiles(containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger, pod *corev1.Pod, container corev1.Container, contextTracker *ContextTracker, manifestTarget *ManifestTarget, filteredPackageSteps map[int][]string, listScript string) ([]string, bool) {
+	logger.Info(fmt.Sprintf("RunScriptOnContainer listing files to package: %s", listScript))
+
+	var stdout, stderr bytes.Buffer
+	manifestStep := manifestTarget.StartStep(0, "list", nil, []string{listScript})
+	err := r.ExecInContainer(pod, container, []string{listScript}, &stdout, &stderr, nil, nil)
+	manifestStep.Finish(err)
+
+	logger.Debug1(fmt.Sprintf("ExecInContainer results: stdout: %s\n\nstderr: %s\n", stdout.String(), stderr.String()))
+
+	if err != nil {
+		r.SetStatus(StatusError, fmt.Sprintf("Error listing files to package on pod: %s container: %s error: %+v", pod.Name, container.Name, err), containerDiagnostic, logger)
+		return nil, false
+	}
+
+	listing := ParseRemoteFileListing(stdout.String())
+	now := time.Now()
+
+	stepIndexes := []int{}
+	for stepIndex := range filteredPackageSteps {
+		stepIndexes = append(stepIndexes, stepIndex)
+	}
+	sort.Ints(stepIndexes)
+
+	// zip fails if the same file is passed twice
+	candidatePaths := make(map[string]bool)
+
+	var candidates []RemoteFile
+	var skipped []SkippedFile
+	for _, stepIndex := range stepIndexes {
+		stepSelected, stepSkipped := FilterRemoteFiles(listing[stepIndex], containerDiagnostic.Spec.Steps[stepIndex].Filter, now)
+		for _, file := range stepSelected {
+			if !candidatePaths[file.Path] {
+				candidatePaths[file.Path] = true
+				candidates = append(candidates, file)
+			}
+		}
+		skipped = append(skipped, stepSkipped...)
+	}
+
+	remainingBytes := int64(-1)
+	if containerDiagnostic.Spec.MaxBundleSizeMB > 0 {
+		remainingBytes = int64(containerDiagnostic.Spec.MaxBundleSizeMB)*1024*1024 - contextTracker.packagedBytes
+		if remainingBytes < 0 {
+			remainingBytes = 0
+		}
+	}
+
+	selected, limitSkipped, totalBytes := LimitRemoteFiles(candidates, remainingBytes, containerDiagnostic.Spec.MaxBundleSizeMB)
+	skipped = append(skipped, limitSkipped...)
+
+	contextTracker.packagedBytes += totalBytes
+
+	manifestTarget.Skipped = append(manifestTarget.Skipped, skipped...)
+	containerDiagnostic.Status.SkippedFiles += len(skipped)
+
+	if len(skipped) > 0 {
+		r.RecordEventInfo(fmt.Sprintf("Skipped %d files on pod: %s container: %s (see %s)", len(skipped), pod.Name, container.Name, ManifestFileName), containerDiagnostic, logger)
+	}
+
+	var packageFiles []string
+	for _, file := range selected {
+		logger.Info(fmt.Sprintf("RunScriptOnContainer packaging %s", file.Path))
+		packageFiles = append(packageFiles, file.Path)
+	}
+
+	return packageFiles, true
+}
+
 func CopyFile(src string, dest string) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
@@ -1435,7 +1922,7 @@ func WriteExecutionLine(fileWriter *os.File, containerTmpFilesPrefix string, com
 	var redirectStr string = ""
 	var backgroundStr string = ""
 	if redirectOutput {
-		redirectStr = fmt.Sprintf(" >> %s 2>&1", outputFile)
+		redirectStr = fmt.Sprintf(" >> %s 2>&1", ShellQuote(outputFile))
 	}
 	if background {
 		backgroundStr = " &"
@@ -1566,7 +2053,7 @@ func Cleanup(logger *CustomLogger, localScratchSpaceDirectory string) {
 	}
 }
 
-func (r *ContainerDiagnosticReconciler) EnsureDirectoriesOnContainer(ctx context.Context, req ctrl.Request, containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger, pod *corev1.Pod, container corev1.Container, contextTracker *ContextTracker, uuid string) (response string, ok bool) {
+func (r *ContainerDiagnosticReconciler) EnsureDirectoriesOnContainer(ctx context.Context, req ctrl.Request, containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger, pod *corev1.Pod, container corev1.Container, contextTracker *ContextTracker, manifestTarget *ManifestTarget, uuid string) (response string, ok bool) {
 
 	containerTmpFilesPrefix := containerDiagnostic.Spec.Directory
 
@@ -1577,7 +2064,10 @@ func (r *ContainerDiagnosticReconciler) EnsureDirectoriesOnContainer(ctx context
 	logger.Debug1(fmt.Sprintf("RunScriptOnContainer running mkdir: %s", containerTmpFilesPrefix))
 
 	var stdout, stderr bytes.Buffer
-	err := r.ExecInContainer(pod, container, []string{"mkdir", "-p", containerTmpFilesPrefix}, &stdout, &stderr, nil, nil)
+	mkdirCommand := []string{"mkdir", "-p", containerTmpFilesPrefix}
+	manifestStep := manifestTarget.StartStep(0, "mkdir", nil, mkdirCommand)
+	err := r.ExecInContainer(pod, container, mkdirCommand, &stdout, &stderr, nil, nil)
+	manifestStep.Finish(err)
 
 	logger.Debug1(fmt.Sprintf("ExecInContainer results: stdout: %s\n\nstderr: %s\n", stdout.String(), stderr.String()))
 
@@ -1634,7 +2124,53 @@ func (r *ContainerDiagnosticReconciler) FindSharedLibraries(logger *CustomLogger
 	return lines, true
 }
 
+// GetUploadCommand returns the command that extracts the uploaded tar in a container
+func GetUploadCommand(containerTmpFilesPrefix string) []string {
+	return []string{"tar", "-xmf", "-", "-C", containerTmpFilesPrefix}
+}
+
+// UploadFiles creates a tar of local files and extracts it in a container under containerTmpFilesPrefix
+func (r *ContainerDiagnosticReconciler) UploadFiles(pod *corev1.Pod, container corev1.Container, tarFiles []string, localTarFile string, containerTmpFilesPrefix string, stdout *bytes.Buffer, stderr *bytes.Buffer, logger *CustomLogger) error {
+	logger.Info(fmt.Sprintf("UploadFiles creating local tar..."))
+
+	err := CreateTarFromFiles(tarFiles, localTarFile)
+	if err != nil {
+		return fmt.Errorf("could not create tar file %s: %w", localTarFile, err)
+	}
+
+	file, err := os.Open(localTarFile)
+	if err != nil {
+		return fmt.Errorf("could not read tar file %s: %w", localTarFile, err)
+	}
+	defer file.Close()
+
+	fileReader := bufio.NewReader(file)
+	logger.Info(fmt.Sprintf("UploadFiles local tar file binary size: %d", fileReader.Size()))
+
+	err = r.ExecInContainer(pod, container, GetUploadCommand(containerTmpFilesPrefix), stdout, stderr, fileReader, nil)
+
+	logger.Debug1(fmt.Sprintf("ExecInContainer results: stdout: %s\n\nstderr: %s\n", stdout.String(), stderr.String()))
+
+	if err != nil {
+		return err
+	}
+
+	if fileInfo, err := os.Stat(localTarFile); err == nil {
+		TransferredBytes.WithLabelValues(TransferDirectionUpload, TransferTargetContainer).Add(float64(fileInfo.Size()))
+	}
+
+	return nil
+}
+
 func (r *ContainerDiagnosticReconciler) ExecInContainer(pod *corev1.Pod, container corev1.Container, command []string, stdout *bytes.Buffer, stderr *bytes.Buffer, stdin *bufio.Reader, stdoutWriter *bufio.Writer) error {
+	err := r.execInContainer(pod, container, command, stdout, stderr, stdin, stdoutWriter)
+	if err != nil {
+		ExecErrorsTotal.WithLabelValues(GetExecErrorType(err)).Inc()
+	}
+	return err
+}
+
+func (r *ContainerDiagnosticReconciler) execInContainer(pod *corev1.Pod, container corev1.Container, command []string, stdout *bytes.Buffer, stderr *bytes.Buffer, stdin *bufio.Reader, stdoutWriter *bufio.Writer) error {
 	clientset, err := kubernetes.NewForConfig(r.Config)
 	if err != nil {
 		return err
@@ -1710,8 +2246,12 @@ func (r *ContainerDiagnosticReconciler) SetupWithManager(mgr ctrl.Manager) error
 	// https://pkg.go.dev/sigs.k8s.io/controller-runtime/pkg/builder#Builder
 	result := ctrl.NewControllerManagedBy(mgr).
 		For(&diagnosticv1.ContainerDiagnostic{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 	r.Config = mgr.GetConfig()
 	r.EventRecorder = mgr.GetEventRecorderFor("containerdiagnostic")
-	return result
+	if result != nil {
+		return result
+	}
+	return RegisterOutputDirectoryMetrics(r.GetOutputDirectory())
 }
@@ -0,0 +1,522 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// Added to each ContainerDiagnostic created by a ContainerDiagnosticTrigger with its name
+const TriggeredByLabel = "diagnostic.ibm.com/triggered-by"
+
+// Added to each ContainerDiagnostic created by a ContainerDiagnosticTrigger with the event
+// that triggered it
+const TriggerReasonAnnotation = "diagnostic.ibm.com/trigger-reason"
+
+// Defaults of the limits of a ContainerDiagnosticTrigger
+const (
+	DefaultTriggerCooldownSeconds = 600
+	DefaultTriggerMaxRunsPerHour  = 10
+)
+
+// The event of a PodEvent for an alert received by the AlertReceiver. It isn't a value of
+// the events of a trigger because alerts fire a trigger by name.
+const TriggerAlert diagnosticv1.TriggerEvent = "Alert"
+
+// The number of alerts that may be waiting to be processed by the trigger controller
+const AlertEventBufferSize = 1024
+
+// PodEvent is a change in the status of a container of a pod watched by a trigger
+type PodEvent struct {
+	Pod       types.NamespacedName
+	Workload  string
+	Container string
+	Event     diagnosticv1.TriggerEvent
+	Reason    string
+}
+
+// ContainerDiagnosticTriggerReconciler reconciles a ContainerDiagnosticTrigger object. Restarts
+// are detected in Reconcile by comparing the restart counts of the watched pods with those in
+// the trigger's status. A container that became not ready is only seen in a pod update, so
+// those events (and alerts) are queued in memory for the trigger.
+type ContainerDiagnosticTriggerReconciler struct {
+	client.Client
+	Scheme        *runtime.Scheme
+	EventRecorder record.EventRecorder
+
+	mutex   sync.Mutex
+	pending map[types.NamespacedName][]PodEvent
+
+	// Reconciles triggers fired by the AlertReceiver
+	alertEvents chan event.GenericEvent
+}
+
+// +kubebuilder:rbac:groups=diagnostic.ibm.com,resources=containerdiagnostictriggers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=diagnostic.ibm.com,resources=containerdiagnostictriggers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=diagnostic.ibm.com,resources=containerdiagnostictriggers/finalizers,verbs=update
+
+// Reconcile creates a ContainerDiagnostic for each queued event of a trigger that isn't
+// suppressed by the cooldown of its workload or the trigger's maximum runs per hour.
+func (r *ContainerDiagnosticTriggerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	trigger := &diagnosticv1.ContainerDiagnosticTrigger{}
+	err := r.Get(ctx, req.NamespacedName, trigger)
+	if err != nil {
+		r.TakePendingEvents(req.NamespacedName)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if trigger.GetDeletionTimestamp() != nil {
+		r.TakePendingEvents(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	patch := client.MergeFrom(trigger.DeepCopy())
+
+	now := time.Now()
+
+	// The restart counts are updated even if the trigger is suspended so that restarts while
+	// it's suspended are ignored
+	restartEvents, observedContainers, err := r.ObserveRestarts(ctx, trigger)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	observedChanged := !reflect.DeepEqual(observedContainers, trigger.Status.ObservedContainers)
+	if observedChanged {
+		observedTime := metav1.NewTime(now)
+		trigger.Status.ObservedTime = &observedTime
+		trigger.Status.ObservedContainers = observedContainers
+	}
+
+	events := append(restartEvents, r.TakePendingEvents(req.NamespacedName)...)
+	if trigger.Spec.Suspend {
+		events = nil
+	}
+
+	if len(events) == 0 && !observedChanged {
+		return ctrl.Result{}, nil
+	}
+	cooldown := time.Duration(DefaultTriggerCooldownSeconds) * time.Second
+	if trigger.Spec.CooldownSeconds != nil {
+		cooldown = time.Duration(*trigger.Spec.CooldownSeconds) * time.Second
+	}
+	maxRunsPerHour := DefaultTriggerMaxRunsPerHour
+	if trigger.Spec.MaxRunsPerHour != nil {
+		maxRunsPerHour = int(*trigger.Spec.MaxRunsPerHour)
+	}
+
+	PruneTriggerStatus(&trigger.Status, now, cooldown)
+
+	var createErr error
+	for index, podEvent := range events {
+		if IsInCooldown(&trigger.Status, podEvent.Workload) {
+			logger.Info(fmt.Sprintf("ContainerDiagnosticTrigger %s ignored %s because %s is in its cooldown", trigger.Name, podEvent.Reason, podEvent.Workload))
+			trigger.Status.Suppressed++
+			continue
+		}
+
+		if len(trigger.Status.RecentTriggerTimes) >= maxRunsPerHour {
+			logger.Info(fmt.Sprintf("ContainerDiagnosticTrigger %s ignored %s because it created %d ContainerDiagnostics in the last hour", trigger.Name, podEvent.Reason, maxRunsPerHour))
+			trigger.Status.Suppressed++
+			continue
+		}
+
+		containerDiagnostic := NewContainerDiagnosticFromTemplate(&trigger.Spec.Template, trigger.Namespace, "")
+		containerDiagnostic.GenerateName = trigger.Name + "-"
+		containerDiagnostic.Labels[TriggeredByLabel] = trigger.Name
+		containerDiagnostic.Annotations[TriggerReasonAnnotation] = podEvent.Reason
+		containerDiagnostic.Spec.TargetLabelSelectors = nil
+		containerDiagnostic.Spec.TargetObjects = []corev1.ObjectReference{
+			{
+				Kind:      "Pod",
+				Namespace: podEvent.Pod.Namespace,
+				Name:      podEvent.Pod.Name,
+			},
+		}
+		if len(podEvent.Container) > 0 {
+			containerDiagnostic.Spec.Containers = []string{podEvent.Container}
+		}
+
+		err = ctrl.SetControllerReference(trigger, containerDiagnostic, r.Scheme)
+		if err == nil {
+			err = r.Create(ctx, containerDiagnostic)
+		}
+		if err != nil {
+			r.EventRecorder.Event(trigger, corev1.EventTypeWarning, "FailedCreate", fmt.Sprintf("Could not create ContainerDiagnostic for %s: %v", podEvent.Reason, err))
+
+			// Retry the rest of the events
+			r.AddPendingEvents(req.NamespacedName, events[index:])
+			createErr = err
+			break
+		}
+
+		r.EventRecorder.Event(trigger, corev1.EventTypeNormal, "SuccessfulCreate", fmt.Sprintf("Created ContainerDiagnostic %s because %s", containerDiagnostic.Name, podEvent.Reason))
+
+		triggerTime := metav1.NewTime(now)
+		trigger.Status.Runs++
+		trigger.Status.LastRun = containerDiagnostic.Name
+		trigger.Status.LastReason = podEvent.Reason
+		trigger.Status.LastTriggerTime = &triggerTime
+		trigger.Status.RecentTriggerTimes = append(trigger.Status.RecentTriggerTimes, triggerTime)
+		trigger.Status.Workloads = append(trigger.Status.Workloads, diagnosticv1.TriggeredWorkload{Workload: podEvent.Workload, LastTriggerTime: triggerTime})
+	}
+
+	// A patch doesn't conflict with concurrent updates of the spec
+	err = r.Status().Patch(ctx, trigger, patch)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, createErr
+}
+
+// HandlePodUpdate queues the events of a pod update for each trigger that watches the pod
+func (r *ContainerDiagnosticTriggerReconciler) HandlePodUpdate(updateEvent event.UpdateEvent, queue workqueue.RateLimitingInterface) {
+	oldPod, ok := updateEvent.ObjectOld.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	newPod, ok := updateEvent.ObjectNew.(*corev1.Pod)
+	if !ok || newPod.GetDeletionTimestamp() != nil {
+		return
+	}
+
+	triggers := &diagnosticv1.ContainerDiagnosticTriggerList{}
+	err := r.List(context.Background(), triggers, client.InNamespace(newPod.Namespace))
+	if err != nil {
+		ctrl.Log.WithName("trigger").Error(err, fmt.Sprintf("could not list ContainerDiagnosticTriggers in %s", newPod.Namespace))
+		return
+	}
+
+	for i := range triggers.Items {
+		trigger := &triggers.Items[i]
+		if trigger.Spec.Suspend || !MatchesAnyLabelSelector(trigger.Spec.TargetLabelSelectors, newPod.Labels) {
+			continue
+		}
+
+		events := DetectPodEvents(oldPod, newPod, &trigger.Spec)
+		if len(events) > 0 {
+			key := types.NamespacedName{Namespace: trigger.Namespace, Name: trigger.Name}
+
+			// Restarts are detected by Reconcile from the restart counts
+			var pending []PodEvent
+			for _, podEvent := range events {
+				if podEvent.Event == diagnosticv1.TriggerNotReady {
+					pending = append(pending, podEvent)
+				}
+			}
+			if len(pending) > 0 {
+				r.AddPendingEvents(key, pending)
+			}
+
+			queue.Add(reconcile.Request{NamespacedName: key})
+		}
+	}
+}
+
+// QueueAlertEvents queues events for a trigger from outside of the controller (see AlertReceiver)
+func (r *ContainerDiagnosticTriggerReconciler) QueueAlertEvents(ctx context.Context, trigger *diagnosticv1.ContainerDiagnosticTrigger, events []PodEvent) error {
+	r.AddPendingEvents(types.NamespacedName{Namespace: trigger.Namespace, Name: trigger.Name}, events)
+
+	if r.alertEvents == nil {
+		return nil
+	}
+
+	select {
+	case r.alertEvents <- event.GenericEvent{Object: trigger}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *ContainerDiagnosticTriggerReconciler) AddPendingEvents(key types.NamespacedName, events []PodEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.pending == nil {
+		r.pending = map[types.NamespacedName][]PodEvent{}
+	}
+	r.pending[key] = append(r.pending[key], events...)
+}
+
+func (r *ContainerDiagnosticTriggerReconciler) TakePendingEvents(key types.NamespacedName) []PodEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	events := r.pending[key]
+	delete(r.pending, key)
+	return events
+}
+
+// DetectPodEvents compares the container statuses of two versions of a pod and returns the
+// events that the trigger watches for. There is at most one event per container: an OOMKilled
+// restart is reported as OOMKilled if the trigger watches for it and as Restart otherwise.
+func DetectPodEvents(oldPod *corev1.Pod, newPod *corev1.Pod, spec *diagnosticv1.ContainerDiagnosticTriggerSpec) []PodEvent {
+	oldStatuses := map[string]corev1.ContainerStatus{}
+	for _, status := range oldPod.Status.ContainerStatuses {
+		oldStatuses[status.Name] = status
+	}
+
+	var events []PodEvent
+	for _, status := range newPod.Status.ContainerStatuses {
+		oldStatus, ok := oldStatuses[status.Name]
+		if !ok {
+			continue
+		}
+
+		podEvent := DetectContainerEvent(newPod, status, oldStatus.RestartCount, oldStatus.Ready, spec)
+		if podEvent != nil {
+			events = append(events, *podEvent)
+		}
+	}
+
+	return events
+}
+
+// DetectContainerEvent returns the event of a container given its previous restart count and
+// readiness or nil if there isn't one that the trigger watches for (see DetectPodEvents)
+func DetectContainerEvent(pod *corev1.Pod, status corev1.ContainerStatus, oldRestartCount int32, oldReady bool, spec *diagnosticv1.ContainerDiagnosticTriggerSpec) *PodEvent {
+	if len(spec.Containers) > 0 && !ContainsString(spec.Containers, status.Name) {
+		return nil
+	}
+
+	watched := map[diagnosticv1.TriggerEvent]bool{}
+	for _, triggerEvent := range spec.Events {
+		watched[triggerEvent] = true
+	}
+	if len(watched) == 0 {
+		watched[diagnosticv1.TriggerRestart] = true
+	}
+
+	var triggerEvent diagnosticv1.TriggerEvent
+	var reason string
+
+	if status.RestartCount > oldRestartCount {
+		terminationReason := "unknown"
+		if status.LastTerminationState.Terminated != nil {
+			terminationReason = status.LastTerminationState.Terminated.Reason
+		}
+
+		if terminationReason == "OOMKilled" && watched[diagnosticv1.TriggerOOMKilled] {
+			triggerEvent = diagnosticv1.TriggerOOMKilled
+			reason = fmt.Sprintf("container %s of pod %s was OOMKilled (restart count %d)", status.Name, pod.Name, status.RestartCount)
+		} else if watched[diagnosticv1.TriggerRestart] {
+			triggerEvent = diagnosticv1.TriggerRestart
+			reason = fmt.Sprintf("container %s of pod %s restarted (restart count %d, last termination reason %s)", status.Name, pod.Name, status.RestartCount, terminationReason)
+		}
+	} else if oldReady && !status.Ready && status.State.Running != nil && watched[diagnosticv1.TriggerNotReady] {
+		triggerEvent = diagnosticv1.TriggerNotReady
+		reason = fmt.Sprintf("container %s of pod %s became not ready", status.Name, pod.Name)
+	}
+
+	if triggerEvent == "" {
+		return nil
+	}
+
+	return &PodEvent{
+		Pod:       types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name},
+		Workload:  GetWorkload(pod),
+		Container: status.Name,
+		Event:     triggerEvent,
+		Reason:    reason,
+	}
+}
+
+// ObserveRestarts lists the pods watched by a trigger and returns the restart events since the
+// restart counts in its status and the current restart counts. The restarts of a pod that isn't
+// in the status are new if the pod was created after the status was last updated; otherwise
+// (e.g. when the trigger is created), the current restart count is the starting point.
+func (r *ContainerDiagnosticTriggerReconciler) ObserveRestarts(ctx context.Context, trigger *diagnosticv1.ContainerDiagnosticTrigger) ([]PodEvent, []diagnosticv1.ObservedContainer, error) {
+	if len(trigger.Spec.TargetLabelSelectors) == 0 {
+		return nil, nil, nil
+	}
+
+	pods := &corev1.PodList{}
+	err := r.List(ctx, pods, client.InNamespace(trigger.Namespace))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var events []PodEvent
+	var observedContainers []diagnosticv1.ObservedContainer
+	for index := range pods.Items {
+		pod := &pods.Items[index]
+		if pod.GetDeletionTimestamp() != nil || !MatchesAnyLabelSelector(trigger.Spec.TargetLabelSelectors, pod.Labels) {
+			continue
+		}
+
+		podEvents, podObservedContainers := DetectRestarts(pod, &trigger.Status, &trigger.Spec)
+		events = append(events, podEvents...)
+		observedContainers = append(observedContainers, podObservedContainers...)
+	}
+
+	// The order of the list isn't stable so the status is sorted to only change with the pods
+	sort.Slice(observedContainers, func(i, j int) bool {
+		if observedContainers[i].Pod != observedContainers[j].Pod {
+			return observedContainers[i].Pod < observedContainers[j].Pod
+		}
+		return observedContainers[i].Container < observedContainers[j].Container
+	})
+
+	return events, observedContainers, nil
+}
+
+// DetectRestarts compares the restart counts of the containers of a pod with those in the status
+// of a trigger (see ObserveRestarts) and returns the restart events and current restart counts
+func DetectRestarts(pod *corev1.Pod, status *diagnosticv1.ContainerDiagnosticTriggerStatus, spec *diagnosticv1.ContainerDiagnosticTriggerSpec) ([]PodEvent, []diagnosticv1.ObservedContainer) {
+	newPod := status.ObservedTime != nil && status.ObservedTime.Before(&pod.CreationTimestamp)
+
+	var events []PodEvent
+	var observedContainers []diagnosticv1.ObservedContainer
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if len(spec.Containers) > 0 && !ContainsString(spec.Containers, containerStatus.Name) {
+			continue
+		}
+
+		oldRestartCount := containerStatus.RestartCount
+		if newPod {
+			oldRestartCount = 0
+		}
+
+		for _, observedContainer := range status.ObservedContainers {
+			// A lower restart count is a new pod with the same name (e.g. of a StatefulSet)
+			if observedContainer.Pod == pod.Name && observedContainer.Container == containerStatus.Name && observedContainer.RestartCount <= containerStatus.RestartCount {
+				oldRestartCount = observedContainer.RestartCount
+			}
+		}
+
+		// Readiness isn't compared because it's only seen in pod updates
+		podEvent := DetectContainerEvent(pod, containerStatus, oldRestartCount, false, spec)
+		if podEvent != nil {
+			events = append(events, *podEvent)
+		}
+
+		observedContainers = append(observedContainers, diagnosticv1.ObservedContainer{
+			Pod:          pod.Name,
+			Container:    containerStatus.Name,
+			RestartCount: containerStatus.RestartCount,
+		})
+	}
+
+	return events, observedContainers
+}
+
+// GetWorkload returns the kind/name of the controller of a pod for the cooldown. Pods of a
+// ReplicaSet of a Deployment are attributed to the Deployment so that a rollout doesn't reset
+// the cooldown.
+func GetWorkload(pod *corev1.Pod) string {
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return "Pod/" + pod.Name
+	}
+
+	if owner.Kind == "ReplicaSet" {
+		if hash, ok := pod.Labels["pod-template-hash"]; ok && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment/" + strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	}
+
+	return owner.Kind + "/" + owner.Name
+}
+
+// PruneTriggerStatus removes trigger times older than an hour and workloads whose cooldown ended
+func PruneTriggerStatus(status *diagnosticv1.ContainerDiagnosticTriggerStatus, now time.Time, cooldown time.Duration) {
+	var recentTriggerTimes []metav1.Time
+	for _, triggerTime := range status.RecentTriggerTimes {
+		if now.Sub(triggerTime.Time) < time.Hour {
+			recentTriggerTimes = append(recentTriggerTimes, triggerTime)
+		}
+	}
+	status.RecentTriggerTimes = recentTriggerTimes
+
+	var workloads []diagnosticv1.TriggeredWorkload
+	for _, workload := range status.Workloads {
+		if now.Sub(workload.LastTriggerTime.Time) < cooldown {
+			workloads = append(workloads, workload)
+		}
+	}
+	status.Workloads = workloads
+}
+
+// IsInCooldown returns true if the workload triggered a ContainerDiagnostic recently (see PruneTriggerStatus)
+func IsInCooldown(status *diagnosticv1.ContainerDiagnosticTriggerStatus, workload string) bool {
+	for _, triggeredWorkload := range status.Workloads {
+		if triggeredWorkload.Workload == workload {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesAnyLabelSelector returns true if the labels match any of the selectors
+func MatchesAnyLabelSelector(labelSelectors []metav1.LabelSelector, labels map[string]string) bool {
+	for i := range labelSelectors {
+		selector, err := metav1.LabelSelectorAsSelector(&labelSelectors[i])
+		if err != nil {
+			continue
+		}
+		if selector.Matches(k8slabels.Set(labels)) {
+			return true
+		}
+	}
+	return false
+}
+
+func ContainsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ContainerDiagnosticTriggerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.EventRecorder = mgr.GetEventRecorderFor("containerdiagnostictrigger")
+	r.alertEvents = make(chan event.GenericEvent, AlertEventBufferSize)
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&diagnosticv1.ContainerDiagnosticTrigger{}).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.Funcs{UpdateFunc: r.HandlePodUpdate}).
+		Watches(&source.Channel{Source: r.alertEvents}, &handler.EnqueueRequestForObject{}).
+		Complete(r)
+}
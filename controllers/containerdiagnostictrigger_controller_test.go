@@ -0,0 +1,398 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// newTestPod returns a pod of Deployment app1 with the container statuses
+func newTestPod(name string, statuses ...corev1.ContainerStatus) *corev1.Pod {
+	isController := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels:    map[string]string{"app": "app1", "pod-template-hash": "5d8f7c9b4"},
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app1-5d8f7c9b4", UID: "1", Controller: &isController},
+			},
+		},
+		Status: corev1.PodStatus{ContainerStatuses: statuses},
+	}
+}
+
+func runningStatus(name string, restartCount int32, ready bool) corev1.ContainerStatus {
+	return corev1.ContainerStatus{
+		Name:         name,
+		RestartCount: restartCount,
+		Ready:        ready,
+		State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}
+}
+
+func restartedStatus(name string, restartCount int32, terminationReason string) corev1.ContainerStatus {
+	status := runningStatus(name, restartCount, false)
+	status.LastTerminationState.Terminated = &corev1.ContainerStateTerminated{Reason: terminationReason}
+	return status
+}
+
+func TestDetectPodEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		events     []diagnosticv1.TriggerEvent
+		containers []string
+		oldStatus  corev1.ContainerStatus
+		newStatus  corev1.ContainerStatus
+		want       diagnosticv1.TriggerEvent
+	}{
+		{"restart by default", nil, nil, runningStatus("app", 0, true), restartedStatus("app", 1, "Error"), diagnosticv1.TriggerRestart},
+		{"no change", nil, nil, runningStatus("app", 1, true), runningStatus("app", 1, true), ""},
+		{"OOMKilled", []diagnosticv1.TriggerEvent{diagnosticv1.TriggerRestart, diagnosticv1.TriggerOOMKilled}, nil, runningStatus("app", 0, true), restartedStatus("app", 1, "OOMKilled"), diagnosticv1.TriggerOOMKilled},
+		{"OOMKilled is a restart if it isn't watched", nil, nil, runningStatus("app", 0, true), restartedStatus("app", 1, "OOMKilled"), diagnosticv1.TriggerRestart},
+		{"restart isn't watched", []diagnosticv1.TriggerEvent{diagnosticv1.TriggerOOMKilled}, nil, runningStatus("app", 0, true), restartedStatus("app", 1, "Error"), ""},
+		{"restart without a termination state", nil, nil, runningStatus("app", 0, true), runningStatus("app", 1, true), diagnosticv1.TriggerRestart},
+		{"not ready", []diagnosticv1.TriggerEvent{diagnosticv1.TriggerNotReady}, nil, runningStatus("app", 0, true), runningStatus("app", 0, false), diagnosticv1.TriggerNotReady},
+		{"not ready isn't watched", nil, nil, runningStatus("app", 0, true), runningStatus("app", 0, false), ""},
+		{"not ready while not running", []diagnosticv1.TriggerEvent{diagnosticv1.TriggerNotReady}, nil, runningStatus("app", 0, true), corev1.ContainerStatus{Name: "app"}, ""},
+		{"became ready", []diagnosticv1.TriggerEvent{diagnosticv1.TriggerNotReady}, nil, runningStatus("app", 0, false), runningStatus("app", 0, true), ""},
+		{"container isn't watched", nil, []string{"other"}, runningStatus("app", 0, true), restartedStatus("app", 1, "Error"), ""},
+		{"container is watched", nil, []string{"app"}, runningStatus("app", 0, true), restartedStatus("app", 1, "Error"), diagnosticv1.TriggerRestart},
+		{"new container", nil, nil, runningStatus("init", 0, true), restartedStatus("app", 1, "Error"), ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := &diagnosticv1.ContainerDiagnosticTriggerSpec{Events: test.events, Containers: test.containers}
+			events := DetectPodEvents(newTestPod("app1-5d8f7c9b4-x2x7k", test.oldStatus), newTestPod("app1-5d8f7c9b4-x2x7k", test.newStatus), spec)
+
+			if test.want == "" {
+				if len(events) != 0 {
+					t.Errorf("got events %+v, want none", events)
+				}
+				return
+			}
+
+			if len(events) != 1 {
+				t.Fatalf("got events %+v, want one %s", events, test.want)
+			}
+			podEvent := events[0]
+			if podEvent.Event != test.want || podEvent.Container != "app" || podEvent.Workload != "Deployment/app1" || podEvent.Pod.Name != "app1-5d8f7c9b4-x2x7k" || len(podEvent.Reason) == 0 {
+				t.Errorf("unexpected event: %+v", podEvent)
+			}
+		})
+	}
+}
+
+func TestGetWorkload(t *testing.T) {
+	isController := true
+	isNotController := false
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		owners []metav1.OwnerReference
+		want   string
+	}{
+		{"no owner", nil, nil, "Pod/pod1"},
+		{"owner isn't a controller", nil, []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "app1-5d8f7c9b4", Controller: &isNotController}}, "Pod/pod1"},
+		{"Deployment", map[string]string{"pod-template-hash": "5d8f7c9b4"}, []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "app1-5d8f7c9b4", Controller: &isController}}, "Deployment/app1"},
+		{"ReplicaSet without a Deployment", nil, []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "app1-5d8f7c9b4", Controller: &isController}}, "ReplicaSet/app1-5d8f7c9b4"},
+		{"ReplicaSet with another hash", map[string]string{"pod-template-hash": "7c9b4d8f5"}, []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "app1-5d8f7c9b4", Controller: &isController}}, "ReplicaSet/app1-5d8f7c9b4"},
+		{"StatefulSet", map[string]string{"controller-revision-hash": "db-5d8f7c9b4"}, []metav1.OwnerReference{{Kind: "StatefulSet", Name: "db", Controller: &isController}}, "StatefulSet/db"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "pod1",
+					Labels:          test.labels,
+					OwnerReferences: test.owners,
+				},
+			}
+			if workload := GetWorkload(pod); workload != test.want {
+				t.Errorf("got %s, want %s", workload, test.want)
+			}
+		})
+	}
+}
+
+func TestDetectRestarts(t *testing.T) {
+	observedTime := metav1.NewTime(time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		name         string
+		observedTime *metav1.Time
+		observed     []diagnosticv1.ObservedContainer
+		creationTime time.Time
+		restartCount int32
+		want         bool
+	}{
+		{"first check", nil, nil, observedTime.Add(-time.Hour), 3, false},
+		{"restarted since the last check", &observedTime, []diagnosticv1.ObservedContainer{{Pod: "pod1", Container: "app", RestartCount: 2}}, observedTime.Add(-time.Hour), 3, true},
+		{"not restarted since the last check", &observedTime, []diagnosticv1.ObservedContainer{{Pod: "pod1", Container: "app", RestartCount: 3}}, observedTime.Add(-time.Hour), 3, false},
+		{"pod created after the last check", &observedTime, nil, observedTime.Add(time.Minute), 1, true},
+		{"pod created after the last check without restarts", &observedTime, nil, observedTime.Add(time.Minute), 0, false},
+		{"pod not seen before the last check", &observedTime, nil, observedTime.Add(-time.Hour), 3, false},
+		{"pod recreated with the same name", &observedTime, []diagnosticv1.ObservedContainer{{Pod: "pod1", Container: "app", RestartCount: 5}}, observedTime.Add(time.Minute), 1, true},
+		{"another pod restarted", &observedTime, []diagnosticv1.ObservedContainer{{Pod: "pod2", Container: "app", RestartCount: 2}}, observedTime.Add(-time.Hour), 3, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := newTestPod("pod1", restartedStatus("app", test.restartCount, "Error"))
+			pod.CreationTimestamp = metav1.NewTime(test.creationTime)
+
+			status := &diagnosticv1.ContainerDiagnosticTriggerStatus{ObservedTime: test.observedTime, ObservedContainers: test.observed}
+			events, observedContainers := DetectRestarts(pod, status, &diagnosticv1.ContainerDiagnosticTriggerSpec{})
+
+			if test.want != (len(events) == 1) || len(events) > 1 {
+				t.Errorf("got events %+v, want an event: %v", events, test.want)
+			}
+			if len(observedContainers) != 1 || observedContainers[0].Pod != "pod1" || observedContainers[0].Container != "app" || observedContainers[0].RestartCount != test.restartCount {
+				t.Errorf("unexpected observed containers: %+v", observedContainers)
+			}
+		})
+	}
+}
+
+func TestPruneTriggerStatus(t *testing.T) {
+	now := time.Now()
+	status := &diagnosticv1.ContainerDiagnosticTriggerStatus{
+		RecentTriggerTimes: []metav1.Time{
+			metav1.NewTime(now.Add(-2 * time.Hour)),
+			metav1.NewTime(now.Add(-30 * time.Minute)),
+		},
+		Workloads: []diagnosticv1.TriggeredWorkload{
+			{Workload: "Deployment/app1", LastTriggerTime: metav1.NewTime(now.Add(-20 * time.Minute))},
+			{Workload: "Deployment/app2", LastTriggerTime: metav1.NewTime(now.Add(-5 * time.Minute))},
+		},
+	}
+
+	PruneTriggerStatus(status, now, 10*time.Minute)
+
+	if len(status.RecentTriggerTimes) != 1 {
+		t.Errorf("got %d recent trigger times, want 1", len(status.RecentTriggerTimes))
+	}
+	if IsInCooldown(status, "Deployment/app1") {
+		t.Error("Deployment/app1 is still in its cooldown")
+	}
+	if !IsInCooldown(status, "Deployment/app2") {
+		t.Error("Deployment/app2 isn't in its cooldown")
+	}
+	if IsInCooldown(status, "Deployment/app3") {
+		t.Error("Deployment/app3 is in a cooldown")
+	}
+}
+
+// newTriggerTest creates a trigger reconciler with a fake client containing a trigger for the
+// pods of app1 and the pods
+func newTriggerTest(t *testing.T, trigger *diagnosticv1.ContainerDiagnosticTrigger, pods ...*corev1.Pod) (*ContainerDiagnosticTriggerReconciler, client.Client) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := diagnosticv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	trigger.ObjectMeta = metav1.ObjectMeta{Namespace: "default", Name: "app1-trigger"}
+	trigger.Spec.TargetLabelSelectors = []metav1.LabelSelector{
+		{MatchLabels: map[string]string{"app": "app1"}},
+	}
+	trigger.Spec.Template = diagnosticv1.ContainerDiagnosticRunTemplate{
+		Spec: diagnosticv1.ContainerDiagnosticSpec{
+			Command: "script",
+		},
+	}
+
+	objects := []client.Object{trigger}
+	for _, pod := range pods {
+		objects = append(objects, pod)
+	}
+
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+	return &ContainerDiagnosticTriggerReconciler{
+		Client:        fakeClient,
+		Scheme:        scheme,
+		EventRecorder: record.NewFakeRecorder(100),
+	}, fakeClient
+}
+
+func reconcileTrigger(t *testing.T, triggers *ContainerDiagnosticTriggerReconciler, fakeClient client.Client) (*diagnosticv1.ContainerDiagnosticTrigger, []diagnosticv1.ContainerDiagnostic) {
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "app1-trigger"}
+
+	_, err := triggers.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trigger := &diagnosticv1.ContainerDiagnosticTrigger{}
+	if err := fakeClient.Get(ctx, key, trigger); err != nil {
+		t.Fatal(err)
+	}
+
+	containerDiagnostics := &diagnosticv1.ContainerDiagnosticList{}
+	err = fakeClient.List(ctx, containerDiagnostics, client.InNamespace("default"), client.MatchingLabels{TriggeredByLabel: "app1-trigger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return trigger, containerDiagnostics.Items
+}
+
+func TestTriggerLimits(t *testing.T) {
+	workloadEvent := func(workload string) PodEvent {
+		return PodEvent{
+			Pod:       types.NamespacedName{Namespace: "default", Name: workload + "-pod"},
+			Workload:  "Deployment/" + workload,
+			Container: "app",
+			Event:     diagnosticv1.TriggerNotReady,
+			Reason:    "container app of pod " + workload + "-pod became not ready",
+		}
+	}
+
+	cooldownSeconds := int32(600)
+	maxRunsPerHour := int32(3)
+
+	tests := []struct {
+		name           string
+		events         []PodEvent
+		recentRuns     int
+		wantRuns       int
+		wantSuppressed int
+	}{
+		{"one event", []PodEvent{workloadEvent("app1")}, 0, 1, 0},
+		{"cooldown of the workload", []PodEvent{workloadEvent("app1"), workloadEvent("app1")}, 0, 1, 1},
+		{"different workloads", []PodEvent{workloadEvent("app1"), workloadEvent("app2")}, 0, 2, 0},
+		{"runs per hour", []PodEvent{workloadEvent("app1"), workloadEvent("app2"), workloadEvent("app3"), workloadEvent("app4")}, 0, 3, 1},
+		{"runs in the last hour", []PodEvent{workloadEvent("app1"), workloadEvent("app2")}, 2, 1, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trigger := &diagnosticv1.ContainerDiagnosticTrigger{
+				Spec: diagnosticv1.ContainerDiagnosticTriggerSpec{
+					CooldownSeconds: &cooldownSeconds,
+					MaxRunsPerHour:  &maxRunsPerHour,
+				},
+			}
+			now := time.Now()
+			for i := 0; i < test.recentRuns; i++ {
+				trigger.Status.RecentTriggerTimes = append(trigger.Status.RecentTriggerTimes, metav1.NewTime(now.Add(-time.Duration(i+1)*20*time.Minute)))
+			}
+			// Runs more than an hour ago don't count
+			trigger.Status.RecentTriggerTimes = append(trigger.Status.RecentTriggerTimes, metav1.NewTime(now.Add(-2*time.Hour)))
+
+			triggers, fakeClient := newTriggerTest(t, trigger)
+			triggers.AddPendingEvents(types.NamespacedName{Namespace: "default", Name: "app1-trigger"}, test.events)
+
+			updatedTrigger, containerDiagnostics := reconcileTrigger(t, triggers, fakeClient)
+
+			if len(containerDiagnostics) != test.wantRuns || updatedTrigger.Status.Runs != test.wantRuns || updatedTrigger.Status.Suppressed != test.wantSuppressed {
+				t.Errorf("got %d ContainerDiagnostics, %d runs, and %d suppressed, want %d runs and %d suppressed", len(containerDiagnostics), updatedTrigger.Status.Runs, updatedTrigger.Status.Suppressed, test.wantRuns, test.wantSuppressed)
+			}
+			if len(updatedTrigger.Status.RecentTriggerTimes) != test.recentRuns+test.wantRuns {
+				t.Errorf("got %d recent trigger times, want %d", len(updatedTrigger.Status.RecentTriggerTimes), test.recentRuns+test.wantRuns)
+			}
+		})
+	}
+}
+
+func TestTriggerDetectsRestartsFromStatus(t *testing.T) {
+	observedTime := metav1.NewTime(time.Now().Add(-time.Hour))
+	trigger := &diagnosticv1.ContainerDiagnosticTrigger{
+		Status: diagnosticv1.ContainerDiagnosticTriggerStatus{
+			ObservedTime: &observedTime,
+			ObservedContainers: []diagnosticv1.ObservedContainer{
+				{Pod: "app1-5d8f7c9b4-x2x7k", Container: "app", RestartCount: 1},
+				{Pod: "app1-5d8f7c9b4-x2x7k", Container: "sidecar", RestartCount: 0},
+				{Pod: "app1-5d8f7c9b4-deleted", Container: "app", RestartCount: 0},
+			},
+		},
+	}
+
+	// The pod restarted while the operator wasn't running so there was no pod update
+	pod := newTestPod("app1-5d8f7c9b4-x2x7k", restartedStatus("app", 2, "Error"), runningStatus("sidecar", 0, true))
+	pod.CreationTimestamp = metav1.NewTime(observedTime.Add(-time.Hour))
+
+	triggers, fakeClient := newTriggerTest(t, trigger, pod)
+
+	updatedTrigger, containerDiagnostics := reconcileTrigger(t, triggers, fakeClient)
+
+	if len(containerDiagnostics) != 1 {
+		t.Fatalf("got %d ContainerDiagnostics, want 1", len(containerDiagnostics))
+	}
+	if containers := containerDiagnostics[0].Spec.Containers; len(containers) != 1 || containers[0] != "app" {
+		t.Errorf("unexpected containers: %+v", containers)
+	}
+
+	observedContainers := updatedTrigger.Status.ObservedContainers
+	if len(observedContainers) != 2 || observedContainers[0].Container != "app" || observedContainers[0].RestartCount != 2 || observedContainers[1].Container != "sidecar" {
+		t.Errorf("unexpected observed containers: %+v", observedContainers)
+	}
+	if !observedTime.Before(updatedTrigger.Status.ObservedTime) {
+		t.Errorf("the observed time wasn't updated: %v", updatedTrigger.Status.ObservedTime)
+	}
+
+	// The restart is only detected once
+	_, containerDiagnostics = reconcileTrigger(t, triggers, fakeClient)
+	if len(containerDiagnostics) != 1 {
+		t.Errorf("got %d ContainerDiagnostics, want 1", len(containerDiagnostics))
+	}
+}
+
+func TestTriggerIgnoresRestartsWhileSuspended(t *testing.T) {
+	observedTime := metav1.NewTime(time.Now().Add(-time.Hour))
+	trigger := &diagnosticv1.ContainerDiagnosticTrigger{
+		Spec: diagnosticv1.ContainerDiagnosticTriggerSpec{Suspend: true},
+		Status: diagnosticv1.ContainerDiagnosticTriggerStatus{
+			ObservedTime:       &observedTime,
+			ObservedContainers: []diagnosticv1.ObservedContainer{{Pod: "app1-5d8f7c9b4-x2x7k", Container: "app", RestartCount: 1}},
+		},
+	}
+
+	pod := newTestPod("app1-5d8f7c9b4-x2x7k", restartedStatus("app", 2, "Error"))
+	pod.CreationTimestamp = metav1.NewTime(observedTime.Add(-time.Hour))
+
+	triggers, fakeClient := newTriggerTest(t, trigger, pod)
+
+	updatedTrigger, containerDiagnostics := reconcileTrigger(t, triggers, fakeClient)
+	if len(containerDiagnostics) != 0 {
+		t.Errorf("got %d ContainerDiagnostics, want 0", len(containerDiagnostics))
+	}
+	if len(updatedTrigger.Status.ObservedContainers) != 1 || updatedTrigger.Status.ObservedContainers[0].RestartCount != 2 {
+		t.Errorf("unexpected observed containers: %+v", updatedTrigger.Status.ObservedContainers)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The default minimum disk space free (in MB) that must remain in the manager's
+// scratch space and output directory after a download
+const DefaultMinOperatorDiskSpaceFreeMB = 100
+
+// A downloaded container zip needs space in the scratch space for the downloaded tar and
+// the zip extracted from it
+const ScratchSpaceFactor = 2
+
+// A downloaded container zip needs space in the output directory for the copy of the zip,
+// its expanded contents (compressed text is often 3-5x larger), and the final archive
+const OutputSpaceFactor = 6
+
+// GetFreeDiskSpace returns the bytes available to unprivileged users in the filesystem of path
+func GetFreeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// GetFilesystemID returns an identifier of the filesystem of path so that requirements
+// for different directories on the same filesystem can be added together
+func GetFilesystemID(path string) (uint64, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("could not get the device of %s", path)
+	}
+	return uint64(stat.Dev), nil
+}
+
+// CheckDiskSpace checks that each filesystem has enough free space for the sum of the bytes
+// required in its directories (which must exist) plus minFreeMB.
+func CheckDiskSpace(required map[string]uint64, minFreeMB int) error {
+	type filesystem struct {
+		directories []string
+		required    uint64
+	}
+
+	filesystems := map[uint64]*filesystem{}
+
+	for directory, bytes := range required {
+		id, err := GetFilesystemID(directory)
+		if err != nil {
+			return err
+		}
+		fs, ok := filesystems[id]
+		if !ok {
+			fs = &filesystem{}
+			filesystems[id] = fs
+		}
+		fs.directories = append(fs.directories, directory)
+		fs.required += bytes
+	}
+
+	minFreeBytes := uint64(minFreeMB) * 1024 * 1024
+
+	for _, fs := range filesystems {
+		sort.Strings(fs.directories)
+
+		free, err := GetFreeDiskSpace(fs.directories[0])
+		if err != nil {
+			return err
+		}
+
+		if free < fs.required+minFreeBytes {
+			return fmt.Errorf("the available disk space in %s of %d MB is insufficient (%d MB required plus %d MB minimum free)", strings.Join(fs.directories, " and "), free/(1024*1024), ToMBRoundedUp(fs.required), minFreeMB)
+		}
+	}
+
+	return nil
+}
+
+func ToMBRoundedUp(bytes uint64) uint64 {
+	return (bytes + 1024*1024 - 1) / (1024 * 1024)
+}
+
+// ParseRemoteFileSize finds the size of remoteFile in the output of ls -ln. Only regular files
+// match and the name is the rest of the line after the date because it may contain spaces.
+func ParseRemoteFileSize(output string, remoteFile string) (uint64, bool) {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) >= 9 && strings.HasPrefix(fields[0], "-") && GetListingName(line, 8) == remoteFile {
+			size, err := strconv.ParseUint(fields[4], 10, 64)
+			if err == nil {
+				return size, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// GetListingName returns the rest of an ls line after skipping the given number of fields
+func GetListingName(line string, skipFields int) string {
+	rest := line
+	for i := 0; i < skipFields; i++ {
+		rest = strings.TrimLeft(rest, " ")
+		index := strings.IndexByte(rest, ' ')
+		if index < 0 {
+			return ""
+		}
+		rest = rest[index:]
+	}
+	return strings.TrimLeft(rest, " ")
+}
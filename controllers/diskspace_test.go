@@ -0,0 +1,157 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRemoteFileSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		remoteFile string
+		want       uint64
+		wantOK     bool
+	}{
+		{
+			"regular file",
+			"-rw-r--r-- 1 1000 0 123456 Oct 18 12:00 /tmp/containerdiag_20211018_120000.zip\n",
+			"/tmp/containerdiag_20211018_120000.zip",
+			123456,
+			true,
+		},
+		{
+			"name with spaces",
+			"-rw-r--r-- 1 1000 0 42 Oct 18 12:00 /tmp/my dir/diag output.zip\n",
+			"/tmp/my dir/diag output.zip",
+			42,
+			true,
+		},
+		{
+			"name that is a suffix of another name",
+			"-rw-r--r-- 1 1000 0 42 Oct 18 12:00 /tmp/my dir/diag output.zip\n",
+			"output.zip",
+			0,
+			false,
+		},
+		{
+			"other lines",
+			"total 124\n-rw-r--r-- 1 1000 0 1 Oct 18 12:00 /tmp/other.zip\n-rw-r--r-- 1 1000 0 2 Oct 18 12:00 /tmp/diag.zip\n",
+			"/tmp/diag.zip",
+			2,
+			true,
+		},
+		{
+			"symbolic link",
+			"lrwxrwxrwx 1 0 0 13 Oct 18 12:00 /tmp/diag.zip -> /tmp/real.zip\n",
+			"/tmp/real.zip",
+			0,
+			false,
+		},
+		{
+			"device file",
+			"crw-rw-rw- 1 0 0 1, 3 Oct 18 12:00 /tmp/diag.zip\n",
+			"/tmp/diag.zip",
+			0,
+			false,
+		},
+		{
+			"missing file",
+			"ls: cannot access '/tmp/diag.zip': No such file or directory\n",
+			"/tmp/diag.zip",
+			0,
+			false,
+		},
+		{
+			"invalid size",
+			"-rw-r--r-- 1 1000 0 big Oct 18 12:00 /tmp/diag.zip\n",
+			"/tmp/diag.zip",
+			0,
+			false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := ParseRemoteFileSize(test.output, test.remoteFile)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("got %d, %t, want %d, %t", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	directory := t.TempDir()
+	first := filepath.Join(directory, "first")
+	second := filepath.Join(directory, "second")
+	for _, path := range []string{first, second} {
+		if err := os.Mkdir(path, os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	free, err := GetFreeDiskSpace(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each requirement fits on its own but not together on the same filesystem
+	bytes := free / 10 * 6
+
+	if err := CheckDiskSpace(map[string]uint64{first: bytes}, 0); err != nil {
+		t.Errorf("one directory: %+v", err)
+	}
+
+	err = CheckDiskSpace(map[string]uint64{first: bytes, second: bytes}, 0)
+	if err == nil {
+		t.Fatal("the requirements of directories on the same filesystem were not added together")
+	}
+	if !strings.Contains(err.Error(), first+" and "+second) {
+		t.Errorf("got %+v", err)
+	}
+
+	// The minimum free space is added to the requirement
+	if err := CheckDiskSpace(map[string]uint64{first: 0}, int(free/(1024*1024))+1); err == nil {
+		t.Error("the minimum free space was not checked")
+	}
+
+	if err := CheckDiskSpace(map[string]uint64{filepath.Join(directory, "missing"): 0}, 0); err == nil {
+		t.Error("a missing directory was accepted")
+	}
+}
+
+func TestToMBRoundedUp(t *testing.T) {
+	tests := []struct {
+		bytes uint64
+		want  uint64
+	}{
+		{0, 0},
+		{1, 1},
+		{1024 * 1024, 1},
+		{1024*1024 + 1, 2},
+	}
+
+	for _, test := range tests {
+		if got := ToMBRoundedUp(test.bytes); got != test.want {
+			t.Errorf("ToMBRoundedUp(%d) got %d, want %d", test.bytes, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// The path prefix of download requests: /download/<namespace>/<name>
+const DownloadPathPrefix = "/download/"
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// DownloadServer serves the final downloads over HTTP(S) as an alternative to kubectl cp.
+// Every request must have a bearer token of a user or service account that is allowed
+// to get the ContainerDiagnostic.
+type DownloadServer struct {
+	Client          client.Client
+	Clientset       kubernetes.Interface
+	BindAddress     string
+	CertDir         string
+	OutputDirectory string
+	Logger          logr.Logger
+}
+
+// Start implements manager.Runnable
+func (s *DownloadServer) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DownloadPathPrefix, s.HandleDownload)
+
+	server := &http.Server{
+		Addr:    s.BindAddress,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	var err error
+	if len(s.CertDir) > 0 {
+		s.Logger.Info(fmt.Sprintf("download server is starting to listen with TLS on %s", s.BindAddress))
+		err = server.ListenAndServeTLS(filepath.Join(s.CertDir, "tls.crt"), filepath.Join(s.CertDir, "tls.key"))
+	} else {
+		s.Logger.Info(fmt.Sprintf("download server is starting to listen on %s", s.BindAddress))
+		err = server.ListenAndServe()
+	}
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The downloads are written to the
+// output directory of the leader (which runs the reconciles) so only the leader serves them and
+// the other replicas don't listen.
+func (s *DownloadServer) NeedLeaderElection() bool {
+	return true
+}
+
+func (s *DownloadServer) HandleDownload(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pieces := strings.Split(strings.TrimPrefix(req.URL.Path, DownloadPathPrefix), "/")
+	if len(pieces) != 2 || len(pieces[0]) == 0 || len(pieces[1]) == 0 {
+		http.Error(w, fmt.Sprintf("Expected %s<namespace>/<name>", DownloadPathPrefix), http.StatusNotFound)
+		return
+	}
+
+	namespace := pieces[0]
+	name := pieces[1]
+
+	status, message := s.Authorize(req, namespace, name)
+	if status != http.StatusOK {
+		s.Logger.Info(fmt.Sprintf("download server denied request for %s/%s: %s", namespace, name, message))
+		http.Error(w, message, status)
+		return
+	}
+
+	containerDiagnostic := &diagnosticv1.ContainerDiagnostic{}
+	err := s.Client.Get(req.Context(), client.ObjectKey{Namespace: namespace, Name: name}, containerDiagnostic)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			http.Error(w, "ContainerDiagnostic not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Could not get ContainerDiagnostic", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	downloadPath := containerDiagnostic.Status.DownloadPath
+	if len(downloadPath) == 0 {
+		http.Error(w, "ContainerDiagnostic does not have a download", http.StatusNotFound)
+		return
+	}
+
+	// Only serve files from the output directory
+	if !strings.HasPrefix(filepath.Clean(downloadPath), filepath.Clean(s.OutputDirectory)+string(os.PathSeparator)) {
+		http.Error(w, "ContainerDiagnostic download is not in the output directory", http.StatusNotFound)
+		return
+	}
+
+	file, err := os.Open(downloadPath)
+	if err != nil {
+		http.Error(w, "ContainerDiagnostic download no longer exists", http.StatusGone)
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		http.Error(w, "Could not read ContainerDiagnostic download", http.StatusInternalServerError)
+		return
+	}
+
+	s.Logger.Info(fmt.Sprintf("download server serving %s for %s/%s", downloadPath, namespace, name))
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(downloadPath)))
+
+	// ServeContent handles Range and conditional requests
+	http.ServeContent(w, req, filepath.Base(downloadPath), fileInfo.ModTime(), file)
+}
+
+// Authorize authenticates the bearer token of the request with a TokenReview and then checks
+// with a SubjectAccessReview that the caller may get the ContainerDiagnostic
+func (s *DownloadServer) Authorize(req *http.Request, namespace string, name string) (int, string) {
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return http.StatusUnauthorized, "Bearer token required"
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+
+	tokenReview, err := s.Clientset.AuthenticationV1().TokenReviews().Create(req.Context(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, "Could not review token"
+	}
+
+	if !tokenReview.Status.Authenticated {
+		return http.StatusUnauthorized, "Invalid token"
+	}
+
+	user := tokenReview.Status.User
+
+	extra := make(map[string]authorizationv1.ExtraValue)
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+
+	subjectAccessReview, err := s.Clientset.AuthorizationV1().SubjectAccessReviews().Create(req.Context(), &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "get",
+				Group:     diagnosticv1.GroupVersion.Group,
+				Resource:  "containerdiagnostics",
+				Name:      name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return http.StatusInternalServerError, "Could not review access"
+	}
+
+	if !subjectAccessReview.Status.Allowed {
+		return http.StatusForbidden, fmt.Sprintf("User %s may not get containerdiagnostics %s in namespace %s", user.Username, name, namespace)
+	}
+
+	return http.StatusOK, ""
+}
+
+// GetDownloadURL returns the URL of the download server for a ContainerDiagnostic
+func GetDownloadURL(downloadBaseURL string, containerDiagnostic *diagnosticv1.ContainerDiagnostic) string {
+	return strings.TrimSuffix(downloadBaseURL, "/") + DownloadPathPrefix + containerDiagnostic.Namespace + "/" + containerDiagnostic.Name
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// newDownloadServerTest creates a download server with a fake clientset that authenticates the
+// token "user1-token" as user1, who may only get the ContainerDiagnostics in namespace ns1. The
+// fake client has ContainerDiagnostics diag1 in ns1 and diag3 in ns2 with a download in the output
+// directory and diag2 in ns1 with a download outside of it.
+func newDownloadServerTest(t *testing.T) *DownloadServer {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := diagnosticv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	baseDirectory := t.TempDir()
+	outputDirectory := filepath.Join(baseDirectory, "output")
+	if err := os.MkdirAll(outputDirectory, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	downloadPath := filepath.Join(outputDirectory, "diag1.zip")
+	if err := ioutil.WriteFile(downloadPath, []byte("diag1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A sibling directory that shares the prefix of the output directory
+	escapedPath := filepath.Join(baseDirectory, "output2", "secret.zip")
+	if err := os.MkdirAll(filepath.Dir(escapedPath), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(escapedPath, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	diag1 := &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "diag1"},
+		Status:     diagnosticv1.ContainerDiagnosticStatus{DownloadPath: downloadPath},
+	}
+	diag2 := &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "diag2"},
+		Status:     diagnosticv1.ContainerDiagnosticStatus{DownloadPath: filepath.Join(outputDirectory, "..", "output2", "secret.zip")},
+	}
+	diag3 := &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "diag3"},
+		Status:     diagnosticv1.ContainerDiagnosticStatus{DownloadPath: downloadPath},
+	}
+
+	clientset := kubernetesfake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tokenReview := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if tokenReview.Spec.Token == "user1-token" {
+			tokenReview.Status.Authenticated = true
+			tokenReview.Status.User = authenticationv1.UserInfo{Username: "user1"}
+		}
+		return true, tokenReview, nil
+	})
+	clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		subjectAccessReview := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attributes := subjectAccessReview.Spec.ResourceAttributes
+		subjectAccessReview.Status.Allowed = subjectAccessReview.Spec.User == "user1" && attributes.Namespace == "ns1" && attributes.Verb == "get" && attributes.Resource == "containerdiagnostics"
+		return true, subjectAccessReview, nil
+	})
+
+	return &DownloadServer{
+		Client:          fake.NewClientBuilder().WithScheme(scheme).WithObjects(diag1, diag2, diag3).Build(),
+		Clientset:       clientset,
+		OutputDirectory: outputDirectory,
+		Logger:          ctrl.Log.WithName("download"),
+	}
+}
+
+func TestDownloadServerAuthorize(t *testing.T) {
+	server := newDownloadServerTest(t)
+
+	tests := []struct {
+		name          string
+		authorization string
+		namespace     string
+		want          int
+	}{
+		{"allowed", "Bearer user1-token", "ns1", http.StatusOK},
+		{"no token", "", "ns1", http.StatusUnauthorized},
+		{"not a bearer token", "Basic dXNlcjE6cGFzc3dvcmQ=", "ns1", http.StatusUnauthorized},
+		{"invalid token", "Bearer user2-token", "ns1", http.StatusUnauthorized},
+		{"wrong namespace", "Bearer user1-token", "ns2", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, DownloadPathPrefix+test.namespace+"/diag1", nil)
+			if len(test.authorization) > 0 {
+				req.Header.Set("Authorization", test.authorization)
+			}
+
+			status, message := server.Authorize(req, test.namespace, "diag1")
+			if status != test.want {
+				t.Errorf("got status %d (%s), want %d", status, message, test.want)
+			}
+		})
+	}
+}
+
+func TestDownloadServerHandleDownload(t *testing.T) {
+	server := newDownloadServerTest(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+		body   string
+	}{
+		{"allowed", http.MethodGet, "/download/ns1/diag1", "user1-token", http.StatusOK, "diag1"},
+		{"invalid token", http.MethodGet, "/download/ns1/diag1", "user2-token", http.StatusUnauthorized, ""},
+		{"denied in another namespace", http.MethodGet, "/download/ns2/diag3", "user1-token", http.StatusForbidden, ""},
+		{"not found", http.MethodGet, "/download/ns1/diag4", "user1-token", http.StatusNotFound, ""},
+		{"download outside of the output directory", http.MethodGet, "/download/ns1/diag2", "user1-token", http.StatusNotFound, ""},
+		{"extra path segments", http.MethodGet, "/download/ns1/diag1/../../ns2/diag3", "user1-token", http.StatusNotFound, ""},
+		{"missing name", http.MethodGet, "/download/ns1/", "user1-token", http.StatusNotFound, ""},
+		{"wrong method", http.MethodPost, "/download/ns1/diag1", "user1-token", http.StatusMethodNotAllowed, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			req.Header.Set("Authorization", "Bearer "+test.token)
+
+			recorder := httptest.NewRecorder()
+			server.HandleDownload(recorder, req)
+
+			if recorder.Code != test.want {
+				t.Fatalf("got status %d (%s), want %d", recorder.Code, recorder.Body.String(), test.want)
+			}
+			if len(test.body) > 0 && recorder.Body.String() != test.body {
+				t.Errorf("got body %q, want %q", recorder.Body.String(), test.body)
+			}
+		})
+	}
+}
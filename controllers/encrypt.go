@@ -0,0 +1,169 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	// Keys without hash preferences default to RIPEMD-160
+	_ "golang.org/x/crypto/ripemd160"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// The extension added to encrypted downloads
+const EncryptedFileExtension = "gpg"
+
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get
+
+// GetEncryptionRecipients reads the OpenPGP public keys referenced by Spec.Encryption
+func (r *ContainerDiagnosticReconciler) GetEncryptionRecipients(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic) (openpgp.EntityList, error) {
+	encryption := containerDiagnostic.Spec.Encryption
+
+	var keyData []byte
+	var source string
+
+	if encryption.SecretKeyRef != nil {
+		source = fmt.Sprintf("Secret %s key %s", encryption.SecretKeyRef.Name, encryption.SecretKeyRef.Key)
+
+		secret := &corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: containerDiagnostic.Namespace, Name: encryption.SecretKeyRef.Name}, secret)
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s: %w", source, err)
+		}
+		keyData = secret.Data[encryption.SecretKeyRef.Key]
+	} else if encryption.ConfigMapKeyRef != nil {
+		source = fmt.Sprintf("ConfigMap %s key %s", encryption.ConfigMapKeyRef.Name, encryption.ConfigMapKeyRef.Key)
+
+		configMap := &corev1.ConfigMap{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: containerDiagnostic.Namespace, Name: encryption.ConfigMapKeyRef.Name}, configMap)
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s: %w", source, err)
+		}
+		if value, ok := configMap.Data[encryption.ConfigMapKeyRef.Key]; ok {
+			keyData = []byte(value)
+		} else {
+			keyData = configMap.BinaryData[encryption.ConfigMapKeyRef.Key]
+		}
+	} else {
+		return nil, fmt.Errorf("encryption requires secretKeyRef or configMapKeyRef")
+	}
+
+	if len(keyData) == 0 {
+		return nil, fmt.Errorf("%s is empty", source)
+	}
+
+	recipients, err := ReadPublicKeys(keyData)
+	if err != nil {
+		return nil, fmt.Errorf("could not read OpenPGP public keys from %s: %w", source, err)
+	}
+
+	return recipients, nil
+}
+
+// ReadPublicKeys reads ASCII-armored or binary OpenPGP public keys
+func ReadPublicKeys(keyData []byte) (openpgp.EntityList, error) {
+	var recipients openpgp.EntityList
+	var err error
+
+	if bytes.Contains(keyData, []byte("-----BEGIN PGP")) {
+		recipients, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(keyData))
+	} else {
+		recipients, err = openpgp.ReadKeyRing(bytes.NewReader(keyData))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no public keys found")
+	}
+
+	return recipients, nil
+}
+
+// DescribeRecipients returns the identities of the recipients for events
+func DescribeRecipients(recipients openpgp.EntityList) string {
+	var descriptions []string
+	for _, recipient := range recipients {
+		description := recipient.PrimaryKey.KeyIdString()
+		for name := range recipient.Identities {
+			description = fmt.Sprintf("%s (%s)", name, description)
+			break
+		}
+		descriptions = append(descriptions, description)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// EncryptFile encrypts src for the recipients into dest. If there's an error, dest is deleted.
+func EncryptFile(src string, dest string, recipients openpgp.EntityList) error {
+	err := encryptFile(src, dest, recipients)
+	if err != nil {
+		os.Remove(dest)
+	}
+	return err
+}
+
+func encryptFile(src string, dest string, recipients openpgp.EntityList) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	bufferedWriter := bufio.NewWriter(destFile)
+
+	plaintext, err := openpgp.Encrypt(bufferedWriter, recipients, nil, &openpgp.FileHints{IsBinary: true, FileName: filepath.Base(src)}, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(plaintext, srcFile)
+	if err != nil {
+		plaintext.Close()
+		return err
+	}
+
+	err = plaintext.Close()
+	if err != nil {
+		return err
+	}
+
+	err = bufferedWriter.Flush()
+	if err != nil {
+		return err
+	}
+
+	return destFile.Close()
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// Ed25519 keys are much faster to generate than RSA keys
+var testKeyConfig = &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA}
+
+func newTestEntity(t *testing.T, name string) *openpgp.Entity {
+	t.Helper()
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", testKeyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+func serializePublicKeys(t *testing.T, armored bool, entities ...*openpgp.Entity) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+
+	var writer io.WriteCloser = nopWriteCloser{&buffer}
+	if armored {
+		var err error
+		writer, err = armor.Encode(&buffer, openpgp.PublicKeyType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, entity := range entities {
+		if err := entity.Serialize(writer); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestReadPublicKeys(t *testing.T) {
+	alice := newTestEntity(t, "alice")
+	bob := newTestEntity(t, "bob")
+
+	tests := []struct {
+		name    string
+		keyData []byte
+		want    int
+		wantErr bool
+	}{
+		{"armored", serializePublicKeys(t, true, alice), 1, false},
+		{"armored key ring", serializePublicKeys(t, true, alice, bob), 2, false},
+		{"binary", serializePublicKeys(t, false, alice), 1, false},
+		{"binary key ring", serializePublicKeys(t, false, alice, bob), 2, false},
+		{"not a key", []byte("not a key"), 0, true},
+		{"invalid armor", []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nnot a key\n-----END PGP PUBLIC KEY BLOCK-----\n"), 0, true},
+		{"empty", []byte{}, 0, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recipients, err := ReadPublicKeys(test.keyData)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if len(recipients) != test.want {
+				t.Errorf("got %d keys, want %d", len(recipients), test.want)
+			}
+			if len(recipients) > 0 && recipients[0].PrimaryKey.KeyId != alice.PrimaryKey.KeyId {
+				t.Errorf("got key %s, want %s", recipients[0].PrimaryKey.KeyIdString(), alice.PrimaryKey.KeyIdString())
+			}
+		})
+	}
+}
+
+func TestDescribeRecipients(t *testing.T) {
+	alice := newTestEntity(t, "alice")
+
+	got := DescribeRecipients(openpgp.EntityList{alice})
+	want := "alice <alice@example.com> (" + alice.PrimaryKey.KeyIdString() + ")"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestEncryptFile(t *testing.T) {
+	alice := newTestEntity(t, "alice")
+	bob := newTestEntity(t, "bob")
+
+	// The recipients are read from their public keys like the controller does
+	recipients, err := ReadPublicKeys(serializePublicKeys(t, true, alice, bob))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	directory := t.TempDir()
+	src := filepath.Join(directory, "containerdiag.zip")
+	content := []byte(strings.Repeat("diagnostic data\n", 1000))
+	if err := ioutil.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := src + "." + EncryptedFileExtension
+	if err := EncryptFile(src, dest, recipients); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v, want 0600", info.Mode().Perm())
+	}
+
+	// Each recipient can decrypt it with their private key
+	for _, entity := range []*openpgp.Entity{alice, bob} {
+		encrypted, err := os.Open(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		message, err := openpgp.ReadMessage(encrypted, openpgp.EntityList{entity}, nil, nil)
+		if err != nil {
+			encrypted.Close()
+			t.Fatalf("%s could not decrypt: %+v", entity.PrimaryKey.KeyIdString(), err)
+		}
+		decrypted, err := ioutil.ReadAll(message.UnverifiedBody)
+		encrypted.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decrypted, content) {
+			t.Errorf("%s decrypted %d bytes, want %d", entity.PrimaryKey.KeyIdString(), len(decrypted), len(content))
+		}
+		if message.LiteralData.FileName != "containerdiag.zip" {
+			t.Errorf("got file name %q", message.LiteralData.FileName)
+		}
+	}
+
+	// Another key can't
+	encrypted, err := os.Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encrypted.Close()
+	if _, err := openpgp.ReadMessage(encrypted, openpgp.EntityList{newTestEntity(t, "eve")}, nil, nil); err == nil {
+		t.Error("a key that isn't a recipient decrypted the file")
+	}
+}
+
+func TestEncryptFileRemovesDestinationOnError(t *testing.T) {
+	directory := t.TempDir()
+	dest := filepath.Join(directory, "containerdiag.zip."+EncryptedFileExtension)
+
+	if err := EncryptFile(filepath.Join(directory, "missing.zip"), dest, openpgp.EntityList{newTestEntity(t, "alice")}); err == nil {
+		t.Fatal("a missing source was accepted")
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed", dest)
+	}
+}
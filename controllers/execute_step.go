@@ -0,0 +1,194 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// The exit code of an execute script if the tool exited with a non-zero code that isn't ignored.
+// The output is still collected.
+const ExecuteToolFailedExitCode = 5
+
+// GetExecuteCommand returns the tool of an execute step, its arguments as a shell command line,
+// and whether it runs in the background. The tool is empty if the step doesn't specify one.
+func GetExecuteCommand(step diagnosticv1.ContainerDiagnosticStep) (string, string, bool) {
+	var command string
+	var arguments string
+	background := step.Background
+
+	if len(step.ExecCommand) > 0 {
+		// Typed steps are passed to the tool as-is
+		command = step.ExecCommand[0]
+		arguments = ShellQuoteAll(step.ExecCommand[1:])
+	} else if len(step.Arguments) > 0 {
+		// The tool is the first word of the arguments and the rest are passed through the shell
+		command = strings.TrimSpace(step.Arguments[0])
+
+		spaceIndex := strings.Index(command, " ")
+		if spaceIndex != -1 {
+			arguments = strings.TrimSpace(command[spaceIndex+1:])
+			command = command[:spaceIndex]
+		}
+
+		for _, arg := range step.Arguments[1:] {
+			if len(arguments) > 0 {
+				arguments += " "
+			}
+			arguments += arg
+		}
+
+		if arguments == "&" || strings.HasSuffix(arguments, " &") {
+			background = true
+			arguments = strings.TrimSpace(strings.TrimSuffix(arguments, "&"))
+		}
+	}
+
+	if len(step.Args) > 0 {
+		if len(arguments) > 0 {
+			arguments += " "
+		}
+		arguments += ShellQuoteAll(step.Args)
+	}
+
+	return command, arguments, background
+}
+
+// GetStepArguments returns the arguments of a step as recorded in the manifest
+func GetStepArguments(step diagnosticv1.ContainerDiagnosticStep) []string {
+	var arguments []string
+	if len(step.ExecCommand) > 0 {
+		arguments = append(arguments, step.ExecCommand...)
+	} else {
+		arguments = append(arguments, step.Arguments...)
+	}
+	return append(arguments, step.Args...)
+}
+
+// IsIgnoreErrors returns whether a non-zero exit code of the tool of an execute step is ignored.
+// Like before execCommand existed, the exit code of a command line in arguments is ignored by default.
+func IsIgnoreErrors(step diagnosticv1.ContainerDiagnosticStep) bool {
+	if step.IgnoreErrors != nil {
+		return *step.IgnoreErrors
+	}
+	return len(step.ExecCommand) == 0
+}
+
+// ShellQuote quotes a value so that the shell passes it as a single word without expansions
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "'\\''") + "'"
+}
+
+// ShellQuoteAll quotes each value and joins them with spaces
+func ShellQuoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for index, value := range values {
+		quoted[index] = ShellQuote(value)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// GetExecuteOutputFile returns the file in the container that the output of an execute step is
+// written to: the outputFile of the step or defaultName in containerTmpFilesPrefix. The steps are
+// validated before they run, but the output file is checked again because it's written into the
+// script.
+func GetExecuteOutputFile(containerTmpFilesPrefix string, step diagnosticv1.ContainerDiagnosticStep, defaultName string) (string, error) {
+	if len(step.OutputFile) == 0 {
+		return filepath.Join(containerTmpFilesPrefix, defaultName), nil
+	}
+
+	outputFile := filepath.Join(containerTmpFilesPrefix, step.OutputFile)
+	if strings.Contains(step.OutputFile, "/") || filepath.Dir(outputFile) != filepath.Clean(containerTmpFilesPrefix) {
+		return "", fmt.Errorf("outputFile %s must be a file name without a directory", step.OutputFile)
+	}
+	return outputFile, nil
+}
+
+// CheckExecuteEnv returns an error if the name of an environment variable of an execute step
+// can't be exported by the script
+func CheckExecuteEnv(step diagnosticv1.ContainerDiagnosticStep) error {
+	for _, envVar := range step.Env {
+		if !diagnosticv1.EnvVarNamePattern.MatchString(envVar.Name) {
+			return fmt.Errorf("env name %q is not a valid environment variable name", envVar.Name)
+		}
+	}
+	return nil
+}
+
+// WriteExecuteWorkingDir changes to the working directory of an execute step (if any)
+func WriteExecuteWorkingDir(fileWriter *os.File, step diagnosticv1.ContainerDiagnosticStep) {
+	if len(step.WorkingDir) > 0 {
+		fileWriter.WriteString(fmt.Sprintf("cd %s || exit 1\n", ShellQuote(step.WorkingDir)))
+	}
+}
+
+// WriteExecuteCommand writes the execution of the tool of an execute step with its environment
+// variables, output redirection, and repetitions. Unless the step ignores errors, the last
+// non-zero exit code of the tool is kept in ${RC} for WriteExecuteExit. The names of the
+// environment variables must have been checked with CheckExecuteEnv.
+func WriteExecuteCommand(fileWriter *os.File, containerTmpFilesPrefix string, step diagnosticv1.ContainerDiagnosticStep, commandLine string, background bool, outputFile string) {
+	for _, envVar := range step.Env {
+		fileWriter.WriteString(fmt.Sprintf("export %s=%s\n", envVar.Name, ShellQuote(envVar.Value)))
+	}
+
+	redirectStr := fmt.Sprintf(" >> %s 2>&1", ShellQuote(outputFile))
+	if step.CaptureStdout != nil && !*step.CaptureStdout {
+		redirectStr = fmt.Sprintf(" 2>> %s > /dev/null", ShellQuote(outputFile))
+	}
+
+	backgroundStr := ""
+	if background {
+		backgroundStr = " &"
+	}
+
+	// The exit code of a background tool isn't known
+	checkExitCode := !background && !IsIgnoreErrors(step)
+
+	fileWriter.WriteString("RC=0\n")
+
+	indent := ""
+	var count int32 = 1
+	if step.Repeat != nil && step.Repeat.Count > 1 {
+		count = step.Repeat.Count
+		indent = "  "
+		fileWriter.WriteString(fmt.Sprintf("I=1\nwhile [ \"${I}\" -le %d ]; do\n", count))
+		fileWriter.WriteString(fmt.Sprintf("%s%s \"containerdiag: Run ${I} of %d\"%s\n", indent, GetExecutionCommand(containerTmpFilesPrefix, "echo", ""), count, fmt.Sprintf(" >> %s 2>&1", ShellQuote(outputFile))))
+	}
+
+	fileWriter.WriteString(fmt.Sprintf("%s%s%s%s\n", indent, commandLine, redirectStr, backgroundStr))
+
+	if checkExitCode {
+		fileWriter.WriteString(fmt.Sprintf("%sCMDRC=$?\n%sif [ \"${CMDRC}\" -ne 0 ]; then\n%s  RC=${CMDRC}\n%sfi\n", indent, indent, indent, indent))
+	}
+
+	if count > 1 {
+		if step.Repeat.IntervalSeconds > 0 {
+			fileWriter.WriteString(fmt.Sprintf("  if [ \"${I}\" -lt %d ]; then\n    %s\n  fi\n", count, GetExecutionCommand(containerTmpFilesPrefix, "sleep", fmt.Sprintf("%d", step.Repeat.IntervalSeconds))))
+		}
+		fileWriter.WriteString("  I=$((I + 1))\ndone\n")
+	}
+}
+
+// WriteExecuteExit ends an execute script with ExecuteToolFailedExitCode if the tool failed
+func WriteExecuteExit(fileWriter *os.File, containerTmpFilesPrefix string, command string) {
+	fileWriter.WriteString(fmt.Sprintf("if [ \"${RC}\" -ne 0 ]; then\n  %s \"ERROR: %s exited with code ${RC}\" 1>&2\n  exit %d\nfi\n", GetExecutionCommand(containerTmpFilesPrefix, "echo", ""), command, ExecuteToolFailedExitCode))
+}
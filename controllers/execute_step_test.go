@@ -0,0 +1,362 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+func boolPointer(value bool) *bool {
+	return &value
+}
+
+func TestGetExecuteCommand(t *testing.T) {
+	tests := []struct {
+		name           string
+		step           diagnosticv1.ContainerDiagnosticStep
+		wantCommand    string
+		wantArguments  string
+		wantBackground bool
+	}{
+		{"no tool", diagnosticv1.ContainerDiagnosticStep{Command: "execute"}, "", "", false},
+		{"blank arguments", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{" "}}, "", "", false},
+		{"tool", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{"top"}}, "top", "", false},
+		{"command line", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{" jstack -l "}}, "jstack", "-l", false},
+		{"split arguments", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{"jstack -l", "$(pgrep java)"}}, "jstack", "-l $(pgrep java)", false},
+		{"background command line", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{"top -b &"}}, "top", "-b", true},
+		{"background argument", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{"top", "&"}}, "top", "", true},
+		{"ampersand in an argument", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{"echo a&b"}}, "echo", "a&b", false},
+		{"background option", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{"top -b"}, Background: true}, "top", "-b", true},
+		{"execCommand", diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"top", "-b", "it's $HOME"}}, "top", "'-b' 'it'\\''s $HOME'", false},
+		{"execCommand and args", diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"top", "-b"}, Args: []string{"-n", "1"}}, "top", "'-b' '-n' '1'", false},
+		{"execCommand ignores arguments", diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"top"}, Arguments: []string{"ps &"}}, "top", "", false},
+		{"arguments and args", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{"top -b"}, Args: []string{"-n 1"}}, "top", "-b '-n 1'", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			command, arguments, background := GetExecuteCommand(test.step)
+			if command != test.wantCommand || arguments != test.wantArguments || background != test.wantBackground {
+				t.Errorf("got %q, %q, %t, want %q, %q, %t", command, arguments, background, test.wantCommand, test.wantArguments, test.wantBackground)
+			}
+		})
+	}
+}
+
+func TestIsIgnoreErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		step diagnosticv1.ContainerDiagnosticStep
+		want bool
+	}{
+		{"arguments", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{"top"}}, true},
+		{"execCommand", diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"top"}}, false},
+		{"arguments not ignoring errors", diagnosticv1.ContainerDiagnosticStep{Arguments: []string{"top"}, IgnoreErrors: boolPointer(false)}, false},
+		{"execCommand ignoring errors", diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"top"}, IgnoreErrors: boolPointer(true)}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsIgnoreErrors(test.step); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	values := []string{"", "simple", "with space", "it's", "''", "$HOME", "$(touch pwned)", "`id`", "a;b|c&d", "*", "line\nbreak", "back\\slash"}
+
+	for _, value := range values {
+		if got := ShellQuote(value); !strings.HasPrefix(got, "'") || !strings.HasSuffix(got, "'") {
+			t.Errorf("%q isn't quoted: %s", value, got)
+		}
+	}
+
+	if got := ShellQuoteAll([]string{"a b", "it's"}); got != "'a b' 'it'\\''s'" {
+		t.Errorf("got %s", got)
+	}
+	if got := ShellQuoteAll(nil); got != "" {
+		t.Errorf("got %q for no values", got)
+	}
+
+	// The shell passes each quoted value as a single word without expansions
+	command := exec.Command("sh", "-c", "for value in "+ShellQuoteAll(values)+"; do printf '%s|' \"${value}\"; done")
+	command.Dir = t.TempDir()
+	output, err := command.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(values, "|") + "|"; string(output) != want {
+		t.Errorf("got %q, want %q", output, want)
+	}
+	if _, err := os.Stat(filepath.Join(command.Dir, "pwned")); !os.IsNotExist(err) {
+		t.Errorf("a quoted value was run: %v", err)
+	}
+}
+
+func TestGetExecuteOutputFile(t *testing.T) {
+	tests := []struct {
+		outputFile string
+		want       string
+		wantErr    bool
+	}{
+		{"", "/tmp/containerdiag/default.txt", false},
+		{"top.txt", "/tmp/containerdiag/top.txt", false},
+		{"it's here.txt", "/tmp/containerdiag/it's here.txt", false},
+		{"..", "", true},
+		{".", "", true},
+		{"../top.txt", "", true},
+		{"logs/top.txt", "", true},
+		{"/etc/passwd", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.outputFile, func(t *testing.T) {
+			got, err := GetExecuteOutputFile("/tmp/containerdiag/", diagnosticv1.ContainerDiagnosticStep{OutputFile: test.outputFile}, "default.txt")
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckExecuteEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		envName string
+		wantErr bool
+	}{
+		{"valid", "JAVA_TOOL_OPTIONS", false},
+		{"underscore", "_A1", false},
+		{"empty", "", true},
+		{"digit", "1A", true},
+		{"command", "A=1; touch pwned; B", true},
+		{"space", "A B", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			step := diagnosticv1.ContainerDiagnosticStep{Env: []diagnosticv1.StepEnvVar{{Name: "OK", Value: "x"}, {Name: test.envName, Value: "x"}}}
+			if err := CheckExecuteEnv(step); (err != nil) != test.wantErr {
+				t.Errorf("got %v, want error %t", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// newTestContainerPrefix returns a temporary directory that can be used as the
+// containerTmpFilesPrefix of scripts run on this machine: the uploaded ld-linux runs the tool
+// directly and the tools are links to the tools of this machine
+func newTestContainerPrefix(t *testing.T, tools ...string) string {
+	prefix := t.TempDir()
+	for _, directory := range []string{"lib64", "usr/bin", "usr/sbin"} {
+		if err := os.MkdirAll(filepath.Join(prefix, directory), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// GetExecutionCommand runs ld-linux with --inhibit-cache --library-path <directory> <tool>
+	if err := ioutil.WriteFile(filepath.Join(prefix, "lib64", "ld-linux-x86-64.so.2"), []byte("#!/bin/sh\nshift 3\nexec \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tool := range tools {
+		path, err := exec.LookPath(tool)
+		if err != nil {
+			t.Skipf("%s not found: %v", tool, err)
+		}
+		for _, directory := range []string{"usr/bin", "usr/sbin"} {
+			if err := os.Symlink(path, filepath.Join(prefix, directory, tool)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return prefix
+}
+
+// runExecuteCommand runs the script written by WriteExecuteCommand followed by commands that
+// wait for background tools and print ${RC}, and returns the output of the script and the
+// contents of the output file
+func runExecuteCommand(t *testing.T, prefix string, step diagnosticv1.ContainerDiagnosticStep, commandLine string, background bool) (string, string) {
+	scriptPath := filepath.Join(prefix, "execute.sh")
+	script, err := os.Create(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputFile := filepath.Join(prefix, "it's output.txt")
+	WriteExecuteCommand(script, prefix, step, commandLine, background, outputFile)
+	script.WriteString("wait\necho \"RC=${RC}\"\n")
+	script.Close()
+
+	command := exec.Command("sh", scriptPath)
+	command.Dir = prefix
+	scriptOutput, err := command.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, scriptOutput)
+	}
+
+	output, err := ioutil.ReadFile(outputFile)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(scriptOutput), string(output)
+}
+
+func TestWriteExecuteCommand(t *testing.T) {
+	prefix := newTestContainerPrefix(t, "echo", "sleep")
+
+	// The tool counts its runs in a file and fails on the second run
+	counter := "sh -c 'n=$(cat count 2>/dev/null || echo 0); n=$((n + 1)); echo $n > count; echo \"run $n out\"; echo \"run $n err\" >&2; [ $n -ne 2 ] || exit 4'"
+
+	tests := []struct {
+		name        string
+		step        diagnosticv1.ContainerDiagnosticStep
+		commandLine string
+		background  bool
+		wantRC      string
+		wantOutput  []string
+		wantMissing []string
+	}{
+		{
+			"success",
+			diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"sh"}},
+			counter, false, "RC=0",
+			[]string{"run 1 out", "run 1 err"}, []string{"containerdiag: Run"},
+		},
+		{
+			"exit code",
+			diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"sh"}},
+			"sh -c 'echo failed; exit 3'", false, "RC=3",
+			[]string{"failed"}, nil,
+		},
+		{
+			"ignored exit code",
+			diagnosticv1.ContainerDiagnosticStep{Arguments: []string{"sh"}},
+			"sh -c 'echo failed; exit 3'", false, "RC=0",
+			[]string{"failed"}, nil,
+		},
+		{
+			"stdout not captured",
+			diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"sh"}, CaptureStdout: boolPointer(false)},
+			counter, false, "RC=0",
+			[]string{"run 1 err"}, []string{"run 1 out"},
+		},
+		{
+			"repeat",
+			diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"sh"}, Repeat: &diagnosticv1.StepRepeat{Count: 3}},
+			counter, false, "RC=4",
+			[]string{"containerdiag: Run 1 of 3", "run 1 out", "containerdiag: Run 2 of 3", "run 2 err", "containerdiag: Run 3 of 3", "run 3 out"}, []string{"Run 4 of 3"},
+		},
+		{
+			"repeat with an interval",
+			diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"sh"}, Repeat: &diagnosticv1.StepRepeat{Count: 2, IntervalSeconds: 1}},
+			counter, false, "RC=4",
+			[]string{"containerdiag: Run 2 of 2", "run 2 out"}, []string{"run 3"},
+		},
+		{
+			"background",
+			diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"sh"}},
+			"sh -c 'sleep 1; echo done; exit 3'", true, "RC=0",
+			[]string{"done"}, nil,
+		},
+		{
+			"environment",
+			diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"sh"}, Env: []diagnosticv1.StepEnvVar{{Name: "VALUE", Value: "it's $HOME"}}},
+			"sh -c 'echo \"value: ${VALUE}\"'", false, "RC=0",
+			[]string{"value: it's $HOME"}, nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Remove(filepath.Join(prefix, "count"))
+			os.Remove(filepath.Join(prefix, "it's output.txt"))
+
+			scriptOutput, output := runExecuteCommand(t, prefix, test.step, test.commandLine, test.background)
+			if !strings.Contains(scriptOutput, test.wantRC) {
+				t.Errorf("got script output %q, want %s", scriptOutput, test.wantRC)
+			}
+
+			// Everything goes to the output file
+			if strings.Contains(scriptOutput, "run ") || strings.Contains(scriptOutput, "containerdiag:") {
+				t.Errorf("the output wasn't redirected: %q", scriptOutput)
+			}
+
+			last := -1
+			for _, want := range test.wantOutput {
+				index := strings.Index(output, want)
+				if index <= last {
+					t.Errorf("%q isn't in order in the output %q", want, output)
+				}
+				last = index
+			}
+			for _, missing := range test.wantMissing {
+				if strings.Contains(output, missing) {
+					t.Errorf("%q is in the output %q", missing, output)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteExecuteCommandScript(t *testing.T) {
+	script, err := os.Create(filepath.Join(t.TempDir(), "execute.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := diagnosticv1.ContainerDiagnosticStep{ExecCommand: []string{"top"}, Repeat: &diagnosticv1.StepRepeat{Count: 2, IntervalSeconds: 5}}
+	WriteExecuteCommand(script, "/tmp/containerdiag/", step, "top -b", true, "/tmp/containerdiag/top.txt")
+	script.Close()
+
+	content, err := ioutil.ReadFile(script.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"RC=0\n",
+		"while [ \"${I}\" -le 2 ]; do\n",
+		"  top -b >> '/tmp/containerdiag/top.txt' 2>&1 &\n",
+		"  if [ \"${I}\" -lt 2 ]; then\n",
+		"  I=$((I + 1))\ndone\n",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("%q isn't in the script:\n%s", want, content)
+		}
+	}
+
+	// The exit code of a background tool isn't known
+	if strings.Contains(string(content), "CMDRC") {
+		t.Errorf("the exit code of a background tool is checked:\n%s", content)
+	}
+
+	if output, err := exec.Command("sh", "-n", script.Name()).CombinedOutput(); err != nil {
+		t.Errorf("invalid script: %v: %s", err, output)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Environment variables set by the downward API in the manager Deployment
+const (
+	PodNameEnvVar      = "POD_NAME"
+	PodNamespaceEnvVar = "POD_NAMESPACE"
+)
+
+// The namespace of the pod's service account which is mounted into every pod by default
+var ServiceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// GetManagerPodName returns the name of the manager pod from the downward API or, if that isn't
+// configured, /etc/hostname
+func GetManagerPodName() (string, error) {
+	if podName := os.Getenv(PodNameEnvVar); podName != "" {
+		return podName, nil
+	}
+
+	hostnameBytes, err := ioutil.ReadFile("/etc/hostname")
+	if err != nil {
+		return "", fmt.Errorf("could not read /etc/hostname: %w", err)
+	}
+
+	return strings.TrimSpace(string(hostnameBytes)), nil
+}
+
+// GetManagerPodNamespace returns the namespace of the manager pod from the downward API or, if
+// that isn't configured, the service account namespace file
+func GetManagerPodNamespace() (string, error) {
+	if namespace := os.Getenv(PodNamespaceEnvVar); namespace != "" {
+		return namespace, nil
+	}
+
+	namespaceBytes, err := ioutil.ReadFile(ServiceAccountNamespaceFile)
+	if err != nil {
+		return "", fmt.Errorf("%s is not set and could not read %s: %w", PodNamespaceEnvVar, ServiceAccountNamespaceFile, err)
+	}
+
+	namespace := strings.TrimSpace(string(namespaceBytes))
+	if namespace == "" {
+		return "", fmt.Errorf("%s is not set and %s is empty", PodNamespaceEnvVar, ServiceAccountNamespaceFile)
+	}
+
+	return namespace, nil
+}
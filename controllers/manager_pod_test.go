@@ -0,0 +1,68 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetManagerPodNamespace(t *testing.T) {
+	directory := t.TempDir()
+
+	namespaceFile := filepath.Join(directory, "namespace")
+	if err := ioutil.WriteFile(namespaceFile, []byte("from-file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(directory, "empty")
+	if err := ioutil.WriteFile(emptyFile, []byte("\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		env     string
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{"environment variable", "from-env", namespaceFile, "from-env", false},
+		{"environment variable without the file", "from-env", filepath.Join(directory, "missing"), "from-env", false},
+		{"file", "", namespaceFile, "from-file", false},
+		{"empty file", "", emptyFile, "", true},
+		{"neither", "", filepath.Join(directory, "missing"), "", true},
+	}
+
+	originalFile := ServiceAccountNamespaceFile
+	defer func() { ServiceAccountNamespaceFile = originalFile }()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv(PodNamespaceEnvVar, test.env)
+			ServiceAccountNamespaceFile = test.file
+
+			got, err := GetManagerPodNamespace()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// The name of the manifest file at the root of every diagnostic bundle
+const ManifestFileName = "manifest.json"
+
+// The version of the manifest format. Increment when making incompatible changes.
+const ManifestVersion = 1
+
+// BundleManifest describes the contents of a diagnostic bundle so that analysis tools
+// don't have to guess the layout
+type BundleManifest struct {
+	ManifestVersion int                                  `json:"manifestVersion"`
+	OperatorVersion string                               `json:"operatorVersion"`
+	Name            string                               `json:"name"`
+	Namespace       string                               `json:"namespace"`
+	Identifier      string                               `json:"identifier"`
+	Spec            diagnosticv1.ContainerDiagnosticSpec `json:"spec"`
+	StartTime       time.Time                            `json:"startTime"`
+	EndTime         time.Time                            `json:"endTime"`
+	Targets         []*ManifestTarget                    `json:"targets"`
+	Files           []ManifestFile                       `json:"files"`
+	Errors          []string                             `json:"errors"`
+}
+
+// ManifestTarget is a container on which the steps were run
+type ManifestTarget struct {
+	Namespace  string          `json:"namespace"`
+	Pod        string          `json:"pod"`
+	Container  string          `json:"container"`
+	Node       string          `json:"node"`
+	Identifier string          `json:"identifier"`
+	Directory  string          `json:"directory"`
+	StartTime  time.Time       `json:"startTime"`
+	EndTime    time.Time       `json:"endTime"`
+	Success    bool            `json:"success"`
+	Steps      []*ManifestStep `json:"steps"`
+	Skipped    []SkippedFile   `json:"skipped"`
+	Errors     []string        `json:"errors"`
+
+	firstError int
+}
+
+// ManifestStep is a command executed in a target container. Step is the 1-based index
+// of the step in the spec or 0 for commands that the operator runs itself.
+type ManifestStep struct {
+	Step      int       `json:"step"`
+	Command   string    `json:"command"`
+	Arguments []string  `json:"arguments"`
+	Execution []string  `json:"execution"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	ExitCode  int       `json:"exitCode"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ManifestFile is a file in the bundle with a path relative to the root of the bundle
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+func NewBundleManifest(containerDiagnostic *diagnosticv1.ContainerDiagnostic, identifier string) *BundleManifest {
+	return &BundleManifest{
+		ManifestVersion: ManifestVersion,
+		OperatorVersion: OperatorVersion,
+		Name:            containerDiagnostic.Name,
+		Namespace:       containerDiagnostic.Namespace,
+		Identifier:      identifier,
+		Spec:            *containerDiagnostic.Spec.DeepCopy(),
+		StartTime:       time.Now(),
+		Targets:         []*ManifestTarget{},
+		Files:           []ManifestFile{},
+		Errors:          []string{},
+	}
+}
+
+// StartTarget adds a target container to the manifest. Errors recorded by the logger from now
+// until FinishTarget are associated with this target.
+func (m *BundleManifest) StartTarget(namespace string, pod string, container string, node string, identifier string, logger *CustomLogger) *ManifestTarget {
+	target := &ManifestTarget{
+		Namespace:  namespace,
+		Pod:        pod,
+		Container:  container,
+		Node:       node,
+		Identifier: identifier,
+		Directory:  filepath.ToSlash(filepath.Join("namespaces", namespace, "pods", pod, "containers", container, identifier)),
+		StartTime:  time.Now(),
+		Steps:      []*ManifestStep{},
+		Skipped:    []SkippedFile{},
+		Errors:     []string{},
+		firstError: len(logger.errors),
+	}
+	m.Targets = append(m.Targets, target)
+	return target
+}
+
+func (t *ManifestTarget) Finish(success bool, logger *CustomLogger) {
+	t.EndTime = time.Now()
+	t.Success = success
+	t.Errors = append(t.Errors, logger.errors[t.firstError:]...)
+}
+
+// StartStep records the start of a command executed in the target container
+func (t *ManifestTarget) StartStep(step int, command string, arguments []string, execution []string) *ManifestStep {
+	manifestStep := &ManifestStep{
+		Step:      step,
+		Command:   command,
+		Arguments: arguments,
+		Execution: execution,
+		StartTime: time.Now(),
+	}
+	t.Steps = append(t.Steps, manifestStep)
+	return manifestStep
+}
+
+// Finish records the end of a command and its exit code based on the error (if any)
+func (s *ManifestStep) Finish(err error) {
+	s.EndTime = time.Now()
+	s.ExitCode = GetExitCode(err)
+	StepDurationSeconds.WithLabelValues(s.Command, GetOutcome(err)).Observe(s.EndTime.Sub(s.StartTime).Seconds())
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// GetExitCode returns the exit code of a command executed in a container, 0 if there
+// was no error, or -1 if the command failed without an exit code (e.g. the exec failed)
+func GetExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitError utilexec.ExitError
+	if errors.As(err, &exitError) {
+		return exitError.ExitStatus()
+	}
+	return -1
+}
+
+// Write lists all of the files in the bundle directory with their checksums and
+// then writes the manifest to the root of the bundle directory
+func (m *BundleManifest) Write(bundleDirectory string, logger *CustomLogger) error {
+	m.EndTime = time.Now()
+	m.Errors = append(m.Errors, logger.errors...)
+
+	files, err := ListBundleFiles(bundleDirectory)
+	if err != nil {
+		return err
+	}
+	m.Files = files
+
+	jsonBytes, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(bundleDirectory, ManifestFileName), jsonBytes, os.ModePerm)
+}
+
+// ListBundleFiles returns all regular files under bundleDirectory (except for the manifest itself)
+// with their sizes and SHA-256 checksums
+func ListBundleFiles(bundleDirectory string) ([]ManifestFile, error) {
+	files := []ManifestFile{}
+
+	err := WalkDirectoryForArchive(bundleDirectory,
+		func(path string, name string, info os.FileInfo) error {
+			if info.IsDir() || name == ManifestFileName {
+				return nil
+			}
+
+			checksum, err := GetFileChecksum(path)
+			if err != nil {
+				return err
+			}
+
+			files = append(files, ManifestFile{Path: name, Size: info.Size(), SHA256: checksum})
+			return nil
+		})
+
+	return files, err
+}
+
+// GetFileChecksum returns the hex encoded SHA-256 checksum of a file
+func GetFileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
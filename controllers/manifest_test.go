@@ -0,0 +1,165 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilexec "k8s.io/client-go/util/exec"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// The SHA-256 checksum of "hello"
+const helloChecksum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func writeBundleFiles(t *testing.T, directory string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(directory, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListBundleFiles(t *testing.T) {
+	directory := t.TempDir()
+	writeBundleFiles(t, directory, map[string]string{
+		"hello.txt":                     "hello",
+		ManifestFileName:                "{}",
+		"namespaces/app/pods/p/out.txt": "",
+	})
+	if err := os.MkdirAll(filepath.Join(directory, "empty"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(directory, "hello.txt"), filepath.Join(directory, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ListBundleFiles(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories, links and the manifest itself are not listed
+	want := []ManifestFile{
+		{Path: "hello.txt", Size: 5, SHA256: helloChecksum},
+		{Path: "namespaces/app/pods/p/out.txt", Size: 0, SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("got %+v, want %+v", files, want)
+	}
+}
+
+func TestListBundleFilesMissingDirectory(t *testing.T) {
+	if _, err := ListBundleFiles(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("a missing directory was accepted")
+	}
+}
+
+func TestGetExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no error", nil, 0},
+		{"exit code", utilexec.CodeExitError{Err: errors.New("command terminated with exit code 3"), Code: 3}, 3},
+		{"wrapped exit code", fmt.Errorf("step failed: %w", utilexec.CodeExitError{Err: errors.New("exit"), Code: 127}), 127},
+		{"exec failed", k8serrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "app"), -1},
+		{"other", errors.New("stream closed"), -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := GetExitCode(test.err); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestBundleManifestWrite(t *testing.T) {
+	directory := t.TempDir()
+	writeBundleFiles(t, directory, map[string]string{"hello.txt": "hello"})
+
+	containerDiagnostic := &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{Name: "diag", Namespace: "app"},
+		Spec:       diagnosticv1.ContainerDiagnosticSpec{Command: "script"},
+	}
+	logger := &CustomLogger{logger: ctrl.Log.WithName("manifest_test")}
+
+	manifest := NewBundleManifest(containerDiagnostic, "20211018_120000")
+	logger.errors = append(logger.errors, "before the target")
+	target := manifest.StartTarget("app", "pod1", "container1", "node1", "20211018_120001", logger)
+	step := target.StartStep(1, "execute", []string{"echo", "hello"}, []string{"sh", "-c", "echo hello"})
+	step.Finish(utilexec.CodeExitError{Err: errors.New("exit"), Code: 2})
+	logger.errors = append(logger.errors, "during the target")
+	target.Finish(false, logger)
+
+	if err := manifest.Write(directory, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	jsonBytes, err := ioutil.ReadFile(filepath.Join(directory, ManifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written BundleManifest
+	if err := json.Unmarshal(jsonBytes, &written); err != nil {
+		t.Fatal(err)
+	}
+
+	if written.ManifestVersion != ManifestVersion || written.Name != "diag" || written.Namespace != "app" || written.Spec.Command != "script" {
+		t.Errorf("got %+v", written)
+	}
+	if want := []ManifestFile{{Path: "hello.txt", Size: 5, SHA256: helloChecksum}}; !reflect.DeepEqual(written.Files, want) {
+		t.Errorf("got files %+v, want %+v", written.Files, want)
+	}
+	if len(written.Errors) != 2 {
+		t.Errorf("got errors %v, want 2", written.Errors)
+	}
+	if len(written.Targets) != 1 {
+		t.Fatalf("got %d targets, want 1", len(written.Targets))
+	}
+
+	writtenTarget := written.Targets[0]
+	if writtenTarget.Directory != "namespaces/app/pods/pod1/containers/container1/20211018_120001" || writtenTarget.Success {
+		t.Errorf("got target %+v", writtenTarget)
+	}
+	// Only the errors logged after the target started belong to it
+	if len(writtenTarget.Errors) != 1 {
+		t.Errorf("got target errors %v, want 1", writtenTarget.Errors)
+	}
+	if len(writtenTarget.Steps) != 1 || writtenTarget.Steps[0].ExitCode != 2 || writtenTarget.Steps[0].Error == "" {
+		t.Errorf("got steps %+v", writtenTarget.Steps)
+	}
+}
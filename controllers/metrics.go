@@ -0,0 +1,150 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	utilexec "k8s.io/client-go/util/exec"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// The prefix of the names of all of the operator's metrics
+const MetricsNamespace = "containerdiag"
+
+// Values of the direction label of TransferredBytes
+const (
+	TransferDirectionUpload   = "upload"
+	TransferDirectionDownload = "download"
+)
+
+// Values of the target label of TransferredBytes
+const (
+	TransferTargetContainer = "container"
+	TransferTargetS3        = "s3"
+)
+
+// Diagnostic runs take from seconds to many minutes (e.g. linperf.sh)
+var RunDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+var (
+	RunsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "runs_total",
+		Help:      "Number of finished ContainerDiagnostic runs by command and outcome",
+	}, []string{"command", "outcome"})
+
+	RunDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of finished ContainerDiagnostic runs from creation to completion by command and outcome",
+		Buckets:   RunDurationBuckets,
+	}, []string{"command", "outcome"})
+
+	StepDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "step_duration_seconds",
+		Help:      "Duration of each command executed in a target container (e.g. upload, execute, zip, download) by command and outcome",
+		Buckets:   RunDurationBuckets,
+	}, []string{"command", "outcome"})
+
+	TransferredBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "transferred_bytes_total",
+		Help:      "Bytes uploaded to and downloaded from target containers and uploaded to object storage",
+	}, []string{"direction", "target"})
+
+	ExecErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "exec_errors_total",
+		Help:      "Number of failed executions in target containers by type (exit for a non-zero exit code, an API reason such as Forbidden or NotFound, or other)",
+	}, []string{"type"})
+
+	ActiveRuns = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "active_runs",
+		Help:      "Number of ContainerDiagnostic runs in progress",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(RunsTotal, RunDurationSeconds, StepDurationSeconds, TransferredBytes, ExecErrorsTotal, ActiveRuns)
+}
+
+// RegisterOutputDirectoryMetrics registers gauges of the size of the output directory and the free
+// disk space of its filesystem which are calculated when the metrics are scraped
+func RegisterOutputDirectoryMetrics(outputDirectory string) error {
+	collectors := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "output_directory_bytes",
+			Help:      "Total size of the files in the output directory",
+		}, func() float64 {
+			size, err := GetDirectorySize(outputDirectory)
+			if err != nil {
+				return -1
+			}
+			return float64(size)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Name:      "output_directory_free_bytes",
+			Help:      "Free disk space of the filesystem of the output directory",
+		}, func() float64 {
+			free, err := GetFreeDiskSpace(outputDirectory)
+			if err != nil {
+				return -1
+			}
+			return float64(free)
+		}),
+	}
+
+	for _, collector := range collectors {
+		err := metrics.Registry.Register(collector)
+		if err != nil {
+			// SetupWithManager may be called more than once (e.g. in tests)
+			var alreadyRegistered prometheus.AlreadyRegisteredError
+			if !errors.As(err, &alreadyRegistered) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetOutcome returns the value of the outcome label for an error
+func GetOutcome(err error) string {
+	if err == nil {
+		return StatusSuccess.ToString()
+	}
+	return StatusError.ToString()
+}
+
+// GetExecErrorType returns the value of the type label of ExecErrorsTotal for an error
+func GetExecErrorType(err error) string {
+	var exitError utilexec.ExitError
+	if errors.As(err, &exitError) {
+		return "exit"
+	}
+	if reason := k8serrors.ReasonForError(err); reason != "" {
+		return string(reason)
+	}
+	return "other"
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+func TestGetExecErrorType(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"exit code", utilexec.CodeExitError{Err: errors.New("command terminated with exit code 1"), Code: 1}, "exit"},
+		{"wrapped exit code", fmt.Errorf("step failed: %w", utilexec.CodeExitError{Err: errors.New("exit"), Code: 2}), "exit"},
+		{"not found", k8serrors.NewNotFound(schema.GroupResource{Resource: "pods"}, "app"), "NotFound"},
+		{"forbidden", k8serrors.NewForbidden(schema.GroupResource{Resource: "pods/exec"}, "app", errors.New("denied")), "Forbidden"},
+		{"timeout", k8serrors.NewTimeoutError("exec", 10), "Timeout"},
+		{"other", errors.New("connection reset"), "other"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := GetExecErrorType(test.err); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetOutcome(t *testing.T) {
+	if got := GetOutcome(nil); got != StatusSuccess.ToString() {
+		t.Errorf("got %q for no error", got)
+	}
+	if got := GetOutcome(errors.New("failed")); got != StatusError.ToString() {
+		t.Errorf("got %q for an error", got)
+	}
+}
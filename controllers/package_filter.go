@@ -0,0 +1,200 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// Written by list.sh before the files of each package step
+const PackageStepMarker = "containerdiag_package_step"
+
+// RemoteFile is a regular file in a target container
+type RemoteFile struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// SkippedFile is a file that wasn't collected and why
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"`
+}
+
+// IsPackageFilterEnabled returns true if the files of package steps must be listed and filtered
+// before they're zipped rather than passing the arguments directly to zip
+func IsPackageFilterEnabled(containerDiagnostic *diagnosticv1.ContainerDiagnostic, step diagnosticv1.ContainerDiagnosticStep) bool {
+	return step.Command == "package" && (step.Filter != nil || containerDiagnostic.Spec.MaxBundleSizeMB > 0)
+}
+
+// WriteListScript writes a script that lists the regular files under the arguments of each
+// package step with their sizes and modification times
+func WriteListScript(fileWriter *os.File, containerTmpFilesPrefix string, packageSteps map[int][]string) {
+	fileWriter.WriteString("#!/bin/sh\n")
+	if !UseLdLinuxDirect {
+		AddDirectCallEnvars(fileWriter, containerTmpFilesPrefix)
+	}
+
+	stepIndexes := []int{}
+	for stepIndex := range packageSteps {
+		stepIndexes = append(stepIndexes, stepIndex)
+	}
+	sort.Ints(stepIndexes)
+
+	for _, stepIndex := range stepIndexes {
+		WriteExecutionLine(fileWriter, containerTmpFilesPrefix, "echo", fmt.Sprintf("\"%s %d\"", PackageStepMarker, stepIndex), false, "", false)
+		fileWriter.WriteString(fmt.Sprintf("%s 2>/dev/null\n", GetExecutionCommand(containerTmpFilesPrefix, "find", strings.Join(packageSteps[stepIndex], " ")+" -type f -printf '%s %T@ %p\\n'")))
+	}
+
+	// Some paths might not exist but we don't want to fail because of that
+	fileWriter.WriteString("exit 0\n")
+}
+
+// ParseRemoteFileListing parses the output of the list script into the files of each package step
+func ParseRemoteFileListing(output string) map[int][]RemoteFile {
+	result := map[int][]RemoteFile{}
+	stepIndex := -1
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if strings.HasPrefix(line, PackageStepMarker+" ") {
+			index, err := strconv.Atoi(strings.TrimPrefix(line, PackageStepMarker+" "))
+			if err == nil {
+				stepIndex = index
+				seen = map[string]bool{}
+			}
+			continue
+		}
+
+		if stepIndex < 0 {
+			continue
+		}
+
+		// The path may contain spaces
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		seconds, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+
+		// Overlapping arguments would otherwise list the same file twice
+		if seen[fields[2]] {
+			continue
+		}
+		seen[fields[2]] = true
+
+		whole, fraction := math.Modf(seconds)
+		result[stepIndex] = append(result[stepIndex], RemoteFile{
+			Path:    fields[2],
+			Size:    size,
+			ModTime: time.Unix(int64(whole), int64(fraction*1e9)),
+		})
+	}
+
+	return result
+}
+
+// FilterRemoteFiles applies a package step filter (which may be nil)
+func FilterRemoteFiles(files []RemoteFile, filter *diagnosticv1.PackageFilter, now time.Time) (selected []RemoteFile, skipped []SkippedFile) {
+	if filter == nil {
+		return files, nil
+	}
+
+	for _, file := range files {
+		if len(filter.Include) > 0 && !MatchesAnyPattern(file.Path, filter.Include) {
+			skipped = append(skipped, SkippedFile{Path: file.Path, Size: file.Size, Reason: "not included"})
+		} else if MatchesAnyPattern(file.Path, filter.Exclude) {
+			skipped = append(skipped, SkippedFile{Path: file.Path, Size: file.Size, Reason: "excluded"})
+		} else if filter.ModifiedWithinMinutes > 0 && file.ModTime.Before(now.Add(-time.Duration(filter.ModifiedWithinMinutes)*time.Minute)) {
+			skipped = append(skipped, SkippedFile{Path: file.Path, Size: file.Size, Reason: fmt.Sprintf("not modified within %d minutes", filter.ModifiedWithinMinutes)})
+		} else if filter.MaxFileSizeMB > 0 && file.Size > int64(filter.MaxFileSizeMB)*1024*1024 {
+			skipped = append(skipped, SkippedFile{Path: file.Path, Size: file.Size, Reason: fmt.Sprintf("larger than %d MB", filter.MaxFileSizeMB)})
+		} else {
+			selected = append(selected, file)
+		}
+	}
+
+	if filter.NewestFiles > 0 && len(selected) > filter.NewestFiles {
+		SortNewestFirst(selected)
+		for _, file := range selected[filter.NewestFiles:] {
+			skipped = append(skipped, SkippedFile{Path: file.Path, Size: file.Size, Reason: fmt.Sprintf("not one of the newest %d files", filter.NewestFiles)})
+		}
+		selected = selected[:filter.NewestFiles]
+	}
+
+	return selected, skipped
+}
+
+// LimitRemoteFiles keeps the most recently modified files that fit within remainingBytes. If
+// remainingBytes is negative, there is no limit.
+func LimitRemoteFiles(files []RemoteFile, remainingBytes int64, maxBundleSizeMB int) (selected []RemoteFile, skipped []SkippedFile, totalBytes int64) {
+	SortNewestFirst(files)
+
+	for _, file := range files {
+		if remainingBytes >= 0 && totalBytes+file.Size > remainingBytes {
+			skipped = append(skipped, SkippedFile{Path: file.Path, Size: file.Size, Reason: fmt.Sprintf("exceeds the maximum bundle size of %d MB", maxBundleSizeMB)})
+		} else {
+			selected = append(selected, file)
+			totalBytes += file.Size
+		}
+	}
+
+	return selected, skipped, totalBytes
+}
+
+// MatchesAnyPattern returns true if the path or its file name matches one of the patterns
+func MatchesAnyPattern(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func SortNewestFirst(files []RemoteFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].ModTime.After(files[j].ModTime)
+	})
+}
@@ -0,0 +1,241 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+func TestParseRemoteFileListing(t *testing.T) {
+	modTime := time.Unix(1634558400, 500000000)
+
+	tests := []struct {
+		name   string
+		output string
+		want   map[int][]RemoteFile
+	}{
+		{
+			"one step",
+			"containerdiag_package_step 0\n100 1634558400.5 /logs/messages.log\n200 1634558400.5 /logs/trace.log\n",
+			map[int][]RemoteFile{0: {
+				{Path: "/logs/messages.log", Size: 100, ModTime: modTime},
+				{Path: "/logs/trace.log", Size: 200, ModTime: modTime},
+			}},
+		},
+		{
+			"paths with spaces",
+			"containerdiag_package_step 0\n100 1634558400.5 /opt/app/my logs/server log.txt\n",
+			map[int][]RemoteFile{0: {{Path: "/opt/app/my logs/server log.txt", Size: 100, ModTime: modTime}}},
+		},
+		{
+			"multiple steps",
+			"containerdiag_package_step 1\n100 1634558400.5 /logs/a.log\ncontainerdiag_package_step 3\n200 1634558400.5 /logs/b.log\n",
+			map[int][]RemoteFile{
+				1: {{Path: "/logs/a.log", Size: 100, ModTime: modTime}},
+				3: {{Path: "/logs/b.log", Size: 200, ModTime: modTime}},
+			},
+		},
+		{
+			"duplicates within a step",
+			"containerdiag_package_step 0\n100 1634558400.5 /logs/a.log\n100 1634558400.5 /logs/a.log\ncontainerdiag_package_step 1\n100 1634558400.5 /logs/a.log\n",
+			map[int][]RemoteFile{
+				0: {{Path: "/logs/a.log", Size: 100, ModTime: modTime}},
+				1: {{Path: "/logs/a.log", Size: 100, ModTime: modTime}},
+			},
+		},
+		{
+			"malformed lines",
+			"containerdiag_package_step 0\n\nfind: '/missing': No such file or directory\nabc 1634558400.5 /logs/bad-size.log\n100 abc /logs/bad-time.log\n100 1634558400.5\n100 1634558400.5 /logs/a.log\ncontainerdiag_package_step x\n",
+			map[int][]RemoteFile{0: {{Path: "/logs/a.log", Size: 100, ModTime: modTime}}},
+		},
+		{
+			"files before the first step",
+			"100 1634558400.5 /logs/a.log\n",
+			map[int][]RemoteFile{},
+		},
+		{
+			"empty",
+			"",
+			map[int][]RemoteFile{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ParseRemoteFileListing(test.output)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFilterRemoteFiles(t *testing.T) {
+	now := time.Unix(1634558400, 0)
+
+	files := []RemoteFile{
+		{Path: "/logs/messages.log", Size: 1024, ModTime: now.Add(-10 * time.Minute)},
+		{Path: "/logs/trace.log", Size: 2 * 1024 * 1024, ModTime: now.Add(-5 * time.Minute)},
+		{Path: "/logs/old.log", Size: 1024, ModTime: now.Add(-2 * time.Hour)},
+		{Path: "/logs/my app/server output.txt", Size: 1024, ModTime: now.Add(-1 * time.Minute)},
+		{Path: "/tmp/work.tmp", Size: 1024, ModTime: now},
+	}
+
+	tests := []struct {
+		name     string
+		filter   *diagnosticv1.PackageFilter
+		selected []string
+		skipped  map[string]string
+	}{
+		{
+			"no filter",
+			nil,
+			[]string{"/logs/messages.log", "/logs/trace.log", "/logs/old.log", "/logs/my app/server output.txt", "/tmp/work.tmp"},
+			map[string]string{},
+		},
+		{
+			"include file names",
+			&diagnosticv1.PackageFilter{Include: []string{"*.log"}},
+			[]string{"/logs/messages.log", "/logs/trace.log", "/logs/old.log"},
+			map[string]string{"/logs/my app/server output.txt": "not included", "/tmp/work.tmp": "not included"},
+		},
+		{
+			"include paths with spaces",
+			&diagnosticv1.PackageFilter{Include: []string{"/logs/my app/*"}},
+			[]string{"/logs/my app/server output.txt"},
+			map[string]string{"/logs/messages.log": "not included", "/logs/trace.log": "not included", "/logs/old.log": "not included", "/tmp/work.tmp": "not included"},
+		},
+		{
+			"exclude",
+			&diagnosticv1.PackageFilter{Exclude: []string{"*.tmp", "trace*"}},
+			[]string{"/logs/messages.log", "/logs/old.log", "/logs/my app/server output.txt"},
+			map[string]string{"/logs/trace.log": "excluded", "/tmp/work.tmp": "excluded"},
+		},
+		{
+			"exclude wins over include",
+			&diagnosticv1.PackageFilter{Include: []string{"*.log"}, Exclude: []string{"/logs/old.log"}},
+			[]string{"/logs/messages.log", "/logs/trace.log"},
+			map[string]string{"/logs/old.log": "excluded", "/logs/my app/server output.txt": "not included", "/tmp/work.tmp": "not included"},
+		},
+		{
+			"modified within minutes",
+			&diagnosticv1.PackageFilter{ModifiedWithinMinutes: 60},
+			[]string{"/logs/messages.log", "/logs/trace.log", "/logs/my app/server output.txt", "/tmp/work.tmp"},
+			map[string]string{"/logs/old.log": "not modified within 60 minutes"},
+		},
+		{
+			"maximum file size",
+			&diagnosticv1.PackageFilter{MaxFileSizeMB: 1},
+			[]string{"/logs/messages.log", "/logs/old.log", "/logs/my app/server output.txt", "/tmp/work.tmp"},
+			map[string]string{"/logs/trace.log": "larger than 1 MB"},
+		},
+		{
+			"newest files after the other filters",
+			&diagnosticv1.PackageFilter{Include: []string{"*.log"}, NewestFiles: 2},
+			[]string{"/logs/trace.log", "/logs/messages.log"},
+			map[string]string{"/logs/old.log": "not one of the newest 2 files", "/logs/my app/server output.txt": "not included", "/tmp/work.tmp": "not included"},
+		},
+		{
+			"newest files more than the files",
+			&diagnosticv1.PackageFilter{NewestFiles: 10},
+			[]string{"/logs/messages.log", "/logs/trace.log", "/logs/old.log", "/logs/my app/server output.txt", "/tmp/work.tmp"},
+			map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := append([]RemoteFile{}, files...)
+			selected, skipped := FilterRemoteFiles(input, test.filter, now)
+
+			var selectedPaths []string
+			for _, file := range selected {
+				selectedPaths = append(selectedPaths, file.Path)
+			}
+			if !reflect.DeepEqual(selectedPaths, test.selected) {
+				t.Errorf("got selected %v, want %v", selectedPaths, test.selected)
+			}
+
+			skippedReasons := map[string]string{}
+			for _, file := range skipped {
+				skippedReasons[file.Path] = file.Reason
+			}
+			if !reflect.DeepEqual(skippedReasons, test.skipped) {
+				t.Errorf("got skipped %v, want %v", skippedReasons, test.skipped)
+			}
+		})
+	}
+}
+
+func TestLimitRemoteFiles(t *testing.T) {
+	now := time.Unix(1634558400, 0)
+
+	files := []RemoteFile{
+		{Path: "/logs/old.log", Size: 300, ModTime: now.Add(-3 * time.Hour)},
+		{Path: "/logs/newest.log", Size: 500, ModTime: now},
+		{Path: "/logs/large.log", Size: 1000, ModTime: now.Add(-1 * time.Hour)},
+		{Path: "/logs/older.log", Size: 200, ModTime: now.Add(-2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name           string
+		remainingBytes int64
+		selected       []string
+		skipped        []string
+		totalBytes     int64
+	}{
+		{"no limit", -1, []string{"/logs/newest.log", "/logs/large.log", "/logs/older.log", "/logs/old.log"}, nil, 2000},
+		{"all fit", 2000, []string{"/logs/newest.log", "/logs/large.log", "/logs/older.log", "/logs/old.log"}, nil, 2000},
+		// Smaller older files are still collected after a newer file that doesn't fit
+		{"newest first", 1000, []string{"/logs/newest.log", "/logs/older.log", "/logs/old.log"}, []string{"/logs/large.log"}, 1000},
+		{"nothing remaining", 0, nil, []string{"/logs/newest.log", "/logs/large.log", "/logs/older.log", "/logs/old.log"}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := append([]RemoteFile{}, files...)
+			selected, skipped, totalBytes := LimitRemoteFiles(input, test.remainingBytes, 1)
+
+			var selectedPaths []string
+			for _, file := range selected {
+				selectedPaths = append(selectedPaths, file.Path)
+			}
+			if !reflect.DeepEqual(selectedPaths, test.selected) {
+				t.Errorf("got selected %v, want %v", selectedPaths, test.selected)
+			}
+
+			var skippedPaths []string
+			for _, file := range skipped {
+				skippedPaths = append(skippedPaths, file.Path)
+				if file.Reason != "exceeds the maximum bundle size of 1 MB" {
+					t.Errorf("got reason %q for %s", file.Reason, file.Path)
+				}
+			}
+			if !reflect.DeepEqual(skippedPaths, test.skipped) {
+				t.Errorf("got skipped %v, want %v", skippedPaths, test.skipped)
+			}
+
+			if totalBytes != test.totalBytes {
+				t.Errorf("got total %d, want %d", totalBytes, test.totalBytes)
+			}
+		})
+	}
+}
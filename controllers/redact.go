@@ -0,0 +1,378 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Replaces redacted values
+const RedactedText = "***REDACTED***"
+
+// The name of the redaction report at the root of every diagnostic bundle
+const RedactionReportFileName = "redaction.json"
+
+// Files with a NUL byte in this many leading bytes are considered binary and aren't redacted
+const BinaryDetectionBytes = 8000
+
+// Names of environment variables, properties, and attributes whose values are redacted
+const SensitiveNamePattern = `(?i:[a-z0-9_.-]*(?:password|passwd|passphrase|secret|token|api_?key|credential|private_?key)[a-z0-9_.-]*)`
+
+var SensitiveNameRegexp = regexp.MustCompile(`^` + SensitiveNamePattern + `$`)
+
+// RedactionRule replaces the first capture group of each match or, if the pattern has no
+// capture groups, the whole match
+type RedactionRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+}
+
+// The rules applied to every text file. Earlier rules run first and values that are already
+// redacted aren't counted again by later rules.
+var DefaultRedactionRules = []RedactionRule{
+	// WebSphere/Liberty encoded passwords: {xor}, {aes}, {hash}
+	{Name: "encoded-password", Pattern: regexp.MustCompile(`\{(?i:xor|aes|aes-256|hash)\}[A-Za-z0-9+/=_.:-]+`)},
+	// XML attributes such as Liberty server.xml password="..." or keystorePassword="..."
+	{Name: "password-attribute", Pattern: regexp.MustCompile(`\b` + SensitiveNamePattern + `\s*=\s*"([^"]*)"`)},
+	// HTTP Authorization headers
+	{Name: "bearer-token", Pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]+=*)`)},
+	// Environment variables and properties such as DB_PASSWORD=..., password: ..., or "apiKey": "..."
+	{Name: "sensitive-variable", Pattern: regexp.MustCompile(`\b` + SensitiveNamePattern + `"?\s*[=:]\s*"?([^\s"',;]+)`)},
+}
+
+// RedactionReport describes what was redacted in a bundle (without the redacted values)
+type RedactionReport struct {
+	Rules        []RedactionReportRule `json:"rules"`
+	Total        int                   `json:"total"`
+	Files        []RedactedFile        `json:"files"`
+	SkippedFiles []string              `json:"skippedFiles"`
+}
+
+type RedactionReportRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// RedactedFile is a file in the bundle with a path relative to the root of the bundle and the
+// number of values redacted by each rule
+type RedactedFile struct {
+	Path       string         `json:"path"`
+	Redactions map[string]int `json:"redactions"`
+}
+
+// Redactor removes secrets from the files of a bundle before the final archive is created
+type Redactor struct {
+	rules  []RedactionRule
+	report *RedactionReport
+}
+
+// NewRedactor returns a Redactor with the default rules plus a rule for each of the user's
+// regular expressions
+func NewRedactor(userPatterns []string) (*Redactor, error) {
+	rules := append([]RedactionRule{}, DefaultRedactionRules...)
+
+	for index, userPattern := range userPatterns {
+		pattern, err := regexp.Compile(userPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redactPatterns regular expression %q: %w", userPattern, err)
+		}
+		rules = append(rules, RedactionRule{Name: fmt.Sprintf("user-%d", index+1), Pattern: pattern})
+	}
+
+	report := &RedactionReport{
+		Rules:        []RedactionReportRule{},
+		Files:        []RedactedFile{},
+		SkippedFiles: []string{},
+	}
+	for _, rule := range rules {
+		report.Rules = append(report.Rules, RedactionReportRule{Name: rule.Name, Pattern: rule.Pattern.String()})
+	}
+
+	return &Redactor{rules: rules, report: report}, nil
+}
+
+// Total returns the number of values redacted so far
+func (r *Redactor) Total() int {
+	return r.report.Total
+}
+
+// RedactPod redacts a pod before it's written to the bundle at path (see RedactPodSpec)
+func (r *Redactor) RedactPod(pod *corev1.Pod, path string) {
+	r.RedactPodSpec(&pod.ObjectMeta, &pod.Spec, path)
+}
+
+// RedactPodSpec redacts the values of environment variables with sensitive names and the
+// last-applied-configuration annotation (which duplicates them) of a pod or workload before
+// it's written to the bundle at path
+func (r *Redactor) RedactPodSpec(objectMeta *metav1.ObjectMeta, podSpec *corev1.PodSpec, path string) {
+	counts := map[string]int{}
+
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for containerIndex := range containers {
+			for envIndex := range containers[containerIndex].Env {
+				env := &containers[containerIndex].Env[envIndex]
+				if len(env.Value) > 0 && SensitiveNameRegexp.MatchString(env.Name) {
+					env.Value = RedactedText
+					counts["pod-environment"]++
+				}
+			}
+		}
+	}
+
+	if _, ok := objectMeta.Annotations[corev1.LastAppliedConfigAnnotation]; ok {
+		objectMeta.Annotations[corev1.LastAppliedConfigAnnotation] = RedactedText
+		counts["last-applied-configuration"]++
+	}
+
+	r.record(path, counts)
+}
+
+// RedactManifest redacts the bundle manifest before it's written. The spec has the values of
+// step environment variables and template parameters, and the steps have the command lines
+// run in the containers, so these get the same treatment as pods and text files. The errors of
+// the logger are redacted too because they're added to the manifest when it's written.
+func (r *Redactor) RedactManifest(manifest *BundleManifest, logger *CustomLogger) {
+	counts := map[string]int{}
+
+	spec := &manifest.Spec
+	spec.Arguments = r.RedactLines(spec.Arguments, counts)
+	for stepIndex := range spec.Steps {
+		step := &spec.Steps[stepIndex]
+		step.Arguments = r.RedactLines(step.Arguments, counts)
+		step.ExecCommand = r.RedactLines(step.ExecCommand, counts)
+		step.Args = r.RedactLines(step.Args, counts)
+		for envIndex := range step.Env {
+			env := &step.Env[envIndex]
+			if len(env.Value) > 0 && SensitiveNameRegexp.MatchString(env.Name) {
+				env.Value = RedactedText
+				counts["step-environment"]++
+			} else {
+				env.Value = r.RedactLine(env.Value, counts)
+			}
+		}
+	}
+
+	for name, value := range spec.Parameters {
+		if len(value) > 0 && SensitiveNameRegexp.MatchString(name) {
+			spec.Parameters[name] = RedactedText
+			counts["template-parameter"]++
+		} else {
+			spec.Parameters[name] = r.RedactLine(value, counts)
+		}
+	}
+
+	for _, target := range manifest.Targets {
+		for _, step := range target.Steps {
+			step.Arguments = r.RedactLines(step.Arguments, counts)
+			step.Execution = r.RedactLines(step.Execution, counts)
+			step.Error = r.RedactLine(step.Error, counts)
+		}
+		target.Errors = r.RedactLines(target.Errors, counts)
+	}
+	manifest.Errors = r.RedactLines(manifest.Errors, counts)
+	logger.errors = r.RedactLines(logger.errors, counts)
+
+	r.record(ManifestFileName, counts)
+}
+
+// RedactLines returns a copy of lines with RedactLine applied to each
+func (r *Redactor) RedactLines(lines []string, counts map[string]int) []string {
+	if lines == nil {
+		return nil
+	}
+	redacted := make([]string, len(lines))
+	for index, line := range lines {
+		redacted[index] = r.RedactLine(line, counts)
+	}
+	return redacted
+}
+
+// RedactDirectory redacts every text file under directory in place
+func (r *Redactor) RedactDirectory(directory string, logger *CustomLogger) error {
+	return WalkDirectoryForArchive(directory,
+		func(path string, name string, info os.FileInfo) error {
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			binary, err := IsBinaryFile(path)
+			if err != nil {
+				return err
+			}
+
+			if binary {
+				r.report.SkippedFiles = append(r.report.SkippedFiles, name)
+				return nil
+			}
+
+			counts, err := r.RedactFile(path, info.Mode())
+			if err != nil {
+				return err
+			}
+
+			if len(counts) > 0 {
+				logger.Info(fmt.Sprintf("Redacted %+v in %s", counts, name))
+			}
+
+			r.record(name, counts)
+			return nil
+		})
+}
+
+// RedactFile rewrites a text file with the rules applied to each line
+func (r *Redactor) RedactFile(path string, mode os.FileMode) (map[string]int, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer input.Close()
+
+	tempPath := path + ".redacting"
+	output, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	reader := bufio.NewReader(input)
+	writer := bufio.NewWriter(output)
+
+	for {
+		line, readErr := reader.ReadString('\n')
+		if len(line) > 0 {
+			_, err = writer.WriteString(r.RedactLine(line, counts))
+			if err != nil {
+				break
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			err = readErr
+			break
+		}
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil || len(counts) == 0 {
+		os.Remove(tempPath)
+		return counts, err
+	}
+
+	return counts, os.Rename(tempPath, path)
+}
+
+// RedactLine applies every rule to a line and adds the number of redactions by rule name to counts
+func (r *Redactor) RedactLine(line string, counts map[string]int) string {
+	for _, rule := range r.rules {
+		matches := rule.Pattern.FindAllStringSubmatchIndex(line, -1)
+		if matches == nil {
+			continue
+		}
+
+		var result bytes.Buffer
+		last := 0
+		for _, match := range matches {
+			start, end := match[0], match[1]
+			if len(match) >= 4 && match[2] >= 0 {
+				start, end = match[2], match[3]
+			}
+
+			if start < last || line[start:end] == RedactedText || start == end {
+				continue
+			}
+
+			result.WriteString(line[last:start])
+			result.WriteString(RedactedText)
+			last = end
+			counts[rule.Name]++
+		}
+		result.WriteString(line[last:])
+		line = result.String()
+	}
+	return line
+}
+
+func (r *Redactor) record(name string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	for _, count := range counts {
+		r.report.Total += count
+	}
+	for _, file := range r.report.Files {
+		if file.Path == name {
+			for rule, count := range counts {
+				file.Redactions[rule] += count
+			}
+			return
+		}
+	}
+	r.report.Files = append(r.report.Files, RedactedFile{Path: name, Redactions: counts})
+}
+
+// WriteReport writes the redaction report to the root of the bundle directory
+func (r *Redactor) WriteReport(bundleDirectory string) error {
+	sort.SliceStable(r.report.Files, func(i, j int) bool {
+		return r.report.Files[i].Path < r.report.Files[j].Path
+	})
+
+	jsonBytes, err := json.MarshalIndent(r.report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(bundleDirectory, RedactionReportFileName), jsonBytes, os.ModePerm)
+}
+
+// IsBinaryFile returns true if the file has a NUL byte near the beginning
+func IsBinaryFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	buffer := make([]byte, BinaryDetectionBytes)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+
+	return bytes.IndexByte(buffer[:n], 0) != -1, nil
+}
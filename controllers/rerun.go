@@ -0,0 +1,126 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// Setting this annotation (to any value) on a finished ContainerDiagnostic runs it again. The
+// annotation is removed when the new run starts.
+const RerunAnnotation = "diagnostic.ibm.com/rerun"
+
+// The default of Spec.RunsHistoryLimit
+const DefaultRunsHistoryLimit = 5
+
+// IsRerunRequested returns whether a finished ContainerDiagnostic should run again and why
+func IsRerunRequested(containerDiagnostic *diagnosticv1.ContainerDiagnostic) (bool, string) {
+	if _, ok := containerDiagnostic.Annotations[RerunAnnotation]; ok {
+		return true, fmt.Sprintf("annotation %s", RerunAnnotation)
+	}
+	if containerDiagnostic.Spec.RunGeneration > containerDiagnostic.Status.RunGeneration {
+		return true, fmt.Sprintf("runGeneration %d", containerDiagnostic.Spec.RunGeneration)
+	}
+	return false, ""
+}
+
+// StartRerun moves the finished run to Status.Runs and resets the status so that the next
+// reconcile processes the ContainerDiagnostic again
+func (r *ContainerDiagnosticReconciler) StartRerun(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic, reason string, logger *CustomLogger) (ctrl.Result, error) {
+	if _, ok := containerDiagnostic.Annotations[RerunAnnotation]; ok {
+		delete(containerDiagnostic.Annotations, RerunAnnotation)
+		err := r.Update(ctx, containerDiagnostic)
+		if err != nil {
+			logger.Info(fmt.Sprintf("Failed to remove annotation %s: %+v", RerunAnnotation, err))
+			return ctrl.Result{}, err
+		}
+	}
+
+	removed := ArchiveRun(&containerDiagnostic.Status, GetHistoryLimit(containerDiagnostic.Spec.RunsHistoryLimit, DefaultRunsHistoryLimit))
+
+	containerDiagnostic.Status = diagnosticv1.ContainerDiagnosticStatus{
+		RunGeneration: containerDiagnostic.Spec.RunGeneration,
+		Runs:          containerDiagnostic.Status.Runs,
+	}
+
+	r.RecordEventInfo(fmt.Sprintf("Running again because of %s @ %s", reason, CurrentTimeAsString()), containerDiagnostic, logger)
+
+	err := r.Status().Update(ctx, containerDiagnostic)
+	if err != nil {
+		logger.Error(err, fmt.Sprintf("Failed to update ContainerDiagnostic status: %v", err))
+		return ctrl.Result{}, err
+	}
+
+	// The downloads are only deleted once they're no longer referenced by the status
+	for _, run := range removed {
+		RemoveRunDownload(run, logger)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// ArchiveRun adds the current run of a status to the front of Runs and returns the runs
+// removed beyond the limit
+func ArchiveRun(status *diagnosticv1.ContainerDiagnosticStatus, limit int) []diagnosticv1.ContainerDiagnosticRunStatus {
+	run := diagnosticv1.ContainerDiagnosticRunStatus{
+		RunGeneration:   status.RunGeneration,
+		StatusCode:      status.StatusCode,
+		StatusMessage:   status.StatusMessage,
+		Result:          status.Result,
+		Download:        status.Download,
+		DownloadPath:    status.DownloadPath,
+		DownloadMissing: status.DownloadMissing,
+		UploadURL:       status.UploadURL,
+		StartTime:       status.StartTime,
+		CompletionTime:  status.CompletionTime,
+	}
+
+	runs := append([]diagnosticv1.ContainerDiagnosticRunStatus{run}, status.Runs...)
+
+	if limit < 0 {
+		limit = 0
+	}
+
+	var removed []diagnosticv1.ContainerDiagnosticRunStatus
+	if len(runs) > limit {
+		removed = runs[limit:]
+		runs = runs[:limit]
+	}
+
+	status.Runs = runs
+	return removed
+}
+
+// RemoveRunDownload deletes the download of a previous run if it still exists
+func RemoveRunDownload(run diagnosticv1.ContainerDiagnosticRunStatus, logger *CustomLogger) {
+	if len(run.DownloadPath) == 0 || run.DownloadMissing {
+		return
+	}
+
+	err := os.Remove(run.DownloadPath)
+	if err == nil {
+		logger.Info(fmt.Sprintf("Successfully deleted %s", run.DownloadPath))
+	} else if !os.IsNotExist(err) {
+		logger.Info(fmt.Sprintf("Failed to delete %s: %v", run.DownloadPath, err))
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+func TestIsRerunRequested(t *testing.T) {
+	tests := []struct {
+		name                string
+		annotations         map[string]string
+		specRunGeneration   int64
+		statusRunGeneration int64
+		want                bool
+		wantReason          string
+	}{
+		{"not requested", nil, 0, 0, false, ""},
+		{"other annotation", map[string]string{"note": "x"}, 1, 1, false, ""},
+		{"annotation", map[string]string{RerunAnnotation: ""}, 0, 0, true, "annotation " + RerunAnnotation},
+		{"annotation and runGeneration", map[string]string{RerunAnnotation: "true"}, 2, 1, true, "annotation " + RerunAnnotation},
+		{"runGeneration", nil, 2, 1, true, "runGeneration 2"},
+		{"runGeneration of the current run", nil, 2, 2, false, ""},
+		{"runGeneration lower than the current run", nil, 1, 2, false, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			containerDiagnostic := &diagnosticv1.ContainerDiagnostic{
+				ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations},
+				Spec:       diagnosticv1.ContainerDiagnosticSpec{RunGeneration: test.specRunGeneration},
+				Status:     diagnosticv1.ContainerDiagnosticStatus{RunGeneration: test.statusRunGeneration},
+			}
+			got, reason := IsRerunRequested(containerDiagnostic)
+			if got != test.want || reason != test.wantReason {
+				t.Errorf("got %t, %q, want %t, %q", got, reason, test.want, test.wantReason)
+			}
+		})
+	}
+}
+
+func TestArchiveRun(t *testing.T) {
+	newRun := func(runGeneration int64) diagnosticv1.ContainerDiagnosticRunStatus {
+		return diagnosticv1.ContainerDiagnosticRunStatus{RunGeneration: runGeneration, StatusCode: int(StatusSuccess)}
+	}
+
+	tests := []struct {
+		name        string
+		runs        []diagnosticv1.ContainerDiagnosticRunStatus
+		limit       int
+		wantRuns    []int64
+		wantRemoved []int64
+	}{
+		{"first run", nil, 5, []int64{3}, nil},
+		{"under the limit", []diagnosticv1.ContainerDiagnosticRunStatus{newRun(2), newRun(1)}, 5, []int64{3, 2, 1}, nil},
+		{"at the limit", []diagnosticv1.ContainerDiagnosticRunStatus{newRun(2), newRun(1)}, 3, []int64{3, 2, 1}, nil},
+		{"over the limit", []diagnosticv1.ContainerDiagnosticRunStatus{newRun(2), newRun(1)}, 2, []int64{3, 2}, []int64{1}},
+		{"lowered limit", []diagnosticv1.ContainerDiagnosticRunStatus{newRun(2), newRun(1), newRun(0)}, 1, []int64{3}, []int64{2, 1, 0}},
+		{"no history", []diagnosticv1.ContainerDiagnosticRunStatus{newRun(2)}, 0, nil, []int64{3, 2}},
+		{"negative limit", nil, -1, nil, []int64{3}},
+	}
+
+	runGenerations := func(runs []diagnosticv1.ContainerDiagnosticRunStatus) []int64 {
+		var result []int64
+		for _, run := range runs {
+			result = append(result, run.RunGeneration)
+		}
+		return result
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := &diagnosticv1.ContainerDiagnosticStatus{
+				RunGeneration:  3,
+				StatusCode:     int(StatusError),
+				StatusMessage:  StatusError.ToString(),
+				Result:         "Error: no pods",
+				Download:       "kubectl cp ...",
+				DownloadPath:   "/tmp/containerdiag_3.zip",
+				UploadURL:      "http://minio/containerdiag_3.zip",
+				StartTime:      &metav1.Time{},
+				CompletionTime: &metav1.Time{},
+				Runs:           test.runs,
+			}
+
+			removed := ArchiveRun(status, test.limit)
+
+			if got := runGenerations(status.Runs); !reflect.DeepEqual(got, test.wantRuns) {
+				t.Errorf("got runs %v, want %v", got, test.wantRuns)
+			}
+			if got := runGenerations(removed); !reflect.DeepEqual(got, test.wantRemoved) {
+				t.Errorf("got removed %v, want %v", got, test.wantRemoved)
+			}
+		})
+	}
+
+	// The current run is copied to the front of the runs
+	status := &diagnosticv1.ContainerDiagnosticStatus{
+		RunGeneration:   3,
+		StatusCode:      int(StatusMixed),
+		StatusMessage:   StatusMixed.ToString(),
+		Result:          "Mixed results",
+		Download:        "kubectl cp ...",
+		DownloadPath:    "/tmp/containerdiag_3.zip",
+		DownloadMissing: true,
+		UploadURL:       "http://minio/containerdiag_3.zip",
+		StartTime:       &metav1.Time{},
+		CompletionTime:  &metav1.Time{},
+	}
+	ArchiveRun(status, 1)
+	want := diagnosticv1.ContainerDiagnosticRunStatus{
+		RunGeneration:   3,
+		StatusCode:      int(StatusMixed),
+		StatusMessage:   StatusMixed.ToString(),
+		Result:          "Mixed results",
+		Download:        "kubectl cp ...",
+		DownloadPath:    "/tmp/containerdiag_3.zip",
+		DownloadMissing: true,
+		UploadURL:       "http://minio/containerdiag_3.zip",
+		StartTime:       &metav1.Time{},
+		CompletionTime:  &metav1.Time{},
+	}
+	if !reflect.DeepEqual(status.Runs[0], want) {
+		t.Errorf("got %+v, want %+v", status.Runs[0], want)
+	}
+}
+
+func TestStartRerun(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := diagnosticv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	directory := t.TempDir()
+	downloads := map[int64]string{}
+	for _, runGeneration := range []int64{1, 2} {
+		downloads[runGeneration] = filepath.Join(directory, fmt.Sprintf("containerdiag_%d.zip", runGeneration))
+		if err := ioutil.WriteFile(downloads[runGeneration], []byte("zip"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	historyLimit := int32(1)
+	containerDiagnostic := &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "diag", Annotations: map[string]string{RerunAnnotation: ""}},
+		Spec:       diagnosticv1.ContainerDiagnosticSpec{Command: "version", RunsHistoryLimit: &historyLimit, RunGeneration: 2},
+		Status: diagnosticv1.ContainerDiagnosticStatus{
+			StatusCode:    int(StatusSuccess),
+			RunGeneration: 2,
+			DownloadPath:  downloads[2],
+			Runs:          []diagnosticv1.ContainerDiagnosticRunStatus{{RunGeneration: 1, StatusCode: int(StatusSuccess), DownloadPath: downloads[1]}},
+			Template:      &diagnosticv1.ContainerDiagnosticTemplateSpec{Description: "old"},
+		},
+	}
+
+	r := &ContainerDiagnosticReconciler{
+		Client:        fake.NewClientBuilder().WithScheme(scheme).WithObjects(containerDiagnostic).Build(),
+		EventRecorder: record.NewFakeRecorder(100),
+	}
+	logger := &CustomLogger{logger: ctrl.Log.WithName("rerun")}
+
+	ctx := context.Background()
+	rerun, reason := IsRerunRequested(containerDiagnostic)
+	if !rerun {
+		t.Fatal("rerun not requested")
+	}
+	if _, err := r.StartRerun(ctx, containerDiagnostic, reason, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &diagnosticv1.ContainerDiagnostic{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "app", Name: "diag"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Annotations[RerunAnnotation]; ok {
+		t.Errorf("%s wasn't removed", RerunAnnotation)
+	}
+	want := diagnosticv1.ContainerDiagnosticStatus{
+		RunGeneration: 2,
+		Runs:          []diagnosticv1.ContainerDiagnosticRunStatus{{RunGeneration: 2, StatusCode: int(StatusSuccess), DownloadPath: downloads[2]}},
+	}
+	if !reflect.DeepEqual(got.Status, want) {
+		t.Errorf("got status %+v, want %+v", got.Status, want)
+	}
+
+	// Only the download of the run beyond the history limit is deleted
+	if _, err := os.Stat(downloads[1]); !os.IsNotExist(err) {
+		t.Errorf("the download of the removed run wasn't deleted: %v", err)
+	}
+	if _, err := os.Stat(downloads[2]); err != nil {
+		t.Errorf("the download of the kept run was deleted: %v", err)
+	}
+
+	// The rerun is only started once
+	if rerun, _ := IsRerunRequested(got); rerun {
+		t.Error("rerun still requested")
+	}
+}
@@ -0,0 +1,416 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// Files and directories in the output directory that aren't referenced by any ContainerDiagnostic
+// are only deleted once nothing in them has been modified for this long (and not since the oldest
+// run in progress started). This avoids racing with a run whose files aren't referenced yet.
+const OrphanGracePeriod = 10 * time.Minute
+
+// The names of the final downloads and the per-run directories created by CommandScript.
+// Anything else in the output directory (e.g. lost+found on a volume) is left alone.
+var OutputFileNamePattern = regexp.MustCompile(`^containerdiag_.*$`)
+var OutputDirectoryNamePattern = regexp.MustCompile(`^tmp[0-9]+$`)
+
+// RetentionSweeper periodically deletes finished ContainerDiagnostics and downloads based on
+// Spec.TTLSecondsAfterFinished and the limits of the manager, and removes orphaned files from
+// the output directory.
+type RetentionSweeper struct {
+	Client          client.Client
+	EventRecorder   record.EventRecorder
+	OutputDirectory string
+	Interval        time.Duration
+	Logger          logr.Logger
+
+	// If greater than 0, the oldest downloads are deleted when the output directory is larger than this
+	MaxOutputBytes int64
+
+	// If greater than 0, the oldest finished ContainerDiagnostics in a namespace beyond this number are deleted
+	MaxRunsPerNamespace int
+}
+
+// Start implements manager.Runnable
+func (s *RetentionSweeper) Start(ctx context.Context) error {
+	s.Logger.Info(fmt.Sprintf("retention sweeper is starting with interval %s", s.Interval))
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := s.Sweep(ctx)
+			if err != nil {
+				s.Logger.Error(err, "retention sweep failed")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Only the leader deletes anything.
+func (s *RetentionSweeper) NeedLeaderElection() bool {
+	return true
+}
+
+// Sweep applies the retention policy once
+func (s *RetentionSweeper) Sweep(ctx context.Context) error {
+	list := &diagnosticv1.ContainerDiagnosticList{}
+	err := s.Client.List(ctx, list)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deleted := map[string]bool{}
+	finishedByNamespace := map[string][]*diagnosticv1.ContainerDiagnostic{}
+	orphanCutoff := now.Add(-OrphanGracePeriod)
+
+	for i := range list.Items {
+		containerDiagnostic := &list.Items[i]
+
+		if containerDiagnostic.GetDeletionTimestamp() != nil {
+			continue
+		}
+
+		if !IsFinishedStatus(containerDiagnostic) {
+			if startTime := GetStartTime(containerDiagnostic); startTime.Before(orphanCutoff) {
+				orphanCutoff = startTime
+			}
+			continue
+		}
+
+		finishedByNamespace[containerDiagnostic.Namespace] = append(finishedByNamespace[containerDiagnostic.Namespace], containerDiagnostic)
+
+		ttl := containerDiagnostic.Spec.TTLSecondsAfterFinished
+		if ttl != nil && GetCompletionTime(containerDiagnostic).Add(time.Duration(*ttl)*time.Second).Before(now) {
+			if s.Delete(ctx, containerDiagnostic, fmt.Sprintf("finished more than %d seconds ago", *ttl)) {
+				deleted[string(containerDiagnostic.UID)] = true
+			}
+		}
+	}
+
+	if s.MaxRunsPerNamespace > 0 {
+		for _, finished := range finishedByNamespace {
+			SortByCompletionTime(finished)
+
+			// Newest first
+			kept := 0
+			for i := len(finished) - 1; i >= 0; i-- {
+				containerDiagnostic := finished[i]
+				if deleted[string(containerDiagnostic.UID)] {
+					continue
+				}
+				kept++
+				if kept > s.MaxRunsPerNamespace {
+					if s.Delete(ctx, containerDiagnostic, fmt.Sprintf("more than %d finished runs in namespace %s", s.MaxRunsPerNamespace, containerDiagnostic.Namespace)) {
+						deleted[string(containerDiagnostic.UID)] = true
+					}
+				}
+			}
+		}
+	}
+
+	// Files of deleted ContainerDiagnostics are removed by the finalizer so they're still referenced
+	referenced := map[string]bool{}
+	for _, item := range list.Items {
+		if len(item.Status.DownloadPath) > 0 {
+			referenced[filepath.Base(item.Status.DownloadPath)] = true
+		}
+		for _, run := range item.Status.Runs {
+			if len(run.DownloadPath) > 0 {
+				referenced[filepath.Base(run.DownloadPath)] = true
+			}
+		}
+	}
+
+	s.RemoveOrphans(referenced, orphanCutoff)
+
+	if s.MaxOutputBytes > 0 {
+		s.EnforceQuota(ctx, list, deleted)
+	}
+
+	return nil
+}
+
+// Delete deletes a finished ContainerDiagnostic. The finalizer deletes its download.
+func (s *RetentionSweeper) Delete(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic, reason string) bool {
+	s.Logger.Info(fmt.Sprintf("retention sweeper deleting ContainerDiagnostic %s/%s: %s", containerDiagnostic.Namespace, containerDiagnostic.Name, reason))
+
+	err := s.Client.Delete(ctx, containerDiagnostic)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		s.Logger.Error(err, fmt.Sprintf("retention sweeper could not delete ContainerDiagnostic %s/%s", containerDiagnostic.Namespace, containerDiagnostic.Name))
+		return false
+	}
+	return true
+}
+
+// RemoveOrphans deletes downloads and run directories in the output directory that no ContainerDiagnostic
+// references and that haven't been modified since the cutoff
+func (s *RetentionSweeper) RemoveOrphans(referenced map[string]bool, cutoff time.Time) {
+	entries, err := ioutil.ReadDir(s.OutputDirectory)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.Logger.Error(err, fmt.Sprintf("retention sweeper could not list %s", s.OutputDirectory))
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		if referenced[entry.Name()] {
+			continue
+		}
+
+		if entry.IsDir() {
+			if !OutputDirectoryNamePattern.MatchString(entry.Name()) {
+				continue
+			}
+		} else if !entry.Mode().IsRegular() || !OutputFileNamePattern.MatchString(entry.Name()) {
+			continue
+		}
+
+		path := filepath.Join(s.OutputDirectory, entry.Name())
+
+		// A run only writes to files within its directory
+		modTime := entry.ModTime()
+		if entry.IsDir() {
+			modTime, err = GetLatestModTime(path)
+			if err != nil {
+				s.Logger.Error(err, fmt.Sprintf("retention sweeper could not list %s", path))
+				continue
+			}
+		}
+		if !modTime.Before(cutoff) {
+			continue
+		}
+
+		s.Logger.Info(fmt.Sprintf("retention sweeper deleting orphaned %s", path))
+
+		err = os.RemoveAll(path)
+		if err != nil {
+			s.Logger.Error(err, fmt.Sprintf("retention sweeper could not delete %s", path))
+		}
+	}
+}
+
+// QuotaCandidate is a download that EnforceQuota may delete: the download of the current run of a
+// ContainerDiagnostic (Run is -1) or of one of its previous runs in Status.Runs
+type QuotaCandidate struct {
+	ContainerDiagnostic *diagnosticv1.ContainerDiagnostic
+	Run                 int
+	DownloadPath        string
+	CompletionTime      time.Time
+}
+
+// GetQuotaCandidates returns the downloads of a ContainerDiagnostic (see QuotaCandidate)
+func GetQuotaCandidates(containerDiagnostic *diagnosticv1.ContainerDiagnostic) []QuotaCandidate {
+	var candidates []QuotaCandidate
+
+	if len(containerDiagnostic.Status.DownloadPath) > 0 && !containerDiagnostic.Status.DownloadMissing {
+		candidates = append(candidates, QuotaCandidate{
+			ContainerDiagnostic: containerDiagnostic,
+			Run:                 -1,
+			DownloadPath:        containerDiagnostic.Status.DownloadPath,
+			CompletionTime:      GetCompletionTime(containerDiagnostic),
+		})
+	}
+
+	for index, run := range containerDiagnostic.Status.Runs {
+		if len(run.DownloadPath) == 0 || run.DownloadMissing {
+			continue
+		}
+
+		completionTime := containerDiagnostic.CreationTimestamp.Time
+		if run.CompletionTime != nil {
+			completionTime = run.CompletionTime.Time
+		} else if run.StartTime != nil {
+			completionTime = run.StartTime.Time
+		}
+
+		candidates = append(candidates, QuotaCandidate{
+			ContainerDiagnostic: containerDiagnostic,
+			Run:                 index,
+			DownloadPath:        run.DownloadPath,
+			CompletionTime:      completionTime,
+		})
+	}
+
+	return candidates
+}
+
+// EnforceQuota deletes the oldest downloads (including those of previous runs) until the output
+// directory is no larger than MaxOutputBytes. The ContainerDiagnostics are kept and their downloads
+// are marked with DownloadMissing. The downloads of runs in progress aren't deleted so that the
+// status isn't updated at the same time as the reconciler.
+func (s *RetentionSweeper) EnforceQuota(ctx context.Context, list *diagnosticv1.ContainerDiagnosticList, deleted map[string]bool) {
+	totalBytes, err := GetDirectorySize(s.OutputDirectory)
+	if err != nil {
+		s.Logger.Error(err, fmt.Sprintf("retention sweeper could not get the size of %s", s.OutputDirectory))
+		return
+	}
+
+	candidates := []QuotaCandidate{}
+	for i := range list.Items {
+		containerDiagnostic := &list.Items[i]
+
+		// These downloads are about to be removed by the finalizer
+		if deleted[string(containerDiagnostic.UID)] || containerDiagnostic.GetDeletionTimestamp() != nil {
+			for _, candidate := range GetQuotaCandidates(containerDiagnostic) {
+				if fileInfo, err := os.Stat(candidate.DownloadPath); err == nil {
+					totalBytes -= fileInfo.Size()
+				}
+			}
+			continue
+		}
+
+		if IsFinishedStatus(containerDiagnostic) {
+			candidates = append(candidates, GetQuotaCandidates(containerDiagnostic)...)
+		}
+	}
+
+	// Oldest first
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].CompletionTime.Before(candidates[j].CompletionTime)
+	})
+
+	updated := []*diagnosticv1.ContainerDiagnostic{}
+	for _, candidate := range candidates {
+		if totalBytes <= s.MaxOutputBytes {
+			break
+		}
+
+		fileInfo, err := os.Stat(candidate.DownloadPath)
+		if err != nil {
+			continue
+		}
+
+		err = os.Remove(candidate.DownloadPath)
+		if err != nil {
+			s.Logger.Error(err, fmt.Sprintf("retention sweeper could not delete %s", candidate.DownloadPath))
+			continue
+		}
+
+		totalBytes -= fileInfo.Size()
+
+		containerDiagnostic := candidate.ContainerDiagnostic
+		download := fmt.Sprintf("Download file deleted because the output directory exceeded %d MB: %s", s.MaxOutputBytes/(1024*1024), candidate.DownloadPath)
+		if candidate.Run < 0 {
+			containerDiagnostic.Status.DownloadMissing = true
+			containerDiagnostic.Status.Download = download
+		} else {
+			containerDiagnostic.Status.Runs[candidate.Run].DownloadMissing = true
+			containerDiagnostic.Status.Runs[candidate.Run].Download = download
+			download = fmt.Sprintf("%s (run generation %d)", download, containerDiagnostic.Status.Runs[candidate.Run].RunGeneration)
+		}
+
+		s.Logger.Info(fmt.Sprintf("retention sweeper for %s/%s: %s", containerDiagnostic.Namespace, containerDiagnostic.Name, download))
+		s.EventRecorder.Event(containerDiagnostic, corev1.EventTypeNormal, "Informational", fmt.Sprintf("%s @ %s", download, CurrentTimeAsString()))
+
+		if !ContainsContainerDiagnostic(updated, containerDiagnostic) {
+			updated = append(updated, containerDiagnostic)
+		}
+	}
+
+	for _, containerDiagnostic := range updated {
+		err = s.Client.Status().Update(ctx, containerDiagnostic)
+		if err != nil {
+			s.Logger.Error(err, fmt.Sprintf("retention sweeper could not update status of ContainerDiagnostic %s/%s", containerDiagnostic.Namespace, containerDiagnostic.Name))
+		}
+	}
+}
+
+// ContainsContainerDiagnostic returns true if the slice has the pointer
+func ContainsContainerDiagnostic(containerDiagnostics []*diagnosticv1.ContainerDiagnostic, containerDiagnostic *diagnosticv1.ContainerDiagnostic) bool {
+	for _, item := range containerDiagnostics {
+		if item == containerDiagnostic {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCompletionTime returns when a ContainerDiagnostic finished. ContainerDiagnostics
+// that finished before Status.CompletionTime was added use their creation time.
+func GetCompletionTime(containerDiagnostic *diagnosticv1.ContainerDiagnostic) time.Time {
+	if containerDiagnostic.Status.CompletionTime != nil {
+		return containerDiagnostic.Status.CompletionTime.Time
+	}
+	return containerDiagnostic.CreationTimestamp.Time
+}
+
+// SortByCompletionTime sorts oldest first
+func SortByCompletionTime(containerDiagnostics []*diagnosticv1.ContainerDiagnostic) {
+	sort.SliceStable(containerDiagnostics, func(i, j int) bool {
+		return GetCompletionTime(containerDiagnostics[i]).Before(GetCompletionTime(containerDiagnostics[j]))
+	})
+}
+
+// GetLatestModTime returns the latest modification time of a directory and anything under it
+func GetLatestModTime(directory string) (time.Time, error) {
+	var latest time.Time
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest, err
+}
+
+// GetDirectorySize returns the total size of the regular files under a directory
+func GetDirectorySize(directory string) (int64, error) {
+	var total int64
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// newRetentionTest creates a sweeper of a temporary output directory with a fake client containing
+// the ContainerDiagnostics
+func newRetentionTest(t *testing.T, containerDiagnostics ...*diagnosticv1.ContainerDiagnostic) (*RetentionSweeper, client.Client) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := diagnosticv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	var objects []client.Object
+	for _, containerDiagnostic := range containerDiagnostics {
+		objects = append(objects, containerDiagnostic)
+	}
+
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+	return &RetentionSweeper{
+		Client:          fakeClient,
+		EventRecorder:   record.NewFakeRecorder(100),
+		OutputDirectory: t.TempDir(),
+		Logger:          ctrl.Log.WithName("retention"),
+	}, fakeClient
+}
+
+// writeOutputFile writes a file of a size in the output directory and sets its modification time
+func writeOutputFile(t *testing.T, sweeper *RetentionSweeper, name string, size int, modTime time.Time) string {
+	path := filepath.Join(sweeper.OutputDirectory, name)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, make([]byte, size), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newFinishedContainerDiagnostic(name string, completionTime time.Time) *diagnosticv1.ContainerDiagnostic {
+	completion := metav1.NewTime(completionTime)
+	return &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID(name)},
+		Status: diagnosticv1.ContainerDiagnosticStatus{
+			StatusCode:     int(StatusSuccess),
+			CompletionTime: &completion,
+		},
+	}
+}
+
+func TestEnforceQuota(t *testing.T) {
+	now := time.Now()
+
+	// diag1 has the newest current download and the oldest previous download
+	diag1 := newFinishedContainerDiagnostic("diag1", now.Add(-1*time.Hour))
+	diag2 := newFinishedContainerDiagnostic("diag2", now.Add(-3*time.Hour))
+	processing := &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "processing", UID: "processing"},
+		Status:     diagnosticv1.ContainerDiagnosticStatus{StatusCode: int(StatusProcessing)},
+	}
+
+	sweeper, fakeClient := newRetentionTest(t, diag1, diag2, processing)
+	sweeper.MaxOutputBytes = 2500
+
+	oldCompletion := metav1.NewTime(now.Add(-5 * time.Hour))
+	processingCompletion := metav1.NewTime(now.Add(-6 * time.Hour))
+
+	diag1.Status.DownloadPath = writeOutputFile(t, sweeper, "containerdiag_diag1.zip", 1000, now)
+	diag1.Status.Runs = []diagnosticv1.ContainerDiagnosticRunStatus{
+		{RunGeneration: 1, DownloadPath: writeOutputFile(t, sweeper, "containerdiag_diag1_run1.zip", 1000, now), CompletionTime: &oldCompletion},
+	}
+	diag2.Status.DownloadPath = writeOutputFile(t, sweeper, "containerdiag_diag2.zip", 1000, now)
+	processing.Status.Runs = []diagnosticv1.ContainerDiagnosticRunStatus{
+		{RunGeneration: 1, DownloadPath: writeOutputFile(t, sweeper, "containerdiag_processing_run1.zip", 1000, now), CompletionTime: &processingCompletion},
+	}
+
+	ctx := context.Background()
+	for _, containerDiagnostic := range []*diagnosticv1.ContainerDiagnostic{diag1, diag2, processing} {
+		if err := fakeClient.Status().Update(ctx, containerDiagnostic); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := &diagnosticv1.ContainerDiagnosticList{}
+	if err := fakeClient.List(ctx, list); err != nil {
+		t.Fatal(err)
+	}
+
+	// 4000 bytes must go under 2500 so the two oldest downloads of finished runs are deleted
+	sweeper.EnforceQuota(ctx, list, map[string]bool{})
+
+	for _, test := range []struct {
+		path   string
+		exists bool
+	}{
+		{diag1.Status.Runs[0].DownloadPath, false},
+		{diag2.Status.DownloadPath, false},
+		{diag1.Status.DownloadPath, true},
+		{processing.Status.Runs[0].DownloadPath, true},
+	} {
+		_, err := os.Stat(test.path)
+		if exists := err == nil; exists != test.exists {
+			t.Errorf("%s exists: %v, want %v", test.path, exists, test.exists)
+		}
+	}
+
+	updated := &diagnosticv1.ContainerDiagnostic{}
+	if err := fakeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "diag1"}, updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status.DownloadMissing || !updated.Status.Runs[0].DownloadMissing {
+		t.Errorf("unexpected status of diag1: downloadMissing %v, runs[0].downloadMissing %v", updated.Status.DownloadMissing, updated.Status.Runs[0].DownloadMissing)
+	}
+
+	if err := fakeClient.Get(ctx, types.NamespacedName{Namespace: "default", Name: "diag2"}, updated); err != nil {
+		t.Fatal(err)
+	}
+	if !updated.Status.DownloadMissing {
+		t.Error("the download of diag2 isn't marked missing")
+	}
+}
+
+func TestGetQuotaCandidates(t *testing.T) {
+	now := time.Now()
+	startTime := metav1.NewTime(now.Add(-2 * time.Hour))
+
+	containerDiagnostic := newFinishedContainerDiagnostic("diag1", now)
+	containerDiagnostic.CreationTimestamp = metav1.NewTime(now.Add(-3 * time.Hour))
+	containerDiagnostic.Status.DownloadPath = "/output/containerdiag_1.zip"
+	containerDiagnostic.Status.Runs = []diagnosticv1.ContainerDiagnosticRunStatus{
+		{DownloadPath: "/output/containerdiag_2.zip", StartTime: &startTime},
+		{DownloadPath: "/output/containerdiag_3.zip", DownloadMissing: true},
+		{},
+		{DownloadPath: "/output/containerdiag_4.zip"},
+	}
+
+	candidates := GetQuotaCandidates(containerDiagnostic)
+
+	want := []struct {
+		run            int
+		completionTime time.Time
+	}{
+		{-1, now},
+		{0, startTime.Time},
+		{3, containerDiagnostic.CreationTimestamp.Time},
+	}
+
+	if len(candidates) != len(want) {
+		t.Fatalf("got %d candidates, want %d: %+v", len(candidates), len(want), candidates)
+	}
+	for index, candidate := range candidates {
+		if candidate.Run != want[index].run || !candidate.CompletionTime.Equal(want[index].completionTime) {
+			t.Errorf("candidate %d: got run %d completed %v, want run %d completed %v", index, candidate.Run, candidate.CompletionTime, want[index].run, want[index].completionTime)
+		}
+	}
+}
+
+func TestRemoveOrphans(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * OrphanGracePeriod)
+
+	// A run in progress started after the orphans were last modified
+	startTime := metav1.NewTime(now.Add(-time.Hour))
+	processing := &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "processing", UID: "processing"},
+		Status:     diagnosticv1.ContainerDiagnosticStatus{StatusCode: int(StatusProcessing), StartTime: &startTime},
+	}
+	finished := newFinishedContainerDiagnostic("finished", old)
+
+	sweeper, fakeClient := newRetentionTest(t, processing, finished)
+
+	finished.Status.DownloadPath = writeOutputFile(t, sweeper, "containerdiag_referenced.zip", 10, now.Add(-3*time.Hour))
+	if err := fakeClient.Status().Update(context.Background(), finished); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		modTime time.Time
+		removed bool
+	}{
+		{"old orphaned download", "containerdiag_orphan.zip", now.Add(-3 * time.Hour), true},
+		{"old orphaned run directory", "tmp123/cluster/trace.txt", now.Add(-3 * time.Hour), true},
+		{"orphaned download newer than the grace period", "containerdiag_new.zip", now.Add(-time.Minute), false},
+		{"orphaned download newer than the run in progress", "containerdiag_processing.zip", now.Add(-30 * time.Minute), false},
+		{"run directory with a file newer than the run in progress", "tmp456/cluster/trace.txt", now.Add(-30 * time.Minute), false},
+		{"other file", "lost+found/file", now.Add(-3 * time.Hour), false},
+		{"referenced download", "containerdiag_referenced.zip", now.Add(-3 * time.Hour), false},
+	}
+
+	for _, test := range tests {
+		writeOutputFile(t, sweeper, test.path, 10, test.modTime)
+	}
+
+	// The directory of a run isn't modified when files are written in its subdirectories
+	for _, directory := range []string{"tmp123", "tmp123/cluster", "tmp456", "tmp456/cluster"} {
+		if err := os.Chtimes(filepath.Join(sweeper.OutputDirectory, directory), old.Add(-time.Hour), old.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := sweeper.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := os.Stat(filepath.Join(sweeper.OutputDirectory, test.path))
+			if removed := os.IsNotExist(err); removed != test.removed {
+				t.Errorf("removed: %v, want %v", removed, test.removed)
+			}
+		})
+	}
+}
+
+func TestSweepDeletesContainerDiagnostics(t *testing.T) {
+	now := time.Now()
+	ttl := int32(3600)
+
+	expired := newFinishedContainerDiagnostic("expired", now.Add(-2*time.Hour))
+	expired.Spec.TTLSecondsAfterFinished = &ttl
+	notExpired := newFinishedContainerDiagnostic("not-expired", now.Add(-30*time.Minute))
+	notExpired.Spec.TTLSecondsAfterFinished = &ttl
+	oldest := newFinishedContainerDiagnostic("oldest", now.Add(-5*time.Hour))
+	older := newFinishedContainerDiagnostic("older", now.Add(-4*time.Hour))
+	newest := newFinishedContainerDiagnostic("newest", now.Add(-time.Minute))
+	processing := &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "processing", UID: "processing"},
+		Status:     diagnosticv1.ContainerDiagnosticStatus{StatusCode: int(StatusProcessing)},
+	}
+	otherNamespace := newFinishedContainerDiagnostic("other-namespace", now.Add(-6*time.Hour))
+	otherNamespace.Namespace = "other"
+
+	sweeper, fakeClient := newRetentionTest(t, expired, notExpired, oldest, older, newest, processing, otherNamespace)
+	sweeper.MaxRunsPerNamespace = 2
+
+	if err := sweeper.Sweep(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		deleted bool
+	}{
+		// Expired isn't counted for the maximum runs
+		{"expired", true},
+		{"oldest", true},
+		{"older", true},
+		{"not-expired", false},
+		{"newest", false},
+		{"processing", false},
+	}
+
+	for _, test := range tests {
+		err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: test.name}, &diagnosticv1.ContainerDiagnostic{})
+		if deleted := client.IgnoreNotFound(err) == nil && err != nil; deleted != test.deleted {
+			t.Errorf("%s deleted: %v, want %v (%v)", test.name, deleted, test.deleted, err)
+		}
+	}
+
+	if err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "other", Name: "other-namespace"}, &diagnosticv1.ContainerDiagnostic{}); err != nil {
+		t.Errorf("the ContainerDiagnostic in another namespace was deleted: %v", err)
+	}
+}
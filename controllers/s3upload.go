@@ -0,0 +1,144 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// Keys in the Secret referenced by S3Upload.SecretRef
+const (
+	S3SecretAccessKeyID     = "accessKeyID"
+	S3SecretSecretAccessKey = "secretAccessKey"
+	S3SecretSessionToken    = "sessionToken"
+)
+
+// S3UploadConfig is everything needed to upload a file to S3-compatible object storage
+type S3UploadConfig struct {
+	Endpoint             string
+	Secure               bool
+	Region               string
+	Bucket               string
+	ObjectName           string
+	AccessKeyID          string
+	SecretAccessKey      string
+	SessionToken         string
+	ServerSideEncryption string
+	KMSKeyID             string
+	PresignExpiry        time.Duration
+
+	// Optional. Used to override TLS configuration (e.g. in tests).
+	Transport http.RoundTripper
+}
+
+// S3UploadResult is the location of an uploaded object
+type S3UploadResult struct {
+	ObjectURL    string
+	PresignedURL string
+}
+
+// GetS3UploadConfig builds the upload configuration from the spec and the credentials in the referenced Secret
+func (r *ContainerDiagnosticReconciler) GetS3UploadConfig(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic, localFile string) (*S3UploadConfig, error) {
+	s3 := containerDiagnostic.Spec.S3
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: containerDiagnostic.Namespace, Name: s3.SecretRef.Name}, secret)
+	if err != nil {
+		return nil, fmt.Errorf("could not get Secret %s: %w", s3.SecretRef.Name, err)
+	}
+
+	accessKeyID := string(secret.Data[S3SecretAccessKeyID])
+	secretAccessKey := string(secret.Data[S3SecretSecretAccessKey])
+	if len(accessKeyID) == 0 || len(secretAccessKey) == 0 {
+		return nil, fmt.Errorf("Secret %s must have the keys %s and %s", s3.SecretRef.Name, S3SecretAccessKeyID, S3SecretSecretAccessKey)
+	}
+
+	return &S3UploadConfig{
+		Endpoint:             s3.Endpoint,
+		Secure:               !s3.Insecure,
+		Region:               s3.Region,
+		Bucket:               s3.Bucket,
+		ObjectName:           s3.Prefix + path.Base(localFile),
+		AccessKeyID:          accessKeyID,
+		SecretAccessKey:      secretAccessKey,
+		SessionToken:         string(secret.Data[S3SecretSessionToken]),
+		ServerSideEncryption: s3.ServerSideEncryption,
+		KMSKeyID:             s3.KMSKeyID,
+		PresignExpiry:        time.Duration(s3.PresignExpirySeconds) * time.Second,
+	}, nil
+}
+
+// UploadToS3 uploads localFile and returns the object URL and, if requested, a presigned download link
+func UploadToS3(ctx context.Context, config *S3UploadConfig, localFile string) (*S3UploadResult, error) {
+	s3Client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(config.AccessKeyID, config.SecretAccessKey, config.SessionToken),
+		Secure:    config.Secure,
+		Region:    config.Region,
+		Transport: config.Transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	options := minio.PutObjectOptions{ContentType: "application/octet-stream"}
+
+	switch config.ServerSideEncryption {
+	case "":
+	case "AES256":
+		options.ServerSideEncryption = encrypt.NewSSE()
+	case "aws:kms":
+		options.ServerSideEncryption, err = encrypt.NewSSEKMS(config.KMSKeyID, nil)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown server-side encryption: %s", config.ServerSideEncryption)
+	}
+
+	_, err = s3Client.FPutObject(ctx, config.Bucket, config.ObjectName, localFile, options)
+	if err != nil {
+		return nil, err
+	}
+
+	objectURL := *s3Client.EndpointURL()
+	objectURL.Path = "/" + config.Bucket + "/" + config.ObjectName
+
+	result := &S3UploadResult{ObjectURL: objectURL.String()}
+
+	if config.PresignExpiry > 0 {
+		presignedURL, err := s3Client.PresignedGetObject(ctx, config.Bucket, config.ObjectName, config.PresignExpiry, url.Values{})
+		if err != nil {
+			return result, err
+		}
+		result.PresignedURL = presignedURL.String()
+	}
+
+	return result, nil
+}
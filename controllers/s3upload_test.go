@@ -0,0 +1,113 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a minimal stand-in for MinIO that accepts PutObject requests
+type fakeS3 struct {
+	mutex   sync.Mutex
+	objects map[string][]byte
+	headers map[string]http.Header
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPut {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=testaccesskey/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	f.mutex.Lock()
+	f.objects[req.URL.Path] = body
+	f.headers[req.URL.Path] = req.Header.Clone()
+	f.mutex.Unlock()
+	w.Header().Set("ETag", "\"d41d8cd98f00b204e9800998ecf8427e\"")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestUploadToS3(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}, headers: map[string]http.Header{}}
+	server := httptest.NewTLSServer(fake)
+	defer server.Close()
+
+	directory, err := ioutil.TempDir("", "s3upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	localFile := filepath.Join(directory, "containerdiag_20211115_211712_tmp1.zip")
+	err = ioutil.WriteFile(localFile, []byte("bundle contents"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &S3UploadConfig{
+		Endpoint:             strings.TrimPrefix(server.URL, "https://"),
+		Secure:               true,
+		Region:               "us-east-1",
+		Bucket:               "diagnostics",
+		ObjectName:           "support/" + filepath.Base(localFile),
+		AccessKeyID:          "testaccesskey",
+		SecretAccessKey:      "testsecretkey",
+		ServerSideEncryption: "AES256",
+		PresignExpiry:        time.Hour,
+		Transport:            server.Client().Transport,
+	}
+
+	result, err := UploadToS3(context.Background(), config, localFile)
+	if err != nil {
+		t.Fatalf("UploadToS3 failed: %v", err)
+	}
+
+	objectPath := "/diagnostics/support/containerdiag_20211115_211712_tmp1.zip"
+
+	if string(fake.objects[objectPath]) != "bundle contents" {
+		t.Errorf("unexpected object contents at %s: %q", objectPath, fake.objects[objectPath])
+	}
+
+	if sse := fake.headers[objectPath].Get("X-Amz-Server-Side-Encryption"); sse != "AES256" {
+		t.Errorf("unexpected server-side encryption header: %q", sse)
+	}
+
+	if result.ObjectURL != server.URL+objectPath {
+		t.Errorf("unexpected object URL: %s", result.ObjectURL)
+	}
+
+	if !strings.HasPrefix(result.PresignedURL, server.URL+objectPath+"?") || !strings.Contains(result.PresignedURL, "X-Amz-Signature=") {
+		t.Errorf("unexpected presigned URL: %s", result.PresignedURL)
+	}
+}
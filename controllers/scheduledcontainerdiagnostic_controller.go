@@ -0,0 +1,321 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// Added to each ContainerDiagnostic created by a ScheduledContainerDiagnostic with its name
+const ScheduledByLabel = "diagnostic.ibm.com/scheduled-by"
+
+// Added to each ContainerDiagnostic created by a ScheduledContainerDiagnostic with the time it
+// was scheduled for (which may be earlier than its creation time)
+const ScheduledTimeAnnotation = "diagnostic.ibm.com/scheduled-at"
+
+// If more runs than this were missed (e.g. the operator wasn't running for a long time), a warning
+// event is recorded. Like the other missed runs, only the most recent one is started.
+const MaxMissedRuns = 100
+
+// Defaults of the history limits
+const (
+	DefaultSuccessfulRunsHistoryLimit = 3
+	DefaultFailedRunsHistoryLimit     = 1
+)
+
+// ScheduledContainerDiagnosticReconciler reconciles a ScheduledContainerDiagnostic object
+type ScheduledContainerDiagnosticReconciler struct {
+	client.Client
+	Scheme        *runtime.Scheme
+	EventRecorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=diagnostic.ibm.com,resources=scheduledcontainerdiagnostics,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=diagnostic.ibm.com,resources=scheduledcontainerdiagnostics/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=diagnostic.ibm.com,resources=scheduledcontainerdiagnostics/finalizers,verbs=update
+
+// Reconcile creates a ContainerDiagnostic when a run of a ScheduledContainerDiagnostic is due,
+// applies the concurrency policy and history limits, and requeues itself for the next run.
+func (r *ScheduledContainerDiagnosticReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	scheduled := &diagnosticv1.ScheduledContainerDiagnostic{}
+	err := r.Get(ctx, req.NamespacedName, scheduled)
+	if err != nil {
+		// Created ContainerDiagnostics are deleted by garbage collection
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if scheduled.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+
+	originalStatus := scheduled.Status.DeepCopy()
+
+	children := &diagnosticv1.ContainerDiagnosticList{}
+	err = r.List(ctx, children, client.InNamespace(req.Namespace), client.MatchingLabels{ScheduledByLabel: req.Name})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var active, successful, failed []*diagnosticv1.ContainerDiagnostic
+	for i := range children.Items {
+		child := &children.Items[i]
+		if !metav1.IsControlledBy(child, scheduled) || child.GetDeletionTimestamp() != nil {
+			continue
+		}
+
+		if !IsFinishedStatus(child) {
+			active = append(active, child)
+		} else if StatusEnum(child.Status.StatusCode) == StatusSuccess {
+			successful = append(successful, child)
+		} else {
+			failed = append(failed, child)
+		}
+
+		if scheduledTime, err := GetScheduledTime(child); err == nil && scheduledTime != nil {
+			if scheduled.Status.LastScheduleTime == nil || scheduled.Status.LastScheduleTime.Before(scheduledTime) {
+				scheduled.Status.LastScheduleTime = scheduledTime
+			}
+		}
+	}
+
+	scheduled.Status.Active = nil
+	for _, child := range active {
+		scheduled.Status.Active = append(scheduled.Status.Active, corev1.ObjectReference{
+			APIVersion: diagnosticv1.GroupVersion.String(),
+			Kind:       "ContainerDiagnostic",
+			Namespace:  child.Namespace,
+			Name:       child.Name,
+			UID:        child.UID,
+		})
+	}
+
+	for _, child := range successful {
+		completionTime := child.Status.CompletionTime
+		if completionTime != nil && (scheduled.Status.LastSuccessfulTime == nil || scheduled.Status.LastSuccessfulTime.Before(completionTime)) {
+			scheduled.Status.LastSuccessfulTime = completionTime
+		}
+	}
+
+	if !apiequality.Semantic.DeepEqual(originalStatus, &scheduled.Status) {
+		err = r.Status().Update(ctx, scheduled)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	r.DeleteHistory(ctx, logger, scheduled, successful, GetHistoryLimit(scheduled.Spec.SuccessfulRunsHistoryLimit, DefaultSuccessfulRunsHistoryLimit))
+	r.DeleteHistory(ctx, logger, scheduled, failed, GetHistoryLimit(scheduled.Spec.FailedRunsHistoryLimit, DefaultFailedRunsHistoryLimit))
+
+	if scheduled.Spec.Suspend != nil && *scheduled.Spec.Suspend {
+		logger.Info(fmt.Sprintf("ScheduledContainerDiagnostic %s is suspended", scheduled.Name))
+		return ctrl.Result{}, nil
+	}
+
+	schedule, err := cron.ParseStandard(scheduled.Spec.Schedule)
+	if err != nil {
+		// Requeuing won't help until the spec is fixed
+		r.EventRecorder.Event(scheduled, corev1.EventTypeWarning, "InvalidSchedule", fmt.Sprintf("Invalid schedule %q: %v", scheduled.Spec.Schedule, err))
+		return ctrl.Result{}, nil
+	}
+
+	now := time.Now()
+	missedRun, nextRun, missed := GetScheduledTimes(scheduled, schedule, now)
+	if missed > MaxMissedRuns {
+		logger.Info(fmt.Sprintf("ScheduledContainerDiagnostic %s missed %d runs; starting the most recent run at %v", scheduled.Name, missed, missedRun))
+		r.EventRecorder.Event(scheduled, corev1.EventTypeWarning, "TooManyMissedRuns", fmt.Sprintf("More than %d runs were missed; set or decrease startingDeadlineSeconds", MaxMissedRuns))
+	}
+
+	result := ctrl.Result{RequeueAfter: nextRun.Sub(now)}
+
+	if missedRun.IsZero() {
+		return result, nil
+	}
+
+	if scheduled.Spec.StartingDeadlineSeconds != nil && missedRun.Add(time.Duration(*scheduled.Spec.StartingDeadlineSeconds)*time.Second).Before(now) {
+		logger.Info(fmt.Sprintf("ScheduledContainerDiagnostic %s missed the starting deadline of the run at %v", scheduled.Name, missedRun))
+		return result, nil
+	}
+
+	if len(active) > 0 {
+		switch scheduled.Spec.ConcurrencyPolicy {
+		case diagnosticv1.ForbidConcurrent:
+			logger.Info(fmt.Sprintf("ScheduledContainerDiagnostic %s skipped the run at %v because %d runs are active", scheduled.Name, missedRun, len(active)))
+			return result, nil
+		case diagnosticv1.ReplaceConcurrent:
+			for _, child := range active {
+				err = r.Delete(ctx, child, client.PropagationPolicy(metav1.DeletePropagationBackground))
+				if err != nil && !k8serrors.IsNotFound(err) {
+					return ctrl.Result{}, err
+				}
+				r.EventRecorder.Event(scheduled, corev1.EventTypeNormal, "Replaced", fmt.Sprintf("Deleted active ContainerDiagnostic %s", child.Name))
+			}
+		}
+	}
+
+	containerDiagnostic := NewContainerDiagnosticFromTemplate(&scheduled.Spec.Template, scheduled.Namespace, fmt.Sprintf("%s-%d", scheduled.Name, missedRun.Unix()/60))
+	containerDiagnostic.Labels[ScheduledByLabel] = scheduled.Name
+	containerDiagnostic.Annotations[ScheduledTimeAnnotation] = missedRun.Format(time.RFC3339)
+
+	err = ctrl.SetControllerReference(scheduled, containerDiagnostic, r.Scheme)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = r.Create(ctx, containerDiagnostic)
+	if err != nil {
+		if k8serrors.IsAlreadyExists(err) {
+			// Already created by a previous reconcile
+			return result, nil
+		}
+		r.EventRecorder.Event(scheduled, corev1.EventTypeWarning, "FailedCreate", fmt.Sprintf("Could not create ContainerDiagnostic %s: %v", containerDiagnostic.Name, err))
+		return ctrl.Result{}, err
+	}
+
+	r.EventRecorder.Event(scheduled, corev1.EventTypeNormal, "SuccessfulCreate", fmt.Sprintf("Created ContainerDiagnostic %s", containerDiagnostic.Name))
+
+	lastScheduleTime := metav1.NewTime(missedRun)
+	scheduled.Status.LastScheduleTime = &lastScheduleTime
+	scheduled.Status.LastRun = containerDiagnostic.Name
+
+	err = r.Status().Update(ctx, scheduled)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return result, nil
+}
+
+// DeleteHistory deletes the oldest finished ContainerDiagnostics beyond limit. The finalizer
+// of each ContainerDiagnostic deletes its download.
+func (r *ScheduledContainerDiagnosticReconciler) DeleteHistory(ctx context.Context, logger logr.Logger, scheduled *diagnosticv1.ScheduledContainerDiagnostic, finished []*diagnosticv1.ContainerDiagnostic, limit int) {
+	if len(finished) <= limit {
+		return
+	}
+
+	SortByCompletionTime(finished)
+
+	for _, child := range finished[:len(finished)-limit] {
+		err := r.Delete(ctx, child, client.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !k8serrors.IsNotFound(err) {
+			logger.Error(err, fmt.Sprintf("could not delete old ContainerDiagnostic %s", child.Name))
+		} else {
+			logger.Info(fmt.Sprintf("ScheduledContainerDiagnostic %s deleted old ContainerDiagnostic %s", scheduled.Name, child.Name))
+		}
+	}
+}
+
+// GetScheduledTimes returns the most recent run that was due but not yet started (or the zero
+// time if none), the time of the next run, and the number of runs that were due
+func GetScheduledTimes(scheduled *diagnosticv1.ScheduledContainerDiagnostic, schedule cron.Schedule, now time.Time) (missedRun time.Time, nextRun time.Time, missed int) {
+	earliest := scheduled.CreationTimestamp.Time
+	if scheduled.Status.LastScheduleTime != nil {
+		earliest = scheduled.Status.LastScheduleTime.Time
+	}
+
+	// Runs before the starting deadline would be skipped anyway
+	if scheduled.Spec.StartingDeadlineSeconds != nil {
+		deadline := now.Add(-time.Duration(*scheduled.Spec.StartingDeadlineSeconds) * time.Second)
+		if deadline.After(earliest) {
+			earliest = deadline
+		}
+	}
+
+	nextRun = schedule.Next(now)
+
+	for t := schedule.Next(earliest); !t.After(now); t = schedule.Next(t) {
+		missedRun = t
+		missed++
+	}
+
+	return missedRun, nextRun, missed
+}
+
+// GetScheduledTime returns the time a ContainerDiagnostic created by a ScheduledContainerDiagnostic
+// was scheduled for or nil if it doesn't have the annotation
+func GetScheduledTime(containerDiagnostic *diagnosticv1.ContainerDiagnostic) (*metav1.Time, error) {
+	value, ok := containerDiagnostic.Annotations[ScheduledTimeAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	scheduledTime, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+
+	result := metav1.NewTime(scheduledTime)
+	return &result, nil
+}
+
+func GetHistoryLimit(limit *int32, defaultLimit int) int {
+	if limit == nil {
+		return defaultLimit
+	}
+	return int(*limit)
+}
+
+// NewContainerDiagnosticFromTemplate returns a new ContainerDiagnostic with the labels,
+// annotations, and spec of a template
+func NewContainerDiagnosticFromTemplate(template *diagnosticv1.ContainerDiagnosticRunTemplate, namespace string, name string) *diagnosticv1.ContainerDiagnostic {
+	containerDiagnostic := &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Labels:      map[string]string{},
+			Annotations: map[string]string{},
+		},
+		Spec: *template.Spec.DeepCopy(),
+	}
+
+	for key, value := range template.Labels {
+		containerDiagnostic.Labels[key] = value
+	}
+	for key, value := range template.Annotations {
+		containerDiagnostic.Annotations[key] = value
+	}
+
+	return containerDiagnostic
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ScheduledContainerDiagnosticReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.EventRecorder = mgr.GetEventRecorderFor("scheduledcontainerdiagnostic")
+
+	// Changes to the ContainerDiagnostics it created (e.g. when they finish) reconcile the owner
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&diagnosticv1.ScheduledContainerDiagnostic{}).
+		Owns(&diagnosticv1.ContainerDiagnostic{}).
+		Complete(r)
+}
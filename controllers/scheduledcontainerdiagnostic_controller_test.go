@@ -0,0 +1,324 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+func TestGetScheduledTimes(t *testing.T) {
+	hourly, err := cron.ParseStandard("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2021, 10, 18, 12, 30, 0, 0, time.UTC)
+	deadline := func(seconds int64) *int64 { return &seconds }
+	at := func(hour int, minute int) time.Time { return time.Date(2021, 10, 18, hour, minute, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name                    string
+		creationTime            time.Time
+		lastScheduleTime        time.Time
+		startingDeadlineSeconds *int64
+		wantMissedRun           time.Time
+		wantMissed              int
+	}{
+		{"created after the last run", at(12, 10), time.Time{}, nil, time.Time{}, 0},
+		{"one run due since creation", at(11, 50), time.Time{}, nil, at(12, 0), 1},
+		{"already started", at(10, 50), at(12, 0), nil, time.Time{}, 0},
+		{"several runs due", at(8, 50), at(9, 0), nil, at(12, 0), 3},
+		{"runs before the deadline are ignored", at(8, 50), at(9, 0), deadline(2 * 3600), at(12, 0), 2},
+		{"every run before the deadline", at(8, 50), at(9, 0), deadline(60), time.Time{}, 0},
+		{"more than the maximum", now.Add(-(MaxMissedRuns + 10) * time.Hour), time.Time{}, nil, at(12, 0), MaxMissedRuns + 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheduled := &diagnosticv1.ScheduledContainerDiagnostic{
+				ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(test.creationTime)},
+				Spec:       diagnosticv1.ScheduledContainerDiagnosticSpec{StartingDeadlineSeconds: test.startingDeadlineSeconds},
+			}
+			if !test.lastScheduleTime.IsZero() {
+				lastScheduleTime := metav1.NewTime(test.lastScheduleTime)
+				scheduled.Status.LastScheduleTime = &lastScheduleTime
+			}
+
+			missedRun, nextRun, missed := GetScheduledTimes(scheduled, hourly, now)
+			if !missedRun.Equal(test.wantMissedRun) {
+				t.Errorf("got missed run %v, want %v", missedRun, test.wantMissedRun)
+			}
+			if missed != test.wantMissed {
+				t.Errorf("got %d missed, want %d", missed, test.wantMissed)
+			}
+			if !nextRun.Equal(at(13, 0)) {
+				t.Errorf("got next run %v", nextRun)
+			}
+		})
+	}
+}
+
+// newScheduledTest returns a reconciler for a ScheduledContainerDiagnostic that runs every
+// minute and whose last run was scheduled two minutes ago, so a run is due
+func newScheduledTest(t *testing.T, spec diagnosticv1.ScheduledContainerDiagnosticSpec, children ...*diagnosticv1.ContainerDiagnostic) (*ScheduledContainerDiagnosticReconciler, client.Client, *record.FakeRecorder) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := diagnosticv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	spec.Schedule = "* * * * *"
+	spec.Template = diagnosticv1.ContainerDiagnosticRunTemplate{
+		Spec: diagnosticv1.ContainerDiagnosticSpec{Command: "version"},
+	}
+	lastScheduleTime := metav1.NewTime(time.Now().Add(-2 * time.Minute).Truncate(time.Minute))
+	scheduled := &diagnosticv1.ScheduledContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:         "default",
+			Name:              "every-minute",
+			UID:               "every-minute-uid",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+		},
+		Spec:   spec,
+		Status: diagnosticv1.ScheduledContainerDiagnosticStatus{LastScheduleTime: &lastScheduleTime},
+	}
+
+	objects := []client.Object{scheduled}
+	for _, child := range children {
+		if err := ctrl.SetControllerReference(scheduled, child, scheme); err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, child)
+	}
+
+	recorder := record.NewFakeRecorder(100)
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+	return &ScheduledContainerDiagnosticReconciler{
+		Client:        fakeClient,
+		Scheme:        scheme,
+		EventRecorder: recorder,
+	}, fakeClient, recorder
+}
+
+// newScheduledChild returns a ContainerDiagnostic created by the every-minute schedule. Finished
+// runs have completed the given number of minutes ago.
+func newScheduledChild(name string, status StatusEnum, completedMinutesAgo int) *diagnosticv1.ContainerDiagnostic {
+	child := &diagnosticv1.ContainerDiagnostic{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			Labels:    map[string]string{ScheduledByLabel: "every-minute"},
+		},
+		Status: diagnosticv1.ContainerDiagnosticStatus{StatusCode: int(status)},
+	}
+	if IsFinishedStatus(child) {
+		completionTime := metav1.NewTime(time.Now().Add(-time.Duration(completedMinutesAgo) * time.Minute))
+		child.Status.CompletionTime = &completionTime
+	}
+	return child
+}
+
+func reconcileScheduled(t *testing.T, scheduler *ScheduledContainerDiagnosticReconciler, fakeClient client.Client) (ctrl.Result, *diagnosticv1.ScheduledContainerDiagnostic, []string) {
+	ctx := context.Background()
+	key := types.NamespacedName{Namespace: "default", Name: "every-minute"}
+
+	result, err := scheduler.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scheduled := &diagnosticv1.ScheduledContainerDiagnostic{}
+	if err := fakeClient.Get(ctx, key, scheduled); err != nil {
+		t.Fatal(err)
+	}
+
+	children := &diagnosticv1.ContainerDiagnosticList{}
+	if err := fakeClient.List(ctx, children, client.InNamespace("default"), client.MatchingLabels{ScheduledByLabel: "every-minute"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, child := range children.Items {
+		names = append(names, child.Name)
+	}
+	sort.Strings(names)
+
+	return result, scheduled, names
+}
+
+func TestScheduledConcurrencyPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  diagnosticv1.ConcurrencyPolicy
+		active  bool
+		wantNew bool
+		wantOld bool
+	}{
+		{"allow without active runs", diagnosticv1.AllowConcurrent, false, true, true},
+		{"allow with an active run", diagnosticv1.AllowConcurrent, true, true, true},
+		{"forbid without active runs", diagnosticv1.ForbidConcurrent, false, true, true},
+		{"forbid with an active run", diagnosticv1.ForbidConcurrent, true, false, true},
+		{"replace without active runs", diagnosticv1.ReplaceConcurrent, false, true, true},
+		{"replace with an active run", diagnosticv1.ReplaceConcurrent, true, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			status := StatusSuccess
+			if test.active {
+				status = StatusProcessing
+			}
+			previous := newScheduledChild("every-minute-previous", status, 1)
+
+			scheduler, fakeClient, _ := newScheduledTest(t, diagnosticv1.ScheduledContainerDiagnosticSpec{ConcurrencyPolicy: test.policy}, previous)
+			result, scheduled, names := reconcileScheduled(t, scheduler, fakeClient)
+
+			created := ""
+			for _, name := range names {
+				if name != previous.Name {
+					created = name
+				}
+			}
+
+			if (created != "") != test.wantNew {
+				t.Errorf("got ContainerDiagnostics %v, want a new run %t", names, test.wantNew)
+			}
+			if hasOld := len(names) > 0 && names[len(names)-1] == previous.Name; hasOld != test.wantOld {
+				t.Errorf("got ContainerDiagnostics %v, want the previous run %t", names, test.wantOld)
+			}
+			if test.wantNew && scheduled.Status.LastRun != created {
+				t.Errorf("got last run %q, want %q", scheduled.Status.LastRun, created)
+			}
+			if result.RequeueAfter <= 0 || result.RequeueAfter > time.Minute {
+				t.Errorf("got requeue after %v", result.RequeueAfter)
+			}
+		})
+	}
+}
+
+func TestScheduledHistoryLimits(t *testing.T) {
+	two := int32(2)
+	zero := int32(0)
+
+	tests := []struct {
+		name                  string
+		successfulLimit       *int32
+		failedLimit           *int32
+		wantSuccessfulDeleted []string
+		wantFailedDeleted     []string
+	}{
+		{"defaults", nil, nil, []string{"every-minute-success-5", "every-minute-success-4"}, []string{"every-minute-error-3", "every-minute-error-2"}},
+		{"limits", &two, &zero, []string{"every-minute-success-5", "every-minute-success-4", "every-minute-success-3"}, []string{"every-minute-error-3", "every-minute-error-2", "every-minute-error-1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The number in each name is how many minutes ago it completed
+			var children []*diagnosticv1.ContainerDiagnostic
+			for minutes := 1; minutes <= 5; minutes++ {
+				children = append(children, newScheduledChild(fmt.Sprintf("every-minute-success-%d", minutes), StatusSuccess, minutes))
+			}
+			for minutes := 1; minutes <= 3; minutes++ {
+				children = append(children, newScheduledChild(fmt.Sprintf("every-minute-error-%d", minutes), StatusError, minutes))
+			}
+			children = append(children, newScheduledChild("every-minute-running", StatusProcessing, 0))
+
+			suspend := true
+			scheduler, fakeClient, _ := newScheduledTest(t, diagnosticv1.ScheduledContainerDiagnosticSpec{
+				Suspend:                    &suspend,
+				SuccessfulRunsHistoryLimit: test.successfulLimit,
+				FailedRunsHistoryLimit:     test.failedLimit,
+			}, children...)
+			_, _, names := reconcileScheduled(t, scheduler, fakeClient)
+
+			remaining := map[string]bool{}
+			for _, name := range names {
+				remaining[name] = true
+			}
+
+			for _, name := range append(test.wantSuccessfulDeleted, test.wantFailedDeleted...) {
+				if remaining[name] {
+					t.Errorf("%s wasn't deleted", name)
+				}
+			}
+			if want := 9 - len(test.wantSuccessfulDeleted) - len(test.wantFailedDeleted); len(names) != want {
+				t.Errorf("got %v, want %d", names, want)
+			}
+			if !remaining["every-minute-running"] {
+				t.Error("an active run was deleted")
+			}
+		})
+	}
+}
+
+func TestScheduledTooManyMissedRuns(t *testing.T) {
+	scheduler, fakeClient, recorder := newScheduledTest(t, diagnosticv1.ScheduledContainerDiagnosticSpec{})
+
+	scheduled := &diagnosticv1.ScheduledContainerDiagnostic{}
+	key := types.NamespacedName{Namespace: "default", Name: "every-minute"}
+	if err := fakeClient.Get(context.Background(), key, scheduled); err != nil {
+		t.Fatal(err)
+	}
+	lastScheduleTime := metav1.NewTime(time.Now().Add(-(MaxMissedRuns + 10) * time.Minute))
+	scheduled.Status.LastScheduleTime = &lastScheduleTime
+	if err := fakeClient.Status().Update(context.Background(), scheduled); err != nil {
+		t.Fatal(err)
+	}
+
+	_, scheduled, names := reconcileScheduled(t, scheduler, fakeClient)
+
+	// Like a CronJob, the most recent missed run is started and the schedule continues from it
+	if len(names) != 1 {
+		t.Fatalf("got ContainerDiagnostics %v, want 1", names)
+	}
+	if scheduled.Status.LastScheduleTime == nil || time.Since(scheduled.Status.LastScheduleTime.Time) > time.Minute {
+		t.Errorf("got last schedule time %v", scheduled.Status.LastScheduleTime)
+	}
+
+	var events []string
+	for len(recorder.Events) > 0 {
+		events = append(events, <-recorder.Events)
+	}
+	if !strings.Contains(strings.Join(events, "\n"), "TooManyMissedRuns") {
+		t.Errorf("got events %v", events)
+	}
+
+	_, _, names = reconcileScheduled(t, scheduler, fakeClient)
+	if len(names) != 1 {
+		t.Errorf("got ContainerDiagnostics %v after the next reconcile, want 1", names)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+// The kinds of a TemplateReference
+const (
+	TemplateKindNamespaced = "ContainerDiagnosticTemplate"
+	TemplateKindCluster    = "ClusterContainerDiagnosticTemplate"
+)
+
+// A reference to a parameter in the arguments of a template step. Other uses of ${...} (e.g.
+// shell variables) are left as-is.
+var TemplateParameterReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// +kubebuilder:rbac:groups=diagnostic.ibm.com,resources=containerdiagnostictemplates,verbs=get;list;watch
+// +kubebuilder:rbac:groups=diagnostic.ibm.com,resources=clustercontainerdiagnostictemplates,verbs=get;list;watch
+
+// GetTemplate gets the template referenced by a ContainerDiagnostic
+func (r *ContainerDiagnosticReconciler) GetTemplate(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic) (*diagnosticv1.ContainerDiagnosticTemplateSpec, error) {
+	templateRef := containerDiagnostic.Spec.TemplateRef
+
+	switch templateRef.Kind {
+	case "", TemplateKindNamespaced:
+		template := &diagnosticv1.ContainerDiagnosticTemplate{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: containerDiagnostic.Namespace, Name: templateRef.Name}, template)
+		if err != nil {
+			return nil, err
+		}
+		return &template.Spec, nil
+	case TemplateKindCluster:
+		template := &diagnosticv1.ClusterContainerDiagnosticTemplate{}
+		err := r.Get(ctx, client.ObjectKey{Name: templateRef.Name}, template)
+		if err != nil {
+			return nil, err
+		}
+		return &template.Spec, nil
+	default:
+		return nil, fmt.Errorf("unknown template kind %s", templateRef.Kind)
+	}
+}
+
+// GetRunTemplate gets the template of the current run of a ContainerDiagnostic. The template is
+// read on the first reconcile of the run and kept in the status, so later reconciles (e.g. while
+// waiting for a watch) use the same template even if it's changed or deleted.
+func (r *ContainerDiagnosticReconciler) GetRunTemplate(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic) (*diagnosticv1.ContainerDiagnosticTemplateSpec, error) {
+	if containerDiagnostic.Status.Template == nil {
+		template, err := r.GetTemplate(ctx, containerDiagnostic)
+		if err != nil {
+			return nil, err
+		}
+		containerDiagnostic.Status.Template = template.DeepCopy()
+	}
+	return containerDiagnostic.Status.Template, nil
+}
+
+// ApplyTemplate sets the steps of a ContainerDiagnostic spec from a template (unless the spec has
+// its own steps) with the parameters substituted, and sets the options that the spec doesn't
+// specify from the defaults of the template. The spec is only changed in memory.
+//
+// The arguments of steps other than install are run through the shell, so the parameters are
+// substituted into them as single quoted words. The arguments of install steps are tool names
+// and the other fields aren't parsed by the shell (or are quoted when the script is written), so
+// the parameters are substituted as-is; the substituted steps must still be checked with
+// diagnosticv1.ValidateSteps.
+func ApplyTemplate(spec *diagnosticv1.ContainerDiagnosticSpec, template *diagnosticv1.ContainerDiagnosticTemplateSpec) error {
+	parameters, err := ResolveTemplateParameters(template.Parameters, spec.Parameters)
+	if err != nil {
+		return err
+	}
+
+	if len(spec.Steps) == 0 {
+		spec.Steps = make([]diagnosticv1.ContainerDiagnosticStep, 0, len(template.Steps))
+		for _, templateStep := range template.Steps {
+			step := *templateStep.DeepCopy()
+			quote := step.Command != "install"
+			for index, argument := range step.Arguments {
+				step.Arguments[index] = SubstituteTemplateParameters(argument, parameters, quote)
+			}
+			for index, argument := range step.ExecCommand {
+				step.ExecCommand[index] = SubstituteTemplateParameters(argument, parameters, false)
+			}
+			for index, argument := range step.Args {
+				step.Args[index] = SubstituteTemplateParameters(argument, parameters, false)
+			}
+			for index := range step.Env {
+				step.Env[index].Value = SubstituteTemplateParameters(step.Env[index].Value, parameters, false)
+			}
+			step.WorkingDir = SubstituteTemplateParameters(step.WorkingDir, parameters, false)
+			step.OutputFile = SubstituteTemplateParameters(step.OutputFile, parameters, false)
+			spec.Steps = append(spec.Steps, step)
+		}
+	}
+
+	defaults := &template.Defaults
+	if len(spec.ExpandArchives) == 0 {
+		spec.ExpandArchives = defaults.ExpandArchives
+	}
+	if spec.CompressionLevel == 0 {
+		spec.CompressionLevel = defaults.CompressionLevel
+	}
+	if spec.MaxBundleSizeMB == 0 {
+		spec.MaxBundleSizeMB = defaults.MaxBundleSizeMB
+	}
+	if len(spec.RedactPatterns) == 0 {
+		spec.RedactPatterns = defaults.RedactPatterns
+	}
+	if spec.Logs == nil {
+		spec.Logs = defaults.Logs
+	}
+	if spec.Watch == nil {
+		spec.Watch = defaults.Watch
+	}
+
+	return nil
+}
+
+// ResolveTemplateParameters returns the value of each parameter of a template from the values
+// of a ContainerDiagnostic or the defaults
+func ResolveTemplateParameters(templateParameters []diagnosticv1.TemplateParameter, values map[string]string) (map[string]string, error) {
+	result := map[string]string{}
+	declared := map[string]bool{}
+
+	var missing []string
+	for _, templateParameter := range templateParameters {
+		declared[templateParameter.Name] = true
+
+		if value, ok := values[templateParameter.Name]; ok {
+			result[templateParameter.Name] = value
+		} else if templateParameter.Required {
+			missing = append(missing, templateParameter.Name)
+		} else {
+			result[templateParameter.Name] = templateParameter.Default
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required template parameters: %s", strings.Join(missing, ", "))
+	}
+
+	var unknown []string
+	for name := range values {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown template parameters: %s", strings.Join(unknown, ", "))
+	}
+
+	return result, nil
+}
+
+// SubstituteTemplateParameters replaces ${name} with the value of each parameter, quoted with
+// ShellQuote if quote is true
+func SubstituteTemplateParameters(value string, parameters map[string]string, quote bool) string {
+	return TemplateParameterReference.ReplaceAllStringFunc(value, func(reference string) string {
+		name := TemplateParameterReference.FindStringSubmatch(reference)[1]
+		if parameterValue, ok := parameters[name]; ok {
+			if quote {
+				return ShellQuote(parameterValue)
+			}
+			return parameterValue
+		}
+		return reference
+	})
+}
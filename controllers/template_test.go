@@ -0,0 +1,274 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+func TestResolveTemplateParameters(t *testing.T) {
+	templateParameters := []diagnosticv1.TemplateParameter{
+		{Name: "outputDirectory", Default: "/output"},
+		{Name: "pid", Required: true},
+		{Name: "empty"},
+	}
+
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    map[string]string
+		wantErr string
+	}{
+		{"defaults", map[string]string{"pid": "1"}, map[string]string{"outputDirectory": "/output", "pid": "1", "empty": ""}, ""},
+		{"values", map[string]string{"pid": "1", "outputDirectory": "/tmp", "empty": "x"}, map[string]string{"outputDirectory": "/tmp", "pid": "1", "empty": "x"}, ""},
+		{"empty value of a required parameter", map[string]string{"pid": ""}, map[string]string{"outputDirectory": "/output", "pid": "", "empty": ""}, ""},
+		{"missing required", nil, nil, "missing required template parameters: pid"},
+		{"unknown", map[string]string{"pid": "1", "zeta": "z", "alpha": "a"}, nil, "unknown template parameters: alpha, zeta"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ResolveTemplateParameters(templateParameters, test.values)
+			if len(test.wantErr) > 0 {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSubstituteTemplateParameters(t *testing.T) {
+	parameters := map[string]string{"dir": "/output", "name": "it's"}
+
+	tests := []struct {
+		name  string
+		value string
+		quote bool
+		want  string
+	}{
+		{"parameter", "${dir}/javacore*", false, "/output/javacore*"},
+		{"quoted parameter", "${dir}/javacore*", true, "'/output'/javacore*"},
+		{"quote in a value", "echo ${name}", true, "echo 'it'\\''s'"},
+		{"several parameters", "${dir}/${name}", false, "/output/it's"},
+		{"unknown reference", "${HOME} $dir", true, "${HOME} $dir"},
+		{"no references", "ls -l", true, "ls -l"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := SubstituteTemplateParameters(test.value, parameters, test.quote); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyTemplate(t *testing.T) {
+	template := &diagnosticv1.ContainerDiagnosticTemplateSpec{
+		Parameters: []diagnosticv1.TemplateParameter{
+			{Name: "dir", Default: "/output"},
+			{Name: "tool", Default: "linperf.sh"},
+		},
+		Steps: []diagnosticv1.ContainerDiagnosticStep{
+			{Command: "install", Arguments: []string{"${tool}"}},
+			{
+				Command:     "execute",
+				ExecCommand: []string{"ls", "${dir}"},
+				Args:        []string{"${dir}"},
+				Env:         []diagnosticv1.StepEnvVar{{Name: "DIR", Value: "${dir}"}},
+				WorkingDir:  "${dir}",
+				OutputFile:  "${tool}.txt",
+			},
+			{Command: "package", Arguments: []string{"${dir}/javacore*"}},
+		},
+		Defaults: diagnosticv1.ContainerDiagnosticTemplateDefaults{
+			CompressionLevel: 9,
+			MaxBundleSizeMB:  100,
+			RedactPatterns:   []string{"secret"},
+		},
+	}
+
+	spec := &diagnosticv1.ContainerDiagnosticSpec{
+		Parameters:      map[string]string{"dir": "/tmp/$(touch pwned); x"},
+		MaxBundleSizeMB: 10,
+	}
+
+	if err := ApplyTemplate(spec, template); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(spec.Steps) != 3 {
+		t.Fatalf("got %d steps", len(spec.Steps))
+	}
+	if got := spec.Steps[0].Arguments[0]; got != "linperf.sh" {
+		t.Errorf("got install argument %q", got)
+	}
+
+	execute := spec.Steps[1]
+	dir := "/tmp/$(touch pwned); x"
+	if !reflect.DeepEqual(execute.ExecCommand, []string{"ls", dir}) || !reflect.DeepEqual(execute.Args, []string{dir}) ||
+		execute.Env[0].Value != dir || execute.WorkingDir != dir || execute.OutputFile != "linperf.sh.txt" {
+		t.Errorf("got execute step %+v", execute)
+	}
+
+	// The quoted argument is a single word for the shell without any expansion of the value
+	packageArgument := spec.Steps[2].Arguments[0]
+	if packageArgument != "'/tmp/$(touch pwned); x'/javacore*" {
+		t.Errorf("got package argument %q", packageArgument)
+	}
+	scratch := t.TempDir()
+	command := exec.Command("sh", "-c", "printf '%s\\n' "+packageArgument)
+	command.Dir = scratch
+	output, err := command.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "/tmp/$(touch pwned); x/javacore*\n" {
+		t.Errorf("got shell words %q", output)
+	}
+
+	// The template doesn't change
+	if template.Steps[2].Arguments[0] != "${dir}/javacore*" {
+		t.Errorf("the template was changed: %q", template.Steps[2].Arguments[0])
+	}
+
+	// Only the options that the spec doesn't specify come from the defaults
+	if spec.CompressionLevel != 9 || spec.MaxBundleSizeMB != 10 || !reflect.DeepEqual(spec.RedactPatterns, []string{"secret"}) {
+		t.Errorf("got options %d, %d, %v", spec.CompressionLevel, spec.MaxBundleSizeMB, spec.RedactPatterns)
+	}
+}
+
+func TestApplyTemplateOwnSteps(t *testing.T) {
+	template := &diagnosticv1.ContainerDiagnosticTemplateSpec{
+		Parameters: []diagnosticv1.TemplateParameter{{Name: "dir", Required: true}},
+		Steps:      []diagnosticv1.ContainerDiagnosticStep{{Command: "package", Arguments: []string{"${dir}"}}},
+	}
+
+	steps := []diagnosticv1.ContainerDiagnosticStep{{Command: "package", Arguments: []string{"${dir}"}}}
+	spec := &diagnosticv1.ContainerDiagnosticSpec{Steps: steps, Parameters: map[string]string{"dir": "/output"}}
+	if err := ApplyTemplate(spec, template); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(spec.Steps, steps) {
+		t.Errorf("the steps of the spec were changed: %+v", spec.Steps)
+	}
+
+	// The parameters are still checked
+	spec = &diagnosticv1.ContainerDiagnosticSpec{}
+	if err := ApplyTemplate(spec, template); err == nil {
+		t.Error("a missing required parameter wasn't an error")
+	}
+}
+
+func TestGetRunTemplate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := diagnosticv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []diagnosticv1.ContainerDiagnosticStep{{Command: "package", Arguments: []string{"/logs"}}}
+	template := &diagnosticv1.ContainerDiagnosticTemplate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "logs"},
+		Spec:       diagnosticv1.ContainerDiagnosticTemplateSpec{Description: "namespaced", Steps: steps},
+	}
+	clusterTemplate := &diagnosticv1.ClusterContainerDiagnosticTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: "logs"},
+		Spec:       diagnosticv1.ContainerDiagnosticTemplateSpec{Description: "cluster", Steps: steps},
+	}
+
+	ctx := context.Background()
+	r := &ContainerDiagnosticReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(template, clusterTemplate).Build()}
+
+	newContainerDiagnostic := func(kind string, name string) *diagnosticv1.ContainerDiagnostic {
+		return &diagnosticv1.ContainerDiagnostic{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "app", Name: "diag"},
+			Spec:       diagnosticv1.ContainerDiagnosticSpec{TemplateRef: &diagnosticv1.TemplateReference{Kind: kind, Name: name}},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		kind            string
+		wantDescription string
+	}{
+		{"default kind", "", "namespaced"},
+		{"namespaced", TemplateKindNamespaced, "namespaced"},
+		{"cluster", TemplateKindCluster, "cluster"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			containerDiagnostic := newContainerDiagnostic(test.kind, "logs")
+			got, err := r.GetRunTemplate(ctx, containerDiagnostic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Description != test.wantDescription || containerDiagnostic.Status.Template == nil || containerDiagnostic.Status.Template.Description != test.wantDescription {
+				t.Errorf("got %+v and status %+v", got, containerDiagnostic.Status.Template)
+			}
+		})
+	}
+
+	if _, err := r.GetRunTemplate(ctx, newContainerDiagnostic("", "missing")); !k8serrors.IsNotFound(err) {
+		t.Errorf("got %v for a missing template", err)
+	}
+	if _, err := r.GetRunTemplate(ctx, newContainerDiagnostic("Other", "logs")); err == nil {
+		t.Error("an unknown kind wasn't an error")
+	}
+
+	// Later reconciles of the run use the template in the status even if the template is changed
+	// or deleted
+	containerDiagnostic := newContainerDiagnostic("", "logs")
+	if _, err := r.GetRunTemplate(ctx, containerDiagnostic); err != nil {
+		t.Fatal(err)
+	}
+	template.Spec.Description = "changed"
+	if err := r.Update(ctx, template); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.GetRunTemplate(ctx, containerDiagnostic); err != nil || got.Description != "namespaced" {
+		t.Errorf("got %+v, %v after the template changed", got, err)
+	}
+	if err := r.Delete(ctx, template); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.GetRunTemplate(ctx, containerDiagnostic); err != nil || got.Description != "namespaced" {
+		t.Errorf("got %+v, %v after the template was deleted", got, err)
+	}
+}
@@ -0,0 +1,507 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const DefaultWatchIntervalSeconds = 5
+const DefaultWatchConsecutiveSamples = 1
+const DefaultWatchTimeoutSeconds = 86400
+
+// The exit code of watch.sh if no threshold is crossed before the timeout
+const WatchTimeoutExitCode = 3
+
+// The exit code of watch.sh if the cgroup statistics can't be read
+const WatchUnsupportedExitCode = 4
+
+// The states of ContainerWatchStatus
+const (
+	WatchStateWatching = "Watching"
+	WatchStateCrossed  = "Crossed"
+	WatchStateTimedOut = "TimedOut"
+	WatchStateFailed   = "Failed"
+)
+
+// The files written in the directory of a watch in the container
+const WatchOutputFileName = "containerdiag_watch.txt"
+const WatchResultFileName = "containerdiag_watch_result.txt"
+
+// How long past its timeout a watch without a result (e.g. because the container restarted)
+// is considered failed
+const WatchResultGraceSeconds = 60
+
+// The minimum time between checks of the watches so that large numbers of targets aren't
+// exec'ed into too often
+const MinWatchPollSeconds = 10
+
+// The cgroup v2 and v1 files sampled by watch.sh
+const (
+	CgroupV2CPUStat          = "/sys/fs/cgroup/cpu.stat"
+	CgroupV1CPUUsage         = "/sys/fs/cgroup/cpuacct/cpuacct.usage"
+	CgroupV1CPUUsageCombined = "/sys/fs/cgroup/cpu,cpuacct/cpuacct.usage"
+	CgroupV2MemoryCurrent    = "/sys/fs/cgroup/memory.current"
+	CgroupV2MemoryStat       = "/sys/fs/cgroup/memory.stat"
+	CgroupV1MemoryUsage      = "/sys/fs/cgroup/memory/memory.usage_in_bytes"
+	CgroupV1MemoryStat       = "/sys/fs/cgroup/memory/memory.stat"
+)
+
+// GetWatchSettings returns the interval, consecutive samples, and timeout of a watch with defaults applied
+func GetWatchSettings(watch *diagnosticv1.ContainerWatch) (intervalSeconds int, consecutiveSamples int, timeoutSeconds int64) {
+	intervalSeconds = watch.IntervalSeconds
+	if intervalSeconds <= 0 {
+		intervalSeconds = DefaultWatchIntervalSeconds
+	}
+	consecutiveSamples = watch.ConsecutiveSamples
+	if consecutiveSamples <= 0 {
+		consecutiveSamples = DefaultWatchConsecutiveSamples
+	}
+	timeoutSeconds = watch.TimeoutSeconds
+	if timeoutSeconds <= 0 {
+		timeoutSeconds = DefaultWatchTimeoutSeconds
+	}
+	return intervalSeconds, consecutiveSamples, timeoutSeconds
+}
+
+// WriteWatchScript writes a script that samples the CPU and memory usage of the container's
+// cgroup every interval and exits with 0 when a threshold has been crossed for the consecutive
+// samples or WatchTimeoutExitCode after the timeout. Each sample is written to stdout and
+// appended to the output file. The script runs in the background (see StartWatch).
+func WriteWatchScript(fileWriter *os.File, containerTmpFilesPrefix string, watch *diagnosticv1.ContainerWatch, outputFile string) {
+	intervalSeconds, consecutiveSamples, timeoutSeconds := GetWatchSettings(watch)
+
+	awkcmd := GetExecutionCommand(containerTmpFilesPrefix, "awk", "")
+	datecmd := GetExecutionCommand(containerTmpFilesPrefix, "date", "")
+	teecmd := GetExecutionCommand(containerTmpFilesPrefix, "tee", fmt.Sprintf("-a %s", outputFile))
+
+	fileWriter.WriteString("#!/bin/sh\n")
+	fileWriter.WriteString(fmt.Sprintf("cd %s\n", containerTmpFilesPrefix))
+	if !UseLdLinuxDirect {
+		AddDirectCallEnvars(fileWriter, containerTmpFilesPrefix)
+	}
+
+	// Total CPU time of the cgroup in microseconds
+	fileWriter.WriteString("cpu_usage() {\n")
+	fileWriter.WriteString(fmt.Sprintf("  if [ -r %s ]; then\n", CgroupV2CPUStat))
+	fileWriter.WriteString(fmt.Sprintf("    %s '$1 == \"usage_usec\" {printf(\"%%.0f\", $2);}' %s\n", awkcmd, CgroupV2CPUStat))
+	for _, cpuUsage := range []string{CgroupV1CPUUsage, CgroupV1CPUUsageCombined} {
+		fileWriter.WriteString(fmt.Sprintf("  elif [ -r %s ]; then\n", cpuUsage))
+		fileWriter.WriteString(fmt.Sprintf("    %s '{printf(\"%%.0f\", $1 / 1000);}' %s\n", awkcmd, cpuUsage))
+	}
+	fileWriter.WriteString("  fi\n")
+	fileWriter.WriteString("}\n")
+
+	// Memory usage of the cgroup in MB excluding inactive page cache
+	fileWriter.WriteString("memory_usage() {\n")
+	fileWriter.WriteString(fmt.Sprintf("  if [ -r %s ]; then\n", CgroupV2MemoryCurrent))
+	fileWriter.WriteString(fmt.Sprintf("    %s 'FNR == NR {usage = $1; next;} $1 == \"inactive_file\" {inactive = $2;} END {printf(\"%%.0f\", (usage - inactive) / 1048576);}' %s %s\n", awkcmd, CgroupV2MemoryCurrent, CgroupV2MemoryStat))
+	fileWriter.WriteString(fmt.Sprintf("  elif [ -r %s ]; then\n", CgroupV1MemoryUsage))
+	fileWriter.WriteString(fmt.Sprintf("    %s 'FNR == NR {usage = $1; next;} $1 == \"total_inactive_file\" {inactive = $2;} END {printf(\"%%.0f\", (usage - inactive) / 1048576);}' %s %s\n", awkcmd, CgroupV1MemoryUsage, CgroupV1MemoryStat))
+	fileWriter.WriteString("  fi\n")
+	fileWriter.WriteString("}\n")
+
+	fileWriter.WriteString(fmt.Sprintf("now_usec() {\n  %s +%%s%%N | %s '{printf(\"%%.0f\", $1 / 1000);}'\n}\n", datecmd, awkcmd))
+
+	var thresholds string
+	if watch.CPUThresholdMillicores != nil {
+		thresholds += fmt.Sprintf(" cpu>=%dm", *watch.CPUThresholdMillicores)
+	}
+	if watch.MemoryThresholdMB != nil {
+		thresholds += fmt.Sprintf(" memory>=%dMB", *watch.MemoryThresholdMB)
+	}
+
+	WriteExecutionLine(fileWriter, containerTmpFilesPrefix, "echo", fmt.Sprintf("\"containerdiag: Watching for%s for %d consecutive sample(s) every %d second(s) for up to %d second(s)\" | %s", thresholds, consecutiveSamples, intervalSeconds, timeoutSeconds, teecmd), false, "", false)
+
+	if watch.CPUThresholdMillicores != nil {
+		fileWriter.WriteString("PREVIOUS_CPU=\"$(cpu_usage)\"\n")
+		fileWriter.WriteString("if [ \"${PREVIOUS_CPU}\" = \"\" ]; then\n")
+		WriteExecutionLine(fileWriter, containerTmpFilesPrefix, "echo", fmt.Sprintf("\"ERROR: Could not read the CPU usage of the container's cgroup\" | %s", teecmd), false, "", false)
+		fileWriter.WriteString(fmt.Sprintf("  exit %d\n", WatchUnsupportedExitCode))
+		fileWriter.WriteString("fi\n")
+	}
+	if watch.MemoryThresholdMB != nil {
+		fileWriter.WriteString("if [ \"$(memory_usage)\" = \"\" ]; then\n")
+		WriteExecutionLine(fileWriter, containerTmpFilesPrefix, "echo", fmt.Sprintf("\"ERROR: Could not read the memory usage of the container's cgroup\" | %s", teecmd), false, "", false)
+		fileWriter.WriteString(fmt.Sprintf("  exit %d\n", WatchUnsupportedExitCode))
+		fileWriter.WriteString("fi\n")
+	}
+
+	fileWriter.WriteString("PREVIOUS_TIME=\"$(now_usec)\"\n")
+	fileWriter.WriteString("START_TIME=\"${PREVIOUS_TIME}\"\n")
+	fileWriter.WriteString("CROSSED=0\n")
+	fileWriter.WriteString("while true; do\n")
+	WriteExecutionLine(fileWriter, containerTmpFilesPrefix, "sleep", fmt.Sprintf("%d", intervalSeconds), false, "", false)
+	fileWriter.WriteString("  TIME=\"$(now_usec)\"\n")
+	fileWriter.WriteString("  ABOVE=0\n")
+	fileWriter.WriteString("  SAMPLE=\"\"\n")
+
+	if watch.CPUThresholdMillicores != nil {
+		fileWriter.WriteString("  CPU=\"$(cpu_usage)\"\n")
+		fileWriter.WriteString("  ELAPSED=$((TIME - PREVIOUS_TIME))\n")
+		fileWriter.WriteString("  if [ \"${ELAPSED}\" -le 0 ]; then\n    ELAPSED=1\n  fi\n")
+		fileWriter.WriteString("  MILLICORES=$(((CPU - PREVIOUS_CPU) * 1000 / ELAPSED))\n")
+		fileWriter.WriteString("  PREVIOUS_CPU=\"${CPU}\"\n")
+		fileWriter.WriteString("  SAMPLE=\"${SAMPLE} cpu=${MILLICORES}m\"\n")
+		fileWriter.WriteString(fmt.Sprintf("  if [ \"${MILLICORES}\" -ge %d ]; then\n    ABOVE=1\n  fi\n", *watch.CPUThresholdMillicores))
+	}
+
+	if watch.MemoryThresholdMB != nil {
+		fileWriter.WriteString("  MEMORY=\"$(memory_usage)\"\n")
+		fileWriter.WriteString("  SAMPLE=\"${SAMPLE} memory=${MEMORY}MB\"\n")
+		fileWriter.WriteString(fmt.Sprintf("  if [ \"${MEMORY}\" -ge %d ]; then\n    ABOVE=1\n  fi\n", *watch.MemoryThresholdMB))
+	}
+
+	fileWriter.WriteString("  PREVIOUS_TIME=\"${TIME}\"\n")
+	WriteExecutionLine(fileWriter, containerTmpFilesPrefix, "echo", fmt.Sprintf("\"$(%s)${SAMPLE}\" | %s", datecmd, teecmd), false, "", false)
+
+	fileWriter.WriteString("  if [ \"${ABOVE}\" -eq 1 ]; then\n    CROSSED=$((CROSSED + 1))\n  else\n    CROSSED=0\n  fi\n")
+	fileWriter.WriteString(fmt.Sprintf("  if [ \"${CROSSED}\" -ge %d ]; then\n", consecutiveSamples))
+	WriteExecutionLine(fileWriter, containerTmpFilesPrefix, "echo", fmt.Sprintf("\"containerdiag: Threshold crossed at $(%s)\" | %s", datecmd, teecmd), false, "", false)
+	fileWriter.WriteString("    exit 0\n")
+	fileWriter.WriteString("  fi\n")
+	fileWriter.WriteString(fmt.Sprintf("  if [ $((TIME - START_TIME)) -ge %d ]; then\n", timeoutSeconds*1000000))
+	WriteExecutionLine(fileWriter, containerTmpFilesPrefix, "echo", fmt.Sprintf("\"containerdiag: Threshold not crossed within %d second(s)\" | %s", timeoutSeconds, teecmd), false, "", false)
+	fileWriter.WriteString(fmt.Sprintf("    exit %d\n", WatchTimeoutExitCode))
+	fileWriter.WriteString("  fi\n")
+	fileWriter.WriteString("done\n")
+}
+
+// GetWatchPollSeconds returns how often the watches are checked
+func GetWatchPollSeconds(watch *diagnosticv1.ContainerWatch) int {
+	intervalSeconds, _, _ := GetWatchSettings(watch)
+	if intervalSeconds < MinWatchPollSeconds {
+		return MinWatchPollSeconds
+	}
+	return intervalSeconds
+}
+
+// GetWatchState returns the state and message of a watch from the exit code of watch.sh
+func GetWatchState(exitCode int) (string, string) {
+	switch exitCode {
+	case 0:
+		return WatchStateCrossed, ""
+	case WatchTimeoutExitCode:
+		return WatchStateTimedOut, ""
+	case WatchUnsupportedExitCode:
+		return WatchStateFailed, "the CPU and/or memory usage of the container's cgroup could not be read"
+	default:
+		return WatchStateFailed, fmt.Sprintf("the watch exited with code %d", exitCode)
+	}
+}
+
+// IsWatchExpired returns whether a watch should have written its result by now
+func IsWatchExpired(watchStatus *diagnosticv1.ContainerWatchStatus, watch *diagnosticv1.ContainerWatch, now time.Time) bool {
+	if watchStatus.StartTime == nil {
+		return true
+	}
+	intervalSeconds, _, timeoutSeconds := GetWatchSettings(watch)
+	deadline := watchStatus.StartTime.Add(time.Duration(timeoutSeconds+int64(2*intervalSeconds+WatchResultGraceSeconds)) * time.Second)
+	return now.After(deadline)
+}
+
+// FindWatch returns the watch status of a container or nil if it wasn't watched
+func FindWatch(status *diagnosticv1.ContainerDiagnosticStatus, pod *corev1.Pod, containerName string) *diagnosticv1.ContainerWatchStatus {
+	for index := range status.Watches {
+		watch := &status.Watches[index]
+		if watch.Namespace == pod.Namespace && watch.Pod == pod.Name && watch.Container == containerName {
+			return watch
+		}
+	}
+	return nil
+}
+
+// CountWatching returns the number of watches that haven't finished
+func CountWatching(watches []diagnosticv1.ContainerWatchStatus) int {
+	count := 0
+	for _, watch := range watches {
+		if watch.State == WatchStateWatching {
+			count++
+		}
+	}
+	return count
+}
+
+// ReconcileWatches starts the watch in the background in every target container on the first
+// reconcile and checks their results on later reconciles. Until every watch has finished, it
+// returns false with a result that requeues the reconcile so that the operator isn't blocked
+// while waiting for a threshold and all targets are watched at the same time.
+func (r *ContainerDiagnosticReconciler) ReconcileWatches(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger) (bool, ctrl.Result, error) {
+	var changed bool
+	if containerDiagnostic.Status.Watches == nil {
+		pods, err := r.GetTargetPods(ctx, containerDiagnostic, logger)
+		if err != nil {
+			r.SetStatus(StatusError, fmt.Sprintf("Could not get the pods to watch: %+v", err), containerDiagnostic, logger)
+			return false, ctrl.Result{}, err
+		}
+
+		filesToTar := make(map[string]bool)
+		for _, command := range []string{
+			"/usr/bin/awk",
+			"/usr/bin/date",
+			"/usr/bin/tee",
+			"/usr/bin/sleep",
+		} {
+			ok := r.ProcessInstallCommand(command, filesToTar, containerDiagnostic, logger)
+			if !ok {
+				// The error will have been logged within the above function.
+				return false, ctrl.Result{}, nil
+			}
+		}
+
+		watches := []diagnosticv1.ContainerWatchStatus{}
+		for _, pod := range pods {
+			for _, container := range pod.Spec.Containers {
+				if IsTargetContainer(&containerDiagnostic.Spec, container.Name) {
+					watches = append(watches, r.StartWatch(containerDiagnostic, logger, pod, container, filesToTar))
+				}
+			}
+		}
+
+		containerDiagnostic.Status.Watches = watches
+		changed = true
+	} else {
+		for index := range containerDiagnostic.Status.Watches {
+			watch := &containerDiagnostic.Status.Watches[index]
+			if watch.State == WatchStateWatching && r.CheckWatch(ctx, containerDiagnostic, logger, watch) {
+				changed = true
+			}
+		}
+	}
+
+	watching := CountWatching(containerDiagnostic.Status.Watches)
+	if watching == 0 {
+		return true, ctrl.Result{}, nil
+	}
+
+	if changed {
+		// The status is only persisted if the result changes
+		r.SetStatus(StatusProcessing, fmt.Sprintf("Watching %d of %d container(s) for a threshold", watching, len(containerDiagnostic.Status.Watches)), containerDiagnostic, logger)
+	}
+
+	return false, ctrl.Result{RequeueAfter: time.Duration(GetWatchPollSeconds(containerDiagnostic.Spec.Watch)) * time.Second}, nil
+}
+
+// GetTargetPods returns the pods of targetObjects that exist and the pods of targetLabelSelectors
+func (r *ContainerDiagnosticReconciler) GetTargetPods(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger) ([]*corev1.Pod, error) {
+	var pods []*corev1.Pod
+
+	for _, targetObject := range containerDiagnostic.Spec.TargetObjects {
+		pod := &corev1.Pod{}
+		err := r.Get(ctx, client.ObjectKey{
+			Namespace: targetObject.Namespace,
+			Name:      targetObject.Name,
+		}, pod)
+		if err != nil {
+			if k8serrors.IsNotFound(err) {
+				// This is reported by the run after the watch
+				logger.Info(fmt.Sprintf("GetTargetPods pod not found: name: %s namespace: %s", targetObject.Name, targetObject.Namespace))
+				continue
+			}
+			return nil, err
+		}
+		pods = append(pods, pod)
+	}
+
+	if len(containerDiagnostic.Spec.TargetLabelSelectors) > 0 {
+		clientset, err := kubernetes.NewForConfig(r.Config)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, targetSelector := range containerDiagnostic.Spec.TargetLabelSelectors {
+			selector := metav1.FormatLabelSelector(&targetSelector)
+
+			allpods, err := clientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{LabelSelector: selector})
+			if err != nil {
+				return nil, err
+			}
+
+			for index := range allpods.Items {
+				pods = append(pods, &allpods.Items[index])
+			}
+		}
+	}
+
+	return pods, nil
+}
+
+// StartWatch uploads watch.sh and the tools it needs to a new directory in a container and
+// runs it in the background. When watch.sh exits, its exit code is written to WatchResultFileName.
+func (r *ContainerDiagnosticReconciler) StartWatch(containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger, pod *corev1.Pod, container corev1.Container, filesToTar map[string]bool) diagnosticv1.ContainerWatchStatus {
+	now := metav1.Now()
+	watchStatus := diagnosticv1.ContainerWatchStatus{
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Container: container.Name,
+		State:     WatchStateWatching,
+		StartTime: &now,
+	}
+
+	fail := func(message string) diagnosticv1.ContainerWatchStatus {
+		logger.Info(fmt.Sprintf("StartWatch pod: %s container: %s failed: %s", pod.Name, container.Name, message))
+		watchStatus.State = WatchStateFailed
+		watchStatus.Message = message
+		watchStatus.CompletionTime = &now
+		return watchStatus
+	}
+
+	uuid := GetUniqueIdentifier()
+	localScratchSpaceDirectory := filepath.Join(LocalScratchSpaceRoot, uuid)
+	err := os.MkdirAll(localScratchSpaceDirectory, os.ModePerm)
+	if err != nil {
+		return fail(fmt.Sprintf("could not create local scratchspace in %s: %+v", localScratchSpaceDirectory, err))
+	}
+	defer Cleanup(logger, localScratchSpaceDirectory)
+
+	containerTmpFilesPrefix := containerDiagnostic.Spec.Directory + uuid + "/"
+	watchStatus.Directory = containerTmpFilesPrefix
+
+	var stdout, stderr bytes.Buffer
+	err = r.ExecInContainer(pod, container, []string{"mkdir", "-p", containerTmpFilesPrefix}, &stdout, &stderr, nil, nil)
+	if err != nil {
+		return fail(fmt.Sprintf("could not create %s: %+v %s", containerTmpFilesPrefix, err, stderr.String()))
+	}
+
+	localWatchScript := filepath.Join(localScratchSpaceDirectory, "watch.sh")
+	localWatchScriptFile, err := os.OpenFile(localWatchScript, os.O_CREATE|os.O_WRONLY, os.ModePerm)
+	if err != nil {
+		return fail(fmt.Sprintf("could not write local watch.sh file %s: %+v", localWatchScript, err))
+	}
+
+	WriteWatchScript(localWatchScriptFile, containerTmpFilesPrefix, containerDiagnostic.Spec.Watch, filepath.Join(containerTmpFilesPrefix, WatchOutputFileName))
+
+	localWatchScriptFile.Close()
+
+	os.Chmod(localWatchScript, os.ModePerm)
+
+	tarFiles := []string{localWatchScript}
+	for key := range filesToTar {
+		tarFiles = append(tarFiles, key)
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	err = r.UploadFiles(pod, container, tarFiles, filepath.Join(localScratchSpaceDirectory, "files.tar"), containerTmpFilesPrefix, &stdout, &stderr, logger)
+	if err != nil {
+		return fail(fmt.Sprintf("could not upload watch.sh: %+v %s", err, stderr.String()))
+	}
+
+	watchScript := filepath.Join(containerTmpFilesPrefix, localScratchSpaceDirectory, "watch.sh")
+	resultFile := filepath.Join(containerTmpFilesPrefix, WatchResultFileName)
+
+	// The exec returns immediately and the watch keeps running in the container
+	stdout.Reset()
+	stderr.Reset()
+	err = r.ExecInContainer(pod, container, []string{"/bin/sh", "-c", fmt.Sprintf("(%s > /dev/null 2>&1; echo $? > %s) > /dev/null 2>&1 &", watchScript, resultFile)}, &stdout, &stderr, nil, nil)
+	if err != nil {
+		return fail(fmt.Sprintf("could not start watch.sh: %+v %s", err, stderr.String()))
+	}
+
+	r.RecordEventInfo(fmt.Sprintf("Watching pod: %s container: %s for a threshold", pod.Name, container.Name), containerDiagnostic, logger)
+
+	return watchStatus
+}
+
+// CheckWatch reads the result of a watch and returns whether it finished. Errors reaching the
+// container are retried on the next check unless the watch is past its timeout.
+func (r *ContainerDiagnosticReconciler) CheckWatch(ctx context.Context, containerDiagnostic *diagnosticv1.ContainerDiagnostic, logger *CustomLogger, watchStatus *diagnosticv1.ContainerWatchStatus) bool {
+	now := metav1.Now()
+
+	finish := func(state string, message string) bool {
+		watchStatus.State = state
+		watchStatus.Message = message
+		watchStatus.CompletionTime = &now
+
+		switch state {
+		case WatchStateCrossed:
+			r.RecordEventInfo(fmt.Sprintf("Threshold crossed in pod: %s container: %s", watchStatus.Pod, watchStatus.Container), containerDiagnostic, logger)
+		case WatchStateTimedOut:
+			r.RecordEventInfo(fmt.Sprintf("No threshold was crossed in pod: %s container: %s", watchStatus.Pod, watchStatus.Container), containerDiagnostic, logger)
+		default:
+			r.RecordEventInfo(fmt.Sprintf("Watch failed in pod: %s container: %s: %s", watchStatus.Pod, watchStatus.Container, message), containerDiagnostic, logger)
+		}
+		return true
+	}
+
+	pod := &corev1.Pod{}
+	err := r.Get(ctx, client.ObjectKey{
+		Namespace: watchStatus.Namespace,
+		Name:      watchStatus.Pod,
+	}, pod)
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return finish(WatchStateFailed, "the pod no longer exists")
+		}
+		logger.Info(fmt.Sprintf("CheckWatch could not get pod: %s error: %+v", watchStatus.Pod, err))
+		if IsWatchExpired(watchStatus, containerDiagnostic.Spec.Watch, now.Time) {
+			return finish(WatchStateFailed, fmt.Sprintf("the pod could not be checked before the watch timed out: %v", err))
+		}
+		return false
+	}
+
+	var container *corev1.Container
+	for index := range pod.Spec.Containers {
+		if pod.Spec.Containers[index].Name == watchStatus.Container {
+			container = &pod.Spec.Containers[index]
+		}
+	}
+	if container == nil {
+		return finish(WatchStateFailed, "the container no longer exists")
+	}
+
+	resultFile := filepath.Join(watchStatus.Directory, WatchResultFileName)
+
+	var stdout, stderr bytes.Buffer
+	err = r.ExecInContainer(pod, *container, []string{"/bin/sh", "-c", fmt.Sprintf("if [ -r %s ]; then read RC < %s; echo \"${RC}\"; fi", resultFile, resultFile)}, &stdout, &stderr, nil, nil)
+
+	logger.Debug1(fmt.Sprintf("ExecInContainer results: err: %v, stdout: %s\n\nstderr: %s\n", err, stdout.String(), stderr.String()))
+
+	result := strings.TrimSpace(stdout.String())
+	if err == nil && len(result) > 0 {
+		exitCode, err := strconv.Atoi(result)
+		if err != nil {
+			return finish(WatchStateFailed, fmt.Sprintf("unexpected result of the watch: %s", result))
+		}
+		return finish(GetWatchState(exitCode))
+	}
+
+	if err != nil {
+		logger.Info(fmt.Sprintf("CheckWatch could not check pod: %s container: %s error: %+v", watchStatus.Pod, watchStatus.Container, err))
+	}
+
+	if IsWatchExpired(watchStatus, containerDiagnostic.Spec.Watch, now.Time) {
+		return finish(WatchStateFailed, "the watch stopped without a result (the container may have restarted)")
+	}
+
+	return false
+}
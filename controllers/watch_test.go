@@ -0,0 +1,357 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+)
+
+func writeTestWatchScript(t *testing.T, watch *diagnosticv1.ContainerWatch) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "watch.sh")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	WriteWatchScript(file, "/tmp/containerdiag/uuid/", watch, "/tmp/containerdiag/uuid/"+WatchOutputFileName)
+	file.Close()
+
+	script, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sh, err := exec.LookPath("sh"); err == nil {
+		if output, err := exec.Command(sh, "-n", path).CombinedOutput(); err != nil {
+			t.Errorf("watch.sh has a syntax error: %v %s\n%s", err, output, script)
+		}
+	}
+
+	return string(script)
+}
+
+func TestWriteWatchScript(t *testing.T) {
+	cpu := int64(1500)
+	memory := int64(2048)
+
+	tests := []struct {
+		name     string
+		watch    *diagnosticv1.ContainerWatch
+		contains []string
+		excludes []string
+	}{
+		{
+			"cpu",
+			&diagnosticv1.ContainerWatch{CPUThresholdMillicores: &cpu},
+			[]string{
+				"Watching for cpu>=1500m for 1 consecutive sample(s) every 5 second(s) for up to 86400 second(s)",
+				"PREVIOUS_CPU=\"$(cpu_usage)\"",
+				"if [ \"${MILLICORES}\" -ge 1500 ]; then",
+				"if [ \"${CROSSED}\" -ge 1 ]; then",
+				"if [ $((TIME - START_TIME)) -ge 86400000000 ]; then",
+			},
+			[]string{"MEMORY=\"$(memory_usage)\""},
+		},
+		{
+			"memory",
+			&diagnosticv1.ContainerWatch{MemoryThresholdMB: &memory, IntervalSeconds: 30, ConsecutiveSamples: 3, TimeoutSeconds: 600},
+			[]string{
+				"Watching for memory>=2048MB for 3 consecutive sample(s) every 30 second(s) for up to 600 second(s)",
+				"if [ \"${MEMORY}\" -ge 2048 ]; then",
+				"if [ \"${CROSSED}\" -ge 3 ]; then",
+				"if [ $((TIME - START_TIME)) -ge 600000000 ]; then",
+				"/usr/bin/sleep 30",
+			},
+			[]string{"PREVIOUS_CPU"},
+		},
+		{
+			"cpu and memory",
+			&diagnosticv1.ContainerWatch{CPUThresholdMillicores: &cpu, MemoryThresholdMB: &memory},
+			[]string{
+				"Watching for cpu>=1500m memory>=2048MB",
+				"if [ \"${MILLICORES}\" -ge 1500 ]; then",
+				"if [ \"${MEMORY}\" -ge 2048 ]; then",
+			},
+			nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			script := writeTestWatchScript(t, test.watch)
+
+			for _, value := range append(test.contains,
+				"#!/bin/sh\n",
+				"cd /tmp/containerdiag/uuid/\n",
+				CgroupV2CPUStat,
+				CgroupV1CPUUsage,
+				CgroupV2MemoryCurrent,
+				CgroupV1MemoryUsage,
+				"tee -a /tmp/containerdiag/uuid/"+WatchOutputFileName,
+				"    exit 0\n",
+			) {
+				if !strings.Contains(script, value) {
+					t.Errorf("watch.sh doesn't contain %q:\n%s", value, script)
+				}
+			}
+			for _, value := range test.excludes {
+				if strings.Contains(script, value) {
+					t.Errorf("watch.sh contains %q:\n%s", value, script)
+				}
+			}
+		})
+	}
+}
+
+func TestGetWatchSettings(t *testing.T) {
+	intervalSeconds, consecutiveSamples, timeoutSeconds := GetWatchSettings(&diagnosticv1.ContainerWatch{})
+	if intervalSeconds != DefaultWatchIntervalSeconds || consecutiveSamples != DefaultWatchConsecutiveSamples || timeoutSeconds != DefaultWatchTimeoutSeconds {
+		t.Errorf("got defaults %d, %d, %d", intervalSeconds, consecutiveSamples, timeoutSeconds)
+	}
+
+	intervalSeconds, consecutiveSamples, timeoutSeconds = GetWatchSettings(&diagnosticv1.ContainerWatch{IntervalSeconds: 30, ConsecutiveSamples: 3, TimeoutSeconds: 600})
+	if intervalSeconds != 30 || consecutiveSamples != 3 || timeoutSeconds != 600 {
+		t.Errorf("got %d, %d, %d", intervalSeconds, consecutiveSamples, timeoutSeconds)
+	}
+
+	if got := GetWatchPollSeconds(&diagnosticv1.ContainerWatch{IntervalSeconds: 1}); got != MinWatchPollSeconds {
+		t.Errorf("got poll seconds %d for a short interval, want %d", got, MinWatchPollSeconds)
+	}
+	if got := GetWatchPollSeconds(&diagnosticv1.ContainerWatch{IntervalSeconds: 30}); got != 30 {
+		t.Errorf("got poll seconds %d, want 30", got)
+	}
+}
+
+func TestGetWatchState(t *testing.T) {
+	tests := []struct {
+		exitCode    int
+		wantState   string
+		wantMessage string
+	}{
+		{0, WatchStateCrossed, ""},
+		{WatchTimeoutExitCode, WatchStateTimedOut, ""},
+		{WatchUnsupportedExitCode, WatchStateFailed, "the CPU and/or memory usage of the container's cgroup could not be read"},
+		{1, WatchStateFailed, "the watch exited with code 1"},
+		{137, WatchStateFailed, "the watch exited with code 137"},
+	}
+
+	for _, test := range tests {
+		state, message := GetWatchState(test.exitCode)
+		if state != test.wantState || message != test.wantMessage {
+			t.Errorf("exit code %d got %s %q, want %s %q", test.exitCode, state, message, test.wantState, test.wantMessage)
+		}
+	}
+}
+
+func TestIsWatchExpired(t *testing.T) {
+	start := time.Date(2021, 10, 18, 12, 0, 0, 0, time.UTC)
+	startTime := metav1.NewTime(start)
+	watch := &diagnosticv1.ContainerWatch{IntervalSeconds: 30, TimeoutSeconds: 600}
+
+	// The result is expected within the timeout plus two intervals and the grace period
+	deadline := start.Add((600 + 2*30 + WatchResultGraceSeconds) * time.Second)
+
+	tests := []struct {
+		name      string
+		startTime *metav1.Time
+		now       time.Time
+		want      bool
+	}{
+		{"just started", &startTime, start, false},
+		{"past the timeout", &startTime, start.Add(601 * time.Second), false},
+		{"at the deadline", &startTime, deadline, false},
+		{"past the deadline", &startTime, deadline.Add(time.Second), true},
+		{"never started", nil, start, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			watchStatus := &diagnosticv1.ContainerWatchStatus{State: WatchStateWatching, StartTime: test.startTime}
+			if got := IsWatchExpired(watchStatus, watch, test.now); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+// unreachableClient fails to get pods as if the API server couldn't be reached
+type unreachableClient struct {
+	client.Client
+}
+
+func (c *unreachableClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	if _, ok := obj.(*corev1.Pod); ok {
+		return errors.New("connection refused")
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func newWatchTest(t *testing.T, unreachable bool, objects ...client.Object) *ContainerDiagnosticReconciler {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := diagnosticv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	var fakeClient client.Client = fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+	if unreachable {
+		fakeClient = &unreachableClient{fakeClient}
+	}
+
+	return &ContainerDiagnosticReconciler{
+		Client:        fakeClient,
+		Scheme:        scheme,
+		EventRecorder: record.NewFakeRecorder(100),
+	}
+}
+
+func TestReconcileWatches(t *testing.T) {
+	cpu := int64(1500)
+	recent := metav1.Now()
+	// Past the default timeout of 24 hours plus two intervals and the grace period
+	expired := metav1.NewTime(time.Now().Add(-25 * time.Hour))
+
+	tests := []struct {
+		name        string
+		unreachable bool
+		objects     []client.Object
+		watches     []diagnosticv1.ContainerWatchStatus
+		wantDone    bool
+		wantRequeue time.Duration
+		wantStates  []string
+	}{
+		{
+			"all finished",
+			false,
+			nil,
+			[]diagnosticv1.ContainerWatchStatus{
+				{Namespace: "default", Pod: "app1", Container: "app", State: WatchStateCrossed, StartTime: &recent},
+				{Namespace: "default", Pod: "app2", Container: "app", State: WatchStateTimedOut, StartTime: &recent},
+			},
+			true,
+			0,
+			[]string{WatchStateCrossed, WatchStateTimedOut},
+		},
+		{
+			"the pod was deleted",
+			false,
+			nil,
+			[]diagnosticv1.ContainerWatchStatus{
+				{Namespace: "default", Pod: "app1", Container: "app", State: WatchStateWatching, StartTime: &recent},
+			},
+			true,
+			0,
+			[]string{WatchStateFailed},
+		},
+		{
+			"the container was removed",
+			false,
+			[]client.Object{&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app1"},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "other"}}},
+			}},
+			[]diagnosticv1.ContainerWatchStatus{
+				{Namespace: "default", Pod: "app1", Container: "app", State: WatchStateWatching, StartTime: &recent},
+			},
+			true,
+			0,
+			[]string{WatchStateFailed},
+		},
+		{
+			"the pod can't be checked yet",
+			true,
+			nil,
+			[]diagnosticv1.ContainerWatchStatus{
+				{Namespace: "default", Pod: "app1", Container: "app", State: WatchStateWatching, StartTime: &recent},
+				{Namespace: "default", Pod: "app2", Container: "app", State: WatchStateCrossed, StartTime: &recent},
+			},
+			false,
+			30 * time.Second,
+			[]string{WatchStateWatching, WatchStateCrossed},
+		},
+		{
+			"the pod can't be checked past the timeout",
+			true,
+			nil,
+			[]diagnosticv1.ContainerWatchStatus{
+				{Namespace: "default", Pod: "app1", Container: "app", State: WatchStateWatching, StartTime: &expired},
+			},
+			true,
+			0,
+			[]string{WatchStateFailed},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			containerDiagnostic := &diagnosticv1.ContainerDiagnostic{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "watch"},
+				Spec: diagnosticv1.ContainerDiagnosticSpec{
+					Command: "script",
+					Watch:   &diagnosticv1.ContainerWatch{CPUThresholdMillicores: &cpu, IntervalSeconds: 30},
+				},
+				Status: diagnosticv1.ContainerDiagnosticStatus{Watches: test.watches},
+			}
+
+			reconciler := newWatchTest(t, test.unreachable, test.objects...)
+			logger := &CustomLogger{logger: ctrl.Log.WithName("watch_test")}
+
+			done, result, err := reconciler.ReconcileWatches(context.Background(), containerDiagnostic, logger)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if done != test.wantDone {
+				t.Errorf("got done %t, want %t", done, test.wantDone)
+			}
+			if result.RequeueAfter != test.wantRequeue {
+				t.Errorf("got requeue after %v, want %v", result.RequeueAfter, test.wantRequeue)
+			}
+
+			var states []string
+			for index, watch := range containerDiagnostic.Status.Watches {
+				states = append(states, watch.State)
+				if test.watches[index].State == WatchStateWatching && watch.State != WatchStateWatching && watch.CompletionTime == nil {
+					t.Errorf("finished watch %+v has no completion time", watch)
+				}
+			}
+			if strings.Join(states, ",") != strings.Join(test.wantStates, ",") {
+				t.Errorf("got states %v, want %v", states, test.wantStates)
+			}
+		})
+	}
+}
@@ -19,7 +19,11 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -27,12 +31,14 @@ import (
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	diagnosticv1 "github.com/kgibm/containerdiagoperator/api/v1"
+	diagnosticv2 "github.com/kgibm/containerdiagoperator/api/v2"
 	"github.com/kgibm/containerdiagoperator/controllers"
 	//+kubebuilder:scaffold:imports
 )
@@ -46,15 +52,100 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(diagnosticv1.AddToScheme(scheme))
+	utilruntime.Must(diagnosticv2.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
+// getEnvDefault returns the value of an environment variable or defaultValue if it isn't set.
+// Optional features are configured with environment variables so that the kustomize patches
+// that enable them can be combined (unlike args, env is merged by name).
+func getEnvDefault(name string, defaultValue string) string {
+	value, ok := os.LookupEnv(name)
+	if ok {
+		return value
+	}
+	return defaultValue
+}
+
+// getEnvDefaultInt is getEnvDefault for integer flags
+func getEnvDefaultInt(name string, defaultValue int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return defaultValue
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value of environment variable %s: %s\n", name, value)
+		os.Exit(1)
+	}
+	return result
+}
+
+// getEnvDefaultDuration is getEnvDefault for duration flags
+func getEnvDefaultDuration(name string, defaultValue time.Duration) time.Duration {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return defaultValue
+	}
+	result, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid value of environment variable %s: %s\n", name, value)
+		os.Exit(1)
+	}
+	return result
+}
+
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var outputDirectory string
+	var downloadAddr string
+	var downloadCertDir string
+	var downloadBaseURL string
+	var retentionInterval time.Duration
+	var maxOutputSizeMB int
+	var maxRunsPerNamespace int
+	var minDiskSpaceFreeMB int
+	var alertReceiverAddr string
+	var maxConcurrentReconciles int
+	var enableWebhooks bool
+	var defaultExcludeContainers string
+	var alertReceiverTokenFile string
+	var alertReceiverAllowUnauthenticated bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&outputDirectory, "output-directory", getEnvDefault("OUTPUT_DIRECTORY", controllers.DefaultOutputDirectory),
+		"The directory for diagnostic downloads. "+
+			"Mount a persistent volume here so that downloads survive restarts of the manager.")
+	flag.StringVar(&downloadAddr, "download-bind-address", getEnvDefault("DOWNLOAD_BIND_ADDRESS", "0"),
+		"The address the download endpoint binds to. Set this to \"0\" to disable the download endpoint.")
+	flag.StringVar(&downloadCertDir, "download-cert-dir", getEnvDefault("DOWNLOAD_CERT_DIR", ""),
+		"The directory with tls.crt and tls.key for the download endpoint. If not set, the download endpoint uses HTTP.")
+	flag.StringVar(&downloadBaseURL, "download-base-url", getEnvDefault("DOWNLOAD_BASE_URL", ""),
+		"The external URL of the download endpoint used for the download URL in the status (e.g. https://diag.example.com).")
+	flag.StringVar(&alertReceiverAddr, "alert-receiver-bind-address", getEnvDefault("ALERT_RECEIVER_BIND_ADDRESS", "0"),
+		"The address the Alertmanager webhook receiver binds to. Set this to \"0\" to disable the receiver.")
+	flag.StringVar(&alertReceiverTokenFile, "alert-receiver-token-file", getEnvDefault("ALERT_RECEIVER_TOKEN_FILE", ""),
+		"A file with the bearer token that requests to the Alertmanager webhook receiver must have. Required unless --alert-receiver-allow-unauthenticated is set.")
+	flag.BoolVar(&alertReceiverAllowUnauthenticated, "alert-receiver-allow-unauthenticated", getEnvDefault("ALERT_RECEIVER_ALLOW_UNAUTHENTICATED", "false") == "true",
+		"Allow requests to the Alertmanager webhook receiver without a bearer token if --alert-receiver-token-file isn't set (e.g. for testing).")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", getEnvDefaultInt("MAX_CONCURRENT_RECONCILES", 1),
+		"The maximum number of ContainerDiagnostics processed at the same time. Increase this so that ContainerDiagnostics waiting for a watch threshold don't delay others.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", getEnvDefault("ENABLE_WEBHOOKS", "false") == "true",
+		"Enable the admission webhooks. They need a serving certificate (see config/webhook and config/certmanager).")
+	flag.StringVar(&defaultExcludeContainers, "default-exclude-containers", getEnvDefault("DEFAULT_EXCLUDE_CONTAINERS", strings.Join(diagnosticv1.DefaultExcludeContainers, ",")),
+		"A comma-separated list of containers (e.g. sidecars) that the defaulting webhook excludes from ContainerDiagnostics that don't specify containers or excludeContainers.")
+	flag.IntVar(&minDiskSpaceFreeMB, "min-disk-space-free-mb", getEnvDefaultInt("MIN_DISK_SPACE_FREE_MB", controllers.DefaultMinOperatorDiskSpaceFreeMB),
+		"The minimum disk space free (in MB) that must remain in the scratch space and output directory of the manager. "+
+			"Runs that would use more fail before writing their downloads.")
+	flag.DurationVar(&retentionInterval, "retention-interval", getEnvDefaultDuration("RETENTION_INTERVAL", time.Minute),
+		"How often to delete expired ContainerDiagnostics, downloads over the limits, and orphaned files. "+
+			"Set this to 0 to disable the retention sweeper.")
+	flag.IntVar(&maxOutputSizeMB, "max-output-size-mb", getEnvDefaultInt("MAX_OUTPUT_SIZE_MB", 0),
+		"If greater than 0, the oldest downloads are deleted when the output directory is larger than this.")
+	flag.IntVar(&maxRunsPerNamespace, "max-runs-per-namespace", getEnvDefaultInt("MAX_RUNS_PER_NAMESPACE", 0),
+		"If greater than 0, the oldest finished ContainerDiagnostics in a namespace beyond this number are deleted.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -79,13 +170,120 @@ func main() {
 		os.Exit(1)
 	}
 
+	var reconcilerDownloadBaseURL string
+	if downloadAddr != "0" {
+		reconcilerDownloadBaseURL = downloadBaseURL
+	}
+
 	if err = (&controllers.ContainerDiagnosticReconciler{
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		OutputDirectory:         outputDirectory,
+		DownloadBaseURL:         reconcilerDownloadBaseURL,
+		MinDiskSpaceFreeMB:      minDiskSpaceFreeMB,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ContainerDiagnostic")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.ScheduledContainerDiagnosticReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "ContainerDiagnostic")
+		setupLog.Error(err, "unable to create controller", "controller", "ScheduledContainerDiagnostic")
+		os.Exit(1)
+	}
+
+	triggerReconciler := &controllers.ContainerDiagnosticTriggerReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}
+	if err = triggerReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ContainerDiagnosticTrigger")
 		os.Exit(1)
 	}
+
+	if enableWebhooks {
+		diagnosticv1.DefaultExcludeContainers = nil
+		for _, container := range strings.Split(defaultExcludeContainers, ",") {
+			if container = strings.TrimSpace(container); len(container) > 0 {
+				diagnosticv1.DefaultExcludeContainers = append(diagnosticv1.DefaultExcludeContainers, container)
+			}
+		}
+
+		if err = (&diagnosticv1.ContainerDiagnostic{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ContainerDiagnostic")
+			os.Exit(1)
+		}
+	}
+
+	if downloadAddr != "0" {
+		clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+		if err != nil {
+			setupLog.Error(err, "unable to create clientset for download server")
+			os.Exit(1)
+		}
+
+		if err := mgr.Add(&controllers.DownloadServer{
+			Client:          mgr.GetClient(),
+			Clientset:       clientset,
+			BindAddress:     downloadAddr,
+			CertDir:         downloadCertDir,
+			OutputDirectory: outputDirectory,
+			Logger:          ctrl.Log.WithName("download"),
+		}); err != nil {
+			setupLog.Error(err, "unable to set up download server")
+			os.Exit(1)
+		}
+	}
+
+	if alertReceiverAddr != "0" {
+		var alertReceiverToken string
+		if len(alertReceiverTokenFile) > 0 {
+			tokenBytes, err := ioutil.ReadFile(alertReceiverTokenFile)
+			if err != nil {
+				setupLog.Error(err, "unable to read alert receiver token file")
+				os.Exit(1)
+			}
+			alertReceiverToken = strings.TrimSpace(string(tokenBytes))
+			if len(alertReceiverToken) == 0 {
+				setupLog.Error(fmt.Errorf("%s is empty", alertReceiverTokenFile), "unable to read alert receiver token file")
+				os.Exit(1)
+			}
+		} else if alertReceiverAllowUnauthenticated {
+			setupLog.Info("the alert receiver accepts requests without a bearer token so anyone who can reach it can fire triggers")
+		} else {
+			setupLog.Error(fmt.Errorf("--alert-receiver-token-file or --alert-receiver-allow-unauthenticated must be set"), "unable to set up alert receiver")
+			os.Exit(1)
+		}
+
+		if err := mgr.Add(&controllers.AlertReceiver{
+			Client:      mgr.GetClient(),
+			Triggers:    triggerReconciler,
+			BindAddress: alertReceiverAddr,
+			Token:       alertReceiverToken,
+			Logger:      ctrl.Log.WithName("alerts"),
+		}); err != nil {
+			setupLog.Error(err, "unable to set up alert receiver")
+			os.Exit(1)
+		}
+	}
+
+	if retentionInterval > 0 {
+		if err := mgr.Add(&controllers.RetentionSweeper{
+			Client:              mgr.GetClient(),
+			EventRecorder:       mgr.GetEventRecorderFor("containerdiagnostic-retention"),
+			OutputDirectory:     outputDirectory,
+			Interval:            retentionInterval,
+			MaxOutputBytes:      int64(maxOutputSizeMB) * 1024 * 1024,
+			MaxRunsPerNamespace: maxRunsPerNamespace,
+			Logger:              ctrl.Log.WithName("retention"),
+		}); err != nil {
+			setupLog.Error(err, "unable to set up retention sweeper")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {